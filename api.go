@@ -20,10 +20,8 @@ import (
 	"time"
 
 	anthropic "github.com/anthropics/anthropic-sdk-go"
-	anthropicopt "github.com/anthropics/anthropic-sdk-go/option"
 	"github.com/google/generative-ai-go/genai"
 	openai "github.com/openai/openai-go"
-	openaiopt "github.com/openai/openai-go/option"
 	"github.com/openai/openai-go/packages/param"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
@@ -43,6 +41,9 @@ var (
 
 const defaultOllamaHTTPTimeout = 2 * time.Minute
 
+// maxResponseTokens caps the length of every generated response.
+const maxResponseTokens = 4000
+
 func getOllamaHTTPTimeout() time.Duration {
 	// Optional override for slower local/CI CPU inference runs.
 	// Example: AI_MR_COMMENT_OLLAMA_TIMEOUT_MS=300000
@@ -81,21 +82,67 @@ func getGeminiClient(ctx context.Context, apiKey string) (*genai.Client, error)
 	return client, nil
 }
 
-// callOpenAI sends a chat completion request to the OpenAI API and returns the
-// generated message content.
-func callOpenAI(ctx context.Context, client *openai.Client, cfg *Config, systemPrompt, diffContent string) (string, error) {
-	resp, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Model: cfg.OpenAIModel,
+// openAIRequestOptions controls which optional request parameters are sent.
+// Some OpenAI-compatible servers reject temperature or max_tokens outright.
+type openAIRequestOptions struct {
+	OmitTemperature bool
+	OmitMaxTokens   bool
+	// OmitStreamUsage drops stream_options from streaming requests; see withStreamUsage.
+	OmitStreamUsage bool
+}
+
+// newOpenAIChatParams builds the chat completion request shared by the openai
+// and openai-compatible providers.
+func newOpenAIChatParams(model, systemPrompt, diffContent string, opts openAIRequestOptions) openai.ChatCompletionNewParams {
+	params := openai.ChatCompletionNewParams{
+		Model: model,
 		Messages: []openai.ChatCompletionMessageParamUnion{
 			openai.SystemMessage(systemPrompt),
 			openai.UserMessage(diffContent),
 		},
-		Temperature: param.NewOpt(0.7),
-		MaxTokens:   param.NewOpt(int64(4000)),
-	})
+	}
+	if !opts.OmitTemperature {
+		params.Temperature = param.NewOpt(0.7)
+	}
+	if !opts.OmitMaxTokens {
+		params.MaxTokens = param.NewOpt(int64(maxResponseTokens))
+	}
+	return params
+}
+
+// withStreamUsage asks for a final stream chunk carrying token usage
+// (stream_options.include_usage). OpenAI rejects it on buffered requests.
+func withStreamUsage(params openai.ChatCompletionNewParams) openai.ChatCompletionNewParams {
+	params.StreamOptions.IncludeUsage = param.NewOpt(true)
+	return params
+}
+
+// openAIUsage converts OpenAI usage; cached prompt tokens are included in
+// prompt_tokens.
+func openAIUsage(u openai.CompletionUsage) tokenUsage {
+	return tokenUsage{
+		InputTokens:       u.PromptTokens,
+		CachedInputTokens: u.PromptTokensDetails.CachedTokens,
+		OutputTokens:      u.CompletionTokens,
+	}
+}
+
+// callOpenAI sends a chat completion request to the OpenAI API and returns the
+// generated message content.
+func callOpenAI(ctx context.Context, client *openai.Client, cfg *Config, systemPrompt, diffContent string) (string, error) {
+	return callOpenAIChat(ctx, client, newOpenAIChatParams(cfg.OpenAIModel, systemPrompt, diffContent, openAIRequestOptions{}))
+}
+
+// callOpenAIChat sends a prepared chat completion request and returns the
+// content of the first choice.
+func callOpenAIChat(ctx context.Context, client *openai.Client, params openai.ChatCompletionNewParams) (string, error) {
+	resp, err := client.Chat.Completions.New(ctx, params)
 	if err != nil {
 		return "", enrichOpenAIError(err)
 	}
+	if resp.JSON.Usage.Valid() {
+		reportUsage(ctx, openAIUsage(resp.Usage))
+	}
 	if len(resp.Choices) == 0 {
 		return "", errors.New("no choices returned")
 	}
@@ -156,7 +203,7 @@ func enrichOpenAIError(err error) error {
 func callAnthropic(ctx context.Context, client *anthropic.Client, cfg *Config, systemPrompt, diffContent string) (string, error) {
 	resp, err := client.Messages.New(ctx, anthropic.MessageNewParams{
 		Model:     anthropic.Model(cfg.AnthropicModel),
-		MaxTokens: 4000,
+		MaxTokens: maxResponseTokens,
 		System: []anthropic.TextBlockParam{
 			{Text: systemPrompt},
 		},
@@ -172,6 +219,7 @@ func callAnthropic(ctx context.Context, client *anthropic.Client, cfg *Config, s
 	if err != nil {
 		return "", enrichAnthropicError(err)
 	}
+	reportUsage(ctx, anthropicUsage(resp.Usage))
 	if len(resp.Content) == 0 {
 		return "", errors.New("no content returned")
 	}
@@ -182,13 +230,24 @@ func callAnthropic(ctx context.Context, client *anthropic.Client, cfg *Config, s
 	return block.Text, nil
 }
 
+// anthropicUsage converts Anthropic usage. input_tokens excludes prompt-cache
+// reads and writes, so they are added to the input total.
+func anthropicUsage(u anthropic.Usage) tokenUsage {
+	return tokenUsage{
+		InputTokens:       u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens,
+		CachedInputTokens: u.CacheReadInputTokens,
+		OutputTokens:      u.OutputTokens,
+	}
+}
+
 // callOllama sends a generation request to the Ollama local API and returns
 // the response text.
 func callOllama(ctx context.Context, cfg *Config, systemPrompt, diffContent string) (string, error) {
 	reqBody := map[string]any{
-		"model":  cfg.OllamaModel,
-		"prompt": systemPrompt + "\n" + diffContent,
-		"stream": false,
+		"model":   cfg.OllamaModel,
+		"prompt":  systemPrompt + "\n" + diffContent,
+		"stream":  false,
+		"options": map[string]any{"num_ctx": ollamaContextWindow(cfg)},
 	}
 
 	buf, _ := json.Marshal(reqBody)
@@ -207,27 +266,47 @@ func callOllama(ctx context.Context, cfg *Config, systemPrompt, diffContent stri
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		var errResp struct {
-			Error string `json:"error"`
-		}
-		if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error != "" {
-			return "", fmt.Errorf("ollama API error: %s - %s", resp.Status, errResp.Error)
-		}
-		if len(body) > 0 {
-			return "", fmt.Errorf("ollama API error: %s - %s", resp.Status, string(body))
-		}
-		return "", fmt.Errorf("ollama API error: %s", resp.Status)
+		return "", ollamaStatusError(resp, body)
 	}
 
 	var result struct {
 		Response string `json:"response"`
+		ollamaUsage
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return "", err
 	}
+	result.report(ctx)
 	return result.Response, nil
 }
 
+// ollamaUsage holds the token counts Ollama includes in its final response.
+type ollamaUsage struct {
+	PromptEvalCount int64 `json:"prompt_eval_count"`
+	EvalCount       int64 `json:"eval_count"`
+}
+
+func (u ollamaUsage) report(ctx context.Context) {
+	if u.PromptEvalCount > 0 || u.EvalCount > 0 {
+		reportUsage(ctx, tokenUsage{InputTokens: u.PromptEvalCount, OutputTokens: u.EvalCount})
+	}
+}
+
+// ollamaStatusError builds the error for a non-200 Ollama response, using the
+// JSON "error" field when present and the raw body otherwise.
+func ollamaStatusError(resp *http.Response, body []byte) error {
+	var errResp struct {
+		Error string `json:"error"`
+	}
+	msg := "ollama API error: " + resp.Status
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error != "" {
+		msg += " - " + errResp.Error
+	} else if len(body) > 0 {
+		msg += " - " + string(body)
+	}
+	return &httpStatusError{StatusCode: resp.StatusCode, Header: resp.Header, msg: msg}
+}
+
 // callGemini sends a content generation request to the Google Gemini API and
 // returns the concatenated text from all response parts.
 func callGemini(ctx context.Context, cfg *Config, systemPrompt, diffContent string) (string, error) {
@@ -245,6 +324,7 @@ func callGemini(ctx context.Context, cfg *Config, systemPrompt, diffContent stri
 	if err != nil {
 		return "", enrichNetworkError(err)
 	}
+	reportGeminiUsage(ctx, resp)
 
 	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
 		return "", errors.New("no content returned from Gemini")
@@ -259,6 +339,18 @@ func callGemini(ctx context.Context, cfg *Config, systemPrompt, diffContent stri
 	return sb.String(), nil
 }
 
+// reportGeminiUsage reports the usage metadata of a Gemini response, if any.
+func reportGeminiUsage(ctx context.Context, resp *genai.GenerateContentResponse) {
+	if resp.UsageMetadata == nil {
+		return
+	}
+	reportUsage(ctx, tokenUsage{
+		InputTokens:       int64(resp.UsageMetadata.PromptTokenCount),
+		CachedInputTokens: int64(resp.UsageMetadata.CachedContentTokenCount),
+		OutputTokens:      int64(resp.UsageMetadata.CandidatesTokenCount),
+	})
+}
+
 // cliPrompt combines the system prompt and diff, stripping null bytes that
 // would cause exec to reject the argument with "invalid argument".
 func cliPrompt(systemPrompt, diffContent string) string {
@@ -442,123 +534,85 @@ func streamCodexCLI(ctx context.Context, cfg *Config, systemPrompt, diffContent
 }
 
 // validateAPIKey returns an error if the required API key for provider is missing.
+// Unknown providers are not an error here; validateProviderConfig rejects them.
 func validateAPIKey(provider ApiProvider, cfg *Config) error {
-	switch provider {
-	case OpenAI:
-		if cfg.OpenAIAPIKey == "" {
-			return errors.New("no OpenAI API key found\n\nSet the OPENAI_API_KEY environment variable or add 'openai_api_key' to ~/.ai-mr-comment.toml")
-		}
-	case Anthropic:
-		if cfg.AnthropicAPIKey == "" {
-			return errors.New("no Anthropic API key found\n\nSet the ANTHROPIC_API_KEY environment variable or add 'anthropic_api_key' to ~/.ai-mr-comment.toml")
-		}
-	case Gemini:
-		if cfg.GeminiAPIKey == "" {
-			return errors.New("no Gemini API key found\n\nSet the GEMINI_API_KEY environment variable or add 'gemini_api_key' to ~/.ai-mr-comment.toml")
-		}
+	p, ok := lookupProvider(provider)
+	if !ok {
+		return nil
 	}
-	return nil
+	return p.Validate(cfg)
 }
 
-// chatCompletions dispatches a prompt and diff to the appropriate provider and
+// chatCompletions dispatches a prompt and diff to the registered provider and
 // returns the generated comment.
+// Transient failures are retried, then handed to fallback_providers in order.
+// Each provider is checked against max_cost_per_run/max_cost_per_day first.
+// The provider that answered is reported to ctx; see withAnswerReport.
 func chatCompletions(ctx context.Context, cfg *Config, provider ApiProvider, systemPrompt, diffContent string) (string, error) {
-	if err := validateAPIKey(provider, cfg); err != nil {
-		return "", err
-	}
-	switch provider {
-	case OpenAI:
-		debugLog(cfg, "api: calling openai model=%s endpoint=%s mode=buffered", cfg.OpenAIModel, cfg.OpenAIEndpoint)
-		client := openai.NewClient(
-			openaiopt.WithAPIKey(cfg.OpenAIAPIKey),
-			openaiopt.WithBaseURL(cfg.OpenAIEndpoint),
-		)
-		return callOpenAI(ctx, &client, cfg, systemPrompt, diffContent)
-	case Anthropic:
-		debugLog(cfg, "api: calling anthropic model=%s endpoint=%s mode=buffered", cfg.AnthropicModel, cfg.AnthropicEndpoint)
-		client := anthropic.NewClient(
-			anthropicopt.WithAPIKey(cfg.AnthropicAPIKey),
-			anthropicopt.WithBaseURL(strings.TrimRight(cfg.AnthropicEndpoint, "/")+"/"),
-		)
-		return callAnthropic(ctx, &client, cfg, systemPrompt, diffContent)
-	case Ollama:
-		debugLog(cfg, "api: calling ollama model=%s endpoint=%s mode=buffered", cfg.OllamaModel, cfg.OllamaEndpoint)
-		return callOllama(ctx, cfg, systemPrompt, diffContent)
-	case Gemini:
-		debugLog(cfg, "api: calling gemini model=%s mode=buffered", cfg.GeminiModel)
-		return callGemini(ctx, cfg, systemPrompt, diffContent)
-	case ClaudeCLI:
-		debugLog(cfg, "api: calling claude-cli model=%s mode=buffered", cfg.ClaudeCLIModel)
-		return callClaudeCLI(ctx, cfg, systemPrompt, diffContent)
-	case GeminiCLI:
-		debugLog(cfg, "api: calling gemini-cli model=%s mode=buffered", cfg.GeminiCLIModel)
-		return callGeminiCLI(ctx, cfg, systemPrompt, diffContent)
-	case CodexCLI:
-		debugLog(cfg, "api: calling codex-cli model=%s mode=buffered", cfg.CodexCLIModel)
-		return callCodexCLI(ctx, cfg, systemPrompt, diffContent)
-	default:
-		return "", errors.New("unsupported provider")
+	result, answer, err := callWithFallback(ctx, cfg, provider, func(p Provider) (string, error) {
+		release, err := reserveBudget(ctx, cfg, p, systemPrompt, diffContent)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+		return withRetry(ctx, cfg, string(p.Name()), func() (string, error) {
+			return trackUsage(ctx, cfg, p, systemPrompt, diffContent, func(ctx context.Context) (string, error) {
+				return p.Call(ctx, cfg, systemPrompt, diffContent)
+			})
+		})
+	})
+	if err == nil {
+		reportAnswer(ctx, answer)
 	}
+	return result, err
 }
 
 // streamToWriter streams tokens from the AI provider to w as they arrive and
 // returns the full accumulated response. It is used when stdout is a TTY and
 // text output is selected. Callers should fall back to chatCompletions on error.
+// A failed stream is retried, or handed to a fallback provider, only if nothing
+// has been written to w yet.
 func streamToWriter(ctx context.Context, cfg *Config, provider ApiProvider, systemPrompt, diffContent string, w io.Writer) (string, error) {
-	if err := validateAPIKey(provider, cfg); err != nil {
-		return "", err
-	}
-	switch provider {
-	case OpenAI:
-		debugLog(cfg, "api: calling openai model=%s endpoint=%s mode=stream", cfg.OpenAIModel, cfg.OpenAIEndpoint)
-		client := openai.NewClient(
-			openaiopt.WithAPIKey(cfg.OpenAIAPIKey),
-			openaiopt.WithBaseURL(cfg.OpenAIEndpoint),
-		)
-		return streamOpenAI(ctx, &client, cfg, systemPrompt, diffContent, w)
-	case Anthropic:
-		debugLog(cfg, "api: calling anthropic model=%s endpoint=%s mode=stream", cfg.AnthropicModel, cfg.AnthropicEndpoint)
-		client := anthropic.NewClient(
-			anthropicopt.WithAPIKey(cfg.AnthropicAPIKey),
-			anthropicopt.WithBaseURL(strings.TrimRight(cfg.AnthropicEndpoint, "/")+"/"),
-		)
-		return streamAnthropic(ctx, &client, cfg, systemPrompt, diffContent, w)
-	case Ollama:
-		debugLog(cfg, "api: calling ollama model=%s endpoint=%s mode=stream", cfg.OllamaModel, cfg.OllamaEndpoint)
-		return streamOllama(ctx, cfg, systemPrompt, diffContent, w)
-	case Gemini:
-		debugLog(cfg, "api: calling gemini model=%s mode=stream", cfg.GeminiModel)
-		return streamGemini(ctx, cfg, systemPrompt, diffContent, w)
-	case ClaudeCLI:
-		debugLog(cfg, "api: calling claude-cli model=%s mode=stream", cfg.ClaudeCLIModel)
-		return streamClaudeCLI(ctx, cfg, systemPrompt, diffContent, w)
-	case GeminiCLI:
-		debugLog(cfg, "api: calling gemini-cli model=%s mode=stream", cfg.GeminiCLIModel)
-		return streamGeminiCLI(ctx, cfg, systemPrompt, diffContent, w)
-	case CodexCLI:
-		debugLog(cfg, "api: calling codex-cli model=%s mode=stream", cfg.CodexCLIModel)
-		return streamCodexCLI(ctx, cfg, systemPrompt, diffContent, w)
-	default:
-		return "", errors.New("unsupported provider")
+	cw := &countingWriter{w: w}
+	result, answer, err := callWithFallback(ctx, cfg, provider, func(p Provider) (string, error) {
+		release, err := reserveBudget(ctx, cfg, p, systemPrompt, diffContent)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+		return withRetry(ctx, cfg, string(p.Name())+" (stream)", func() (string, error) {
+			result, err := trackUsage(ctx, cfg, p, systemPrompt, diffContent, func(ctx context.Context) (string, error) {
+				return p.Stream(ctx, cfg, systemPrompt, diffContent, cw)
+			})
+			if err != nil && cw.n > 0 {
+				return "", &noRetryError{err: err}
+			}
+			return result, err
+		})
+	})
+	if err == nil {
+		reportAnswer(ctx, answer)
 	}
+	return result, err
 }
 
 // streamOpenAI streams a chat completion from OpenAI, writing each token to w.
 func streamOpenAI(ctx context.Context, client *openai.Client, cfg *Config, systemPrompt, diffContent string, w io.Writer) (string, error) {
-	stream := client.Chat.Completions.NewStreaming(ctx, openai.ChatCompletionNewParams{
-		Model: cfg.OpenAIModel,
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage(systemPrompt),
-			openai.UserMessage(diffContent),
-		},
-		Temperature: param.NewOpt(0.7),
-		MaxTokens:   param.NewOpt(int64(4000)),
-	})
+	return streamOpenAIChat(ctx, client, withStreamUsage(newOpenAIChatParams(cfg.OpenAIModel, systemPrompt, diffContent, openAIRequestOptions{})), w)
+}
+
+// streamOpenAIChat streams a prepared chat completion request, writing each token to w.
+func streamOpenAIChat(ctx context.Context, client *openai.Client, params openai.ChatCompletionNewParams, w io.Writer) (string, error) {
+	stream := client.Chat.Completions.NewStreaming(ctx, params)
 	defer func() { _ = stream.Close() }()
 
 	var sb strings.Builder
 	for stream.Next() {
 		chunk := stream.Current()
+		// With include_usage the final chunk has no choices, only usage.
+		if chunk.JSON.Usage.Valid() && chunk.Usage.TotalTokens > 0 {
+			reportUsage(ctx, openAIUsage(chunk.Usage))
+		}
 		if len(chunk.Choices) > 0 {
 			token := chunk.Choices[0].Delta.Content
 			_, _ = fmt.Fprint(w, token)
@@ -575,7 +629,7 @@ func streamOpenAI(ctx context.Context, client *openai.Client, cfg *Config, syste
 func streamAnthropic(ctx context.Context, client *anthropic.Client, cfg *Config, systemPrompt, diffContent string, w io.Writer) (string, error) {
 	stream := client.Messages.NewStreaming(ctx, anthropic.MessageNewParams{
 		Model:     anthropic.Model(cfg.AnthropicModel),
-		MaxTokens: 4000,
+		MaxTokens: maxResponseTokens,
 		System:    []anthropic.TextBlockParam{{Text: systemPrompt}},
 		Messages: []anthropic.MessageParam{
 			{
@@ -589,21 +643,31 @@ func streamAnthropic(ctx context.Context, client *anthropic.Client, cfg *Config,
 	defer func() { _ = stream.Close() }()
 
 	var sb strings.Builder
+	var usage tokenUsage
 	for stream.Next() {
 		event := stream.Current()
-		if delta, ok := event.AsAny().(anthropic.ContentBlockDeltaEvent); ok {
+		switch ev := event.AsAny().(type) {
+		case anthropic.ContentBlockDeltaEvent:
 			// Only text_delta events carry text; other delta types (input_json_delta,
 			// thinking_delta, etc.) are silently skipped.
-			if delta.Delta.Type == "text_delta" {
-				token := delta.Delta.AsTextDelta().Text
+			if ev.Delta.Type == "text_delta" {
+				token := ev.Delta.AsTextDelta().Text
 				_, _ = fmt.Fprint(w, token)
 				sb.WriteString(token)
 			}
+		case anthropic.MessageStartEvent:
+			usage = anthropicUsage(ev.Message.Usage)
+		case anthropic.MessageDeltaEvent:
+			// output_tokens is cumulative, so the last delta holds the total.
+			usage.OutputTokens = ev.Usage.OutputTokens
 		}
 	}
 	if err := stream.Err(); err != nil {
 		return "", enrichAnthropicError(err)
 	}
+	if usage.InputTokens > 0 || usage.OutputTokens > 0 {
+		reportUsage(ctx, usage)
+	}
 	return sb.String(), nil
 }
 
@@ -631,6 +695,8 @@ func streamGemini(ctx context.Context, cfg *Config, systemPrompt, diffContent st
 		if err != nil {
 			return "", enrichNetworkError(err)
 		}
+		// Each chunk carries the running totals, so the last one wins.
+		reportGeminiUsage(ctx, resp)
 		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
 			continue
 		}
@@ -649,9 +715,10 @@ func streamGemini(ctx context.Context, cfg *Config, systemPrompt, diffContent st
 // writing each response token to w.
 func streamOllama(ctx context.Context, cfg *Config, systemPrompt, diffContent string, w io.Writer) (string, error) {
 	reqBody := map[string]any{
-		"model":  cfg.OllamaModel,
-		"prompt": systemPrompt + "\n" + diffContent,
-		"stream": true,
+		"model":   cfg.OllamaModel,
+		"prompt":  systemPrompt + "\n" + diffContent,
+		"stream":  true,
+		"options": map[string]any{"num_ctx": ollamaContextWindow(cfg)},
 	}
 
 	buf, _ := json.Marshal(reqBody)
@@ -669,22 +736,14 @@ func streamOllama(ctx context.Context, cfg *Config, systemPrompt, diffContent st
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		var errResp struct {
-			Error string `json:"error"`
-		}
-		if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error != "" {
-			return "", fmt.Errorf("ollama API error: %s - %s", resp.Status, errResp.Error)
-		}
-		if len(body) > 0 {
-			return "", fmt.Errorf("ollama API error: %s - %s", resp.Status, string(body))
-		}
-		return "", fmt.Errorf("ollama API error: %s", resp.Status)
+		return "", ollamaStatusError(resp, body)
 	}
 
 	var sb strings.Builder
 	var chunk struct {
 		Response string `json:"response"`
 		Done     bool   `json:"done"`
+		ollamaUsage
 	}
 	scanner := bufio.NewScanner(resp.Body)
 	// Ollama can emit large JSON lines, so raise scanner limit above default 64K.
@@ -702,6 +761,7 @@ func streamOllama(ctx context.Context, cfg *Config, systemPrompt, diffContent st
 		_, _ = fmt.Fprint(w, chunk.Response)
 		sb.WriteString(chunk.Response)
 		if chunk.Done {
+			chunk.report(ctx)
 			break
 		}
 	}
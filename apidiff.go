@@ -0,0 +1,692 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// api-diff type-checks the Go packages a change touches at both revisions and
+// compares their exported API. Imports are type-checked from source, so a
+// dependency missing from the module cache shows up as a type error and an
+// "invalid type" in the signatures that use it.
+const (
+	// maxAPIDiffTypeErrors caps the type errors kept in a report.
+	maxAPIDiffTypeErrors = 20
+	// maxAPIDiffPromptChanges caps the changes listed in the prompt.
+	maxAPIDiffPromptChanges = 60
+)
+
+// apiSymbol is one exported part of a package's API.
+type apiSymbol struct {
+	kind string // "func", "method", "type", "field", "const" or "var"
+	// name is Name, Type.Method or Type.Field.
+	name string
+	// text is how the symbol is shown; key is what is compared, which leaves
+	// out parameter names and constant values.
+	text, key string
+	// interfaceMethod is set for the methods of an interface type, which
+	// every implementation has to add.
+	interfaceMethod bool
+}
+
+// apiDiffChange is one difference in a package's exported API.
+type apiDiffChange struct {
+	Package  string `json:"package"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Change   string `json:"change"` // "added", "removed" or "changed"
+	Before   string `json:"before,omitempty"`
+	After    string `json:"after,omitempty"`
+	Breaking bool   `json:"breaking"`
+}
+
+// apiDiffReport is the result of api-diff and of --api-diff.
+type apiDiffReport struct {
+	From       string          `json:"from"`
+	To         string          `json:"to"`
+	Packages   []string        `json:"packages"`
+	Changes    []apiDiffChange `json:"changes"`
+	Breaking   int             `json:"breaking"`
+	TypeErrors []string        `json:"type_errors,omitempty"`
+}
+
+// typeParamsString renders a type parameter list such as "[K comparable, V any]".
+func typeParamsString(tps *types.TypeParamList, qual types.Qualifier) string {
+	if tps.Len() == 0 {
+		return ""
+	}
+	parts := make([]string, tps.Len())
+	for i := range tps.Len() {
+		tp := tps.At(i)
+		parts[i] = tp.Obj().Name() + " " + types.TypeString(tp.Constraint(), qual)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// signatureKey renders sig without parameter names, so renaming one is not
+// an API change.
+func signatureKey(sig *types.Signature, qual types.Qualifier) string {
+	tuple := func(t *types.Tuple, variadic bool) string {
+		parts := make([]string, t.Len())
+		for i := range t.Len() {
+			typ := t.At(i).Type()
+			if s, ok := typ.(*types.Slice); ok && variadic && i == t.Len()-1 {
+				parts[i] = "..." + types.TypeString(s.Elem(), qual)
+				continue
+			}
+			parts[i] = types.TypeString(typ, qual)
+		}
+		return "(" + strings.Join(parts, ", ") + ")"
+	}
+	return typeParamsString(sig.TypeParams(), qual) + tuple(sig.Params(), sig.Variadic()) + " " + tuple(sig.Results(), false)
+}
+
+// packageAPI lists the exported API of pkg by name. Types of pkg are written
+// unqualified and other packages by name.
+func packageAPI(pkg *types.Package) map[string]apiSymbol {
+	qual := func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		return p.Name()
+	}
+	api := map[string]apiSymbol{}
+	add := func(s apiSymbol) {
+		if s.key == "" {
+			s.key = s.text
+		}
+		api[s.name] = s
+	}
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		switch obj := scope.Lookup(name).(type) {
+		case *types.Func:
+			if !obj.Exported() {
+				continue
+			}
+			sig := obj.Type().(*types.Signature)
+			add(apiSymbol{
+				kind: "func", name: name,
+				text: "func " + name + strings.TrimPrefix(types.TypeString(sig, qual), "func"),
+				key:  "func " + name + signatureKey(sig, qual),
+			})
+		case *types.Const:
+			if obj.Exported() {
+				text := types.ObjectString(obj, qual)
+				add(apiSymbol{kind: "const", name: name, text: text + " = " + obj.Val().ExactString(), key: text})
+			}
+		case *types.Var:
+			if obj.Exported() {
+				add(apiSymbol{kind: "var", name: name, text: types.ObjectString(obj, qual)})
+			}
+		case *types.TypeName:
+			if obj.Exported() {
+				typeAPI(add, obj, qual)
+			}
+		}
+	}
+	return api
+}
+
+// typeAPI adds a type, its exported fields or interface methods, and its
+// exported methods.
+func typeAPI(add func(apiSymbol), obj *types.TypeName, qual types.Qualifier) {
+	name := obj.Name()
+	named, ok := obj.Type().(*types.Named)
+	if obj.IsAlias() || !ok {
+		add(apiSymbol{kind: "type", name: name, text: "type " + name + " = " + types.TypeString(obj.Type(), qual)})
+		return
+	}
+	head := "type " + name + typeParamsString(named.TypeParams(), qual)
+	switch u := named.Underlying().(type) {
+	case *types.Struct:
+		add(apiSymbol{kind: "type", name: name, text: head + " struct"})
+		for i := range u.NumFields() {
+			f := u.Field(i)
+			if !f.Exported() {
+				continue
+			}
+			text := "field " + name + "." + f.Name() + " " + types.TypeString(f.Type(), qual)
+			if f.Embedded() {
+				text = "field " + name + " embeds " + types.TypeString(f.Type(), qual)
+			}
+			add(apiSymbol{kind: "field", name: name + "." + f.Name(), text: text})
+		}
+	case *types.Interface:
+		add(apiSymbol{kind: "type", name: name, text: head + " interface"})
+		for i := range u.NumMethods() {
+			m := u.Method(i)
+			if !m.Exported() {
+				continue
+			}
+			sig := m.Type().(*types.Signature)
+			add(apiSymbol{
+				kind: "method", name: name + "." + m.Name(), interfaceMethod: true,
+				text: "method " + name + "." + m.Name() + strings.TrimPrefix(types.TypeString(sig, qual), "func"),
+				key:  "method " + name + "." + m.Name() + signatureKey(sig, qual),
+			})
+		}
+	default:
+		add(apiSymbol{kind: "type", name: name, text: head + " " + types.TypeString(u, qual)})
+	}
+	for i := range named.NumMethods() {
+		m := named.Method(i)
+		if !m.Exported() {
+			continue
+		}
+		sig := m.Type().(*types.Signature)
+		recv := "func (" + types.TypeString(sig.Recv().Type(), qual) + ") " + m.Name()
+		add(apiSymbol{
+			kind: "method", name: name + "." + m.Name(),
+			text: recv + strings.TrimPrefix(types.TypeString(sig, qual), "func"),
+			key:  recv + signatureKey(sig, qual),
+		})
+	}
+}
+
+// diffPackageAPI compares two versions of a package's API; either may be
+// nil for a package that was added or removed. Removing or changing a
+// symbol breaks callers, and so does adding a method to an existing
+// interface. A constant whose value alone changed is reported but not
+// breaking.
+func diffPackageAPI(pkgPath string, before, after map[string]apiSymbol) []apiDiffChange {
+	var changes []apiDiffChange
+	names := slices.Sorted(func(yield func(string) bool) {
+		for n := range before {
+			if !yield(n) {
+				return
+			}
+		}
+		for n := range after {
+			if _, dup := before[n]; !dup && !yield(n) {
+				return
+			}
+		}
+	})
+	for _, name := range names {
+		b, hadBefore := before[name]
+		a, hasAfter := after[name]
+		c := apiDiffChange{Package: pkgPath, Name: name}
+		switch {
+		case !hadBefore:
+			typeName, _, _ := strings.Cut(name, ".")
+			_, hadType := before[typeName]
+			c.Kind, c.Change, c.After, c.Breaking = a.kind, "added", a.text, a.interfaceMethod && hadType
+		case !hasAfter:
+			c.Kind, c.Change, c.Before, c.Breaking = b.kind, "removed", b.text, true
+		case b.key != a.key:
+			c.Kind, c.Change, c.Before, c.After, c.Breaking = a.kind, "changed", b.text, a.text, true
+		case b.text != a.text && a.kind == "const":
+			c.Kind, c.Change, c.Before, c.After = a.kind, "changed", b.text, a.text
+		default:
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// apiTree is a source tree at one revision whose packages are type-checked
+// on demand. The importer caches the packages it has checked.
+type apiTree struct {
+	root     string
+	module   string
+	fset     *token.FileSet
+	importer types.Importer
+}
+
+func newAPITree(root string) *apiTree {
+	fset := token.NewFileSet()
+	return &apiTree{root: root, module: goModulePath(root), fset: fset, importer: importer.ForCompiler(fset, "source", nil)}
+}
+
+// goModulePath reads the module path from root's go.mod, or returns "".
+func goModulePath(root string) string {
+	f, err := os.Open(filepath.Join(root, "go.mod")) //nolint:gosec // G304: go.mod of the repository being compared
+	if err != nil {
+		return ""
+	}
+	defer func() { _ = f.Close() }()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if mod, ok := strings.CutPrefix(strings.TrimSpace(sc.Text()), "module "); ok {
+			return strings.Trim(strings.TrimSpace(mod), `"`)
+		}
+	}
+	return ""
+}
+
+// importPath is the path of the package in dir, relative to the tree.
+func (t *apiTree) importPath(dir string) string {
+	if t.module == "" {
+		return dir
+	}
+	return path.Join(t.module, dir)
+}
+
+// load type-checks the package in dir. It returns a nil package when dir
+// has no Go package or holds a main package, which has no importable API.
+// Type errors do not stop the check; they are returned with the package.
+func (t *apiTree) load(dir string) (*types.Package, []string, error) {
+	bp, err := build.Default.ImportDir(filepath.Join(t.root, filepath.FromSlash(dir)), 0)
+	var noGo *build.NoGoError
+	if errors.As(err, &noGo) || errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if bp.Name == "main" {
+		return nil, nil, nil
+	}
+	var files []*ast.File
+	for _, name := range append(bp.GoFiles, bp.CgoFiles...) {
+		f, err := parser.ParseFile(t.fset, filepath.Join(bp.Dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, f)
+	}
+	var typeErrors []string
+	conf := types.Config{
+		Importer: t.importer,
+		Error: func(err error) {
+			typeErrors = append(typeErrors, err.Error())
+		},
+	}
+	pkg, _ := conf.Check(t.importPath(dir), t.fset, files, nil)
+	return pkg, typeErrors, nil
+}
+
+// extractGoTree writes the Go files of ref in the repository at root, with
+// the go.mod, go.sum and vendor/modules.txt files the type checker needs,
+// below dir.
+func extractGoTree(root, ref, dir string) error {
+	// git archive run in a subdirectory archives only that subdirectory.
+	cmd := exec.Command("git", "-C", root, "archive", "--format=tar", ref) //nolint:gosec // G204: git is a fixed binary, ref is a revision the user asked for
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	tr := tar.NewReader(stdout)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = cmd.Wait()
+			return fmt.Errorf("reading %s: %w: %s", ref, err, strings.TrimSpace(stderr.String()))
+		}
+		name := filepath.FromSlash(hdr.Name)
+		base := path.Base(hdr.Name)
+		wanted := strings.HasSuffix(base, ".go") || base == "go.mod" || base == "go.sum" || base == "modules.txt"
+		if hdr.Typeflag != tar.TypeReg || !wanted || !filepath.IsLocal(name) {
+			continue
+		}
+		target := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		f, err := os.Create(target) //nolint:gosec // G304: target is a local path below the temporary directory
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr) //nolint:gosec // G110: the archive is the user's own repository
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("git archive %s: %w: %s", ref, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// changedGoDirs lists the directories with non-test Go files that differ
+// between from and to (the working tree, with untracked files, when empty).
+// testdata and vendor directories are left out.
+func changedGoDirs(from, to string) ([]string, error) {
+	args := []string{"diff", "--name-only", "--no-renames", "-z", from}
+	if to != "" {
+		args = append(args, to)
+	}
+	out, err := exec.Command("git", args...).Output() //nolint:gosec // G204: git is a fixed binary, the revisions were verified
+	if err != nil {
+		return nil, fmt.Errorf("listing changed files: %w", err)
+	}
+	paths := strings.Split(string(out), "\x00")
+	if to == "" {
+		untracked, err := listUntrackedFiles(repoRoot())
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, untracked...)
+	}
+	var dirs []string
+	for _, p := range paths {
+		if !strings.HasSuffix(p, ".go") || strings.HasSuffix(p, "_test.go") {
+			continue
+		}
+		d := path.Dir(p)
+		parts := strings.Split(d, "/")
+		if slices.Contains(parts, "testdata") || slices.Contains(parts, "vendor") || slices.Contains(dirs, d) {
+			continue
+		}
+		dirs = append(dirs, d)
+	}
+	slices.Sort(dirs)
+	return dirs, nil
+}
+
+// verifyCommit resolves ref to a commit hash.
+func verifyCommit(ref string) (string, error) {
+	out, err := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}").Output() //nolint:gosec // G204: git is a fixed binary, ref is a revision the user asked for
+	if err != nil {
+		return "", fmt.Errorf("unknown revision %q", ref)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// apiDiffRefs returns the revisions to compare for commit, read as --commit
+// is elsewhere: "A..B" compares A with B, "A...B" their merge base with B,
+// and a single commit its parent with it. An empty commit compares the merge
+// base with base, or HEAD without one, with the working tree (an empty to),
+// like the working-tree diff.
+// from is empty when there is nothing before, such as for a root commit.
+func apiDiffRefs(commit string, base gitBase) (from, to string, err error) {
+	resolve := func(ref string) (string, error) {
+		if ref == "" {
+			ref = "HEAD"
+		}
+		return verifyCommit(ref)
+	}
+	switch {
+	case strings.Contains(commit, "..."):
+		a, b, _ := strings.Cut(commit, "...")
+		if to, err = resolve(b); err != nil {
+			return "", "", err
+		}
+		if a, err = resolve(a); err != nil {
+			return "", "", err
+		}
+		mb, ok := mergeBaseOf(a, to)
+		if !ok {
+			return "", "", fmt.Errorf("no merge base for %q", commit)
+		}
+		return mb, to, nil
+	case strings.Contains(commit, ".."):
+		a, b, _ := strings.Cut(commit, "..")
+		if from, err = resolve(a); err != nil {
+			return "", "", err
+		}
+		if to, err = resolve(b); err != nil {
+			return "", "", err
+		}
+		return from, to, nil
+	case commit != "":
+		if to, err = verifyCommit(commit); err != nil {
+			return "", "", err
+		}
+		from, _ = verifyCommit(to + "^")
+		return from, to, nil
+	}
+	if mb, err := getAutoMergeBase(base); err == nil {
+		return mb, "", nil
+	}
+	if hasCommits() {
+		return "HEAD", "", nil
+	}
+	return "", "", nil
+}
+
+// mergeBaseOf returns the merge base of a and b.
+func mergeBaseOf(a, b string) (string, bool) {
+	out, err := exec.Command("git", "merge-base", a, b).Output() //nolint:gosec // G204: git is a fixed binary, a and b are verified commits
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(out)), true
+}
+
+// computeAPIDiff compares the exported API of the Go packages changed
+// between from and to. An empty from has no packages; an empty to is the
+// working tree.
+func computeAPIDiff(cfg *Config, from, to string) (*apiDiffReport, error) {
+	report := &apiDiffReport{From: from, To: to, Packages: []string{}, Changes: []apiDiffChange{}}
+	if to == "" {
+		report.To = "working tree"
+	}
+	emptyTree := ""
+	if from == "" {
+		// Diff against git's empty tree, so a root commit's files all count.
+		out, err := exec.Command("git", "hash-object", "-t", "tree", devNull).Output()
+		if err != nil {
+			return nil, fmt.Errorf("resolving the empty tree: %w", err)
+		}
+		emptyTree = strings.TrimSpace(string(out))
+		report.From = "(none)"
+	}
+	dirs, err := changedGoDirs(cmp.Or(from, emptyTree), to)
+	if err != nil {
+		return nil, err
+	}
+	debugLog(cfg, "api-diff: from=%s to=%s packages=%d", report.From, report.To, len(dirs))
+	if len(dirs) == 0 {
+		return report, nil
+	}
+
+	tmp, err := os.MkdirTemp("", "ai-mr-comment-api-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+	root := repoRoot()
+	tree := func(ref, name string) (*apiTree, error) {
+		if ref == "" {
+			return newAPITree(root), nil
+		}
+		dir := filepath.Join(tmp, name)
+		if err := extractGoTree(root, ref, dir); err != nil {
+			return nil, err
+		}
+		return newAPITree(dir), nil
+	}
+	var before *apiTree
+	if from != "" {
+		if before, err = tree(from, "from"); err != nil {
+			return nil, err
+		}
+	}
+	after, err := tree(to, "to")
+	if err != nil {
+		return nil, err
+	}
+
+	addTypeErrors := func(label string, errs []string) {
+		for _, e := range errs {
+			if len(report.TypeErrors) < maxAPIDiffTypeErrors {
+				report.TypeErrors = append(report.TypeErrors, label+": "+e)
+			}
+		}
+	}
+	for _, dir := range dirs {
+		var beforeAPI, afterAPI map[string]apiSymbol
+		pkgPath := after.importPath(dir)
+		if before != nil {
+			pkg, typeErrors, err := before.load(dir)
+			if err != nil {
+				return nil, fmt.Errorf("loading %s at %s: %w", dir, from, err)
+			}
+			if pkg != nil {
+				beforeAPI, pkgPath = packageAPI(pkg), before.importPath(dir)
+				addTypeErrors(report.From, typeErrors)
+			}
+		}
+		pkg, typeErrors, err := after.load(dir)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s at %s: %w", dir, report.To, err)
+		}
+		if pkg != nil {
+			afterAPI, pkgPath = packageAPI(pkg), after.importPath(dir)
+			addTypeErrors(report.To, typeErrors)
+		}
+		if beforeAPI == nil && afterAPI == nil {
+			continue
+		}
+		report.Packages = append(report.Packages, pkgPath)
+		for _, c := range diffPackageAPI(pkgPath, beforeAPI, afterAPI) {
+			if c.Breaking {
+				report.Breaking++
+			}
+			report.Changes = append(report.Changes, c)
+		}
+	}
+	debugLog(cfg, "api-diff: changes=%d breaking=%d type-errors=%d", len(report.Changes), report.Breaking, len(report.TypeErrors))
+	return report, nil
+}
+
+// buildAPIDiff resolves commit as apiDiffRefs does and computes the report.
+func buildAPIDiff(cfg *Config, commit string, base gitBase) (*apiDiffReport, error) {
+	from, to, err := apiDiffRefs(commit, base)
+	if err != nil {
+		return nil, err
+	}
+	return computeAPIDiff(cfg, from, to)
+}
+
+// lines renders the report as text, at most limit changes (all when 0).
+func (r *apiDiffReport) lines(limit int) []string {
+	lines := []string{fmt.Sprintf("Go API changes from %s to %s: %d breaking, %d other, in %d package(s)",
+		r.From, r.To, r.Breaking, len(r.Changes)-r.Breaking, len(r.Packages))}
+	for i, c := range r.Changes {
+		if limit > 0 && i == limit {
+			lines = append(lines, fmt.Sprintf("- ... and %d more", len(r.Changes)-limit))
+			break
+		}
+		text := c.After
+		if c.Change == "removed" {
+			text = c.Before
+		}
+		line := "- " + c.Package + ": " + c.Change + " " + text
+		if c.Breaking {
+			line += " [BREAKING]"
+		}
+		lines = append(lines, line)
+		if c.Change == "changed" {
+			lines = append(lines, "  was "+c.Before)
+		}
+	}
+	return lines
+}
+
+// addAPIDiff puts the report at the end of diff's preamble, asking the model
+// to call out the breaking changes. An empty report adds nothing.
+func addAPIDiff(diff string, r *apiDiffReport) string {
+	if len(r.Changes) == 0 {
+		return diff
+	}
+	lines := r.lines(maxAPIDiffPromptChanges)
+	if r.Breaking > 0 {
+		lines = append(lines, "The [BREAKING] changes break existing importers of these packages; call out each one and weigh them in any verdict.")
+	}
+	d := parseDiff(diff)
+	d.Preamble = append(d.Preamble, append(lines, "")...)
+	return d.String()
+}
+
+// newAPIDiffCmd returns the api-diff subcommand, which reports the exported
+// Go API changes of a commit range without calling a provider.
+func newAPIDiffCmd() *cobra.Command {
+	var commit, baseBranch, format, profileName string
+	var exitCode bool
+
+	cmd := &cobra.Command{
+		Use:   "api-diff",
+		Short: "Report exported Go API changes between two revisions",
+		Long: `Type-checks the Go packages changed between two revisions and lists the
+exported functions, types, methods, struct fields, constants and variables
+that were added, removed or changed, per package. Removals, signature changes
+and new interface methods are marked breaking. No provider is called.
+
+--commit takes a range (A..B or A...B) or a single commit, compared with its
+parent. Without it, the working tree is compared with the branch's merge base.
+
+Examples:
+  ai-mr-comment api-diff --commit=v1.2.0..HEAD
+  ai-mr-comment api-diff --commit=v1.2.0..HEAD --format=json
+  ai-mr-comment api-diff --exit-code`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return withExitCode(4, fmt.Errorf("invalid --format %q: must be text or json", format))
+			}
+			if commit != "" && baseBranch != "" {
+				return withExitCode(4, errors.New("--base cannot be combined with --commit"))
+			}
+			cfg, err := loadConfigForProfile(profileName)
+			if err != nil {
+				return err
+			}
+			if !isGitRepo() {
+				return fmt.Errorf("not a git repository. Run api-diff from inside a git repo")
+			}
+			report, err := buildAPIDiff(cfg, commit, newGitBase(cmd.Context(), cfg, baseBranch))
+			if err != nil {
+				return err
+			}
+			if len(report.TypeErrors) > 0 {
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %d type error(s), the report may be incomplete. First: %s\n", len(report.TypeErrors), report.TypeErrors[0])
+			}
+
+			out := cmd.OutOrStdout()
+			if format == "json" {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(report); err != nil {
+					return err
+				}
+			} else {
+				for _, line := range report.lines(0) {
+					_, _ = fmt.Fprintln(out, line)
+				}
+			}
+			if exitCode && report.Breaking > 0 {
+				return withExitCode(2, fmt.Errorf("%d breaking API change(s)", report.Breaking))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&commit, "commit", "", "Commit or commit range to compare (e.g. v1.2.0..HEAD)")
+	cmd.Flags().StringVar(&baseBranch, "base", "", "Branch whose merge base the working tree is compared with (default: base_branch, or the detected default branch)")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format: text or json")
+	cmd.Flags().BoolVar(&exitCode, "exit-code", false, "Exit with code 2 when there are breaking changes")
+	cmd.Flags().StringVar(&profileName, "profile", "", "Named config profile to activate")
+	return cmd
+}
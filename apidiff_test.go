@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// apiV1 and apiV2 are two versions of example.com/lib/api. Between them New
+// only renames its parameter, which is not an API change.
+const apiV1 = `package api
+
+import "io"
+
+const Version = "1"
+
+type Client struct {
+	Name    string
+	Retries int
+	secret  string
+}
+
+func (c *Client) Do(r io.Reader) error { return nil }
+
+type Store interface {
+	Get(key string) (string, error)
+}
+
+func Legacy() {}
+
+func New(name string) *Client { return &Client{Name: name} }
+`
+
+const apiV2 = `package api
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+const Version = "2"
+
+type Client struct {
+	Name    string
+	Timeout time.Duration
+	secret  int
+}
+
+func (c *Client) Do(ctx context.Context, r io.Reader) error { return nil }
+
+type Store interface {
+	Get(key string) (string, error)
+	Put(key, value string) error
+}
+
+func New(n string) *Client { return &Client{Name: n} }
+
+type Option func(*Client)
+
+func Open(path string, opts ...Option) (*Client, error) { return nil, nil }
+`
+
+// initAPIDiffRepo commits apiV1, with a main package beside it, tags it v1
+// and commits apiV2 on top.
+func initAPIDiffRepo(t *testing.T) string {
+	t.Helper()
+	dir := initEmptyRepo(t)
+	write := func(name, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/lib\n\ngo 1.22\n")
+	write("api/api.go", apiV1)
+	write("cmd/tool/main.go", "package main\n\nfunc main() {}\n")
+	runGit(t, "add", ".")
+	runGit(t, "commit", "-m", "v1")
+	runGit(t, "tag", "v1")
+
+	write("api/api.go", apiV2)
+	write("cmd/tool/main.go", "package main\n\nfunc Run() {}\n\nfunc main() { Run() }\n")
+	runGit(t, "commit", "-am", "v2")
+	return dir
+}
+
+func TestComputeAPIDiff(t *testing.T) {
+	initAPIDiffRepo(t)
+	report, err := buildAPIDiff(&Config{}, "v1..HEAD", gitBase{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(report.lines(0)[1:], "\n")
+	want := `- example.com/lib/api: changed func (*Client) Do(ctx context.Context, r io.Reader) error [BREAKING]
+  was func (*Client) Do(r io.Reader) error
+- example.com/lib/api: removed field Client.Retries int [BREAKING]
+- example.com/lib/api: added field Client.Timeout time.Duration
+- example.com/lib/api: removed func Legacy() [BREAKING]
+- example.com/lib/api: added func Open(path string, opts ...Option) (*Client, error)
+- example.com/lib/api: added type Option func(*Client)
+- example.com/lib/api: added method Store.Put(key string, value string) error [BREAKING]
+- example.com/lib/api: changed const Version untyped string = "2"
+  was const Version untyped string = "1"`
+	if got != want {
+		t.Errorf("got:\n%s\n\nwant:\n%s", got, want)
+	}
+	if report.Breaking != 4 || len(report.Packages) != 1 || len(report.TypeErrors) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if !strings.HasSuffix(report.lines(0)[0], ": 4 breaking, 4 other, in 1 package(s)") {
+		t.Errorf("unexpected summary %q", report.lines(0)[0])
+	}
+	if lines := report.lines(2); lines[len(lines)-1] != "- ... and 6 more" {
+		t.Errorf("expected the list capped, got %q", lines)
+	}
+}
+
+func TestComputeAPIDiff_RootCommitAndWorkingTree(t *testing.T) {
+	dir := initAPIDiffRepo(t)
+	report, err := buildAPIDiff(&Config{}, "v1", gitBase{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.From != "(none)" || report.Breaking != 0 || len(report.Changes) != 9 {
+		t.Errorf("expected v1's API all added, got %+v", report.lines(0))
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "api", "extra.go"), []byte("package api\n\nfunc Extra() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	report, err = buildAPIDiff(&Config{}, "", gitBase{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.To != "working tree" || len(report.Changes) != 1 || report.Changes[0].After != "func Extra()" {
+		t.Errorf("expected the untracked file's function, got %+v", report.lines(0))
+	}
+}
+
+func TestComputeAPIDiff_FromSubdirectory(t *testing.T) {
+	dir := initAPIDiffRepo(t)
+	t.Chdir(filepath.Join(dir, "api"))
+	report, err := buildAPIDiff(&Config{}, "v1..HEAD", gitBase{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Breaking != 4 || len(report.Packages) != 1 || report.Packages[0] != "example.com/lib/api" {
+		t.Errorf("expected the same report as from the root, got %+v", report.lines(0))
+	}
+}
+
+func TestAPIDiffRefs(t *testing.T) {
+	initAPIDiffRepo(t)
+	rev := func(ref string) string {
+		hash, err := verifyCommit(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	tests := []struct{ commit, from, to string }{
+		{"v1..HEAD", rev("v1"), rev("HEAD")},
+		{"v1..", rev("v1"), rev("HEAD")},
+		{"v1...HEAD", rev("v1"), rev("HEAD")},
+		{"HEAD", rev("v1"), rev("HEAD")},
+		{"v1", "", rev("v1")},
+		{"", "HEAD", ""},
+	}
+	for _, tt := range tests {
+		from, to, err := apiDiffRefs(tt.commit, gitBase{})
+		if err != nil || from != tt.from || to != tt.to {
+			t.Errorf("apiDiffRefs(%q) = %q, %q, %v; want %q, %q", tt.commit, from, to, err, tt.from, tt.to)
+		}
+	}
+	if _, _, err := apiDiffRefs("nope..HEAD", gitBase{}); err == nil {
+		t.Error("expected an error for an unknown revision")
+	}
+}
+
+func TestAPIDiffCmd(t *testing.T) {
+	initAPIDiffRepo(t)
+
+	var out strings.Builder
+	cmd := newAPIDiffCmd()
+	cmd.SetArgs([]string{"--commit=v1..HEAD", "--format=json"})
+	cmd.SetOut(&out)
+	cmd.SetErr(io.Discard)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var report apiDiffReport
+	if err := json.Unmarshal([]byte(out.String()), &report); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if report.Breaking != 4 || report.Changes[0].Kind != "method" || report.Changes[0].Change != "changed" {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	cmd = newAPIDiffCmd()
+	cmd.SetArgs([]string{"--commit=v1..HEAD", "--exit-code"})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	if err := cmd.Execute(); exitCodeOf(err) != 2 {
+		t.Errorf("expected exit code 2 for breaking changes, got %v", err)
+	}
+
+	cmd = newAPIDiffCmd()
+	cmd.SetArgs([]string{"--format=yaml"})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	if err := cmd.Execute(); exitCodeOf(err) != 4 {
+		t.Errorf("expected a usage error, got %v", err)
+	}
+}
+
+func TestRootCmd_APIDiff(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "dummy")
+	initAPIDiffRepo(t)
+
+	for _, enabled := range []bool{false, true} {
+		args := []string{"--provider=openai", "--print-request", "--commit=v1..HEAD"}
+		if enabled {
+			args = append(args, "--api-diff")
+		}
+		var out strings.Builder
+		cmd := newRootCmd(func(context.Context, *Config, ApiProvider, string, string) (string, error) {
+			return "ok", nil
+		})
+		cmd.SetArgs(args)
+		cmd.SetOut(&out)
+		cmd.SetErr(io.Discard)
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var payload struct {
+			Diff string `json:"diff"`
+		}
+		if err := json.Unmarshal([]byte(out.String()), &payload); err != nil {
+			t.Fatalf("invalid JSON %q: %v", out.String(), err)
+		}
+		got := strings.Contains(payload.Diff, "- example.com/lib/api: removed func Legacy() [BREAKING]\n")
+		if got != enabled {
+			t.Errorf("api-diff=%v: report present=%v in:\n%s", enabled, got, payload.Diff)
+		}
+	}
+}
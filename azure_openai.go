@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	openai "github.com/openai/openai-go"
+	openaiopt "github.com/openai/openai-go/option"
+)
+
+func init() {
+	RegisterProvider(azureOpenAIProvider{})
+}
+
+// azureOpenAIProvider calls Azure OpenAI, which serves chat completions at
+// {endpoint}/openai/deployments/{deployment}/chat/completions?api-version=…
+// and authenticates with an api-key header instead of a bearer token. The
+// request and response bodies are the same as OpenAI's, so it reuses the
+// OpenAI request path.
+type azureOpenAIProvider struct{}
+
+func (azureOpenAIProvider) Name() ApiProvider { return AzureOpenAI }
+
+// deploymentURL returns the base URL for the configured deployment, e.g.
+// https://my-resource.openai.azure.com/openai/deployments/gpt-4o/.
+func (azureOpenAIProvider) deploymentURL(cfg *Config) string {
+	return strings.TrimRight(cfg.AzureEndpoint, "/") + "/openai/deployments/" + url.PathEscape(cfg.AzureDeployment) + "/"
+}
+
+// client builds an OpenAI SDK client pointed at the Azure deployment. The
+// Authorization and organization headers the SDK derives from OPENAI_* env
+// vars are removed so OpenAI credentials are never sent to Azure.
+func (p azureOpenAIProvider) client(cfg *Config) openai.Client {
+	return openai.NewClient(
+		openaiopt.WithBaseURL(p.deploymentURL(cfg)),
+		openaiopt.WithQuery("api-version", cfg.AzureAPIVersion),
+		openaiopt.WithHeaderDel("Authorization"),
+		openaiopt.WithHeaderDel("OpenAI-Organization"),
+		openaiopt.WithHeaderDel("OpenAI-Project"),
+		openaiopt.WithHeader("api-key", cfg.AzureAPIKey),
+		openaiopt.WithMaxRetries(0), // retried by withRetry
+	)
+}
+
+// params builds the request body. Azure ignores the model field and routes by
+// deployment, but the deployment name is sent so logs on both sides match.
+func (azureOpenAIProvider) params(cfg *Config, systemPrompt, diffContent string) openai.ChatCompletionNewParams {
+	return newOpenAIChatParams(cfg.AzureDeployment, systemPrompt, diffContent, openAIRequestOptions{})
+}
+
+func (p azureOpenAIProvider) Call(ctx context.Context, cfg *Config, systemPrompt, diffContent string) (string, error) {
+	debugLog(cfg, "api: calling azure-openai deployment=%s endpoint=%s api-version=%s mode=buffered", cfg.AzureDeployment, cfg.AzureEndpoint, cfg.AzureAPIVersion)
+	client := p.client(cfg)
+	return callOpenAIChat(ctx, &client, p.params(cfg, systemPrompt, diffContent))
+}
+
+func (p azureOpenAIProvider) Stream(ctx context.Context, cfg *Config, systemPrompt, diffContent string, w io.Writer) (string, error) {
+	debugLog(cfg, "api: calling azure-openai deployment=%s endpoint=%s api-version=%s mode=stream", cfg.AzureDeployment, cfg.AzureEndpoint, cfg.AzureAPIVersion)
+	client := p.client(cfg)
+	return streamOpenAIChat(ctx, &client, withStreamUsage(p.params(cfg, systemPrompt, diffContent)), w)
+}
+
+func (azureOpenAIProvider) Validate(cfg *Config) error {
+	if cfg.AzureAPIKey == "" {
+		return errors.New("no Azure OpenAI API key found\n\nSet the AZURE_OPENAI_API_KEY environment variable or add 'azure_api_key' to ~/.ai-mr-comment.toml")
+	}
+	if cfg.AzureEndpoint == "" {
+		return errors.New("no Azure OpenAI endpoint configured\n\nSet AZURE_OPENAI_ENDPOINT or add 'azure_endpoint' (e.g. https://my-resource.openai.azure.com/) to ~/.ai-mr-comment.toml")
+	}
+	if cfg.AzureDeployment == "" {
+		return errors.New("no Azure OpenAI deployment configured\n\nSet AZURE_OPENAI_DEPLOYMENT, add 'azure_deployment' to ~/.ai-mr-comment.toml, or pass --model")
+	}
+	if cfg.AzureAPIVersion == "" {
+		return errors.New("no Azure OpenAI API version configured\n\nAdd 'azure_api_version' (e.g. 2024-10-21) to ~/.ai-mr-comment.toml")
+	}
+	return nil
+}
+
+// Model returns the deployment name; --model overrides it because Azure
+// routes by deployment, not by model.
+func (azureOpenAIProvider) Model(cfg *Config) string              { return cfg.AzureDeployment }
+func (azureOpenAIProvider) SetModel(cfg *Config, model string)    { cfg.AzureDeployment = model }
+func (azureOpenAIProvider) TokenEstimator(*Config) TokenEstimator { return &HeuristicTokenEstimator{} }
+
+// PricingModel returns azure_model when set, since deployment names are
+// arbitrary and often do not contain the underlying model name.
+func (azureOpenAIProvider) PricingModel(cfg *Config) string {
+	if cfg.AzureModel != "" {
+		return cfg.AzureModel
+	}
+	return cfg.AzureDeployment
+}
+
+// KnownModels is empty: deployments are named per Azure resource.
+func (azureOpenAIProvider) KnownModels() []string { return nil }
+
+func (azureOpenAIProvider) Describe(cfg *Config) []ProviderDetail {
+	details := []ProviderDetail{
+		{Label: "Endpoint", Value: cfg.AzureEndpoint},
+		{Label: "Version", Value: cfg.AzureAPIVersion},
+		{Label: "API key", Value: maskSecret(cfg.AzureAPIKey)},
+	}
+	if cfg.AzureModel != "" {
+		details = append(details, ProviderDetail{Label: "Pricing", Value: cfg.AzureModel})
+	}
+	return details
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func azureTestConfig(endpoint string) *Config {
+	return &Config{
+		Provider:        AzureOpenAI,
+		AzureEndpoint:   endpoint,
+		AzureDeployment: "gpt-4o-prod",
+		AzureAPIVersion: "2024-10-21",
+		AzureAPIKey:     "azure-key",
+	}
+}
+
+func TestAzureOpenAI_DeploymentRoutingAndAuth(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "real-openai-key")
+	var gotPath, gotVersion, gotKey, gotAuth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotVersion = r.URL.Query().Get("api-version")
+		gotKey = r.Header.Get("api-key")
+		gotAuth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id": "1", "object": "chat.completion", "created": 0, "model": "gpt-4o",
+			"choices": []map[string]any{
+				{"index": 0, "message": map[string]string{"role": "assistant", "content": "azure response"}, "finish_reason": "stop"},
+			},
+		})
+	}))
+	defer ts.Close()
+
+	got, err := chatCompletions(context.Background(), azureTestConfig(ts.URL+"/"), AzureOpenAI, "prompt", "diff")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "azure response" {
+		t.Errorf("expected 'azure response', got %q", got)
+	}
+	if gotPath != "/openai/deployments/gpt-4o-prod/chat/completions" {
+		t.Errorf("unexpected path %q", gotPath)
+	}
+	if gotVersion != "2024-10-21" {
+		t.Errorf("expected api-version 2024-10-21, got %q", gotVersion)
+	}
+	if gotKey != "azure-key" {
+		t.Errorf("expected api-key header, got %q", gotKey)
+	}
+	if gotAuth != "" {
+		t.Errorf("expected no Authorization header, got %q", gotAuth)
+	}
+}
+
+func TestAzureOpenAI_Stream(t *testing.T) {
+	var gotPath string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range []string{"Hello", " Azure"} {
+			_, _ = fmt.Fprintf(w, "data: {\"id\":\"1\",\"object\":\"chat.completion.chunk\",\"created\":0,\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q},\"finish_reason\":null}]}\n\n", c)
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer ts.Close()
+
+	var buf strings.Builder
+	got, err := streamToWriter(context.Background(), azureTestConfig(ts.URL), AzureOpenAI, "sys", "diff", &buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "Hello Azure" || buf.String() != "Hello Azure" {
+		t.Errorf("unexpected stream result %q / %q", got, buf.String())
+	}
+	if gotPath != "/openai/deployments/gpt-4o-prod/chat/completions" {
+		t.Errorf("unexpected path %q", gotPath)
+	}
+}
+
+func TestAzureOpenAI_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Config)
+		wantErr string
+	}{
+		{"missing key", func(c *Config) { c.AzureAPIKey = "" }, "AZURE_OPENAI_API_KEY"},
+		{"missing endpoint", func(c *Config) { c.AzureEndpoint = "" }, "azure_endpoint"},
+		{"missing deployment", func(c *Config) { c.AzureDeployment = "" }, "azure_deployment"},
+		{"missing version", func(c *Config) { c.AzureAPIVersion = "" }, "azure_api_version"},
+		{"valid", func(*Config) {}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := azureTestConfig("https://example.openai.azure.com/")
+			tt.mutate(cfg)
+			err := validateProviderConfig(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAzureOpenAI_ModelOverrideSetsDeployment(t *testing.T) {
+	cfg := azureTestConfig("https://example.openai.azure.com/")
+	setModelOverride(cfg, "gpt-4.1-staging")
+	if cfg.AzureDeployment != "gpt-4.1-staging" || getModelName(cfg) != "gpt-4.1-staging" {
+		t.Errorf("expected --model to set the deployment, got %q", cfg.AzureDeployment)
+	}
+}
+
+func TestAzureOpenAI_CostUsesAzureModel(t *testing.T) {
+	cfg := azureTestConfig("https://example.openai.azure.com/")
+	cfg.AzureDeployment = "prod-review"
+	cfg.AzureModel = "gpt-4o"
+
+	var buf strings.Builder
+	showCostEstimate(context.Background(), cfg, "system", strings.Repeat("x", 3500), &buf)
+	if !strings.Contains(buf.String(), "Model: prod-review") {
+		t.Errorf("expected deployment as model, got:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "Estimated Input Cost: $0.000000") {
+		t.Errorf("expected azure_model to be priced, got:\n%s", buf.String())
+	}
+}
+
+func TestLoadConfig_AzureDefaults(t *testing.T) {
+	path := writeConfigFile(t, `
+provider = "azure-openai"
+azure_endpoint = "https://example.openai.azure.com/"
+azure_deployment = "gpt-4o-prod"
+`)
+	cfg, err := loadConfigWith(newViperFromFile(path), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Provider != AzureOpenAI {
+		t.Errorf("expected provider azure-openai, got %v", cfg.Provider)
+	}
+	if cfg.AzureAPIVersion != "2024-10-21" {
+		t.Errorf("expected default api version, got %q", cfg.AzureAPIVersion)
+	}
+	if cfg.AzureDeployment != "gpt-4o-prod" {
+		t.Errorf("expected deployment gpt-4o-prod, got %q", cfg.AzureDeployment)
+	}
+}
+
+func TestCheckCmd_AzureDescribe(t *testing.T) {
+	t.Setenv("AZURE_OPENAI_API_KEY", "azure-key")
+	t.Setenv("AZURE_OPENAI_ENDPOINT", "https://example.openai.azure.com/")
+	t.Setenv("AZURE_OPENAI_DEPLOYMENT", "gpt-4o-prod")
+	t.Chdir(t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	var out strings.Builder
+	cmd := newRootCmd(func(context.Context, *Config, ApiProvider, string, string) (string, error) {
+		return "OK", nil
+	})
+	cmd.SetArgs([]string{"check", "--provider=azure-openai"})
+	cmd.SetOut(&out)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out.String())
+	}
+	for _, want := range []string{"Model    : gpt-4o-prod", "Endpoint : https://example.openai.azure.com/", "Version  : 2024-10-21", "API key  : azur****"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in output, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestGenWorkflow_AzureOpenAI(t *testing.T) {
+	cmd := newRootCmd(dummyChatFn)
+	var out strings.Builder
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"gen-workflow", "--provider=azure-openai", "--output=-"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"AZURE_OPENAI_API_KEY: ${{ secrets.AZURE_OPENAI_API_KEY }}",
+		"AZURE_OPENAI_ENDPOINT: ${{ vars.AZURE_OPENAI_ENDPOINT }}",
+		"AZURE_OPENAI_DEPLOYMENT: ${{ vars.AZURE_OPENAI_DEPLOYMENT }}",
+		"--provider azure-openai",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// budgetEnabled reports whether max_cost_per_run or max_cost_per_day is set.
+func budgetEnabled(cfg *Config) bool {
+	return cfg.MaxCostPerRun > 0 || cfg.MaxCostPerDay > 0
+}
+
+// estimateCallCost returns the worst-case USD cost of sending systemPrompt and
+// diffContent to p: the estimated input tokens plus a maximum-length response.
+// priced is false for a model without a built-in or [[pricing]] price.
+func estimateCallCost(ctx context.Context, cfg *Config, p Provider, systemPrompt, diffContent string) (cost float64, tokens int32, priced bool) {
+	tokens = estimateTokens(ctx, cfg, p, systemPrompt, diffContent)
+	price, priced := providerPrice(cfg, p)
+	return price.cost(tokenUsage{InputTokens: int64(tokens), OutputTokens: maxResponseTokens}), tokens, priced
+}
+
+// reserveBudget refuses a call whose worst-case cost would take the run or
+// today's ledger total over budget. Otherwise the estimate is held against
+// the run until release is called, so parallel calls (title and description,
+// smart-chunk passes) cannot each pass the check on the same headroom.
+// A model without a known price cannot be checked and is refused too. A
+// refusal exits with code 5.
+func reserveBudget(ctx context.Context, cfg *Config, p Provider, systemPrompt, diffContent string) (release func(), err error) {
+	if !budgetEnabled(cfg) {
+		return func() {}, nil
+	}
+	estimate, tokens, priced := estimateCallCost(ctx, cfg, p, systemPrompt, diffContent)
+	if !priced {
+		return nil, withExitCode(5, fmt.Errorf(
+			"%s has no known price, so max_cost_per_run and max_cost_per_day cannot be enforced\n\nAdd a [[pricing]] entry for %q to the config (zero prices for a free model), or unset the budget",
+			p.Model(cfg), providerPricingModel(cfg, p)))
+	}
+
+	var today float64
+	if cfg.MaxCostPerDay > 0 {
+		today, err = dailySpend(ledgerPath(cfg), time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("reading spend ledger: %w", err)
+		}
+	}
+
+	t := usageTrackerFor(cfg)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	completed, reserved := t.spent()
+	debugLog(cfg, "budget: %s model=%s estimate=$%.6f (%d input + up to %d output tokens) run=$%.6f day=$%.6f",
+		p.Name(), p.Model(cfg), estimate, tokens, maxResponseTokens, completed+reserved, today+reserved)
+
+	if cfg.MaxCostPerRun > 0 && completed+reserved+estimate > cfg.MaxCostPerRun {
+		return nil, withExitCode(5, fmt.Errorf(
+			"max_cost_per_run of $%.2f would be exceeded: %s may cost up to $%.4f (%d input tokens, up to %d output tokens) and $%.4f is already committed this run\n\nRaise max_cost_per_run, choose a cheaper model, or narrow the diff",
+			cfg.MaxCostPerRun, p.Model(cfg), estimate, tokens, maxResponseTokens, completed+reserved))
+	}
+	// The ledger already includes this run's completed calls.
+	if cfg.MaxCostPerDay > 0 && today+reserved+estimate > cfg.MaxCostPerDay {
+		return nil, withExitCode(5, fmt.Errorf(
+			"max_cost_per_day of $%.2f would be exceeded: $%.4f spent today and %s may cost up to $%.4f\n\nThe daily total is kept in %s",
+			cfg.MaxCostPerDay, today+reserved, p.Model(cfg), estimate, ledgerPath(cfg)))
+	}
+
+	t.reserved += estimate
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.reserved -= estimate
+	}, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exitCodeOf(err error) int {
+	var coded interface{ ExitCode() int }
+	if errors.As(err, &coded) {
+		return coded.ExitCode()
+	}
+	return 0
+}
+
+func TestChatCompletions_RefusesCallOverRunBudget(t *testing.T) {
+	metered := newMetered("metered", "gpt-4o", 10, 10)
+	withTestRegistry(t, metered)
+
+	// A maximum-length gpt-4o response alone costs $0.04.
+	cfg := &Config{Provider: "metered", MaxCostPerRun: 0.01}
+	_, err := chatCompletions(context.Background(), cfg, "metered", "prompt", "diff")
+	if exitCodeOf(err) != 5 || !strings.Contains(err.Error(), "max_cost_per_run") {
+		t.Fatalf("expected budget refusal with exit code 5, got %v", err)
+	}
+	if metered.calls.Load() != 0 {
+		t.Error("expected provider not to be called")
+	}
+}
+
+func TestChatCompletions_RunBudgetCountsCompletedCalls(t *testing.T) {
+	// Each call really costs 200k × $0.15/1M = $0.03; the estimate is ~$0.0024.
+	withTestRegistry(t, newMetered("metered", "gpt-4o-mini", 200_000, 0))
+	cfg := &Config{Provider: "metered", MaxCostPerRun: 0.05}
+
+	for i := range 2 {
+		if _, err := chatCompletions(context.Background(), cfg, "metered", "p", "d"); err != nil {
+			t.Fatalf("call %d: unexpected error: %v", i+1, err)
+		}
+	}
+	if _, err := chatCompletions(context.Background(), cfg, "metered", "p", "d"); exitCodeOf(err) != 5 {
+		t.Fatalf("expected third call to exceed the run budget, got %v", err)
+	}
+}
+
+func TestReserveBudget_HoldsEstimateUntilRelease(t *testing.T) {
+	p := newMetered("metered", "gpt-4o", 0, 0)
+	cfg := &Config{MaxCostPerRun: 0.06} // room for one $0.04 worst-case call
+
+	release, err := reserveBudget(context.Background(), cfg, p, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := reserveBudget(context.Background(), cfg, p, "", ""); exitCodeOf(err) != 5 {
+		t.Fatalf("expected concurrent reservation to be refused, got %v", err)
+	}
+	release()
+	if _, err := reserveBudget(context.Background(), cfg, p, "", ""); err != nil {
+		t.Errorf("expected reservation after release to pass, got %v", err)
+	}
+}
+
+func TestReserveBudget_OllamaIsFree(t *testing.T) {
+	for _, model := range []string{"mistral", "phi3", "qwen2.5-coder:7b"} {
+		cfg := &Config{OllamaModel: model, MaxCostPerRun: 0.0001}
+		if _, err := reserveBudget(context.Background(), cfg, ollamaProvider{}, "p", "d"); err != nil {
+			t.Errorf("expected ollama model %s to pass any budget, got %v", model, err)
+		}
+	}
+}
+
+func TestReserveBudget_HostedLlamaIsNotFree(t *testing.T) {
+	cfg := &Config{MaxCostPerRun: 10}
+	_, err := reserveBudget(context.Background(), cfg, newMetered("gateway", "llama-3.3-70b", 0, 0), "p", "d")
+	if exitCodeOf(err) != 5 {
+		t.Fatalf("expected a Llama model on a gateway to need a price, got %v", err)
+	}
+}
+
+func TestReserveBudget_UnknownPriceRefused(t *testing.T) {
+	p := newMetered("gateway", "acme-coder-7", 0, 0)
+	cfg := &Config{MaxCostPerRun: 10}
+	_, err := reserveBudget(context.Background(), cfg, p, "p", "d")
+	if exitCodeOf(err) != 5 || !strings.Contains(err.Error(), "[[pricing]]") {
+		t.Fatalf("expected an unpriced model to be refused with exit code 5, got %v", err)
+	}
+	cfg.Pricing = []PricingOverride{{Model: "acme-coder-7"}}
+	if _, err := reserveBudget(context.Background(), cfg, p, "p", "d"); err != nil {
+		t.Errorf("expected a [[pricing]] entry to allow the call, got %v", err)
+	}
+	if _, err := reserveBudget(context.Background(), &Config{}, p, "p", "d"); err != nil {
+		t.Errorf("expected no budget to allow the call, got %v", err)
+	}
+}
+
+func writeLedger(t *testing.T, entries ...ledgerEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	for _, e := range entries {
+		if err := appendLedger(path, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestChatCompletions_RefusesCallOverDailyBudget(t *testing.T) {
+	metered := newMetered("metered", "gpt-4o-mini", 10, 10)
+	withTestRegistry(t, metered)
+	path := writeLedger(t,
+		ledgerEntry{Time: time.Now().Add(-48 * time.Hour), CostUSD: 50},
+		ledgerEntry{Time: time.Now(), CostUSD: 0.999},
+	)
+
+	cfg := &Config{Provider: "metered", MaxCostPerDay: 1.00, LedgerPath: path}
+	_, err := chatCompletions(context.Background(), cfg, "metered", "p", "d")
+	if exitCodeOf(err) != 5 || !strings.Contains(err.Error(), "max_cost_per_day") {
+		t.Fatalf("expected daily budget refusal, got %v", err)
+	}
+	if metered.calls.Load() != 0 {
+		t.Error("expected provider not to be called")
+	}
+}
+
+func TestChatCompletions_RecordsDailySpendInLedger(t *testing.T) {
+	withTestRegistry(t, newMetered("metered", "gpt-4o-mini", 1_000_000, 0))
+	path := filepath.Join(t.TempDir(), "state", "usage.jsonl")
+
+	cfg := &Config{Provider: "metered", MaxCostPerDay: 10, LedgerPath: path}
+	if _, err := chatCompletions(context.Background(), cfg, "metered", "p", "d"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected ledger to be written: %v", err)
+	}
+	var entry ledgerEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("invalid ledger line %q: %v", data, err)
+	}
+	if entry.Provider != "metered" || entry.InputTokens != 1_000_000 || entry.CostUSD != 0.15 {
+		t.Errorf("unexpected ledger entry: %+v", entry)
+	}
+	if spent, _ := dailySpend(path, time.Now()); spent != 0.15 {
+		t.Errorf("expected $0.15 spent today, got $%f", spent)
+	}
+}
+
+func TestDailySpend_SkipsOtherDaysAndBadLines(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	path := writeLedger(t,
+		ledgerEntry{Time: now.Add(-time.Hour), CostUSD: 1.5},
+		ledgerEntry{Time: now.Add(-24 * time.Hour), CostUSD: 7},
+	)
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	_, _ = f.WriteString("{not json\n")
+	_ = f.Close()
+
+	spent, err := dailySpend(path, now)
+	if err != nil || spent != 1.5 {
+		t.Errorf("expected $1.5, got $%f / %v", spent, err)
+	}
+	if spent, err := dailySpend(filepath.Join(t.TempDir(), "missing.jsonl"), now); err != nil || spent != 0 {
+		t.Errorf("expected empty ledger for missing file, got $%f / %v", spent, err)
+	}
+}
+
+func TestRootCmd_BudgetExceededExitCode(t *testing.T) {
+	withTestRegistry(t, newMetered("metered", "gpt-4o", 10, 10))
+	t.Setenv("AI_MR_COMMENT_MAX_COST_PER_RUN", "0.001")
+
+	cmd := newRootCmd(chatCompletions)
+	cmd.SetArgs([]string{"--file=testdata/simple.diff", "--provider=metered", "--format=json"})
+	cmd.SetOut(&strings.Builder{})
+	cmd.SetErr(&strings.Builder{})
+	err := cmd.Execute()
+	if exitCodeOf(err) != 5 {
+		t.Fatalf("expected exit code 5, got %v", err)
+	}
+}
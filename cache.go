@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// cacheVersion is part of every cache key so a change to the entry format or
+// to request parameters invalidates old entries instead of misreading them.
+const cacheVersion = "v1"
+
+// cacheEntry is the JSON document stored for one cached completion.
+type cacheEntry struct {
+	Provider  ApiProvider `json:"provider"`
+	Model     string      `json:"model"`
+	CreatedAt time.Time   `json:"created_at"`
+	Response  string      `json:"response"`
+}
+
+// defaultCacheDir returns $XDG_CACHE_HOME/ai-mr-comment, falling back to
+// ~/.cache/ai-mr-comment.
+func defaultCacheDir() string {
+	if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "ai-mr-comment")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".cache", "ai-mr-comment")
+}
+
+// cacheDir returns the configured cache directory or the default.
+func cacheDir(cfg *Config) string {
+	if cfg.CacheDir != "" {
+		return cfg.CacheDir
+	}
+	return defaultCacheDir()
+}
+
+// cacheEnabled reports whether responses should be read from and written to
+// the cache for this run.
+func cacheEnabled(cfg *Config) bool {
+	return !cfg.NoCache && cfg.CacheTTL > 0 && cacheDir(cfg) != ""
+}
+
+// cacheKey hashes everything that determines a completion. settings holds
+// the provider's request options (see providerCacheSettings). Fields are
+// length-prefixed so that moving text between the prompt and the diff can
+// never produce the same key.
+func cacheKey(provider ApiProvider, model, settings, systemPrompt, diffContent string) string {
+	h := sha256.New()
+	for _, part := range []string{cacheVersion, string(provider), model, settings, systemPrompt, diffContent} {
+		_, _ = fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// providerCacheSettings returns the settings of provider, besides its model,
+// that change what a request returns: the server it goes to, the headers and
+// parameters sent with it, and the response length. Two openai-compatible or
+// Ollama servers serving the same model name therefore never share entries.
+func providerCacheSettings(cfg *Config, provider ApiProvider) string {
+	settings := []string{"max_tokens=" + strconv.Itoa(maxResponseTokens)}
+	switch provider {
+	case OpenAI:
+		settings = append(settings, "endpoint="+cfg.OpenAIEndpoint)
+	case Anthropic:
+		settings = append(settings, "endpoint="+cfg.AnthropicEndpoint)
+	case Ollama:
+		settings = append(settings, "endpoint="+cfg.OllamaEndpoint, "num_ctx="+strconv.Itoa(ollamaContextWindow(cfg)))
+	case OpenAICompatible:
+		settings = append(settings, "endpoint="+cfg.OpenAICompatibleEndpoint, "auth_header="+cfg.OpenAICompatibleAuthHeader,
+			"omit="+strings.Join(slices.Sorted(slices.Values(cfg.OpenAICompatibleOmitParams)), ","))
+		for _, name := range slices.Sorted(maps.Keys(cfg.OpenAICompatibleHeaders)) {
+			settings = append(settings, "header="+name+": "+cfg.OpenAICompatibleHeaders[name])
+		}
+	case AzureOpenAI:
+		settings = append(settings, "endpoint="+cfg.AzureEndpoint, "deployment="+cfg.AzureDeployment, "api_version="+cfg.AzureAPIVersion)
+	}
+	return strings.Join(settings, "\n")
+}
+
+// providerCacheKey is the cache key of a request to provider with its
+// configured model and settings.
+func providerCacheKey(cfg *Config, provider ApiProvider, systemPrompt, diffContent string) string {
+	return cacheKey(provider, providerModel(cfg, provider), providerCacheSettings(cfg, provider), systemPrompt, diffContent)
+}
+
+func cacheEntryPath(cfg *Config, key string) string {
+	return filepath.Join(cacheDir(cfg), key+".json")
+}
+
+// cacheLookup returns the cached response for the request and the provider
+// and model that produced it, if a fresh entry exists. Expired entries are
+// removed on access.
+func cacheLookup(cfg *Config, provider ApiProvider, systemPrompt, diffContent string) (string, providerAnswer, bool) {
+	if !cacheEnabled(cfg) {
+		return "", providerAnswer{}, false
+	}
+	key := providerCacheKey(cfg, provider, systemPrompt, diffContent)
+	path := cacheEntryPath(cfg, key)
+	data, err := os.ReadFile(path) //nolint:gosec // G304: path is built from a hex digest inside the cache dir
+	if err != nil {
+		return "", providerAnswer{}, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		debugLog(cfg, "cache: discarding unreadable entry %s: %v", key[:12], err)
+		_ = os.Remove(path)
+		return "", providerAnswer{}, false
+	}
+	if age := time.Since(entry.CreatedAt); age > cfg.CacheTTL {
+		debugLog(cfg, "cache: expired key=%s age=%s", key[:12], age.Round(time.Second))
+		_ = os.Remove(path)
+		return "", providerAnswer{}, false
+	}
+	debugLog(cfg, "cache: hit key=%s provider=%s model=%s", key[:12], entry.Provider, entry.Model)
+	return entry.Response, providerAnswer{Provider: entry.Provider, Model: entry.Model}, true
+}
+
+// cacheStore writes a successful response to the cache, under answered, the
+// provider that produced it: a fallback's response is not the primary
+// provider's and is not served for it. Failures are logged and otherwise
+// ignored: the cache must never break a run.
+func cacheStore(cfg *Config, answered providerAnswer, systemPrompt, diffContent, response string) {
+	if !cacheEnabled(cfg) {
+		return
+	}
+	key := providerCacheKey(cfg, answered.Provider, systemPrompt, diffContent)
+	entry := cacheEntry{
+		Provider:  answered.Provider,
+		Model:     answered.Model,
+		CreatedAt: time.Now().UTC(),
+		Response:  response,
+	}
+	if err := writeCacheEntry(cacheEntryPath(cfg, key), entry); err != nil {
+		debugLog(cfg, "cache: write failed: %v", err)
+		return
+	}
+	debugLog(cfg, "cache: stored key=%s", key[:12])
+}
+
+// writeCacheEntry writes entry atomically so a concurrent reader never sees a
+// partial file.
+func writeCacheEntry(path string, entry cacheEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// providerModel returns the model configured for provider, which may differ
+// from cfg.Provider (e.g. check --all or a fallback).
+func providerModel(cfg *Config, provider ApiProvider) string {
+	if p, ok := lookupProvider(provider); ok {
+		return p.Model(cfg)
+	}
+	return ""
+}
+
+// withResponseCache wraps chatFn so identical requests are answered from the
+// on-disk cache instead of calling the provider again. The provider that
+// answered, or produced the cached response, is reported to ctx.
+func withResponseCache(chatFn func(context.Context, *Config, ApiProvider, string, string) (string, error)) func(context.Context, *Config, ApiProvider, string, string) (string, error) {
+	return func(ctx context.Context, cfg *Config, provider ApiProvider, systemPrompt, diffContent string) (string, error) {
+		if cached, answered, ok := cacheLookup(cfg, provider, systemPrompt, diffContent); ok {
+			reportAnswer(ctx, answered)
+			return cached, nil
+		}
+		callCtx, report := withAnswerReport(ctx)
+		result, err := chatFn(callCtx, cfg, provider, systemPrompt, diffContent)
+		if err == nil {
+			answered := report.get(cfg, provider)
+			reportAnswer(ctx, answered)
+			cacheStore(cfg, answered, systemPrompt, diffContent, result)
+		}
+		return result, err
+	}
+}
+
+// cacheStats summarises the contents of the cache directory.
+type cacheStats struct {
+	Entries int
+	Expired int
+	Bytes   int64
+}
+
+// readCacheStats walks the cache directory. A missing directory is an empty
+// cache, not an error.
+func readCacheStats(dir string, ttl time.Duration) (cacheStats, error) {
+	var stats cacheStats
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return stats, nil
+	}
+	if err != nil {
+		return stats, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		stats.Entries++
+		stats.Bytes += info.Size()
+		if ttl > 0 && time.Since(info.ModTime()) > ttl {
+			stats.Expired++
+		}
+	}
+	return stats, nil
+}
+
+// clearCache removes every cache entry in dir and returns how many were removed.
+func clearCache(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, e := range entries {
+		if e.IsDir() || !(strings.HasSuffix(e.Name(), ".json") || strings.HasPrefix(e.Name(), ".tmp-")) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// newCacheCmd returns the cache command with its stats and clear subcommands.
+func newCacheCmd() *cobra.Command {
+	var profileName string
+
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect or clear the response cache",
+		Long: `Identical requests (same provider, model, system prompt and diff) are answered
+from an on-disk cache for cache_ttl (default 24h). Use --no-cache to bypass it
+for a single run, or set cache_ttl = "0s" to disable it.`,
+	}
+	cmd.PersistentFlags().StringVar(&profileName, "profile", "", "Named config profile to activate")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "stats",
+		Short: "Show cache location, entry count and size",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfigForProfile(profileName)
+			if err != nil {
+				return err
+			}
+			dir := cacheDir(cfg)
+			stats, err := readCacheStats(dir, cfg.CacheTTL)
+			if err != nil {
+				return fmt.Errorf("reading cache: %w", err)
+			}
+			out := cmd.OutOrStdout()
+			status := "enabled"
+			if cfg.CacheTTL <= 0 {
+				status = "disabled (cache_ttl = 0)"
+			}
+			_, _ = fmt.Fprintf(out, "Directory: %s\n", dir)
+			_, _ = fmt.Fprintf(out, "Status   : %s\n", status)
+			_, _ = fmt.Fprintf(out, "TTL      : %s\n", cfg.CacheTTL)
+			_, _ = fmt.Fprintf(out, "Entries  : %d (%d expired)\n", stats.Entries, stats.Expired)
+			_, _ = fmt.Fprintf(out, "Size     : %d bytes\n", stats.Bytes)
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "clear",
+		Short: "Delete every cached response",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfigForProfile(profileName)
+			if err != nil {
+				return err
+			}
+			dir := cacheDir(cfg)
+			removed, err := clearCache(dir)
+			if err != nil {
+				return fmt.Errorf("clearing cache: %w", err)
+			}
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Removed %d cached response(s) from %s\n", removed, dir)
+			return nil
+		},
+	})
+	return cmd
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// enableTestCache points the cache at a temp dir and re-enables it for one test.
+func enableTestCache(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("AI_MR_COMMENT_CACHE_DIR", dir)
+	t.Setenv("AI_MR_COMMENT_CACHE_TTL", "1h")
+	return dir
+}
+
+// countingChatFn returns a chatFn that answers with a numbered reply so a
+// cached response is distinguishable from a fresh one.
+func countingChatFn(calls *int) func(context.Context, *Config, ApiProvider, string, string) (string, error) {
+	return func(context.Context, *Config, ApiProvider, string, string) (string, error) {
+		*calls++
+		return "reply " + string(rune('0'+*calls)), nil
+	}
+}
+
+func TestCacheKey_DistinguishesInputs(t *testing.T) {
+	base := cacheKey(OpenAI, "gpt-4o", "s", "prompt", "diff")
+	for name, other := range map[string]string{
+		"provider": cacheKey(Anthropic, "gpt-4o", "s", "prompt", "diff"),
+		"model":    cacheKey(OpenAI, "gpt-4.1", "s", "prompt", "diff"),
+		"settings": cacheKey(OpenAI, "gpt-4o", "s2", "prompt", "diff"),
+		"prompt":   cacheKey(OpenAI, "gpt-4o", "s", "prompt2", "diff"),
+		"diff":     cacheKey(OpenAI, "gpt-4o", "s", "prompt", "diff2"),
+		"boundary": cacheKey(OpenAI, "gpt-4o", "s", "promptd", "iff"),
+	} {
+		if other == base {
+			t.Errorf("changing %s did not change the key", name)
+		}
+	}
+	if cacheKey(OpenAI, "gpt-4o", "s", "prompt", "diff") != base {
+		t.Error("expected key to be deterministic")
+	}
+}
+
+func TestProviderCacheKey_Settings(t *testing.T) {
+	cfg := &Config{OpenAICompatibleEndpoint: "http://a:8000/v1", OpenAICompatibleModel: "served"}
+	base := providerCacheKey(cfg, OpenAICompatible, "p", "d")
+	for name, change := range map[string]func(c *Config){
+		"endpoint": func(c *Config) { c.OpenAICompatibleEndpoint = "http://b:8000/v1" },
+		"headers":  func(c *Config) { c.OpenAICompatibleHeaders = map[string]string{"X-Tenant": "b"} },
+		"omit":     func(c *Config) { c.OpenAICompatibleOmitParams = []string{"temperature"} },
+	} {
+		changed := *cfg
+		change(&changed)
+		if providerCacheKey(&changed, OpenAICompatible, "p", "d") == base {
+			t.Errorf("changing %s did not change the key", name)
+		}
+	}
+	ollama := &Config{OllamaModel: "llama3", OllamaEndpoint: "http://a:11434"}
+	if providerCacheKey(ollama, Ollama, "p", "d") == providerCacheKey(&Config{OllamaModel: "llama3", OllamaEndpoint: "http://b:11434"}, Ollama, "p", "d") {
+		t.Error("expected Ollama servers not to share entries")
+	}
+}
+
+func TestWithResponseCache_StoresUnderAnsweringProvider(t *testing.T) {
+	calls := 0
+	fallback := func(ctx context.Context, cfg *Config, _ ApiProvider, _, _ string) (string, error) {
+		calls++
+		reportAnswer(ctx, providerAnswer{Provider: Ollama, Model: cfg.OllamaModel})
+		return "from ollama", nil
+	}
+	cached := withResponseCache(fallback)
+	cfg := &Config{Provider: Anthropic, AnthropicModel: "claude-sonnet-4-6", OllamaModel: "llama3", FallbackProviders: []ApiProvider{Ollama},
+		CacheDir: t.TempDir(), CacheTTL: time.Hour}
+
+	_, _ = cached(context.Background(), cfg, Anthropic, "p", "d")
+	_, _ = cached(context.Background(), cfg, Anthropic, "p", "d")
+	if calls != 2 {
+		t.Errorf("expected a fallback's answer not to be served for the primary, got %d calls", calls)
+	}
+	if got, answered, ok := cacheLookup(cfg, Ollama, "p", "d"); !ok || got != "from ollama" || answered.Model != "llama3" {
+		t.Errorf("expected the answer cached under ollama, got %q, %+v, %v", got, answered, ok)
+	}
+}
+
+func TestWithResponseCache_ParallelCallsKeepTheirOwnAnswer(t *testing.T) {
+	// The title falls back to ollama while the description is answered by
+	// the primary; each must be cached under its own provider.
+	chatFn := func(ctx context.Context, cfg *Config, provider ApiProvider, systemPrompt, _ string) (string, error) {
+		if systemPrompt == "title" {
+			reportAnswer(ctx, providerAnswer{Provider: Ollama, Model: cfg.OllamaModel})
+		} else {
+			reportAnswer(ctx, providerAnswer{Provider: provider, Model: cfg.AnthropicModel})
+		}
+		return systemPrompt + " reply", nil
+	}
+	cached := withResponseCache(chatFn)
+	cfg := &Config{Provider: Anthropic, AnthropicModel: "claude-sonnet-4-6", OllamaModel: "llama3", FallbackProviders: []ApiProvider{Ollama},
+		CacheDir: t.TempDir(), CacheTTL: time.Hour}
+
+	var wg sync.WaitGroup
+	for _, prompt := range []string{"title", "description"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = cached(context.Background(), cfg, Anthropic, prompt, "d")
+		}()
+	}
+	wg.Wait()
+	if got, _, ok := cacheLookup(cfg, Anthropic, "description", "d"); !ok || got != "description reply" {
+		t.Errorf("expected the description cached under anthropic, got %q, %v", got, ok)
+	}
+	if _, _, ok := cacheLookup(cfg, Ollama, "description", "d"); ok {
+		t.Error("expected no description entry under ollama")
+	}
+	if got, _, ok := cacheLookup(cfg, Ollama, "title", "d"); !ok || got != "title reply" {
+		t.Errorf("expected the title cached under ollama, got %q, %v", got, ok)
+	}
+}
+
+func TestWithResponseCache_HitMissAndTTL(t *testing.T) {
+	calls := 0
+	cached := withResponseCache(countingChatFn(&calls))
+	cfg := &Config{Provider: OpenAI, OpenAIModel: "gpt-4o", CacheDir: t.TempDir(), CacheTTL: time.Hour}
+
+	first, _ := cached(context.Background(), cfg, OpenAI, "p", "d")
+	second, _ := cached(context.Background(), cfg, OpenAI, "p", "d")
+	if calls != 1 || first != second {
+		t.Fatalf("expected second call to hit the cache, got %d calls (%q vs %q)", calls, first, second)
+	}
+
+	if _, _ = cached(context.Background(), cfg, OpenAI, "p", "other diff"); calls != 2 {
+		t.Errorf("expected a different diff to miss the cache")
+	}
+
+	cfg.NoCache = true
+	if _, _ = cached(context.Background(), cfg, OpenAI, "p", "d"); calls != 3 {
+		t.Errorf("expected NoCache to bypass the cache")
+	}
+	cfg.NoCache = false
+
+	// Age the entry past the TTL.
+	key := providerCacheKey(cfg, OpenAI, "p", "d")
+	path := cacheEntryPath(cfg, key)
+	var entry cacheEntry
+	data, _ := os.ReadFile(path)
+	_ = json.Unmarshal(data, &entry)
+	entry.CreatedAt = time.Now().Add(-2 * time.Hour)
+	if err := writeCacheEntry(path, entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, _ = cached(context.Background(), cfg, OpenAI, "p", "d"); calls != 4 {
+		t.Errorf("expected expired entry to miss the cache")
+	}
+}
+
+func TestWithResponseCache_DoesNotStoreErrors(t *testing.T) {
+	calls := 0
+	failing := func(context.Context, *Config, ApiProvider, string, string) (string, error) {
+		calls++
+		return "", errOverloaded
+	}
+	cached := withResponseCache(failing)
+	cfg := &Config{Provider: OpenAI, CacheDir: t.TempDir(), CacheTTL: time.Hour}
+	_, _ = cached(context.Background(), cfg, OpenAI, "p", "d")
+	_, _ = cached(context.Background(), cfg, OpenAI, "p", "d")
+	if calls != 2 {
+		t.Errorf("expected errors not to be cached, got %d calls", calls)
+	}
+}
+
+func TestRootCmd_UsesCacheAndNoCacheFlag(t *testing.T) {
+	enableTestCache(t)
+	t.Setenv("OPENAI_API_KEY", "dummy")
+	calls := 0
+	chat := countingChatFn(&calls)
+
+	run := func(extra ...string) string {
+		t.Helper()
+		var out strings.Builder
+		cmd := newRootCmd(chat)
+		cmd.SetArgs(append([]string{"--file=testdata/simple.diff", "--provider=openai", "--plain"}, extra...))
+		cmd.SetOut(&out)
+		cmd.SetErr(&strings.Builder{})
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return out.String()
+	}
+
+	first := run()
+	second := run()
+	if calls != 1 || first != second {
+		t.Fatalf("expected second run to be served from cache, got %d calls", calls)
+	}
+	third := run("--no-cache")
+	if calls != 2 || third == first {
+		t.Errorf("expected --no-cache to call the provider, got %d calls", calls)
+	}
+}
+
+func TestCacheCmd_StatsAndClear(t *testing.T) {
+	dir := enableTestCache(t)
+	t.Setenv("HOME", t.TempDir())
+	for _, key := range []string{"a", "b"} {
+		if err := writeCacheEntry(filepath.Join(dir, key+".json"), cacheEntry{Response: "x", CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out strings.Builder
+	cmd := newRootCmd(dummyChatFn)
+	cmd.SetArgs([]string{"cache", "stats"})
+	cmd.SetOut(&out)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "Entries  : 2 (0 expired)") || !strings.Contains(out.String(), dir) {
+		t.Errorf("unexpected stats output:\n%s", out.String())
+	}
+
+	out.Reset()
+	cmd = newRootCmd(dummyChatFn)
+	cmd.SetArgs([]string{"cache", "clear"})
+	cmd.SetOut(&out)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "Removed 2 cached response(s)") {
+		t.Errorf("unexpected clear output:\n%s", out.String())
+	}
+	if stats, _ := readCacheStats(dir, time.Hour); stats.Entries != 0 {
+		t.Errorf("expected empty cache after clear, got %d entries", stats.Entries)
+	}
+}
+
+func TestReadCacheStats_MissingDir(t *testing.T) {
+	stats, err := readCacheStats(filepath.Join(t.TempDir(), "missing"), time.Hour)
+	if err != nil || stats.Entries != 0 {
+		t.Errorf("expected empty stats for missing dir, got %+v / %v", stats, err)
+	}
+}
+
+func TestDefaultCacheDir_XDG(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", "/tmp/xdg")
+	if got := defaultCacheDir(); got != filepath.Join("/tmp/xdg", "ai-mr-comment") {
+		t.Errorf("expected XDG cache dir, got %q", got)
+	}
+}
@@ -22,6 +22,9 @@ type changelogArgs struct {
 	profile          string
 	estimate         bool
 	autoYes          bool
+	noCache          bool
+	noDefaultIgnores bool
+	includeCommits   bool
 }
 
 // runChangelog executes the changelog generation logic.
@@ -36,6 +39,8 @@ func runChangelog(cmd *cobra.Command, a changelogArgs, chatFn func(context.Conte
 	if cmd.Flags().Changed("model") {
 		setModelOverride(cfg, a.modelOverride)
 	}
+	cfg.NoCache = a.noCache
+	setLedgerRun(cfg, "changelog", "")
 
 	if cfgErr := validateProviderConfig(cfg); cfgErr != nil {
 		return cfgErr
@@ -48,16 +53,36 @@ func runChangelog(cmd *cobra.Command, a changelogArgs, chatFn func(context.Conte
 		return fmt.Errorf("unsupported format %q: must be text or json", a.format)
 	}
 
-	diffContent, err := resolveDiff(cmd, a.commit, a.diffFilePath)
+	base := newGitBase(cmd.Context(), cfg, "")
+	local := a.diffFilePath == "" && !commandStdinIsPiped(cmd)
+	diffContent, err := resolveDiff(cmd, cfg, a.commit, a.diffFilePath, base, a.noDefaultIgnores)
+	if err != nil {
+		return err
+	}
+	diffContent, err = applyIgnoreRules(cfg, diffContent, a.noDefaultIgnores, nil)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(diffContent) == "" {
+		return errAllFilesIgnored
+	}
+	if a.includeCommits || cfg.IncludeCommits {
+		commits, commitsErr := fetchCommitMessages(cmd.Context(), cfg, "", local, a.commit, false, base)
+		if commitsErr != nil {
+			_, _ = fmt.Fprintln(cmd.ErrOrStderr(), "Warning: commit messages not included:", commitsErr)
+		}
+		diffContent = addCommitMessages(diffContent, commits)
+	}
+	diffContent, _, err = applyRedaction(cfg, diffContent)
 	if err != nil {
 		return err
 	}
-	diffContent = processDiff(diffContent, 4000)
-
 	prompt, err := resolveChangelogPrompt(a.systemPromptFlag)
 	if err != nil {
 		return err
 	}
+	diffContent, _ = addDiffStats(diffContent)
+	diffContent, _ = fitDiffToContext(cmd.Context(), cfg, prompt, diffContent)
 
 	if a.estimate {
 		showCostEstimate(cmd.Context(), cfg, prompt, diffContent, cmd.OutOrStdout())
@@ -66,8 +91,9 @@ func runChangelog(cmd *cobra.Command, a changelogArgs, chatFn func(context.Conte
 		}
 	}
 
+	answerCtx, answered := withAnswerReport(cmd.Context())
 	entry, err := timedCall(cfg, "changelog", func() (string, error) {
-		return chatFn(cmd.Context(), cfg, cfg.Provider, prompt, diffContent)
+		return chatFn(answerCtx, cfg, cfg.Provider, prompt, diffContent)
 	})
 	if err != nil {
 		if cfg.Provider == Ollama && strings.Contains(err.Error(), "connection refused") {
@@ -76,12 +102,15 @@ func runChangelog(cmd *cobra.Command, a changelogArgs, chatFn func(context.Conte
 		return err
 	}
 	entry = strings.TrimSpace(entry)
+	logRunUsage(cfg)
 
-	return writeChangelogOutput(cmd, cfg, a.outputPath, a.format, entry)
+	return writeChangelogOutput(cmd, cfg, answered.get(cfg, cfg.Provider), a.outputPath, a.format, entry)
 }
 
-// resolveDiff obtains diff content from a file path, commit range, or working tree.
-func resolveDiff(cmd *cobra.Command, commit, diffFilePath string) (string, error) {
+// resolveDiff obtains diff content from a file path, commit range, or the
+// working tree against base. Working-tree diffs include untracked files unless
+// include_untracked is off; noDefaults is passed on to their ignore check.
+func resolveDiff(cmd *cobra.Command, cfg *Config, commit, diffFilePath string, base gitBase, noDefaults bool) (string, error) {
 	var diffContent string
 	var err error
 	if diffFilePath != "" {
@@ -92,7 +121,10 @@ func resolveDiff(cmd *cobra.Command, commit, diffFilePath string) (string, error
 		if !isGitRepo() {
 			return "", fmt.Errorf("not a git repository. Run from inside a git repo or use --file to provide a diff")
 		}
-		diffContent, err = getGitDiff(commit, false, nil)
+		diffContent, err = getGitDiff(commit, false, base, "")
+		if err == nil {
+			diffContent, err = addUntrackedFiles(cfg, diffContent, commit == "" && cfg.IncludeUntracked, noDefaults, nil)
+		}
 	}
 	if err != nil {
 		return "", err
@@ -114,20 +146,21 @@ func resolveChangelogPrompt(systemPromptFlag string) (string, error) {
 	return resolveSystemPrompt(systemPromptFlag)
 }
 
-// writeChangelogOutput writes the generated entry to a file or stdout.
-func writeChangelogOutput(cmd *cobra.Command, cfg *Config, outputPath, format, entry string) error {
+// writeChangelogOutput writes the generated entry, which answer produced, to
+// a file or stdout.
+func writeChangelogOutput(cmd *cobra.Command, cfg *Config, answer providerAnswer, outputPath, format, entry string) error {
 	if outputPath != "" {
-		return writeChangelogFile(cfg, outputPath, format, entry)
+		return writeChangelogFile(cfg, answer, outputPath, format, entry)
 	}
-	return writeChangelogStdout(cmd, cfg, format, entry)
+	return writeChangelogStdout(cmd, cfg, answer, format, entry)
 }
 
 // writeChangelogFile persists the changelog entry to disk.
-func writeChangelogFile(cfg *Config, outputPath, format, entry string) error {
+func writeChangelogFile(cfg *Config, answer providerAnswer, outputPath, format, entry string) error {
 	var fileContent []byte
 	if format == "json" {
 		var buf strings.Builder
-		if err := json.NewEncoder(&buf).Encode(changelogPayload(cfg, entry)); err != nil {
+		if err := json.NewEncoder(&buf).Encode(changelogPayload(cfg, answer, entry)); err != nil {
 			return err
 		}
 		fileContent = []byte(buf.String())
@@ -138,25 +171,27 @@ func writeChangelogFile(cfg *Config, outputPath, format, entry string) error {
 }
 
 // writeChangelogStdout writes the changelog entry to the command's stdout.
-func writeChangelogStdout(cmd *cobra.Command, cfg *Config, format, entry string) error {
+func writeChangelogStdout(cmd *cobra.Command, cfg *Config, answer providerAnswer, format, entry string) error {
 	out := cmd.OutOrStdout()
 	if format == "json" {
-		return json.NewEncoder(out).Encode(changelogPayload(cfg, entry))
+		return json.NewEncoder(out).Encode(changelogPayload(cfg, answer, entry))
 	}
 	_, _ = fmt.Fprintln(out, entry)
 	return nil
 }
 
 // changelogPayload builds the JSON payload struct for changelog output.
-func changelogPayload(cfg *Config, entry string) any {
+func changelogPayload(cfg *Config, answer providerAnswer, entry string) any {
 	return struct {
-		Changelog string `json:"changelog"`
-		Provider  string `json:"provider"`
-		Model     string `json:"model"`
+		Changelog string        `json:"changelog"`
+		Provider  string        `json:"provider"`
+		Model     string        `json:"model"`
+		Usage     *usageSummary `json:"usage,omitempty"`
 	}{
 		Changelog: entry,
-		Provider:  string(cfg.Provider),
-		Model:     getModelName(cfg),
+		Provider:  string(answer.Provider),
+		Model:     answer.Model,
+		Usage:     runUsage(cfg),
 	}
 }
 
@@ -190,6 +225,9 @@ Examples:
 	cmd.Flags().StringVar(&a.systemPromptFlag, "system-prompt", "", `Override the system prompt for this run. Use @path to read from a file (e.g. --system-prompt=@notes.txt).`)
 	cmd.Flags().BoolVar(&a.estimate, "estimate", false, "Show token/cost estimate and prompt for confirmation before calling the API")
 	cmd.Flags().BoolVarP(&a.autoYes, "yes", "y", false, "Auto-confirm the cost estimate prompt (use with --estimate)")
+	cmd.Flags().BoolVar(&a.noCache, "no-cache", false, "Bypass the response cache for this run")
+	cmd.Flags().BoolVar(&a.noDefaultIgnores, "no-default-ignores", false, "Keep lockfiles, vendored, minified and generated files in the diff sent to the model")
+	cmd.Flags().BoolVar(&a.includeCommits, "include-commits", false, "Add the commit messages of the range to the prompt")
 	cmd.Flags().StringVar(&a.profile, "profile", "", "Named config profile to activate (defined in ~/.ai-mr-comment.toml under [profile.<name>])")
 	return cmd
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	gogithub "github.com/google/go-github/v68/github"
+	gogitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// maxContextCommits caps how many commits are listed before the diff, and
+// maxCommitBodyLines how much of each message body is kept.
+const (
+	maxContextCommits  = 50
+	maxCommitBodyLines = 20
+)
+
+// commitInfo is one commit message from the diffed range.
+type commitInfo struct {
+	Hash    string
+	Subject string
+	Body    string
+}
+
+// newCommitInfo splits a full commit message into subject and body.
+func newCommitInfo(hash, message string) commitInfo {
+	message = strings.TrimSpace(strings.ReplaceAll(message, "\r\n", "\n"))
+	subject, body, _ := strings.Cut(message, "\n")
+	return commitInfo{Hash: hash, Subject: strings.TrimSpace(subject), Body: strings.TrimSpace(body)}
+}
+
+// getCommitMessages returns the commits whose changes getGitDiff shows for
+// the same arguments, oldest first: the commits of an explicit range, a single
+// commit, or the branch since its merge base with base. Staged diffs, and
+// branches without a merge base, have no commits.
+func getCommitMessages(commit string, staged bool, base gitBase) ([]commitInfo, error) {
+	var args []string
+	switch {
+	case staged:
+		return nil, nil
+	case strings.Contains(commit, ".."):
+		args = []string{commit}
+	case commit != "":
+		args = []string{"-1", commit}
+	default:
+		mergeBase, err := getAutoMergeBase(base)
+		if err != nil {
+			return nil, nil
+		}
+		args = []string{mergeBase + "..HEAD"}
+	}
+	// %x1f and %x1e separate the hash from the message and the commits from
+	// each other, since messages can contain any text.
+	logArgs := append([]string{"log", "--reverse", "--format=%H%x1f%B%x1e"}, args...)
+	out, err := exec.Command("git", logArgs...).Output() //nolint:gosec // G204: git is a fixed binary, args are the user's commit range or a merge-base hash
+	if err != nil {
+		return nil, fmt.Errorf("reading commit messages: %w", err)
+	}
+	var commits []commitInfo
+	for _, record := range strings.Split(string(out), "\x1e") {
+		hash, message, ok := strings.Cut(strings.TrimLeft(record, "\n"), "\x1f")
+		if !ok {
+			continue
+		}
+		commits = append(commits, newCommitInfo(hash, message))
+	}
+	return commits, nil
+}
+
+// getPRCommitsWithClient returns the commits of the GitHub PR at prURL.
+func getPRCommitsWithClient(ctx context.Context, gh *gogithub.Client, prURL string) ([]commitInfo, error) {
+	owner, repo, number, err := parsePRURL(prURL)
+	if err != nil {
+		return nil, err
+	}
+	var commits []commitInfo
+	opts := &gogithub.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := gh.PullRequests.ListCommits(ctx, owner, repo, number, opts)
+		if err != nil {
+			return nil, wrapGitHubAuthError("fetching GitHub PR commits", err)
+		}
+		for _, c := range page {
+			commits = append(commits, newCommitInfo(c.GetSHA(), c.GetCommit().GetMessage()))
+		}
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return commits, nil
+}
+
+// getPRCommits fetches the commits of a GitHub PR; see getPRDiff for token
+// and baseURL.
+func getPRCommits(ctx context.Context, prURL, token, baseURL string) ([]commitInfo, error) {
+	resolvedBaseURL, err := resolveGitHubBaseURL(prURL, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	gh, err := newGitHubClient(ctx, token, resolvedBaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return getPRCommitsWithClient(ctx, gh, prURL)
+}
+
+// getMRCommitsWithClient returns the commits of the GitLab MR at mrURL, oldest
+// first (the API lists them newest first).
+func getMRCommitsWithClient(ctx context.Context, gl *gogitlab.Client, mrURL string) ([]commitInfo, error) {
+	namespace, project, iid, err := parseMRURL(mrURL)
+	if err != nil {
+		return nil, err
+	}
+	var commits []commitInfo
+	opts := &gogitlab.GetMergeRequestCommitsOptions{ListOptions: gogitlab.ListOptions{PerPage: 100}}
+	for {
+		page, resp, err := gl.MergeRequests.GetMergeRequestCommits(namespace+"/"+project, iid, opts, gogitlab.WithContext(ctx))
+		if err != nil {
+			return nil, wrapGitLabAuthError("fetching GitLab MR commits", err)
+		}
+		for _, c := range page {
+			commits = append(commits, newCommitInfo(c.ID, c.Message))
+		}
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+	return commits, nil
+}
+
+// getMRCommits fetches the commits of a GitLab MR; see getMRDiff for token
+// and baseURL.
+func getMRCommits(ctx context.Context, mrURL, token, baseURL string) ([]commitInfo, error) {
+	resolvedBaseURL, err := resolveGitLabBaseURL(mrURL, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	gl, err := newGitLabClient(token, resolvedBaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("creating GitLab client: %w", err)
+	}
+	return getMRCommitsWithClient(ctx, gl, mrURL)
+}
+
+// commitLines renders commits for the prompt: a short hash and the subject,
+// with the body indented below it. Long lists keep the newest commits, which
+// usually describe the final state of the change.
+func commitLines(commits []commitInfo) []string {
+	lines := []string{fmt.Sprintf("Commits (%d):", len(commits))}
+	if len(commits) > maxContextCommits {
+		lines = append(lines, fmt.Sprintf("- ... %d earlier commits omitted", len(commits)-maxContextCommits))
+		commits = commits[len(commits)-maxContextCommits:]
+	}
+	for _, c := range commits {
+		hash := c.Hash
+		if len(hash) > 7 {
+			hash = hash[:7]
+		}
+		lines = append(lines, "- "+strings.TrimSpace(hash+" "+c.Subject))
+		if c.Body == "" {
+			continue
+		}
+		body := strings.Split(c.Body, "\n")
+		if len(body) > maxCommitBodyLines {
+			body = append(body[:maxCommitBodyLines], "[...]")
+		}
+		for _, line := range body {
+			lines = append(lines, strings.TrimRight("  "+line, " \t"))
+		}
+	}
+	return lines
+}
+
+// addCommitMessages puts the commit list at the end of diff's preamble, after
+// the PR title and description, so the model sees the intent and ticket
+// references the author already wrote. diff is unchanged when there are none.
+func addCommitMessages(diff string, commits []commitInfo) string {
+	if len(commits) == 0 {
+		return diff
+	}
+	d := parseDiff(diff)
+	d.Preamble = append(d.Preamble, append(commitLines(commits), "")...)
+	return d.String()
+}
+
+// fetchCommitMessages returns the commits behind a diff: from the hosting API
+// for a PR/MR URL, from git log when local is set, and none otherwise (diff
+// files and stdin carry no commit history).
+func fetchCommitMessages(ctx context.Context, cfg *Config, prURL string, local bool, commit string, staged bool, base gitBase) ([]commitInfo, error) {
+	switch {
+	case prURL != "" && isGitHubURL(prURL):
+		return getPRCommits(ctx, prURL, cfg.GitHubToken, cfg.GitHubBaseURL)
+	case prURL != "" && isGitLabURL(prURL):
+		return getMRCommits(ctx, prURL, cfg.GitLabToken, cfg.GitLabBaseURL)
+	case local:
+		return getCommitMessages(commit, staged, base)
+	}
+	return nil, nil
+}
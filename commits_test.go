@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetCommitMessages_Ranges(t *testing.T) {
+	initForkRepo(t)
+	runGit(t, "commit", "--allow-empty", "-m", "Fix login redirect\n\nThe callback dropped the state parameter.\n\nRefs: ABC-123")
+
+	base := gitBase{Remote: "upstream", Branch: "develop"}
+	commits, err := getCommitMessages("", false, base)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(commits) != 2 || commits[0].Subject != "feature.txt" || commits[1].Subject != "Fix login redirect" {
+		t.Fatalf("expected the branch's two commits oldest first, got %+v", commits)
+	}
+	if commits[1].Body != "The callback dropped the state parameter.\n\nRefs: ABC-123" || len(commits[1].Hash) != 40 {
+		t.Errorf("unexpected commit %+v", commits[1])
+	}
+
+	if commits, err := getCommitMessages("upstream/develop..HEAD", false, gitBase{}); err != nil || len(commits) != 2 {
+		t.Errorf("range: expected 2 commits, got %d (%v)", len(commits), err)
+	}
+	if commits, err := getCommitMessages("HEAD", false, gitBase{}); err != nil || len(commits) != 1 || commits[0].Subject != "Fix login redirect" {
+		t.Errorf("single commit: unexpected %+v (%v)", commits, err)
+	}
+	if commits, err := getCommitMessages("", true, base); err != nil || commits != nil {
+		t.Errorf("staged: expected no commits, got %+v (%v)", commits, err)
+	}
+	if _, err := getCommitMessages("no-such-ref..HEAD", false, gitBase{}); err == nil {
+		t.Error("expected an error for an unknown range")
+	}
+}
+
+func TestCommitLines(t *testing.T) {
+	lines := commitLines([]commitInfo{
+		{Hash: "0123456789abcdef", Subject: "Add login", Body: "Refs: ABC-1  \n\nMore detail"},
+		{Hash: "fedcba", Subject: "Tidy"},
+	})
+	want := []string{"Commits (2):", "- 0123456 Add login", "  Refs: ABC-1", "", "  More detail", "- fedcba Tidy"}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	var many []commitInfo
+	for i := 0; i < maxContextCommits+5; i++ {
+		many = append(many, commitInfo{Subject: fmt.Sprintf("c%d", i), Body: strings.Repeat("x\n", maxCommitBodyLines+5)})
+	}
+	lines = commitLines(many)
+	if lines[1] != "- ... 5 earlier commits omitted" || lines[2] != "- c5" {
+		t.Errorf("expected the oldest commits to be dropped, got %q %q", lines[1], lines[2])
+	}
+	if lines[3+maxCommitBodyLines] != "  [...]" {
+		t.Errorf("expected long bodies to be cut, got %q", lines[3+maxCommitBodyLines])
+	}
+}
+
+func TestAddCommitMessages(t *testing.T) {
+	diff := "Title: Add login\n\n" + fileDiff("main.go", 1)
+	got := addCommitMessages(diff, []commitInfo{{Hash: "abc1234", Subject: "Add login"}})
+	want := "Title: Add login\n\nCommits (1):\n- abc1234 Add login\n\ndiff --git a/main.go"
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("expected the commits after the existing preamble, got:\n%s", got)
+	}
+	if got := addCommitMessages(diff, nil); got != diff {
+		t.Errorf("expected no change without commits, got %q", got)
+	}
+}
+
+func TestGetPRCommitsWithClient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/owner/repo/pulls/42/commits", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]map[string]any{
+			{"sha": "aaa1111", "commit": map[string]any{"message": "Add cache\n\nCloses #7"}},
+			{"sha": "bbb2222", "commit": map[string]any{"message": "Fix cache key"}},
+		})
+	})
+	commits, err := getPRCommitsWithClient(context.Background(), newTestGitHubClient(t, mux), "https://github.com/owner/repo/pull/42")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(commits) != 2 || commits[0].Subject != "Add cache" || commits[0].Body != "Closes #7" || commits[1].Hash != "bbb2222" {
+		t.Errorf("unexpected commits %+v", commits)
+	}
+}
+
+func TestGetMRCommitsWithClient_OldestFirst(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/projects/group%2Fproject/merge_requests/5/commits", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]map[string]any{
+			{"id": "bbb2222", "title": "Second", "message": "Second\n"},
+			{"id": "aaa1111", "title": "First", "message": "First\n\nJIRA-9"},
+		})
+	})
+	commits, err := getMRCommitsWithClient(context.Background(), newTestGitLabClient(t, mux), "https://gitlab.com/group/project/-/merge_requests/5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(commits) != 2 || commits[0].Subject != "First" || commits[0].Body != "JIRA-9" || commits[1].Subject != "Second" {
+		t.Errorf("expected commits oldest first, got %+v", commits)
+	}
+}
+
+func TestRootCmd_IncludeCommits(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "dummy")
+	initForkRepo(t)
+	runGit(t, "commit", "--allow-empty", "-m", "Wire up login\n\nRefs: ABC-123")
+
+	for _, include := range []bool{false, true} {
+		args := []string{"--commit=upstream/develop..HEAD", "--provider=openai", "--print-request"}
+		if include {
+			args = append(args, "--include-commits")
+		}
+		var out strings.Builder
+		cmd := newRootCmd(func(context.Context, *Config, ApiProvider, string, string) (string, error) {
+			return "ok", nil
+		})
+		cmd.SetArgs(args)
+		cmd.SetOut(&out)
+		cmd.SetErr(io.Discard)
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var payload struct {
+			Diff string `json:"diff"`
+		}
+		if err := json.Unmarshal([]byte(out.String()), &payload); err != nil {
+			t.Fatalf("invalid JSON %q: %v", out.String(), err)
+		}
+		if got := strings.Contains(payload.Diff, "Commits (2):\n- "); got != include {
+			t.Errorf("include=%v: commit list present=%v in:\n%s", include, got, payload.Diff)
+		}
+		if include && !strings.Contains(payload.Diff, "  Refs: ABC-123\n") {
+			t.Errorf("expected the commit body in the prompt, got:\n%s", payload.Diff)
+		}
+	}
+}
@@ -22,6 +22,9 @@ const (
 	ClaudeCLI ApiProvider = "claude-cli"
 	GeminiCLI ApiProvider = "gemini-cli"
 	CodexCLI  ApiProvider = "codex-cli"
+
+	OpenAICompatible ApiProvider = "openai-compatible"
+	AzureOpenAI      ApiProvider = "azure-openai"
 )
 
 // Config holds all runtime settings, populated from the TOML config file,
@@ -46,6 +49,24 @@ type Config struct {
 	CodexCLIModel  string `mapstructure:"codex_cli_model"`
 	CodexCLIPath   string `mapstructure:"codex_cli_path"`
 
+	// OpenAI-compatible servers (vLLM, LM Studio, LiteLLM, …) keep their own
+	// endpoint, model, and key so they never mix with the real OpenAI settings.
+	OpenAICompatibleEndpoint   string            `mapstructure:"openai_compatible_endpoint"`
+	OpenAICompatibleModel      string            `mapstructure:"openai_compatible_model"`
+	OpenAICompatibleAPIKey     string            `mapstructure:"openai_compatible_api_key"`
+	OpenAICompatibleAuthHeader string            `mapstructure:"openai_compatible_auth_header"`
+	OpenAICompatibleHeaders    map[string]string `mapstructure:"openai_compatible_headers"`
+	OpenAICompatibleOmitParams []string          `mapstructure:"openai_compatible_omit_params"`
+
+	// Azure OpenAI routes by deployment name rather than model and authenticates
+	// with an api-key header. AzureModel is the model behind the deployment and
+	// is only used for pricing when the deployment name does not reveal it.
+	AzureEndpoint   string `mapstructure:"azure_endpoint"`
+	AzureDeployment string `mapstructure:"azure_deployment"`
+	AzureAPIVersion string `mapstructure:"azure_api_version"`
+	AzureAPIKey     string `mapstructure:"azure_api_key"`
+	AzureModel      string `mapstructure:"azure_model"`
+
 	OpenAIEndpoint    string        `mapstructure:"openai_endpoint"`
 	AnthropicEndpoint string        `mapstructure:"anthropic_endpoint"`
 	OllamaEndpoint    string        `mapstructure:"ollama_endpoint"`
@@ -53,10 +74,104 @@ type Config struct {
 	Template          string        `mapstructure:"template"`
 	RequestTimeout    time.Duration `mapstructure:"request_timeout"`
 
+	// FallbackProviders are tried in order when the active provider still
+	// fails with a transient error after its retries.
+	FallbackProviders []ApiProvider `mapstructure:"fallback_providers"`
+
+	// Transient failures (rate limits, 5xx, dropped connections) are retried
+	// up to RetryMaxAttempts times in total with exponential backoff starting
+	// at RetryBackoff and capped at RetryMaxBackoff. 1 disables retries.
+	RetryMaxAttempts int           `mapstructure:"retry_max_attempts"`
+	RetryBackoff     time.Duration `mapstructure:"retry_backoff"`
+	RetryMaxBackoff  time.Duration `mapstructure:"retry_max_backoff"`
+
 	// DebugWriter is the output destination for verbose debug messages.
 	// Nil when verbose mode is disabled. Set by the CLI after config load; never read from TOML.
 	DebugWriter io.Writer
 
+	// Completions are cached on disk under CacheDir (default
+	// ~/.cache/ai-mr-comment) for CacheTTL; a zero TTL disables the cache.
+	CacheDir string        `mapstructure:"cache_dir"`
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+
+	// Pricing overrides or extends the built-in per-model price table;
+	// PricingFile names a TOML file with more [[pricing]] entries.
+	Pricing     []PricingOverride `mapstructure:"pricing"`
+	PricingFile string            `mapstructure:"pricing_file"`
+
+	// MaxCostPerRun and MaxCostPerDay cap spend in USD; 0 means no limit. A
+	// call whose worst-case cost would exceed either is refused (exit code 5).
+	MaxCostPerRun float64 `mapstructure:"max_cost_per_run"`
+	MaxCostPerDay float64 `mapstructure:"max_cost_per_day"`
+
+	// ContextWindow overrides the model's context window in tokens, which sizes
+	// how much of a large diff is sent. 0 uses the built-in table; for Ollama
+	// it is also sent as options.num_ctx (default 8192).
+	ContextWindow int `mapstructure:"context_window"`
+
+	// Every provider call is appended to the JSONL usage ledger at LedgerPath
+	// (default ~/.local/state/ai-mr-comment/usage.jsonl) unless RecordUsage is
+	// false. The ledger is always written while MaxCostPerDay is set.
+	RecordUsage bool   `mapstructure:"record_usage"`
+	LedgerPath  string `mapstructure:"ledger_path"`
+
+	// Redaction controls what happens to secrets detected in a diff before it
+	// is sent: "redact" (default) replaces them, "abort" refuses to send the
+	// diff (exit code 6), "off" sends it unchanged. RedactionPatterns adds
+	// custom regular expressions to the built-in detectors.
+	Redaction         string   `mapstructure:"redaction"`
+	RedactionPatterns []string `mapstructure:"redaction_patterns"`
+
+	// BaseBranch is what the working tree is diffed against when no commit is
+	// given; empty detects the default branch of Remote. Remote is also where
+	// quick-commit pushes.
+	BaseBranch string `mapstructure:"base_branch"`
+	Remote     string `mapstructure:"remote"`
+
+	// DiffContext is how much unchanged code surrounds each change: "" for
+	// git's default, "function" or a line count. Set by --context.
+	DiffContext string `mapstructure:"diff_context"`
+
+	// IncludeCommits adds the commit messages of the diffed range, or of the
+	// PR/MR, to the prompt. Set by include_commits or --include-commits.
+	IncludeCommits bool `mapstructure:"include_commits"`
+
+	// GoSymbols adds the changed Go declarations, exported API changes and the
+	// definitions the added lines refer to. Set by go_symbols or --go-symbols.
+	GoSymbols bool `mapstructure:"go_symbols"`
+
+	// APIDiff adds the type-checked exported Go API changes of a git diff,
+	// as api-diff reports them. Set by api_diff or --api-diff.
+	APIDiff bool `mapstructure:"api_diff"`
+
+	// IncludeUntracked adds untracked files that .gitignore does not hide to
+	// working-tree diffs as new files. Turned off by --no-untracked.
+	IncludeUntracked bool `mapstructure:"include_untracked"`
+
+	// --smart-chunk summarises batches of about SmartChunkBatchTokens tokens
+	// of diff, running at most SmartChunkWorkers provider calls at once.
+	SmartChunkWorkers     int `mapstructure:"smart_chunk_workers"`
+	SmartChunkBatchTokens int `mapstructure:"smart_chunk_batch_tokens"`
+	// SmartChunkPartial lets the run finish when some batch summaries fail;
+	// their files are reported as not_summarized.
+	SmartChunkPartial bool `mapstructure:"smart_chunk_partial"`
+
+	// NoCache bypasses the response cache for this run. Set by --no-cache; never read from TOML.
+	NoCache bool `mapstructure:"-"`
+
+	// ledgerCommand, ledgerRepo and ledgerPRURL label this run's entries in
+	// the usage ledger; see setLedgerRun.
+	ledgerCommand string
+	ledgerRepo    string
+	ledgerPRURL   string
+
+	// usage accumulates token usage reported by every provider call in this
+	// run; see recordUsage. A pointer so copies of the Config share it.
+	usage *usageTracker
+
+	// redactors are the compiled RedactionPatterns; see compileRedactionPatterns.
+	redactors []secretDetector
+
 	// ConfigFile is the path of the TOML config file that was loaded, or "" if none was found.
 	// Set by loadConfigWith; never read from TOML.
 	ConfigFile string
@@ -88,6 +203,11 @@ func loadConfigForProfile(profile string) (*Config, error) {
 	_ = v.BindEnv("gitlab_token", "GITLAB_TOKEN")
 	_ = v.BindEnv("github_base_url", "GITHUB_BASE_URL")
 	_ = v.BindEnv("gitlab_base_url", "GITLAB_BASE_URL")
+	_ = v.BindEnv("openai_compatible_api_key", "OPENAI_COMPATIBLE_API_KEY")
+	_ = v.BindEnv("azure_api_key", "AZURE_OPENAI_API_KEY")
+	_ = v.BindEnv("azure_endpoint", "AZURE_OPENAI_ENDPOINT")
+	_ = v.BindEnv("azure_deployment", "AZURE_OPENAI_DEPLOYMENT")
+	_ = v.BindEnv("azure_api_version", "AZURE_OPENAI_API_VERSION")
 
 	return loadConfigWith(v, profile)
 }
@@ -126,8 +246,39 @@ func loadConfigWith(v *viper.Viper, profile string) (*Config, error) {
 	v.SetDefault("gemini_cli_path", "")
 	v.SetDefault("codex_cli_model", "")
 	v.SetDefault("codex_cli_path", "")
+	v.SetDefault("openai_compatible_endpoint", "")
+	v.SetDefault("openai_compatible_model", "")
+	v.SetDefault("openai_compatible_auth_header", "Authorization")
+	v.SetDefault("azure_endpoint", "")
+	v.SetDefault("azure_deployment", "")
+	v.SetDefault("azure_api_version", "2024-10-21")
+	v.SetDefault("azure_model", "")
 	v.SetDefault("template", "default")
 	v.SetDefault("request_timeout", "0s")
+	v.SetDefault("fallback_providers", []string{})
+	v.SetDefault("cache_dir", "")
+	v.SetDefault("cache_ttl", "24h")
+	v.SetDefault("pricing_file", "")
+	v.SetDefault("max_cost_per_run", 0.0)
+	v.SetDefault("max_cost_per_day", 0.0)
+	v.SetDefault("context_window", 0)
+	v.SetDefault("record_usage", true)
+	v.SetDefault("ledger_path", "")
+	v.SetDefault("redaction", redactionRedact)
+	v.SetDefault("redaction_patterns", []string{})
+	v.SetDefault("base_branch", "")
+	v.SetDefault("remote", defaultRemote)
+	v.SetDefault("diff_context", "")
+	v.SetDefault("include_commits", false)
+	v.SetDefault("include_untracked", true)
+	v.SetDefault("go_symbols", false)
+	v.SetDefault("api_diff", false)
+	v.SetDefault("smart_chunk_workers", defaultSmartChunkWorkers)
+	v.SetDefault("smart_chunk_batch_tokens", defaultSmartChunkBatchTokens)
+	v.SetDefault("smart_chunk_partial", false)
+	v.SetDefault("retry_max_attempts", defaultRetryMaxAttempts)
+	v.SetDefault("retry_backoff", defaultRetryBackoff.String())
+	v.SetDefault("retry_max_backoff", defaultRetryMaxBackoff.String())
 
 	if err := v.ReadInConfig(); err != nil {
 		var configParseError viper.ConfigParseError
@@ -145,13 +296,19 @@ func loadConfigWith(v *viper.Viper, profile string) (*Config, error) {
 		return nil, err
 	}
 
-	cfg := &Config{}
+	cfg := &Config{usage: &usageTracker{}}
 	// Strip the "profile" subtree before unmarshalling so that UnmarshalExact
 	// does not reject it as an unknown key.
 	if err := unmarshalConfig(v, cfg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 	cfg.ConfigFile = v.ConfigFileUsed()
+	if err := resolvePricing(cfg); err != nil {
+		return nil, err
+	}
+	if err := compileRedactionPatterns(cfg); err != nil {
+		return nil, err
+	}
 
 	return cfg, nil
 }
@@ -166,7 +323,14 @@ func unmarshalConfig(v *viper.Viper, cfg *Config) error {
 		WeaklyTypedInput: false,
 		Result:           cfg,
 		TagName:          "mapstructure",
-		DecodeHook:       mapstructure.ComposeDecodeHookFunc(mapstructure.StringToTimeDurationHookFunc()),
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			// Environment variables always arrive as strings.
+			mapstructure.StringToIntHookFunc(),
+			mapstructure.StringToFloat64HookFunc(),
+			mapstructure.StringToBoolHookFunc(),
+			mapstructure.StringToWeakSliceHookFunc(","),
+		),
 	})
 	if err != nil {
 		return err
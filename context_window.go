@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// defaultContextWindow is assumed for models with no known context window
+// (OpenAI-compatible servers, unlisted models). It is deliberately modest;
+// set context_window to use more of a larger model.
+const defaultContextWindow = 32_768
+
+// defaultOllamaContextWindow is the context window requested from Ollama
+// (options.num_ctx) when context_window is not set. Ollama's own default is
+// smaller than most models support and silently drops the start of the
+// prompt when it is exceeded, so the budget and the request must agree.
+const defaultOllamaContextWindow = 8_192
+
+// contextWindowRule matches a model name by substring, most specific first.
+type contextWindowRule struct {
+	substr string
+	tokens int
+}
+
+// contextWindowRules maps model families to their input context window in
+// tokens (approximate, subject to change).
+var contextWindowRules = []contextWindowRule{
+	// OpenAI
+	{"gpt-4.1", 1_047_576},
+	{"gpt-4o", 128_000},
+	{"gpt-4-turbo", 128_000},
+	{"gpt-3.5-turbo", 16_385},
+	// Anthropic
+	{"claude", 200_000},
+	// Gemini
+	{"gemini-1.5-pro", 2_097_152},
+	{"gemini", 1_048_576},
+	// OpenAI reasoning models; short names, so matched last.
+	{"o1-mini", 128_000},
+	{"o1", 200_000},
+	{"o3", 200_000},
+	{"o4", 200_000},
+}
+
+// lookupContextWindow returns the known context window for model.
+func lookupContextWindow(model string) (int, bool) {
+	model = strings.ToLower(model)
+	for _, r := range contextWindowRules {
+		if strings.Contains(model, r.substr) {
+			return r.tokens, true
+		}
+	}
+	return 0, false
+}
+
+// ollamaContextWindow is the num_ctx sent with every Ollama request.
+func ollamaContextWindow(cfg *Config) int {
+	if cfg.ContextWindow > 0 {
+		return cfg.ContextWindow
+	}
+	return defaultOllamaContextWindow
+}
+
+// providerContextWindow returns the context window, in tokens, of name's
+// model: context_window when set, else the built-in table.
+func providerContextWindow(cfg *Config, name ApiProvider) int {
+	if name == Ollama {
+		return ollamaContextWindow(cfg)
+	}
+	if cfg.ContextWindow > 0 {
+		return cfg.ContextWindow
+	}
+	if p, ok := lookupProvider(name); ok {
+		if window, ok := lookupContextWindow(providerPricingModel(cfg, p)); ok {
+			return window
+		}
+	}
+	return defaultContextWindow
+}
+
+// contextWindow returns the smallest context window of the active provider
+// and the configured fallback_providers it may fall back to, so that the
+// diff fits whichever of them answers. Fallbacks that are not set up are
+// skipped, as callWithFallback skips them.
+func contextWindow(cfg *Config) int {
+	window := providerContextWindow(cfg, cfg.Provider)
+	for _, name := range providerChain(cfg, cfg.Provider)[1:] {
+		if p, ok := lookupProvider(name); !ok || p.Validate(cfg) != nil {
+			continue
+		}
+		window = min(window, providerContextWindow(cfg, name))
+	}
+	return window
+}
+
+// diffTokenBudget returns how many tokens of diff fit in the context window
+// next to systemPrompt, a response of up to maxResponseTokens, and a 5%
+// margin for estimation error. Small windows reserve at most a quarter of the
+// window for the response. The prompt is counted with the conservative
+// heuristic; it is small and the same for every call.
+func diffTokenBudget(ctx context.Context, cfg *Config, systemPrompt string) int {
+	window := contextWindow(cfg)
+	promptTokens, _ := (&HeuristicTokenEstimator{}).CountTokens(ctx, "", systemPrompt)
+	output := maxResponseTokens
+	if output > window/4 {
+		output = window / 4
+	}
+	budget := window - int(promptTokens) - output - window/20
+	debugLog(cfg, "context: window=%d prompt=%d output=%d → diff budget=%d tokens", window, promptTokens, output, budget)
+	if budget < 256 {
+		budget = 256
+	}
+	return budget
+}
+
+// countTokens counts text with the active provider's TokenEstimator, falling
+// back to the character heuristic when the estimator fails.
+func countTokens(ctx context.Context, cfg *Config, text ...string) int32 {
+	tokens, err := NewTokenEstimator(cfg).CountTokens(ctx, getModelName(cfg), text...)
+	if err != nil {
+		debugLog(cfg, "context: token count failed, using heuristic: %v", err)
+		tokens, _ = (&HeuristicTokenEstimator{}).CountTokens(ctx, "", text...)
+	}
+	return tokens
+}
+
+// fitDiffToContext shrinks diffContent to fit in the model's context window
+// next to systemPrompt, omitting low-priority files first (see
+// truncateDiffByPriority). It returns nil when nothing was cut. The
+// conservative character heuristic is tried first so the provider's estimator
+// (a network call for Gemini) is only used when the diff may not fit.
+func fitDiffToContext(ctx context.Context, cfg *Config, systemPrompt, diffContent string) (string, *diffTruncation) {
+	budget := diffTokenBudget(ctx, cfg, systemPrompt)
+	if rough, _ := (&HeuristicTokenEstimator{}).CountTokens(ctx, "", diffContent); int(rough) <= budget {
+		return diffContent, nil
+	}
+	tokens := int(countTokens(ctx, cfg, diffContent))
+	if tokens <= budget {
+		return diffContent, nil
+	}
+	// Scale the byte length by the measured bytes-per-token ratio.
+	maxBytes := int(int64(len(diffContent)) * int64(budget) / int64(tokens))
+	fitted, truncation := truncateDiffByPriority(diffContent, maxBytes)
+	debugLog(cfg, "context: diff tokens=%d exceed budget=%d, kept %d of %d bytes, omitted files=%v trimmed=%v",
+		tokens, budget, len(fitted), len(diffContent), truncation.OmittedFiles, truncation.Trimmed)
+	return fitted, truncation
+}
+
+// truncateDiffBytes keeps whole lines from the start and end of diff, about
+// maxBytes/2 each, with a "[...diff truncated...]" marker at the cut.
+func truncateDiffBytes(diff string, maxBytes int) string {
+	if len(diff) <= maxBytes {
+		return diff
+	}
+	half := maxBytes / 2
+	head := diff[:half]
+	if i := strings.LastIndexByte(head, '\n'); i >= 0 {
+		head = head[:i]
+	}
+	tail := diff[len(diff)-half:]
+	if i := strings.IndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+	return head + "\n[...diff truncated...]\n" + tail
+}
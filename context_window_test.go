@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLookupContextWindow(t *testing.T) {
+	tests := []struct {
+		model string
+		want  int
+		ok    bool
+	}{
+		{"gpt-4.1-mini", 1_047_576, true},
+		{"gpt-4o-mini", 128_000, true},
+		{"claude-sonnet-4-6", 200_000, true},
+		{"claude-haiku-4-5-20251001", 200_000, true},
+		{"gemini-2.5-flash", 1_048_576, true},
+		{"o3-mini", 200_000, true},
+		{"my-local-model", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := lookupContextWindow(tt.model)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("lookupContextWindow(%q) = %d, %v; want %d, %v", tt.model, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestContextWindow_OverridesAndDefaults(t *testing.T) {
+	if got := contextWindow(&Config{Provider: Anthropic, AnthropicModel: "claude-sonnet-4-6"}); got != 200_000 {
+		t.Errorf("expected built-in window, got %d", got)
+	}
+	if got := contextWindow(&Config{Provider: Anthropic, AnthropicModel: "claude-sonnet-4-6", ContextWindow: 50_000}); got != 50_000 {
+		t.Errorf("expected context_window override, got %d", got)
+	}
+	if got := contextWindow(&Config{Provider: Ollama, OllamaModel: "llama3.1"}); got != defaultOllamaContextWindow {
+		t.Errorf("expected Ollama default window, got %d", got)
+	}
+	if got := contextWindow(&Config{Provider: OpenAICompatible, OpenAICompatibleModel: "served-model"}); got != defaultContextWindow {
+		t.Errorf("expected default window for unknown model, got %d", got)
+	}
+}
+
+func TestContextWindow_FallbackChain(t *testing.T) {
+	cfg := &Config{Provider: Anthropic, AnthropicModel: "claude-sonnet-4-6", FallbackProviders: []ApiProvider{OpenAI, Ollama}}
+	if got := contextWindow(cfg); got != defaultOllamaContextWindow {
+		t.Errorf("expected the Ollama fallback's window, got %d", got)
+	}
+	cfg.FallbackProviders = []ApiProvider{OpenAI}
+	if got := contextWindow(cfg); got != 200_000 {
+		t.Errorf("expected an unconfigured fallback to be skipped, got %d", got)
+	}
+	cfg.OpenAIAPIKey, cfg.OpenAIModel = "key", "gpt-4o"
+	if got := contextWindow(cfg); got != 128_000 {
+		t.Errorf("expected the smaller OpenAI window, got %d", got)
+	}
+}
+
+func TestTruncateDiffBytes_KeepsWholeLines(t *testing.T) {
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, "+line of the diff")
+	}
+	diff := strings.Join(lines, "\n")
+	got := truncateDiffBytes(diff, 400)
+	if !strings.Contains(got, "[...diff truncated...]") {
+		t.Fatal("expected truncation marker")
+	}
+	if len(got) > 400+len("\n[...diff truncated...]\n") {
+		t.Errorf("expected at most ~400 bytes, got %d", len(got))
+	}
+	for _, line := range strings.Split(got, "\n") {
+		if line != "+line of the diff" && line != "[...diff truncated...]" {
+			t.Errorf("expected only whole lines, got %q", line)
+		}
+	}
+	if truncateDiffBytes(diff, len(diff)) != diff {
+		t.Error("expected diff within the limit to be unchanged")
+	}
+}
+
+func TestFitDiffToContext_LargeModelKeepsLongDiff(t *testing.T) {
+	data, err := os.ReadFile("testdata/truncation-trigger.diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), "\n") <= 4000 {
+		t.Skip("fixture is not longer than the old 4000-line cap")
+	}
+	cfg := &Config{Provider: Anthropic, AnthropicModel: "claude-sonnet-4-6"}
+	got, truncation := fitDiffToContext(context.Background(), cfg, "prompt", string(data))
+	if truncation != nil || got != string(data) {
+		t.Error("expected a 200k-token model to receive the whole diff")
+	}
+}
+
+func TestFitDiffToContext_SmallWindowTruncates(t *testing.T) {
+	diff := strings.Repeat("+some changed line in a file\n", 5000)
+	cfg := &Config{Provider: Ollama, OllamaModel: "llama3"}
+	got, truncation := fitDiffToContext(context.Background(), cfg, "prompt", diff)
+	if truncation == nil || !truncation.Trimmed || !strings.Contains(got, "[...diff truncated...]") {
+		t.Fatal("expected diff to be truncated for an 8k window")
+	}
+	tokens, _ := (&HeuristicTokenEstimator{}).CountTokens(context.Background(), "", "prompt", got)
+	if int(tokens)+maxResponseTokens/2 > defaultOllamaContextWindow {
+		t.Errorf("truncated prompt of %d tokens leaves no room for the response", tokens)
+	}
+}
+
+func TestCallOllama_SendsNumCtx(t *testing.T) {
+	var body struct {
+		Options struct {
+			NumCtx int `json:"num_ctx"`
+		} `json:"options"`
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"response": "ok"})
+	}))
+	defer ts.Close()
+
+	cfg := &Config{OllamaModel: "llama3", OllamaEndpoint: ts.URL, ContextWindow: 16_384}
+	if _, err := callOllama(context.Background(), cfg, "p", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if body.Options.NumCtx != 16_384 {
+		t.Errorf("expected num_ctx 16384, got %d", body.Options.NumCtx)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// diffFileStatus is what a diff does to a file.
+type diffFileStatus string
+
+const (
+	fileModified diffFileStatus = "modified"
+	fileAdded    diffFileStatus = "added"
+	fileDeleted  diffFileStatus = "deleted"
+	fileRenamed  diffFileStatus = "renamed"
+	fileCopied   diffFileStatus = "copied"
+)
+
+// devNull is the path git uses for the missing side of an added or deleted file.
+const devNull = "/dev/null"
+
+// diffHunk is one "@@ -a,b +c,d @@" section of a file diff.
+type diffHunk struct {
+	// Header is the raw "@@" line, including any function context after it.
+	Header             string
+	OldStart, OldLines int
+	NewStart, NewLines int
+	// Lines are the hunk body lines with their " ", "+", "-" or "\" prefix.
+	Lines []string
+}
+
+// diffFile is the diff of one file: the "diff --git" header, the extended
+// header lines git writes below it, and the hunks.
+type diffFile struct {
+	// OldPath and NewPath are repo-relative, without the a/ and b/ prefixes.
+	// The missing side of an added or deleted file is empty.
+	OldPath, NewPath string
+	Status           diffFileStatus
+	Binary           bool
+	// OldMode and NewMode are octal file modes such as "100644"; both are
+	// set from the index line when the mode did not change.
+	OldMode, NewMode string
+	// Header holds the "diff --git" line and every line up to the first hunk,
+	// exactly as they appeared.
+	Header []string
+	Hunks  []*diffHunk
+	// Added and Removed count the "+" and "-" lines in the hunks.
+	Added, Removed int
+}
+
+// parsedDiff is a unified diff split into files. Every diff source renders to
+// text that round-trips through it: parseDiff(s).String() == s.
+type parsedDiff struct {
+	// Preamble is the text before the first "diff --git" line, such as the
+	// PR title and description or the current branch.
+	Preamble []string
+	Files    []*diffFile
+	// noFinalNewline is set when the text did not end with a newline.
+	noFinalNewline bool
+}
+
+// parseDiff parses a git unified diff. It never fails: lines it does not
+// understand are kept where they appeared so the text is preserved exactly.
+// Only "diff --git" starts a new file.
+func parseDiff(raw string) *parsedDiff {
+	d := &parsedDiff{}
+	if raw == "" {
+		return d
+	}
+	body, hadNewline := strings.CutSuffix(raw, "\n")
+	d.noFinalNewline = !hadNewline
+
+	var file *diffFile
+	var hunk *diffHunk
+	for _, line := range strings.Split(body, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			file = &diffFile{Status: fileModified}
+			file.OldPath, file.NewPath = parseGitHeaderPaths(trimCR(line))
+			file.Header = []string{line}
+			hunk = nil
+			d.Files = append(d.Files, file)
+		case file == nil:
+			d.Preamble = append(d.Preamble, line)
+		case strings.HasPrefix(line, "@@"):
+			hunk = parseHunkHeader(line)
+			file.Hunks = append(file.Hunks, hunk)
+		case hunk != nil:
+			hunk.Lines = append(hunk.Lines, line)
+			switch {
+			case strings.HasPrefix(line, "+"):
+				file.Added++
+			case strings.HasPrefix(line, "-"):
+				file.Removed++
+			}
+		default:
+			file.Header = append(file.Header, line)
+			file.parseExtendedHeader(trimCR(line))
+		}
+	}
+	return d
+}
+
+// parseExtendedHeader records what an extended header line says about f.
+func (f *diffFile) parseExtendedHeader(line string) {
+	key, value, _ := strings.Cut(line, " ")
+	switch {
+	case strings.HasPrefix(line, "new file mode "):
+		f.Status, f.NewMode, f.OldPath = fileAdded, strings.TrimPrefix(line, "new file mode "), ""
+	case strings.HasPrefix(line, "deleted file mode "):
+		f.Status, f.OldMode, f.NewPath = fileDeleted, strings.TrimPrefix(line, "deleted file mode "), ""
+	case strings.HasPrefix(line, "old mode "):
+		f.OldMode = strings.TrimPrefix(line, "old mode ")
+	case strings.HasPrefix(line, "new mode "):
+		f.NewMode = strings.TrimPrefix(line, "new mode ")
+	case strings.HasPrefix(line, "rename from "):
+		f.Status, f.OldPath = fileRenamed, unquoteDiffPath(strings.TrimPrefix(line, "rename from "))
+	case strings.HasPrefix(line, "rename to "):
+		f.Status, f.NewPath = fileRenamed, unquoteDiffPath(strings.TrimPrefix(line, "rename to "))
+	case strings.HasPrefix(line, "copy from "):
+		f.Status, f.OldPath = fileCopied, unquoteDiffPath(strings.TrimPrefix(line, "copy from "))
+	case strings.HasPrefix(line, "copy to "):
+		f.Status, f.NewPath = fileCopied, unquoteDiffPath(strings.TrimPrefix(line, "copy to "))
+	case key == "index":
+		// "index abc..def 100644" carries the mode when it did not change.
+		if _, mode, ok := strings.Cut(value, " "); ok && f.OldMode == "" && f.NewMode == "" {
+			f.OldMode, f.NewMode = mode, mode
+		}
+	case key == "---":
+		if p := unquoteDiffPath(value); p == devNull {
+			f.OldPath = ""
+		} else {
+			f.OldPath = strings.TrimPrefix(p, "a/")
+		}
+	case key == "+++":
+		if p := unquoteDiffPath(value); p == devNull {
+			f.NewPath = ""
+		} else {
+			f.NewPath = strings.TrimPrefix(p, "b/")
+		}
+	case strings.HasPrefix(line, "Binary files "), line == "GIT binary patch":
+		f.Binary = true
+	}
+}
+
+// parseGitHeaderPaths returns the old and new paths of a "diff --git a/x b/y"
+// line. Paths with spaces are ambiguous there; the "---"/"+++" and rename
+// lines that follow override them.
+func parseGitHeaderPaths(line string) (oldPath, newPath string) {
+	rest := strings.TrimPrefix(line, "diff --git ")
+	if strings.HasPrefix(rest, `"`) {
+		if q, err := strconv.QuotedPrefix(rest); err == nil {
+			oldPath = unquoteDiffPath(q)
+			newPath = unquoteDiffPath(strings.TrimSpace(rest[len(q):]))
+			return strings.TrimPrefix(oldPath, "a/"), strings.TrimPrefix(newPath, "b/")
+		}
+	}
+	if i := strings.LastIndex(rest, " b/"); i >= 0 {
+		return strings.TrimPrefix(rest[:i], "a/"), rest[i+3:]
+	}
+	if i := strings.LastIndex(rest, ` "b/`); i >= 0 {
+		return strings.TrimPrefix(rest[:i], "a/"), strings.TrimPrefix(unquoteDiffPath(rest[i+1:]), "b/")
+	}
+	// --no-prefix diffs: "diff --git path path".
+	if n := len(rest); n%2 == 1 && rest[:n/2] == rest[n/2+1:] {
+		return rest[:n/2], rest[:n/2]
+	}
+	oldPath, newPath, _ = strings.Cut(rest, " ")
+	return oldPath, newPath
+}
+
+// unquoteDiffPath decodes a path git quoted because of special characters.
+func unquoteDiffPath(p string) string {
+	if strings.HasPrefix(p, `"`) {
+		if s, err := strconv.Unquote(p); err == nil {
+			return s
+		}
+	}
+	return p
+}
+
+// parseHunkHeader parses "@@ -a,b +c,d @@ context". Malformed headers keep
+// zero positions; the raw line is preserved either way.
+func parseHunkHeader(line string) *diffHunk {
+	h := &diffHunk{Header: line}
+	ranges, _, ok := strings.Cut(strings.TrimPrefix(trimCR(line), "@@ "), " @@")
+	if !ok {
+		return h
+	}
+	for _, r := range strings.Fields(ranges) {
+		start, count := parseHunkRange(r[1:])
+		switch r[0] {
+		case '-':
+			h.OldStart, h.OldLines = start, count
+		case '+':
+			h.NewStart, h.NewLines = start, count
+		}
+	}
+	return h
+}
+
+// parseHunkRange parses "start,count" or "start" (count 1).
+func parseHunkRange(r string) (start, count int) {
+	s, c, hasCount := strings.Cut(r, ",")
+	start, _ = strconv.Atoi(s)
+	count = 1
+	if hasCount {
+		count, _ = strconv.Atoi(c)
+	}
+	return start, count
+}
+
+func trimCR(line string) string { return strings.TrimSuffix(line, "\r") }
+
+// Path returns the path a reader would look for: the new path, or the old
+// one for a deleted file.
+func (f *diffFile) Path() string {
+	if f.NewPath != "" {
+		return f.NewPath
+	}
+	return f.OldPath
+}
+
+// ModeChanged reports whether the diff changes the file's mode.
+func (f *diffFile) ModeChanged() bool {
+	return f.OldMode != "" && f.NewMode != "" && f.OldMode != f.NewMode
+}
+
+// writeTo appends f's text to sb, each line newline-terminated.
+func (f *diffFile) writeTo(sb *strings.Builder) {
+	for _, line := range f.Header {
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+	for _, h := range f.Hunks {
+		sb.WriteString(h.Header)
+		sb.WriteByte('\n')
+		for _, line := range h.Lines {
+			sb.WriteString(line)
+			sb.WriteByte('\n')
+		}
+	}
+}
+
+// String renders f as a diff, ending with a newline.
+func (f *diffFile) String() string {
+	var sb strings.Builder
+	f.writeTo(&sb)
+	return sb.String()
+}
+
+// preambleString renders the preamble, ending with a newline when non-empty.
+func (d *parsedDiff) preambleString() string {
+	var sb strings.Builder
+	for _, line := range d.Preamble {
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// String renders d back to diff text.
+func (d *parsedDiff) String() string {
+	var sb strings.Builder
+	sb.WriteString(d.preambleString())
+	for _, f := range d.Files {
+		f.writeTo(&sb)
+	}
+	out := sb.String()
+	if d.noFinalNewline {
+		out = strings.TrimSuffix(out, "\n")
+	}
+	return out
+}
+
+// prependPreamble puts lines, followed by a blank line, before the existing
+// preamble. It is how context such as the PR title or branch name is added.
+func (d *parsedDiff) prependPreamble(lines ...string) {
+	d.Preamble = append(append(lines[:len(lines):len(lines)], ""), d.Preamble...)
+}
+
+// newSyntheticDiffFile builds a diffFile, with the header git would have
+// written, for sources that report file metadata separately from the hunks
+// (the GitLab API). body holds the "@@" hunks, or a "Binary files" line.
+func newSyntheticDiffFile(oldPath, newPath string, status diffFileStatus, oldMode, newMode, body string) *diffFile {
+	if oldPath == "" {
+		oldPath = newPath
+	}
+	if newPath == "" {
+		newPath = oldPath
+	}
+	if oldMode == "" || oldMode == "0" {
+		oldMode = "100644"
+	}
+	if newMode == "" || newMode == "0" {
+		newMode = "100644"
+	}
+	header := []string{"diff --git " + quoteDiffPath("a/"+oldPath) + " " + quoteDiffPath("b/"+newPath)}
+	switch {
+	case status == fileAdded:
+		header = append(header, "new file mode "+newMode)
+	case status == fileDeleted:
+		header = append(header, "deleted file mode "+oldMode)
+	case oldMode != newMode:
+		header = append(header, "old mode "+oldMode, "new mode "+newMode)
+	}
+	if status == fileRenamed {
+		header = append(header, "rename from "+quoteDiffPath(oldPath), "rename to "+quoteDiffPath(newPath))
+	}
+
+	body = strings.TrimSuffix(body, "\n")
+	if strings.HasPrefix(body, "@@") {
+		from, to := quoteDiffPath("a/"+oldPath), quoteDiffPath("b/"+newPath)
+		if status == fileAdded {
+			from = devNull
+		}
+		if status == fileDeleted {
+			to = devNull
+		}
+		header = append(header, "--- "+from, "+++ "+to)
+	}
+	if body != "" {
+		header = append(header, strings.Split(body, "\n")...)
+	}
+	return parseDiff(strings.Join(header, "\n") + "\n").Files[0]
+}
+
+// quoteDiffPath quotes p the way git does when it contains special characters.
+func quoteDiffPath(p string) string {
+	if strings.ContainsAny(p, "\"\\\t\n") || strings.IndexFunc(p, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0 {
+		return strconv.Quote(p)
+	}
+	return p
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gogitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestParseDiff_FixturesRoundTrip(t *testing.T) {
+	paths, err := filepath.Glob("testdata/*.diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths = append(paths, "testdata/diff.txt")
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw := string(data)
+			d := parseDiff(raw)
+			if got := d.String(); got != raw {
+				t.Errorf("round trip changed the diff")
+			}
+			if want := strings.Count("\n"+raw, "\ndiff --git "); len(d.Files) != want {
+				t.Errorf("expected %d files, got %d", want, len(d.Files))
+			}
+		})
+	}
+}
+
+func TestParseDiff_RoundTripEdgeCases(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"\n",
+		"no diff here",
+		"Branch: main\n\ndiff --git a/x b/x\n+a",
+		"diff --git a/x b/x\n@@ -1 +1 @@\n-a\n+b\n\n\n",
+		"diff --git",
+	} {
+		if got := parseDiff(raw).String(); got != raw {
+			t.Errorf("round trip of %q gave %q", raw, got)
+		}
+	}
+}
+
+func TestParseDiff_FileMetadata(t *testing.T) {
+	type meta struct {
+		OldPath, NewPath string
+		Status           diffFileStatus
+		Binary           bool
+		OldMode, NewMode string
+	}
+	tests := []struct {
+		fixture string
+		index   int
+		want    meta
+	}{
+		{"rename-move.diff", 0, meta{OldPath: "internal/util/helpers.go", NewPath: "internal/utils/helpers.go", Status: fileRenamed, OldMode: "100644", NewMode: "100644"}},
+		{"deleted-files-only.diff", 0, meta{OldPath: "internal/legacy/handler.go", Status: fileDeleted, OldMode: "100644"}},
+		{"binary-files.diff", 0, meta{OldPath: "assets/logo.png", NewPath: "assets/logo.png", Status: fileModified, Binary: true, OldMode: "100644", NewMode: "100644"}},
+		{"binary-files.diff", 1, meta{NewPath: "assets/banner.jpg", Status: fileAdded, Binary: true, NewMode: "100644"}},
+		{"empty-commit.diff", 0, meta{OldPath: "scripts/run.sh", NewPath: "scripts/run.sh", Status: fileModified, OldMode: "100644", NewMode: "100755"}},
+		{"submodule-changes.diff", 0, meta{OldPath: "vendor/proto", NewPath: "vendor/proto", Status: fileModified, OldMode: "160000", NewMode: "160000"}},
+		{"crlf-line-endings.diff", 0, meta{OldPath: "internal/config/config.go", NewPath: "internal/config/config.go", Status: fileModified, OldMode: "100644", NewMode: "100644"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := parseDiff(string(data)).Files[tt.index]
+			got := meta{OldPath: f.OldPath, NewPath: f.NewPath, Status: f.Status, Binary: f.Binary, OldMode: f.OldMode, NewMode: f.NewMode}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDiff_DeletedFilePath(t *testing.T) {
+	data, err := os.ReadFile("testdata/deleted-files-only.diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := parseDiff(string(data)).Files[0]
+	if f.Path() != "internal/legacy/handler.go" {
+		t.Errorf("expected the old path for a deleted file, got %q", f.Path())
+	}
+	if f.Removed == 0 || f.Added != 0 {
+		t.Errorf("expected only removed lines, got +%d -%d", f.Added, f.Removed)
+	}
+}
+
+func TestParseDiff_Hunks(t *testing.T) {
+	data, err := os.ReadFile("testdata/multiple-hunks-one-file.diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := parseDiff(string(data)).Files[0]
+	if len(f.Hunks) < 2 {
+		t.Fatalf("expected several hunks, got %d", len(f.Hunks))
+	}
+	added, removed := 0, 0
+	for _, h := range f.Hunks {
+		if !strings.HasPrefix(h.Header, "@@") || h.NewStart == 0 {
+			t.Errorf("unexpected hunk header parse: %+v", h)
+		}
+		for _, line := range h.Lines {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				added++
+			case strings.HasPrefix(line, "-"):
+				removed++
+			}
+		}
+	}
+	if added != f.Added || removed != f.Removed {
+		t.Errorf("file counts +%d -%d do not match hunks +%d -%d", f.Added, f.Removed, added, removed)
+	}
+
+	h := parseHunkHeader("@@ -10,16 +10,8 @@ func Resolve(a, b string) string {")
+	if h.OldStart != 10 || h.OldLines != 16 || h.NewStart != 10 || h.NewLines != 8 {
+		t.Errorf("unexpected ranges: %+v", h)
+	}
+	if h := parseHunkHeader("@@ -1 +1 @@"); h.OldLines != 1 || h.NewLines != 1 {
+		t.Errorf("expected an omitted count to mean 1, got %+v", h)
+	}
+}
+
+func TestParseGitHeaderPaths(t *testing.T) {
+	tests := []struct {
+		line, oldPath, newPath string
+	}{
+		{"diff --git a/x.go b/y.go", "x.go", "y.go"},
+		{"diff --git a/dir with space/f.go b/dir with space/f.go", "dir with space/f.go", "dir with space/f.go"},
+		{`diff --git "a/t\tab.go" "b/t\tab.go"`, "t\tab.go", "t\tab.go"},
+		{"diff --git x.go x.go", "x.go", "x.go"},
+	}
+	for _, tt := range tests {
+		oldPath, newPath := parseGitHeaderPaths(tt.line)
+		if oldPath != tt.oldPath || newPath != tt.newPath {
+			t.Errorf("%q: got (%q, %q), want (%q, %q)", tt.line, oldPath, newPath, tt.oldPath, tt.newPath)
+		}
+	}
+}
+
+func TestPrependPreamble(t *testing.T) {
+	d := parseDiff("diff --git a/x b/x\n+a\n")
+	d.prependPreamble("Branch: main")
+	if got := d.String(); got != "Branch: main\n\ndiff --git a/x b/x\n+a\n" {
+		t.Errorf("unexpected preamble rendering %q", got)
+	}
+}
+
+func TestGitLabDiffFiles_RebuildsHeader(t *testing.T) {
+	files := gitLabDiffFiles(&gogitlab.MergeRequestDiff{
+		OldPath: "old.go", NewPath: "new.go", AMode: "100644", BMode: "100755",
+		RenamedFile: true,
+		Diff:        "@@ -1 +1 @@\n-a\n+b\n",
+	})
+	if len(files) != 1 {
+		t.Fatalf("expected one file, got %d", len(files))
+	}
+	f := files[0]
+	if f.Status != fileRenamed || f.OldPath != "old.go" || f.NewPath != "new.go" || !f.ModeChanged() || f.Added != 1 || f.Removed != 1 {
+		t.Errorf("unexpected file %+v", f)
+	}
+	want := "diff --git a/old.go b/new.go\nold mode 100644\nnew mode 100755\nrename from old.go\nrename to new.go\n--- a/old.go\n+++ b/new.go\n@@ -1 +1 @@\n-a\n+b\n"
+	if got := f.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	added := gitLabDiffFiles(&gogitlab.MergeRequestDiff{OldPath: "n.go", NewPath: "n.go", AMode: "0", BMode: "100644", NewFile: true, Diff: "@@ -0,0 +1 @@\n+x\n"})[0]
+	if added.Status != fileAdded || added.OldPath != "" || added.Path() != "n.go" || !strings.Contains(added.String(), "--- /dev/null\n") {
+		t.Errorf("unexpected added file %+v:\n%s", added, added.String())
+	}
+}
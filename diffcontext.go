@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// diffContext is the --context setting: how much unchanged code surrounds
+// each change. "" keeps git's three lines, "function" shows the whole
+// enclosing function, and a number sets the line count.
+type diffContext string
+
+const functionContext diffContext = "function"
+
+// parseDiffContext validates a --context or diff_context value.
+func parseDiffContext(s string) (diffContext, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == string(functionContext) {
+		return diffContext(s), nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return diffContext(strconv.Itoa(n)), nil
+	}
+	return "", fmt.Errorf("invalid context %q: must be \"function\" or a number of lines", s)
+}
+
+// gitArgs returns the git diff options that select c.
+func (c diffContext) gitArgs() []string {
+	switch c {
+	case "":
+		return nil
+	case functionContext:
+		return []string{"--function-context"}
+	}
+	return []string{"-U" + string(c)}
+}
+
+// isFuncHeader reports whether line starts a function by git's default
+// funcname rule: it begins with a letter, "_" or "$".
+func isFuncHeader(line string) bool {
+	if line == "" {
+		return false
+	}
+	c := line[0]
+	return c == '_' || c == '$' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// newSpan returns the first and last new-file lines (1-based) a hunk covers.
+// A hunk that only deletes covers no new lines; first is then the line after
+// the deletion and last the line before it.
+func (h *diffHunk) newSpan() (first, last int) {
+	first = h.NewStart
+	if h.NewLines == 0 {
+		first++
+	}
+	return first, first + h.NewLines - 1
+}
+
+// matchesFile reports whether h's context and added lines are the lines of
+// local, the new file, at the positions the hunk header gives.
+func (h *diffHunk) matchesFile(local []string) bool {
+	first, last := h.newSpan()
+	if first < 1 || last > len(local) {
+		return false
+	}
+	n := first - 1
+	for _, line := range h.Lines {
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "+") {
+			continue
+		}
+		if n > last-1 || trimCR(line[1:]) != trimCR(local[n]) {
+			return false
+		}
+		n++
+	}
+	return n == last
+}
+
+// readCheckoutFile returns the lines of p, a path from a diff, in the checkout
+// at root. A diff from --file or stdin can name any path, so one that would
+// leave root, such as "../secret", is refused.
+func readCheckoutFile(root, p string) ([]string, error) {
+	name := filepath.FromSlash(p)
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("path %q is outside the checkout", p)
+	}
+	data, err := os.ReadFile(filepath.Join(root, name)) //nolint:gosec // G304: a local path of the diff, read from the user's checkout
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
+
+// matchesCheckout reports whether every hunk of f matches local, its new
+// file. A hunk without context or added lines matches any file, so at least
+// one hunk has to have some for the match to mean anything.
+func (f *diffFile) matchesCheckout(local []string) bool {
+	checked := false
+	for _, h := range f.Hunks {
+		if !h.matchesFile(local) {
+			return false
+		}
+		checked = checked || h.NewLines > 0
+	}
+	return checked
+}
+
+// changedSpan returns the new-file lines between which h's first and last
+// changes sit, for looking up the function around them. A deletion sits
+// between two new lines; the one above it is used.
+func (h *diffHunk) changedSpan() (from, to int, ok bool) {
+	n, _ := h.newSpan()
+	for _, line := range h.Lines {
+		switch {
+		case strings.HasPrefix(line, "+"):
+			if !ok {
+				from = n
+			}
+			to, ok = n, true
+			n++
+		case strings.HasPrefix(line, "-"):
+			if !ok {
+				from = n - 1
+			}
+			to, ok = n-1, true
+		case strings.HasPrefix(line, " "):
+			n++
+		}
+	}
+	return from, to, ok
+}
+
+// functionSpan returns the new-file lines, from and to, of the function
+// around h's changes in local, kept between the lines already shown (up to
+// prevEnd) and the next hunk (from nextStart). open says the previous hunk's
+// function ran on into this one. inFunction is false, and the span is h's
+// own, when no function header precedes the changes; ended reports whether
+// the function was seen to end before nextStart.
+func (h *diffHunk) functionSpan(local []string, prevEnd, nextStart int, open bool) (from, to int, inFunction, ended bool) {
+	first, last := h.newSpan()
+	changeFrom, changeTo, ok := h.changedSpan()
+	if !ok {
+		return first, last, false, true
+	}
+	from, to = first, last
+
+	inFunction = open
+	for n := min(changeFrom, len(local)); n > prevEnd; n-- {
+		if isFuncHeader(local[n-1]) {
+			from, inFunction = min(n, first), true
+			break
+		}
+	}
+	if !inFunction {
+		return first, last, false, true
+	}
+
+	to = max(nextStart-1, last)
+	for n := changeTo + 1; n < nextStart; n++ {
+		if isFuncHeader(local[n-1]) {
+			to, ended = n-1, true
+			break
+		}
+	}
+	for to > last && strings.TrimSpace(local[to-1]) == "" {
+		to--
+	}
+	return from, max(to, last), true, ended
+}
+
+// widen adds the lines of local from..to around h as context and rewrites
+// its header, keeping git's function name after the second "@@".
+func (h *diffHunk) widen(local []string, from, to int) {
+	first, last := h.newSpan()
+	before, after := first-from, to-last
+	if before <= 0 && after <= 0 {
+		return
+	}
+	lines := make([]string, 0, before+len(h.Lines)+after)
+	for n := from; n < first; n++ {
+		lines = append(lines, " "+local[n-1])
+	}
+	lines = append(lines, h.Lines...)
+	for n := last + 1; n <= to; n++ {
+		lines = append(lines, " "+local[n-1])
+	}
+
+	oldFirst := h.OldStart
+	if h.OldLines == 0 {
+		oldFirst++
+	}
+	h.OldStart, h.OldLines = oldFirst-before, h.OldLines+before+after
+	h.NewStart, h.NewLines = from, h.NewLines+before+after
+	_, suffix, _ := strings.Cut(strings.TrimPrefix(trimCR(h.Header), "@@ "), " @@")
+	h.Header = fmt.Sprintf("@@ -%d,%d +%d,%d @@%s", h.OldStart, h.OldLines, h.NewStart, h.NewLines, suffix)
+	h.Lines = lines
+}
+
+// addFunctionContext widens the hunks of a diff that did not come from the
+// local git repository (--pr, --file) to their enclosing functions, read from
+// the files under root, much as git --function-context would. A file is left
+// alone when it is missing under root, outside it, or its hunks do not match
+// it, which means the checkout is at another version. Hunks are widened in
+// order while the diff stays within budget tokens. It returns the diff and
+// how many hunks were widened.
+func addFunctionContext(ctx context.Context, cfg *Config, diff, root string, budget int) (string, int) {
+	d := parseDiff(diff)
+	tokens := roughTokens(ctx, diff)
+	widened := 0
+	for _, f := range d.Files {
+		if f.Binary || f.Status == fileDeleted || len(f.Hunks) == 0 {
+			continue
+		}
+		local, err := readCheckoutFile(root, f.NewPath)
+		if err != nil {
+			continue
+		}
+		if !f.matchesCheckout(local) {
+			debugLog(cfg, "context: %s does not match the local file, not widened", f.Path())
+			continue
+		}
+
+		prevEnd, open := 0, false
+		for i, h := range f.Hunks {
+			nextStart := len(local) + 1
+			if i+1 < len(f.Hunks) {
+				nextStart, _ = f.Hunks[i+1].newSpan()
+			}
+			from, to, inFunction, ended := h.functionSpan(local, prevEnd, nextStart, open)
+			first, last := h.newSpan()
+			extra := 0
+			if from < first {
+				extra += roughTokens(ctx, strings.Join(local[from-1:first-1], "\n"))
+			}
+			if to > last {
+				extra += roughTokens(ctx, strings.Join(local[last:to], "\n"))
+			}
+			if tokens+extra > budget {
+				debugLog(cfg, "context: token budget reached at %s, %d hunk(s) widened", f.Path(), widened)
+				return d.String(), widened
+			}
+			if from < first || to > last {
+				h.widen(local, from, to)
+				tokens += extra
+				widened++
+			}
+			prevEnd, open = max(to, last), inFunction && !ended
+		}
+	}
+	return d.String(), widened
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDiffContext(t *testing.T) {
+	for in, want := range map[string]diffContext{"": "", "function": functionContext, " Function ": functionContext, "0": "0", "10": "10", "007": "7"} {
+		if got, err := parseDiffContext(in); err != nil || got != want {
+			t.Errorf("parseDiffContext(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"-1", "func", "3.5"} {
+		if _, err := parseDiffContext(in); err == nil {
+			t.Errorf("parseDiffContext(%q): expected an error", in)
+		}
+	}
+	if diffContext("").gitArgs() != nil || functionContext.gitArgs()[0] != "--function-context" || diffContext("0").gitArgs()[0] != "-U0" {
+		t.Error("unexpected git options")
+	}
+}
+
+// contextSource is a Go file with three functions; line 9 is "\tb := 2".
+const contextSource = `package demo
+
+func first() int {
+	return 1
+}
+
+func second() int {
+	a := 1
+	b := 2
+	c := 3
+	d := 4
+	e := 5
+	return a + b + c + d + e
+}
+
+func third() int {
+	return 3
+}
+`
+
+// contextDiff changes "b := 2" in contextSource with git's three lines.
+const contextDiff = `diff --git a/demo.go b/demo.go
+index 1111111..2222222 100644
+--- a/demo.go
++++ b/demo.go
+@@ -6,7 +6,7 @@ func first() int {
+` + " \n" + ` func second() int {
+ 	a := 1
+-	b := 1
++	b := 2
+ 	c := 3
+ 	d := 4
+ 	e := 5
+`
+
+func TestGetGitDiff_Context(t *testing.T) {
+	dir := initEmptyRepo(t)
+	path := filepath.Join(dir, "demo.go")
+	if err := os.WriteFile(path, []byte(strings.Replace(contextSource, "b := 2", "b := 1", 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", ".")
+	runGit(t, "commit", "-m", "initial")
+	if err := os.WriteFile(path, []byte(contextSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := getGitDiff("", false, gitBase{}, functionContext)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(diff, " \treturn a + b + c + d + e\n }\n") || strings.Contains(diff, "func third") {
+		t.Errorf("expected the whole of second() and nothing more, got:\n%s", diff)
+	}
+	diff, err = getGitDiff("", false, gitBase{}, "0")
+	if err != nil || !strings.Contains(diff, "@@ -9 +9 @@") {
+		t.Errorf("expected a diff without context lines, got:\n%s (%v)", diff, err)
+	}
+}
+
+func TestAddFunctionContext(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "demo.go"), []byte(contextSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	diff := "Title: Bump b\n\n" + contextDiff
+
+	got, widened := addFunctionContext(context.Background(), &Config{}, diff, root, 10_000)
+	if widened != 1 {
+		t.Fatalf("expected one hunk widened, got %d:\n%s", widened, got)
+	}
+	d := parseDiff(got)
+	h := d.Files[0].Hunks[0]
+	if h.Header != "@@ -6,9 +6,9 @@ func first() int {" {
+		t.Errorf("unexpected hunk header %q", h.Header)
+	}
+	if h.Lines[1] != " func second() int {" || h.Lines[len(h.Lines)-1] != " }" {
+		t.Errorf("expected the hunk to span second(), got %q", h.Lines)
+	}
+	if !strings.HasPrefix(got, "Title: Bump b\n\n") || d.Files[0].Added != 1 || d.Files[0].Removed != 1 {
+		t.Errorf("expected the preamble and changes kept, got:\n%s", got)
+	}
+
+	if got, widened := addFunctionContext(context.Background(), &Config{}, diff, root, roughTokens(context.Background(), diff)); widened != 0 || got != diff {
+		t.Errorf("expected nothing widened without budget, got %d", widened)
+	}
+	if err := os.WriteFile(filepath.Join(root, "demo.go"), []byte(strings.Replace(contextSource, "c := 3", "c := 30", 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, widened := addFunctionContext(context.Background(), &Config{}, diff, root, 10_000); widened != 0 || got != diff {
+		t.Errorf("expected a file that differs locally to be left alone, got %d", widened)
+	}
+	if got, widened := addFunctionContext(context.Background(), &Config{}, diff, t.TempDir(), 10_000); widened != 0 || got != diff {
+		t.Errorf("expected a missing file to be left alone, got %d", widened)
+	}
+}
+
+func TestAddFunctionContext_AdjacentHunks(t *testing.T) {
+	root := t.TempDir()
+	src := "package demo\n\nfunc a() {\n\tx()\n\ty()\n}\n\nfunc b() {\n\tz()\n}\n"
+	if err := os.WriteFile(filepath.Join(root, "demo.go"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	diff := "diff --git a/demo.go b/demo.go\n--- a/demo.go\n+++ b/demo.go\n" +
+		"@@ -3,0 +4 @@ func a() {\n+\tx()\n" +
+		"@@ -7,0 +9 @@ func b() {\n+\tz()\n"
+
+	got, widened := addFunctionContext(context.Background(), &Config{}, diff, root, 10_000)
+	hunks := parseDiff(got).Files[0].Hunks
+	if widened != 2 || hunks[0].Header != "@@ -3,3 +3,4 @@ func a() {" || hunks[1].Header != "@@ -7,2 +8,3 @@ func b() {" {
+		t.Fatalf("expected each hunk widened to its own function, got:\n%s", got)
+	}
+	if hunks[1].Lines[0] != " func b() {" || hunks[1].Lines[len(hunks[1].Lines)-1] != " }" {
+		t.Errorf("unexpected second hunk %q", hunks[1].Lines)
+	}
+}
+
+func TestRootCmd_InvalidContext(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "dummy")
+	cmd := newRootCmd(func(context.Context, *Config, ApiProvider, string, string) (string, error) {
+		return "ok", nil
+	})
+	cmd.SetArgs([]string{"--provider=openai", "--context=lots", "--file=testdata/new-file.diff"})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	if err := cmd.Execute(); exitCodeOf(err) != 4 {
+		t.Errorf("expected a usage error, got %v", err)
+	}
+}
+
+func TestAddFunctionContext_OnlyTrustedFiles(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "repo")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{
+		filepath.Join(parent, "zz_outside.txt"): "top secret line one\nline two\n",
+		filepath.Join(root, "demo.go"):          contextSource,
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deletion := func(p string) string {
+		return "diff --git a/" + p + " b/" + p + "\n--- a/" + p + "\n+++ b/" + p + "\n@@ -2 +1,0 @@\n-gone\n"
+	}
+
+	for _, p := range []string{"../zz_outside.txt", "demo.go"} {
+		diff := deletion(p)
+		if got, widened := addFunctionContext(context.Background(), &Config{}, diff, root, 10_000); widened != 0 || got != diff {
+			t.Errorf("%s: expected a diff with nothing to check against the file left alone, got:\n%s", p, got)
+		}
+	}
+	if _, err := readCheckoutFile(root, "../zz_outside.txt"); err == nil {
+		t.Error("expected a path outside the checkout to be refused")
+	}
+	if _, ok := newGoFileChange(parseDiff(fileDiff("../x.go", 1)).Files[0], root); ok {
+		t.Error("expected a Go file outside the checkout to be skipped")
+	}
+}
@@ -168,9 +168,27 @@ func TestShowCostEstimate_ContainsExpectedFields(t *testing.T) {
 		"- Diff lines:",
 		"- Estimated Input Tokens:",
 		"- Estimated Input Cost:",
+		"- Output Price:",
 	} {
 		if !strings.Contains(out, want) {
 			t.Errorf("expected %q in output, got:\n%s", want, out)
 		}
 	}
 }
+
+// TestShowCostEstimate_UsesPricingOverride verifies that [[pricing]] entries
+// replace the built-in price in the estimate.
+func TestShowCostEstimate_UsesPricingOverride(t *testing.T) {
+	cfg := &Config{
+		Provider:    OpenAI,
+		OpenAIModel: "gpt-4o",
+		Pricing:     []PricingOverride{{Model: "gpt-4o", Input: 1_000_000, Output: 2}},
+	}
+	var buf strings.Builder
+	// 7 chars / 3.5 = 2 heuristic tokens at $1 per token.
+	showCostEstimate(context.Background(), cfg, "", "1234567", &buf)
+	out := buf.String()
+	if !strings.Contains(out, "Estimated Input Cost: $2.000000") || !strings.Contains(out, "Output Price: $2.00 per 1M tokens") {
+		t.Errorf("expected override prices in output, got:\n%s", out)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// providerAnswer names the provider and model that produced a response.
+type providerAnswer struct {
+	Provider ApiProvider
+	Model    string
+}
+
+// answerReportKey is the context key under which an *answerReport is stored.
+type answerReportKey struct{}
+
+// answerReport receives the providerAnswer of a single chatFn call. Like
+// usageReport it travels in the context, which keeps the chatFn signature
+// unchanged and gives each parallel call its own answer.
+type answerReport struct {
+	mu       sync.Mutex
+	answer   providerAnswer
+	reported bool
+}
+
+// withAnswerReport returns a context carrying a fresh answerReport.
+func withAnswerReport(ctx context.Context) (context.Context, *answerReport) {
+	r := &answerReport{}
+	return context.WithValue(ctx, answerReportKey{}, r), r
+}
+
+// reportAnswer records which provider and model answered. It is a no-op when
+// ctx carries no answerReport.
+func reportAnswer(ctx context.Context, a providerAnswer) {
+	r, ok := ctx.Value(answerReportKey{}).(*answerReport)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.answer = a
+	r.reported = true
+}
+
+// get returns the reported answer, or requested with its configured model
+// when none was reported (a chatFn that does not go through
+// callWithFallback, such as a test double).
+func (r *answerReport) get(cfg *Config, requested ApiProvider) providerAnswer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.reported {
+		return r.answer
+	}
+	return providerAnswer{Provider: requested, Model: providerModel(cfg, requested)}
+}
+
+// providerChain returns primary followed by cfg.FallbackProviders, without
+// duplicates.
+func providerChain(cfg *Config, primary ApiProvider) []ApiProvider {
+	chain := []ApiProvider{primary}
+	seen := map[ApiProvider]bool{primary: true}
+	for _, name := range cfg.FallbackProviders {
+		if !seen[name] {
+			seen[name] = true
+			chain = append(chain, name)
+		}
+	}
+	return chain
+}
+
+// validateFallbackProviders rejects fallback_providers entries that are not
+// registered, so typos are reported at startup rather than mid-outage.
+func validateFallbackProviders(cfg *Config) error {
+	for _, name := range cfg.FallbackProviders {
+		if _, ok := lookupProvider(name); !ok {
+			return fmt.Errorf("unknown provider %q in fallback_providers: choose from %s", name, providerNameList())
+		}
+	}
+	return nil
+}
+
+// callWithFallback runs call against the primary provider and, if it fails
+// with a retryable error once its own retries are exhausted, against each
+// fallback provider in order. Fallbacks that are not configured (missing key,
+// binary, …) are skipped. Errors from the primary that are not retryable —
+// including its config validation — are returned unchanged. On success it
+// also returns which provider and model answered.
+func callWithFallback(ctx context.Context, cfg *Config, primary ApiProvider, call func(Provider) (string, error)) (string, providerAnswer, error) {
+	var primaryErr error
+	var failedFallbacks []string
+	for i, name := range providerChain(cfg, primary) {
+		p, ok := lookupProvider(name)
+		if !ok {
+			if i == 0 {
+				return "", providerAnswer{}, errors.New("unsupported provider")
+			}
+			continue
+		}
+		if err := p.Validate(cfg); err != nil {
+			if i == 0 {
+				return "", providerAnswer{}, err
+			}
+			debugLog(cfg, "fallback: skipping %s: %s", name, firstLine(err.Error()))
+			continue
+		}
+		if i > 0 {
+			debugLog(cfg, "fallback: trying %s model=%s", name, p.Model(cfg))
+		}
+		result, err := call(p)
+		if err == nil {
+			if i > 0 {
+				debugLog(cfg, "fallback: answered by %s model=%s", name, p.Model(cfg))
+			}
+			return result, providerAnswer{Provider: name, Model: p.Model(cfg)}, nil
+		}
+
+		// Partial stream output or a cancelled context ends the chain: the
+		// next provider would duplicate text or be cancelled too.
+		var final *noRetryError
+		if errors.As(err, &final) || ctx.Err() != nil {
+			return "", providerAnswer{}, err
+		}
+		if i == 0 {
+			if !classifyRetry(err).retry {
+				return "", providerAnswer{}, err
+			}
+			primaryErr = err
+		} else {
+			failedFallbacks = append(failedFallbacks, string(name))
+		}
+		debugLog(cfg, "fallback: %s failed: %s", name, firstLine(err.Error()))
+	}
+	if len(failedFallbacks) > 0 {
+		return "", providerAnswer{}, fmt.Errorf("%w\n\nFallback providers also failed: %s", primaryErr, strings.Join(failedFallbacks, ", "))
+	}
+	return "", providerAnswer{}, primaryErr
+}
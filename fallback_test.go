@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedProvider returns err from every call when set, otherwise reply.
+// Stream writes partial before failing so partial-output handling can be tested.
+type scriptedProvider struct {
+	fakeProvider
+	err     error
+	partial string
+	invalid bool
+	calls   int
+}
+
+func (s *scriptedProvider) Call(context.Context, *Config, string, string) (string, error) {
+	s.calls++
+	if s.err != nil {
+		return "", s.err
+	}
+	return s.reply, nil
+}
+
+func (s *scriptedProvider) Stream(_ context.Context, _ *Config, _, _ string, w io.Writer) (string, error) {
+	s.calls++
+	if s.partial != "" {
+		_, _ = io.WriteString(w, s.partial)
+	}
+	if s.err != nil {
+		return "", s.err
+	}
+	_, _ = io.WriteString(w, s.reply)
+	return s.reply, nil
+}
+
+func (s *scriptedProvider) Validate(*Config) error {
+	if s.invalid {
+		return errors.New("not configured")
+	}
+	return nil
+}
+
+func newScripted(name ApiProvider, model, reply string, err error) *scriptedProvider {
+	return &scriptedProvider{fakeProvider: fakeProvider{name: name, reply: reply, model: model}, err: err}
+}
+
+var errOverloaded = &httpStatusError{StatusCode: 529, msg: "overloaded"}
+
+func TestChatCompletions_FallsBackOnTransientFailure(t *testing.T) {
+	primary := newScripted("primary", "p-model", "", errOverloaded)
+	backup := newScripted("backup", "b-model", "from backup", nil)
+	withTestRegistry(t, primary, backup)
+
+	var log strings.Builder
+	cfg := &Config{Provider: "primary", FallbackProviders: []ApiProvider{"backup"}, DebugWriter: &log}
+	ctx, answered := withAnswerReport(context.Background())
+	got, err := chatCompletions(ctx, cfg, "primary", "p", "d")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "from backup" {
+		t.Errorf("expected fallback answer, got %q", got)
+	}
+	if a := answered.get(cfg, "primary"); a.Provider != "backup" || a.Model != "b-model" {
+		t.Errorf("expected backup/b-model to be reported, got %s/%s", a.Provider, a.Model)
+	}
+	if !strings.Contains(log.String(), "fallback: answered by backup model=b-model") {
+		t.Errorf("expected fallback in debug log, got:\n%s", log.String())
+	}
+}
+
+func TestChatCompletions_NoFallbackOnAuthError(t *testing.T) {
+	primary := newScripted("primary", "p", "", &httpStatusError{StatusCode: 401, msg: "unauthorized"})
+	backup := newScripted("backup", "b", "from backup", nil)
+	withTestRegistry(t, primary, backup)
+
+	cfg := &Config{Provider: "primary", FallbackProviders: []ApiProvider{"backup"}}
+	if _, err := chatCompletions(context.Background(), cfg, "primary", "p", "d"); err == nil || err.Error() != "unauthorized" {
+		t.Fatalf("expected primary auth error unchanged, got %v", err)
+	}
+	if backup.calls != 0 {
+		t.Errorf("expected no fallback call, got %d", backup.calls)
+	}
+}
+
+func TestChatCompletions_SkipsUnconfiguredFallback(t *testing.T) {
+	primary := newScripted("primary", "p", "", errOverloaded)
+	unconfigured := newScripted("nokey", "n", "never", nil)
+	unconfigured.invalid = true
+	last := newScripted("local", "l", "from local", nil)
+	withTestRegistry(t, primary, unconfigured, last)
+
+	cfg := &Config{Provider: "primary", FallbackProviders: []ApiProvider{"nokey", "local"}}
+	got, err := chatCompletions(context.Background(), cfg, "primary", "p", "d")
+	if err != nil || got != "from local" {
+		t.Fatalf("expected local to answer, got %q / %v", got, err)
+	}
+	if unconfigured.calls != 0 {
+		t.Errorf("expected unconfigured provider to be skipped")
+	}
+}
+
+func TestChatCompletions_AllProvidersFail(t *testing.T) {
+	primary := newScripted("primary", "p", "", errOverloaded)
+	backup := newScripted("backup", "b", "", &httpStatusError{StatusCode: 500, msg: "backup down"})
+	withTestRegistry(t, primary, backup)
+
+	cfg := &Config{Provider: "primary", FallbackProviders: []ApiProvider{"backup"}}
+	_, err := chatCompletions(context.Background(), cfg, "primary", "p", "d")
+	if err == nil || !strings.Contains(err.Error(), "overloaded") || !strings.Contains(err.Error(), "Fallback providers also failed: backup") {
+		t.Fatalf("expected combined error, got %v", err)
+	}
+}
+
+func TestStreamToWriter_FallsBackOnlyBeforeOutput(t *testing.T) {
+	primary := newScripted("primary", "p", "", errOverloaded)
+	backup := newScripted("backup", "b", "streamed backup", nil)
+	withTestRegistry(t, primary, backup)
+
+	cfg := &Config{Provider: "primary", FallbackProviders: []ApiProvider{"backup"}}
+	var buf strings.Builder
+	got, err := streamToWriter(context.Background(), cfg, "primary", "p", "d", &buf)
+	if err != nil || got != "streamed backup" || buf.String() != "streamed backup" {
+		t.Fatalf("expected fallback stream, got %q / %q / %v", got, buf.String(), err)
+	}
+
+	primary.partial = "half an answer"
+	backup.calls = 0
+	buf.Reset()
+	if _, err := streamToWriter(context.Background(), cfg, "primary", "p", "d", &buf); err == nil {
+		t.Fatal("expected stream error")
+	}
+	if backup.calls != 0 {
+		t.Errorf("expected no fallback after partial output")
+	}
+}
+
+func TestRootCmd_JSONReportsFallbackProvider(t *testing.T) {
+	primary := newScripted("primary", "p-model", "", errOverloaded)
+	backup := newScripted("backup", "b-model", "fallback comment", nil)
+	withTestRegistry(t, primary, backup)
+	t.Setenv("AI_MR_COMMENT_FALLBACK_PROVIDERS", "backup")
+
+	var out, errOut strings.Builder
+	cmd := newRootCmd(chatCompletions)
+	cmd.SetArgs([]string{"--file=testdata/simple.diff", "--provider=primary", "--format=json", "--verbose"})
+	cmd.SetOut(&out)
+	cmd.SetErr(&errOut)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var payload struct {
+		Provider string `json:"provider"`
+		Model    string `json:"model"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &payload); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if payload.Provider != "backup" || payload.Model != "b-model" {
+		t.Errorf("expected backup/b-model in JSON, got %+v", payload)
+	}
+	if !strings.Contains(errOut.String(), "fallback: answered by backup") {
+		t.Errorf("expected fallback in verbose log, got:\n%s", errOut.String())
+	}
+}
+
+func TestRootCmd_JSONReportsDescriptionProvider(t *testing.T) {
+	// The title falls back and finishes last; provider and model still
+	// describe the description.
+	chatFn := func(ctx context.Context, cfg *Config, provider ApiProvider, systemPrompt, _ string) (string, error) {
+		if systemPrompt == titlePrompt {
+			time.Sleep(50 * time.Millisecond)
+			reportAnswer(ctx, providerAnswer{Provider: Ollama, Model: "llama3"})
+			return "title", nil
+		}
+		reportAnswer(ctx, providerAnswer{Provider: provider, Model: "gpt-4o"})
+		return "description", nil
+	}
+	t.Setenv("OPENAI_API_KEY", "test")
+	var out strings.Builder
+	cmd := newRootCmd(chatFn)
+	cmd.SetArgs([]string{"--file=testdata/simple.diff", "--provider=openai", "--format=json", "--no-cache"})
+	cmd.SetOut(&out)
+	cmd.SetErr(io.Discard)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var payload struct {
+		Title    string `json:"title"`
+		Provider string `json:"provider"`
+		Model    string `json:"model"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &payload); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if payload.Title != "title" || payload.Provider != "openai" || payload.Model != "gpt-4o" {
+		t.Errorf("expected the description's openai/gpt-4o in JSON, got %+v", payload)
+	}
+}
+
+func TestValidateProviderConfig_UnknownFallback(t *testing.T) {
+	cfg := &Config{Provider: Ollama, FallbackProviders: []ApiProvider{"nope"}}
+	err := validateProviderConfig(cfg)
+	if err == nil || !strings.Contains(err.Error(), "fallback_providers") {
+		t.Errorf("expected unknown fallback error, got %v", err)
+	}
+}
+
+func TestPingProvider_IgnoresFallbacks(t *testing.T) {
+	primary := newScripted("primary", "p", "", errOverloaded)
+	backup := newScripted("backup", "b", "OK", nil)
+	withTestRegistry(t, primary, backup)
+
+	cfg := &Config{FallbackProviders: []ApiProvider{"backup"}}
+	r := pingProvider(context.Background(), cfg, "primary", chatCompletions)
+	if r.err == nil {
+		t.Error("expected ping to report the primary failure, not the fallback answer")
+	}
+}
+
+func TestLoadConfigWith_FallbackProvidersProfile(t *testing.T) {
+	path := writeConfigFile(t, `
+provider = "anthropic"
+fallback_providers = ["openai"]
+
+[profile.ci]
+fallback_providers = ["openai", "ollama"]
+`)
+	cfg, err := loadConfigWith(newViperFromFile(path), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.FallbackProviders) != 1 || cfg.FallbackProviders[0] != OpenAI {
+		t.Errorf("expected global fallback [openai], got %v", cfg.FallbackProviders)
+	}
+	cfg, err = loadConfigWith(newViperFromFile(path), "ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.FallbackProviders) != 2 || cfg.FallbackProviders[1] != Ollama {
+		t.Errorf("expected profile fallback [openai ollama], got %v", cfg.FallbackProviders)
+	}
+}
@@ -7,9 +7,9 @@ import (
 	"testing"
 )
 
-// FuzzSplitDiffByFile tests that splitDiffByFile never panics and that every
-// returned chunk contains the "diff --git" header it was split on.
-func FuzzSplitDiffByFile(f *testing.F) {
+// FuzzParseDiffFiles tests that parseDiff starts a file at every "diff --git "
+// line and nowhere else.
+func FuzzParseDiffFiles(f *testing.F) {
 	// Inline seeds covering common diff shapes.
 	f.Add("")
 	f.Add("diff --git a/foo.txt b/foo.txt\n--- a/foo.txt\n+++ b/foo.txt\n@@ -1 +1 @@\n-old\n+new\n")
@@ -31,24 +31,52 @@ func FuzzSplitDiffByFile(f *testing.F) {
 	}
 
 	f.Fuzz(func(t *testing.T, raw string) {
-		chunks := splitDiffByFile(raw)
-		// The first chunk may be pre-header content (no "diff --git") when the
-		// input has text before the first marker. Every chunk after the first
-		// must start with "diff --git" since splits only happen at those markers.
-		for i, c := range chunks {
-			if i == 0 {
-				continue
+		d := parseDiff(raw)
+		// Text before the first marker is the preamble; every file must start
+		// with "diff --git" since splits only happen at those markers.
+		for _, line := range d.Preamble {
+			if strings.HasPrefix(line, "diff --git ") {
+				t.Errorf("preamble should not contain a 'diff --git ' line; got: %q", line[:min(len(line), 80)])
 			}
-			if !strings.HasPrefix(c, "diff --git") {
-				t.Errorf("chunk[%d] should start with 'diff --git'; got: %q", i, c[:min(len(c), 80)])
+		}
+		for i, file := range d.Files {
+			if !strings.HasPrefix(file.Header[0], "diff --git ") {
+				t.Errorf("file[%d] should start with 'diff --git'; got: %q", i, file.Header[0][:min(len(file.Header[0]), 80)])
 			}
 		}
 	})
 }
 
-// FuzzProcessDiff tests that processDiff never panics for any combination of
-// raw string input and maxLines value.
-func FuzzProcessDiff(f *testing.F) {
+// FuzzParseDiff tests that parseDiff never panics and that rendering the
+// parsed diff gives back the input exactly.
+func FuzzParseDiff(f *testing.F) {
+	f.Add("")
+	f.Add("\n")
+	f.Add("diff --git a/foo.txt b/foo.txt\n--- a/foo.txt\n+++ b/foo.txt\n@@ -1 +1 @@\n-old\n+new\n")
+	f.Add("PR Title: x\n\ndiff --git \"a/t\\tab\" \"b/t\\tab\"\nnew file mode 100644\n@@ -0,0 +1 @@\n+x")
+	f.Add("diff --git\n@@\n@@ -a,b +c @@\r\n")
+
+	for _, name := range []string{
+		"testdata/rename-move.diff",
+		"testdata/binary-files.diff",
+		"testdata/crlf-line-endings.diff",
+		"testdata/no-newline-at-eof.diff",
+	} {
+		if b, err := os.ReadFile(name); err == nil {
+			f.Add(string(b))
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		if got := parseDiff(raw).String(); got != raw {
+			t.Errorf("round trip changed %q to %q", raw[:min(len(raw), 80)], got[:min(len(got), 80)])
+		}
+	})
+}
+
+// FuzzTruncateDiffBytes tests that truncateDiffBytes never panics, leaves a
+// diff within the limit unchanged and otherwise keeps at most maxBytes of it.
+func FuzzTruncateDiffBytes(f *testing.F) {
 	f.Add("", 0)
 	f.Add("", 1)
 	f.Add("single line", 0)
@@ -61,10 +89,16 @@ func FuzzProcessDiff(f *testing.F) {
 		f.Add(string(b), 1)
 	}
 
-	f.Fuzz(func(t *testing.T, raw string, maxLines int) {
-		// maxLines must be non-negative; reflect negative values to positive.
-		maxLines = absIntNoOverflow(maxLines)
-		_ = processDiff(raw, maxLines)
+	f.Fuzz(func(t *testing.T, raw string, maxBytes int) {
+		// maxBytes must be non-negative; reflect negative values to positive.
+		maxBytes = absIntNoOverflow(maxBytes)
+		got := truncateDiffBytes(raw, maxBytes)
+		if len(raw) <= maxBytes && got != raw {
+			t.Errorf("expected a diff within the limit unchanged")
+		}
+		if len(got) > max(len(raw), maxBytes+len("\n[...diff truncated...]\n")) {
+			t.Errorf("kept %d bytes of %d with a %d-byte limit", len(got), len(raw), maxBytes)
+		}
 	})
 }
 
@@ -10,6 +10,8 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 
 	gogithub "github.com/google/go-github/v68/github"
 	gogitlab "gitlab.com/gitlab-org/api/client-go"
@@ -83,9 +85,12 @@ func resolveGitLabBaseURL(mrURL, configuredBaseURL string) (string, error) {
 	return normalizedBase, nil
 }
 
-// getRemoteURL returns the push URL for the "origin" remote.
-func getRemoteURL() (string, error) {
-	out, err := exec.Command("git", "remote", "get-url", "origin").CombinedOutput() //nolint:gosec // G204: git is a fixed binary, "origin" is a constant
+// defaultRemote is the remote used when remote is not configured.
+const defaultRemote = "origin"
+
+// getRemoteURL returns the URL of the named remote.
+func getRemoteURL(remote string) (string, error) {
+	out, err := exec.Command("git", "remote", "get-url", remote).CombinedOutput() //nolint:gosec // G204: git is a fixed binary, remote is from config
 	if err != nil {
 		return "", fmt.Errorf("getting remote URL: %w", err)
 	}
@@ -134,16 +139,122 @@ func prCreateURL(remoteURL, branch string) string {
 	return ""
 }
 
+// gitBase selects what the working tree is diffed against when no commit is
+// given: Branch when set (--base or base_branch), otherwise the detected
+// default branch of Remote.
+type gitBase struct {
+	Remote string
+	Branch string
+	// hostedDefault asks the hosting API for the default branch; nil skips it.
+	hostedDefault func() (string, error)
+}
+
+// newGitBase builds the gitBase for a run. branch is the --base flag value and
+// overrides base_branch. The hosting API is queried with cfg's credentials, at
+// most once however often the merge base is resolved.
+func newGitBase(ctx context.Context, cfg *Config, branch string) gitBase {
+	if branch == "" {
+		branch = cfg.BaseBranch
+	}
+	b := gitBase{Remote: cfg.Remote, Branch: branch}
+	var once sync.Once
+	var name string
+	var err error
+	b.hostedDefault = func() (string, error) {
+		once.Do(func() { name, err = getHostedDefaultBranch(ctx, cfg, b.remote()) })
+		return name, err
+	}
+	return b
+}
+
+func (b gitBase) remote() string {
+	if b.Remote == "" {
+		return defaultRemote
+	}
+	return b.Remote
+}
+
+// mergeBaseWith returns the merge base of HEAD and ref.
+func mergeBaseWith(ref string) (string, bool) {
+	out, err := exec.Command("git", "merge-base", "HEAD", ref).CombinedOutput() //nolint:gosec // G204: git is a fixed binary, ref is a branch name from config or git itself
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(out)), true
+}
+
+// remoteHEADRef returns the remote's default branch as recorded by clone or
+// "git remote set-head", e.g. "origin/main".
+func remoteHEADRef(remote string) string {
+	out, err := exec.Command("git", "symbolic-ref", "--quiet", "--short", "refs/remotes/"+remote+"/HEAD").Output() //nolint:gosec // G204: git is a fixed binary, remote is from config
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// upstreamBaseRef returns the current branch's upstream tracking ref when it
+// is another branch, e.g. origin/develop for a branch created with
+// "git switch -c feat origin/develop". The branch's own pushed copy is not a
+// base and is skipped.
+func upstreamBaseRef() string {
+	out, err := exec.Command("git", "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{upstream}").Output() //nolint:gosec // G204: git is a fixed binary, args are internal constants
+	if err != nil {
+		return ""
+	}
+	upstream := strings.TrimSpace(string(out))
+	if branch, err := getCurrentBranch(); err == nil && branch != "" && (upstream == branch || strings.HasSuffix(upstream, "/"+branch)) {
+		return ""
+	}
+	return upstream
+}
+
 // getAutoMergeBase returns the common ancestor commit between HEAD and the
-// remote default branch, trying origin/main then origin/master.
-func getAutoMergeBase() (string, error) {
-	for _, branch := range []string{"origin/main", "origin/master"} {
-		out, err := exec.Command("git", "merge-base", "HEAD", branch).CombinedOutput() //nolint:gosec // G204: git is a fixed binary, args are internal constants
-		if err == nil {
-			return strings.TrimSpace(string(out)), nil
+// base branch. An explicit base is looked up on the remote, then locally.
+// Otherwise the default branch is detected from the remote's HEAD, the current
+// branch's upstream, and the hosting API, falling back to <remote>/main and
+// <remote>/master.
+func getAutoMergeBase(base gitBase) (string, error) {
+	remote := base.remote()
+	if base.Branch != "" {
+		refs := []string{remote + "/" + base.Branch, base.Branch}
+		if strings.HasPrefix(base.Branch, "refs/") {
+			refs = refs[1:]
+		}
+		for _, ref := range refs {
+			if mb, ok := mergeBaseWith(ref); ok {
+				return mb, nil
+			}
+		}
+		return "", fmt.Errorf("could not determine merge base: base branch %q not found on remote %q or locally (try git fetch %s)", base.Branch, remote, remote)
+	}
+
+	detectors := []func() string{
+		func() string { return remoteHEADRef(remote) },
+		upstreamBaseRef,
+		func() string {
+			if base.hostedDefault == nil {
+				return ""
+			}
+			if name, err := base.hostedDefault(); err == nil && name != "" {
+				return remote + "/" + name
+			}
+			return ""
+		},
+	}
+	for _, detect := range detectors {
+		if ref := detect(); ref != "" {
+			if mb, ok := mergeBaseWith(ref); ok {
+				return mb, nil
+			}
+		}
+	}
+	for _, ref := range []string{remote + "/main", remote + "/master"} {
+		if mb, ok := mergeBaseWith(ref); ok {
+			return mb, nil
 		}
 	}
-	return "", fmt.Errorf("could not determine merge base: no origin/main or origin/master found")
+	return "", fmt.Errorf("could not determine merge base: no default branch found for remote %q; set base_branch or use --base", remote)
 }
 
 // isGitRepo reports whether the current directory is inside a git repository.
@@ -197,11 +308,11 @@ func gitCommit(message, body string) error {
 	return nil
 }
 
-// gitPush pushes the current branch to its upstream remote.
-// It uses --set-upstream origin <branch> so it works even on a branch with no
+// gitPush pushes the current branch to remote.
+// It uses --set-upstream <remote> <branch> so it works even on a branch with no
 // tracking ref yet (e.g. the first push of a new branch).
-func gitPush(branch string) error {
-	out, err := exec.Command("git", "push", "--set-upstream", "origin", branch).CombinedOutput() //nolint:gosec // G204: git is a fixed binary, branch is from getCurrentBranch
+func gitPush(remote, branch string) error {
+	out, err := exec.Command("git", "push", "--set-upstream", remote, branch).CombinedOutput() //nolint:gosec // G204: git is a fixed binary, remote is from config and branch is from getCurrentBranch
 	if err != nil {
 		return fmt.Errorf("git push: %w\n%s", err, strings.TrimSpace(string(out)))
 	}
@@ -215,9 +326,10 @@ func hasCommits() bool {
 }
 
 // getGitDiff returns the git diff for the given mode.
-// Priority: staged > explicit commit > auto merge-base > unstaged working tree.
-// Patterns in exclude are passed as git pathspecs (":!pattern") to filter files at the source.
-func getGitDiff(commit string, staged bool, exclude []string) (string, error) {
+// Priority: staged > explicit commit > merge-base with base > unstaged working tree.
+// An explicit base branch that cannot be found is an error rather than a fallback.
+// diffCtx sets how much unchanged code git shows around each change.
+func getGitDiff(commit string, staged bool, base gitBase, diffCtx diffContext) (string, error) {
 	var args []string
 	if staged {
 		args = []string{"diff", "--cached"}
@@ -229,10 +341,12 @@ func getGitDiff(commit string, staged bool, exclude []string) (string, error) {
 			// root commits and commits with parents.
 			args = []string{"show", "--format=", commit}
 		}
-	} else if base, err := getAutoMergeBase(); err == nil {
+	} else if mergeBase, err := getAutoMergeBase(base); err == nil {
 		// Diff the merge base against the working tree (staged + unstaged).
 		// This covers both committed and uncommitted changes on the branch.
-		args = []string{"diff", base}
+		args = []string{"diff", mergeBase}
+	} else if base.Branch != "" {
+		return "", err
 	} else if hasCommits() {
 		// No merge base found (no remote, detached HEAD, etc.).
 		// Fall back to all changes relative to the last commit — includes both
@@ -243,11 +357,8 @@ func getGitDiff(commit string, staged bool, exclude []string) (string, error) {
 		args = []string{"diff", "--cached"}
 	}
 
-	if len(exclude) > 0 {
-		args = append(args, "--", ".")
-		for _, pattern := range exclude {
-			args = append(args, ":!"+pattern)
-		}
+	if opts := diffCtx.gitArgs(); len(opts) > 0 {
+		args = append(append([]string{args[0]}, opts...), args[1:]...)
 	}
 
 	out, err := exec.Command("git", args...).CombinedOutput() //nolint:gosec // G204: git is a fixed binary, args are controlled by internal logic
@@ -462,6 +573,67 @@ func findOrCreateGitLabMRFromConfig(ctx context.Context, cfg *Config, projectPat
 	return findOrCreateGitLabMR(ctx, gl, projectPath, branch, title)
 }
 
+// getGitHubDefaultBranch returns the default branch of owner/repo.
+func getGitHubDefaultBranch(ctx context.Context, gh *gogithub.Client, owner, repo string) (string, error) {
+	repoInfo, _, err := gh.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return "", wrapGitHubAuthError("getting GitHub repo info", err)
+	}
+	return repoInfo.GetDefaultBranch(), nil
+}
+
+// getGitLabDefaultBranch returns the default branch of projectPath.
+func getGitLabDefaultBranch(ctx context.Context, gl *gogitlab.Client, projectPath string) (string, error) {
+	proj, _, err := gl.Projects.GetProject(projectPath, nil, gogitlab.WithContext(ctx))
+	if err != nil {
+		return "", wrapGitLabAuthError("getting GitLab project info", err)
+	}
+	return proj.DefaultBranch, nil
+}
+
+// hostedDefaultBranchTimeout bounds the hosting API lookup, which only runs
+// when git itself does not know the default branch.
+const hostedDefaultBranchTimeout = 5 * time.Second
+
+// getHostedDefaultBranch asks GitHub or GitLab for the default branch of the
+// repository behind remote. Self-hosted instances are reached at the remote's
+// host unless github_base_url or gitlab_base_url is set.
+func getHostedDefaultBranch(ctx context.Context, cfg *Config, remote string) (string, error) {
+	remoteURL, err := getRemoteURL(remote)
+	if err != nil {
+		return "", err
+	}
+	info, err := parseRemoteInfo(remoteURL)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, hostedDefaultBranchTimeout)
+	defer cancel()
+	switch {
+	case isGitHubHost(info.Host, cfg.GitHubBaseURL):
+		baseURL := cfg.GitHubBaseURL
+		if baseURL == "" && info.Host != "github.com" {
+			baseURL = "https://" + info.Host
+		}
+		gh, err := newGitHubClient(ctx, cfg.GitHubToken, baseURL)
+		if err != nil {
+			return "", err
+		}
+		return getGitHubDefaultBranch(ctx, gh, info.PathParts[0], info.PathParts[1])
+	case isGitLabHost(info.Host, cfg.GitLabBaseURL):
+		baseURL := cfg.GitLabBaseURL
+		if baseURL == "" && info.Host != "gitlab.com" {
+			baseURL = "https://" + info.Host
+		}
+		gl, err := newGitLabClient(cfg.GitLabToken, baseURL)
+		if err != nil {
+			return "", err
+		}
+		return getGitLabDefaultBranch(ctx, gl, strings.Join(info.PathParts, "/"))
+	}
+	return "", fmt.Errorf("remote host %q is not a known GitHub or GitLab host", info.Host)
+}
+
 // newGitHubClient returns a go-github client. When token is non-empty the client
 // is authenticated (5000 req/hr); otherwise unauthenticated (60 req/hr for
 // public repos). When baseURL is non-empty the client is configured for a
@@ -528,7 +700,7 @@ func getPRDiffWithClient(ctx context.Context, gh *gogithub.Client, prURL string)
 		return "", wrapGitHubAuthError("fetching GitHub PR diff", err)
 	}
 
-	return formatPRContent(pr.GetTitle(), pr.GetBody(), rawDiff), nil
+	return formatPRContent(pr.GetTitle(), pr.GetBody(), parseDiff(rawDiff)), nil
 }
 
 // getPRDiff fetches the diff and metadata for a GitHub pull request using the
@@ -576,7 +748,7 @@ func getMRDiffWithClient(ctx context.Context, gl *gogitlab.Client, mrURL string)
 		return "", wrapGitLabAuthError("fetching GitLab MR metadata", err)
 	}
 
-	var diffBuilder strings.Builder
+	diff := &parsedDiff{}
 	opts := &gogitlab.ListMergeRequestDiffsOptions{
 		ListOptions: gogitlab.ListOptions{PerPage: 100},
 	}
@@ -586,7 +758,7 @@ func getMRDiffWithClient(ctx context.Context, gl *gogitlab.Client, mrURL string)
 			return "", wrapGitLabAuthError("fetching GitLab MR diff", err)
 		}
 		for _, c := range changes {
-			diffBuilder.WriteString(c.Diff)
+			diff.Files = append(diff.Files, gitLabDiffFiles(c)...)
 		}
 		if resp == nil || resp.NextPage == 0 {
 			break
@@ -594,7 +766,26 @@ func getMRDiffWithClient(ctx context.Context, gl *gogitlab.Client, mrURL string)
 		opts.Page = resp.NextPage
 	}
 
-	return formatPRContent(mr.Title, mr.Description, diffBuilder.String()), nil
+	return formatPRContent(mr.Title, mr.Description, diff), nil
+}
+
+// gitLabDiffFiles converts one entry of the MR diffs API into diff files. The
+// API returns the hunks without git's file header, so the header is rebuilt
+// from the entry's paths, modes and flags.
+func gitLabDiffFiles(c *gogitlab.MergeRequestDiff) []*diffFile {
+	if strings.HasPrefix(c.Diff, "diff --git") {
+		return parseDiff(c.Diff).Files
+	}
+	status := fileModified
+	switch {
+	case c.NewFile:
+		status = fileAdded
+	case c.DeletedFile:
+		status = fileDeleted
+	case c.RenamedFile:
+		status = fileRenamed
+	}
+	return []*diffFile{newSyntheticDiffFile(c.OldPath, c.NewPath, status, c.AMode, c.BMode, c.Diff)}
 }
 
 // getMRDiff fetches the diff and metadata for a GitLab merge request using the
@@ -673,21 +864,15 @@ func postGitLabMRNote(ctx context.Context, mrURL, token, baseURL, body string) e
 }
 
 // formatPRContent builds the combined title + description + diff string that is
-// passed to the AI provider.
-func formatPRContent(title, body, rawDiff string) string {
-	var sb strings.Builder
-	sb.WriteString("PR Title: ")
-	sb.WriteString(title)
-	sb.WriteByte('\n')
+// passed to the AI provider. The title and description become the diff's
+// preamble.
+func formatPRContent(title, body string, diff *parsedDiff) string {
+	preamble := []string{"PR Title: " + title}
 	if strings.TrimSpace(body) != "" {
-		sb.WriteString("PR Description: ")
-		sb.WriteString(strings.TrimSpace(body))
-		sb.WriteString("\n\n")
-	} else {
-		sb.WriteByte('\n')
+		preamble = append(preamble, "PR Description: "+strings.TrimSpace(body))
 	}
-	sb.WriteString(rawDiff)
-	return sb.String()
+	diff.prependPreamble(preamble...)
+	return diff.String()
 }
 
 // isGitHubURL reports whether rawURL looks like a GitHub pull request URL.
@@ -709,43 +894,3 @@ func readDiffFromFile(path string) (string, error) {
 	bytes, err := os.ReadFile(path) //nolint:gosec // G304: reading user-supplied diff file is intentional
 	return string(bytes), err
 }
-
-// splitDiffByFile splits a raw git diff into per-file chunks.
-// Each chunk starts with a "diff --git" header and includes all hunks for that file.
-func splitDiffByFile(raw string) []string {
-	var chunks []string
-	var current strings.Builder
-	for _, line := range strings.Split(raw, "\n") {
-		if strings.HasPrefix(line, "diff --git") && current.Len() > 0 {
-			chunks = append(chunks, current.String())
-			current.Reset()
-		}
-		current.WriteString(line)
-		current.WriteByte('\n')
-	}
-	if current.Len() > 0 && strings.TrimSpace(current.String()) != "" {
-		chunks = append(chunks, current.String())
-	}
-	return chunks
-}
-
-// processDiff truncates the raw diff to at most maxLines lines to avoid
-// exceeding provider context limits.
-func processDiff(raw string, maxLines int) string {
-	lines := strings.Split(raw, "\n")
-	return truncateDiff(lines, maxLines)
-}
-
-// truncateDiff keeps the first and last halves of lines when the total exceeds
-// max, inserting a marker at the cut point.
-func truncateDiff(lines []string, max int) string {
-	if max <= 0 {
-		return strings.Join(lines, "\n")
-	}
-	if len(lines) <= max {
-		return strings.Join(lines, "\n")
-	}
-	head := strings.Join(lines[:max/2], "\n")
-	tail := strings.Join(lines[len(lines)-(max/2):], "\n")
-	return head + "\n[...diff truncated...]\n" + tail
-}
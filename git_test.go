@@ -16,7 +16,7 @@ import (
 	gogitlab "gitlab.com/gitlab-org/api/client-go"
 )
 
-func TestProcessDiffAndTruncate(t *testing.T) {
+func TestTruncateDiffBytes_Basic(t *testing.T) {
 	raw := `
 diff --git a/foo.txt b/foo.txt
 index e69de29..4b825dc 100644
@@ -26,21 +26,21 @@ index e69de29..4b825dc 100644
 +Hello
 +World
 `
-	output := processDiff(raw, 10)
+	output := truncateDiffBytes(raw, 1000)
 	if !strings.Contains(output, "Hello") || !strings.Contains(output, "World") {
 		t.Error("Diff output missing expected content")
 	}
 }
 
-func TestProcessDiff_Truncation(t *testing.T) {
+func TestTruncateDiffBytes_Truncation(t *testing.T) {
 	lines := []string{}
 	for i := 0; i < 20; i++ {
 		lines = append(lines, "line")
 	}
 	raw := strings.Join(lines, "\n")
 
-	// Max 10 lines
-	output := processDiff(raw, 10)
+	// About 10 lines' worth of bytes
+	output := truncateDiffBytes(raw, 50)
 
 	if !strings.Contains(output, "[...diff truncated...]") {
 		t.Error("Expected truncation message")
@@ -50,19 +50,9 @@ func TestProcessDiff_Truncation(t *testing.T) {
 	}
 }
 
-func TestProcessDiff_NonPositiveMax(t *testing.T) {
-	raw := "line1\nline2\nline3\n"
-	if got := processDiff(raw, 0); got != raw {
-		t.Fatalf("expected full diff for max=0, got %q", got)
-	}
-	if got := processDiff(raw, -1); got != raw {
-		t.Fatalf("expected full diff for max<0, got %q", got)
-	}
-}
-
 func TestGetGitDiff_NoArgs(t *testing.T) {
 	// We're in a git repo, so this should not error
-	_, err := getGitDiff("", false, nil)
+	_, err := getGitDiff("", false, gitBase{}, "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -73,7 +63,7 @@ func TestGetGitDiff_WithCommit(t *testing.T) {
 	if err := exec.Command("git", "rev-parse", "HEAD^").Run(); err != nil {
 		t.Skip("skipping: HEAD has no parent commit")
 	}
-	result, err := getGitDiff("HEAD", false, nil)
+	result, err := getGitDiff("HEAD", false, gitBase{}, "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -85,7 +75,7 @@ func TestGetGitDiff_WithRange(t *testing.T) {
 	if err := exec.Command("git", "rev-parse", "HEAD~1").Run(); err != nil {
 		t.Skip("skipping: HEAD~1 does not exist")
 	}
-	result, err := getGitDiff("HEAD~1..HEAD", false, nil)
+	result, err := getGitDiff("HEAD~1..HEAD", false, gitBase{}, "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -93,23 +83,15 @@ func TestGetGitDiff_WithRange(t *testing.T) {
 }
 
 func TestGetGitDiff_Staged(t *testing.T) {
-	result, err := getGitDiff("", true, nil)
+	result, err := getGitDiff("", true, gitBase{}, "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	_ = result
 }
 
-func TestGetGitDiff_Exclude(t *testing.T) {
-	result, err := getGitDiff("", false, []string{"*.md"})
-	if err != nil {
-		t.Fatalf("unexpected error with exclude: %v", err)
-	}
-	_ = result
-}
-
 func TestGetAutoMergeBase(t *testing.T) {
-	base, err := getAutoMergeBase()
+	base, err := getAutoMergeBase(gitBase{})
 	if err != nil {
 		t.Skip("skipping: no origin/main or origin/master remote found")
 	}
@@ -119,46 +101,46 @@ func TestGetAutoMergeBase(t *testing.T) {
 }
 
 func TestGetGitDiff_AutoBase(t *testing.T) {
-	if _, err := getAutoMergeBase(); err != nil {
+	if _, err := getAutoMergeBase(gitBase{}); err != nil {
 		t.Skip("skipping: no remote found for auto base-branch detection")
 	}
-	result, err := getGitDiff("", false, nil)
+	result, err := getGitDiff("", false, gitBase{}, "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	_ = result
 }
 
-func TestSplitDiffByFile_Empty(t *testing.T) {
-	chunks := splitDiffByFile("")
-	if len(chunks) != 0 {
-		t.Errorf("expected 0 chunks for empty diff, got %d", len(chunks))
+func TestParseDiffFiles_Empty(t *testing.T) {
+	files := parseDiff("").Files
+	if len(files) != 0 {
+		t.Errorf("expected 0 files for empty diff, got %d", len(files))
 	}
 }
 
-func TestSplitDiffByFile_Single(t *testing.T) {
+func TestParseDiffFiles_Single(t *testing.T) {
 	raw := "diff --git a/foo.txt b/foo.txt\n--- a/foo.txt\n+++ b/foo.txt\n@@ -1 +1 @@\n-old\n+new\n"
-	chunks := splitDiffByFile(raw)
-	if len(chunks) != 1 {
-		t.Errorf("expected 1 chunk, got %d", len(chunks))
+	files := parseDiff(raw).Files
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(files))
 	}
-	if !strings.Contains(chunks[0], "diff --git a/foo.txt") {
-		t.Error("expected chunk to contain diff header")
+	if files[0].Header[0] != "diff --git a/foo.txt b/foo.txt" {
+		t.Errorf("expected file to start with its diff header, got %q", files[0].Header[0])
 	}
 }
 
-func TestSplitDiffByFile_Multi(t *testing.T) {
+func TestParseDiffFiles_Multi(t *testing.T) {
 	raw := "diff --git a/foo.txt b/foo.txt\n+foo\n" +
 		"diff --git a/bar.txt b/bar.txt\n+bar\n"
-	chunks := splitDiffByFile(raw)
-	if len(chunks) != 2 {
-		t.Errorf("expected 2 chunks, got %d", len(chunks))
+	files := parseDiff(raw).Files
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(files))
 	}
-	if !strings.Contains(chunks[0], "foo.txt") {
-		t.Error("first chunk should contain foo.txt")
+	if files[0].Path() != "foo.txt" {
+		t.Errorf("first file should be foo.txt, got %q", files[0].Path())
 	}
-	if !strings.Contains(chunks[1], "bar.txt") {
-		t.Error("second chunk should contain bar.txt")
+	if files[1].Path() != "bar.txt" {
+		t.Errorf("second file should be bar.txt, got %q", files[1].Path())
 	}
 }
 
@@ -546,7 +528,7 @@ func TestGetMRDiff_InvalidURL(t *testing.T) {
 }
 
 func TestFormatPRContent(t *testing.T) {
-	result := formatPRContent("My Title", "Some body", "diff content")
+	result := formatPRContent("My Title", "Some body", parseDiff("diff content"))
 	if !strings.Contains(result, "PR Title: My Title") {
 		t.Errorf("expected PR title, got: %q", result)
 	}
@@ -559,7 +541,7 @@ func TestFormatPRContent(t *testing.T) {
 }
 
 func TestFormatPRContent_EmptyBody(t *testing.T) {
-	result := formatPRContent("Title Only", "", "diff")
+	result := formatPRContent("Title Only", "", parseDiff("diff"))
 	if strings.Contains(result, "PR Description:") {
 		t.Errorf("expected no PR Description header for empty body, got: %q", result)
 	}
@@ -726,7 +708,7 @@ func TestGetGitDiff_NoCommits(t *testing.T) {
 	}
 
 	// staged=false, no commit — should fall back to --cached because there are no commits.
-	diff, err := getGitDiff("", false, nil)
+	diff, err := getGitDiff("", false, gitBase{}, "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -747,7 +729,7 @@ func TestGetGitDiff_NoCommits_Staged(t *testing.T) {
 		t.Fatalf("git add: %v\n%s", err, out)
 	}
 
-	diff, err := getGitDiff("", true, nil)
+	diff, err := getGitDiff("", true, gitBase{}, "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -778,7 +760,7 @@ func TestGitPush_NoRemoteFails(t *testing.T) {
 	if err == nil && strings.TrimSpace(string(out)) != "" {
 		t.Skip("skipping: origin remote exists — would risk an accidental push")
 	}
-	pushErr := gitPush("non-existent-test-branch")
+	pushErr := gitPush(defaultRemote, "non-existent-test-branch")
 	if pushErr == nil {
 		t.Fatal("expected error pushing to non-existent remote, got nil")
 	}
@@ -884,134 +866,121 @@ func TestPostGitLabMRNote_APIError(t *testing.T) {
 	}
 }
 
-// ── splitDiffByFile edge case tests ───────────────────────────────────────────
+// ── parseDiff file-splitting edge case tests ───────────────────────────────────
 
-func TestSplitDiffByFile_BinaryFiles(t *testing.T) {
+func TestParseDiffFiles_BinaryFiles(t *testing.T) {
 	data, err := os.ReadFile("testdata/binary-files.diff")
 	if err != nil {
 		t.Fatal(err)
 	}
-	chunks := splitDiffByFile(string(data))
-	if len(chunks) != 5 {
-		t.Errorf("expected 5 chunks for binary-files.diff, got %d", len(chunks))
-	}
-	for i, c := range chunks {
-		if !strings.HasPrefix(c, "diff --git") {
-			t.Errorf("chunk %d does not start with 'diff --git'", i)
-		}
+	d := parseDiff(string(data))
+	if len(d.Preamble) != 0 || len(d.Files) != 5 {
+		t.Errorf("expected 5 files and no preamble for binary-files.diff, got %d and %q", len(d.Files), d.Preamble)
 	}
 }
 
-func TestSplitDiffByFile_RenameMove(t *testing.T) {
+func TestParseDiffFiles_RenameMove(t *testing.T) {
 	data, err := os.ReadFile("testdata/rename-move.diff")
 	if err != nil {
 		t.Fatal(err)
 	}
-	chunks := splitDiffByFile(string(data))
-	if len(chunks) != 4 {
-		t.Errorf("expected 4 chunks for rename-move.diff, got %d", len(chunks))
-	}
-	if !strings.Contains(chunks[0], "rename from") {
-		t.Error("expected 'rename from' in first chunk")
+	files := parseDiff(string(data)).Files
+	if len(files) != 4 {
+		t.Fatalf("expected 4 files for rename-move.diff, got %d", len(files))
 	}
-	if !strings.Contains(chunks[0], "rename to") {
-		t.Error("expected 'rename to' in first chunk")
+	if f := files[0]; f.Status != fileRenamed || f.OldPath == "" || f.NewPath == "" {
+		t.Errorf("expected the first file to be a rename with both paths, got %+v", f)
 	}
 }
 
-func TestSplitDiffByFile_ModeChange(t *testing.T) {
+func TestParseDiffFiles_ModeChange(t *testing.T) {
 	data, err := os.ReadFile("testdata/mode-change.diff")
 	if err != nil {
 		t.Fatal(err)
 	}
-	chunks := splitDiffByFile(string(data))
-	if len(chunks) != 5 {
-		t.Errorf("expected 5 chunks for mode-change.diff, got %d", len(chunks))
+	files := parseDiff(string(data)).Files
+	if len(files) != 5 {
+		t.Errorf("expected 5 files for mode-change.diff, got %d", len(files))
 	}
-	for _, c := range chunks {
-		if !strings.Contains(c, "old mode") || !strings.Contains(c, "new mode") {
-			t.Error("expected mode change lines in chunk")
+	for _, f := range files {
+		if f.OldMode == "" || f.NewMode == "" || f.OldMode == f.NewMode {
+			t.Errorf("expected a mode change for %s, got %q -> %q", f.Path(), f.OldMode, f.NewMode)
 		}
 	}
 }
 
-func TestSplitDiffByFile_SubmoduleChanges(t *testing.T) {
+func TestParseDiffFiles_SubmoduleChanges(t *testing.T) {
 	data, err := os.ReadFile("testdata/submodule-changes.diff")
 	if err != nil {
 		t.Fatal(err)
 	}
-	chunks := splitDiffByFile(string(data))
-	if len(chunks) != 2 {
-		t.Errorf("expected 2 chunks for submodule-changes.diff, got %d", len(chunks))
+	files := parseDiff(string(data)).Files
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files for submodule-changes.diff, got %d", len(files))
 	}
-	if !strings.Contains(chunks[0], "Subproject commit") {
-		t.Error("expected 'Subproject commit' in first chunk")
+	if !strings.Contains(files[0].String(), "Subproject commit") {
+		t.Error("expected 'Subproject commit' in first file")
 	}
 }
 
-func TestSplitDiffByFile_SymlinkChanges(t *testing.T) {
+func TestParseDiffFiles_SymlinkChanges(t *testing.T) {
 	data, err := os.ReadFile("testdata/symlink-changes.diff")
 	if err != nil {
 		t.Fatal(err)
 	}
-	chunks := splitDiffByFile(string(data))
-	if len(chunks) != 3 {
-		t.Errorf("expected 3 chunks for symlink-changes.diff, got %d", len(chunks))
+	files := parseDiff(string(data)).Files
+	if len(files) != 3 {
+		t.Errorf("expected 3 files for symlink-changes.diff, got %d", len(files))
 	}
-	for _, c := range chunks {
-		if !strings.Contains(c, "120000") {
-			t.Error("expected symlink mode 120000 in chunk")
+	for _, f := range files {
+		if f.OldMode != "120000" && f.NewMode != "120000" {
+			t.Errorf("expected symlink mode 120000 for %s, got %q -> %q", f.Path(), f.OldMode, f.NewMode)
 		}
 	}
 }
 
-func TestSplitDiffByFile_MultipleHunksOneFile(t *testing.T) {
+func TestParseDiffFiles_MultipleHunksOneFile(t *testing.T) {
 	data, err := os.ReadFile("testdata/multiple-hunks-one-file.diff")
 	if err != nil {
 		t.Fatal(err)
 	}
-	chunks := splitDiffByFile(string(data))
-	if len(chunks) != 1 {
-		t.Errorf("expected 1 chunk for multiple-hunks-one-file.diff, got %d", len(chunks))
+	files := parseDiff(string(data)).Files
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file for multiple-hunks-one-file.diff, got %d", len(files))
 	}
-	hunkCount := strings.Count(chunks[0], "\n@@")
-	if hunkCount < 2 {
-		t.Errorf("expected at least 2 hunk headers in chunk, got %d", hunkCount)
+	if len(files[0].Hunks) < 2 {
+		t.Errorf("expected at least 2 hunks, got %d", len(files[0].Hunks))
 	}
 }
 
-// ── processDiff edge case tests ────────────────────────────────────────────────
+// ── truncateDiffBytes edge case tests ──────────────────────────────────────────
 
-func TestProcessDiff_TruncationTrigger(t *testing.T) {
+func TestTruncateDiffBytes_TruncationTrigger(t *testing.T) {
 	data, err := os.ReadFile("testdata/truncation-trigger.diff")
 	if err != nil {
 		t.Fatal(err)
 	}
 	raw := string(data)
-	lineCount := strings.Count(raw, "\n") + 1
-	if lineCount <= 4000 {
-		t.Skipf("truncation-trigger.diff has only %d lines, need >4000", lineCount)
-	}
-	output := processDiff(raw, 4000)
+	output := truncateDiffBytes(raw, len(raw)/2)
 	if !strings.Contains(output, "[...diff truncated...]") {
 		t.Error("expected truncation marker in output")
 	}
 }
 
-func TestProcessDiff_VeryLargeSingleFile(t *testing.T) {
+func TestTruncateDiffBytes_VeryLargeSingleFile(t *testing.T) {
 	data, err := os.ReadFile("testdata/very-large-single-file.diff")
 	if err != nil {
 		t.Fatal(err)
 	}
-	// Should not truncate at max=4000 (file is ~1300 lines).
-	output := processDiff(string(data), 4000)
+	// Should not truncate when the whole file fits.
+	output := truncateDiffBytes(string(data), len(data))
 	if strings.Contains(output, "[...diff truncated...]") {
-		t.Error("did not expect truncation for very-large-single-file.diff at max=4000")
+		t.Error("did not expect truncation for very-large-single-file.diff at its own size")
 	}
-	// Should truncate at max=100.
-	outputSmall := processDiff(string(data), 100)
+	// Should truncate at 4000 bytes.
+	outputSmall := truncateDiffBytes(string(data), 4000)
 	if !strings.Contains(outputSmall, "[...diff truncated...]") {
-		t.Error("expected truncation for very-large-single-file.diff at max=100")
+		t.Error("expected truncation for very-large-single-file.diff at 4000 bytes")
 	}
 }
 
@@ -1025,10 +994,9 @@ func TestReadDiffFromFile_UnicodeEmoji(t *testing.T) {
 	if !strings.Contains(content, "diff --git") {
 		t.Error("expected diff header in unicode-emoji.diff")
 	}
-	// File must be non-empty and produce at least one chunk.
-	chunks := splitDiffByFile(content)
-	if len(chunks) == 0 {
-		t.Error("expected at least one chunk from unicode-emoji.diff")
+	// File must be non-empty and produce at least one file.
+	if files := parseDiff(content).Files; len(files) == 0 {
+		t.Error("expected at least one file from unicode-emoji.diff")
 	}
 }
 
@@ -1040,9 +1008,8 @@ func TestReadDiffFromFile_CRLFLineEndings(t *testing.T) {
 	if !strings.Contains(content, "diff --git") {
 		t.Error("expected diff header in crlf-line-endings.diff")
 	}
-	chunks := splitDiffByFile(content)
-	if len(chunks) == 0 {
-		t.Error("expected at least one chunk from crlf-line-endings.diff")
+	if files := parseDiff(content).Files; len(files) == 0 {
+		t.Error("expected at least one file from crlf-line-endings.diff")
 	}
 }
 
@@ -1054,9 +1021,8 @@ func TestReadDiffFromFile_NoNewlineAtEOF(t *testing.T) {
 	if !strings.Contains(content, `\ No newline at end of file`) {
 		t.Error("expected '\\No newline at end of file' marker in diff")
 	}
-	chunks := splitDiffByFile(content)
-	if len(chunks) < 2 {
-		t.Errorf("expected at least 2 chunks from no-newline-at-eof.diff, got %d", len(chunks))
+	if files := parseDiff(content).Files; len(files) < 2 {
+		t.Errorf("expected at least 2 files from no-newline-at-eof.diff, got %d", len(files))
 	}
 }
 
@@ -1292,3 +1258,170 @@ func TestFindOrCreateGitLabMR_CreatesNew(t *testing.T) {
 		t.Errorf("got %q, want new MR URL", got)
 	}
 }
+
+// runGit runs git in the current directory and returns its trimmed output.
+func runGit(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// initForkRepo creates a repo with an "upstream" remote (no "origin") whose
+// develop and release/1.2 branches exist only as remote-tracking refs, and
+// checks out a feature branch with one commit on top of each. It returns the
+// commits develop and release/1.2 point at.
+func initForkRepo(t *testing.T) (develop, release string) {
+	t.Helper()
+	dir := initEmptyRepo(t)
+	commit := func(name string) string {
+		if err := os.WriteFile(dir+"/"+name, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, "add", name)
+		runGit(t, "commit", "-m", name)
+		return runGit(t, "rev-parse", "HEAD")
+	}
+	runGit(t, "checkout", "-q", "-b", "develop")
+	develop = commit("develop.txt")
+	release = commit("release.txt")
+	runGit(t, "remote", "add", "upstream", "https://github.com/acme/widgets.git")
+	runGit(t, "update-ref", "refs/remotes/upstream/develop", develop)
+	runGit(t, "update-ref", "refs/remotes/upstream/release/1.2", release)
+	runGit(t, "checkout", "-q", "-b", "feature", develop)
+	commit("feature.txt")
+	return develop, release
+}
+
+func TestGetAutoMergeBase_RemoteHEAD(t *testing.T) {
+	develop, _ := initForkRepo(t)
+	runGit(t, "symbolic-ref", "refs/remotes/upstream/HEAD", "refs/remotes/upstream/develop")
+
+	got, err := getAutoMergeBase(gitBase{Remote: "upstream"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != develop {
+		t.Errorf("expected the merge base with upstream/develop %s, got %s", develop, got)
+	}
+}
+
+func TestGetAutoMergeBase_UpstreamTrackingRef(t *testing.T) {
+	_, release := initForkRepo(t)
+	runGit(t, "reset", "-q", "--hard", release)
+	runGit(t, "commit", "--allow-empty", "-m", "fix")
+	runGit(t, "config", "branch.feature.remote", "upstream")
+	runGit(t, "config", "branch.feature.merge", "refs/heads/release/1.2")
+
+	got, err := getAutoMergeBase(gitBase{Remote: "upstream"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != release {
+		t.Errorf("expected the merge base with upstream/release/1.2 %s, got %s", release, got)
+	}
+}
+
+func TestGetAutoMergeBase_IgnoresOwnPushedBranch(t *testing.T) {
+	develop, _ := initForkRepo(t)
+	runGit(t, "update-ref", "refs/remotes/upstream/feature", "HEAD")
+	runGit(t, "config", "branch.feature.remote", "upstream")
+	runGit(t, "config", "branch.feature.merge", "refs/heads/feature")
+
+	got, err := getAutoMergeBase(gitBase{Remote: "upstream", hostedDefault: func() (string, error) { return "develop", nil }})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != develop {
+		t.Errorf("expected the branch's own upstream to be skipped, got %s", got)
+	}
+}
+
+func TestGetAutoMergeBase_HostedDefault(t *testing.T) {
+	develop, _ := initForkRepo(t)
+
+	asked := false
+	got, err := getAutoMergeBase(gitBase{Remote: "upstream", hostedDefault: func() (string, error) {
+		asked = true
+		return "develop", nil
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !asked || got != develop {
+		t.Errorf("expected the hosting API default branch to be used, asked=%v got %s", asked, got)
+	}
+
+	if _, err := getAutoMergeBase(gitBase{Remote: "upstream"}); err == nil || !strings.Contains(err.Error(), "--base") {
+		t.Errorf("expected an error suggesting --base when nothing is found, got %v", err)
+	}
+}
+
+func TestGetAutoMergeBase_ExplicitBranch(t *testing.T) {
+	develop, release := initForkRepo(t)
+
+	got, err := getAutoMergeBase(gitBase{Remote: "upstream", Branch: "release/1.2"})
+	if err != nil || got != develop {
+		t.Errorf("expected merge base %s with upstream/release/1.2, got %s (%v)", develop, got, err)
+	}
+
+	runGit(t, "branch", "local-release", release)
+	if got, err := getAutoMergeBase(gitBase{Remote: "upstream", Branch: "local-release"}); err != nil || got != develop {
+		t.Errorf("expected a local branch to be accepted, got %s (%v)", got, err)
+	}
+
+	if _, err := getGitDiff("", false, gitBase{Remote: "upstream", Branch: "nope"}, ""); err == nil || !strings.Contains(err.Error(), `"nope"`) {
+		t.Errorf("expected a missing explicit base to be an error, got %v", err)
+	}
+}
+
+func TestGetGitDiff_ExplicitBase(t *testing.T) {
+	initForkRepo(t)
+
+	diff, err := getGitDiff("", false, gitBase{Remote: "upstream", Branch: "develop"}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(diff, "feature.txt") || strings.Contains(diff, "develop.txt") {
+		t.Errorf("expected only the feature branch changes, got:\n%s", diff)
+	}
+}
+
+func TestGetHostedDefaultBranch_Clients(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/acme/widgets", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"default_branch":"develop"}`)
+	})
+	branch, err := getGitHubDefaultBranch(context.Background(), newTestGitHubClient(t, mux), "acme", "widgets")
+	if err != nil || branch != "develop" {
+		t.Errorf("GitHub: expected develop, got %q (%v)", branch, err)
+	}
+
+	glMux := http.NewServeMux()
+	glMux.HandleFunc("/api/v4/projects/group%2Fsub%2Fwidgets", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"id":1,"default_branch":"release"}`)
+	})
+	branch, err = getGitLabDefaultBranch(context.Background(), newTestGitLabClient(t, glMux), "group/sub/widgets")
+	if err != nil || branch != "release" {
+		t.Errorf("GitLab: expected release, got %q (%v)", branch, err)
+	}
+}
+
+func TestRootCmd_BaseFlagConflicts(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "dummy")
+	for _, extra := range []string{"--staged", "--commit=HEAD", "--file=testdata/diff.txt"} {
+		cmd := newRootCmd(func(context.Context, *Config, ApiProvider, string, string) (string, error) {
+			return "ok", nil
+		})
+		cmd.SetArgs([]string{"--base=develop", extra, "--provider=openai"})
+		cmd.SetOut(&strings.Builder{})
+		cmd.SetErr(&strings.Builder{})
+		if err := cmd.Execute(); exitCodeOf(err) != 4 {
+			t.Errorf("%s: expected a usage error, got %v", extra, err)
+		}
+	}
+}
@@ -0,0 +1,617 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The Go symbol context is capped so a wide refactor cannot crowd out the
+// diff: at most maxGoAPIChanges API changes and maxGoDefinitions referenced
+// definitions, each definition cut to maxGoDefinitionLines lines.
+const (
+	maxGoAPIChanges      = 40
+	maxGoDefinitions     = 25
+	maxGoDefinitionLines = 15
+)
+
+// goDecl is one top-level Go declaration. Grouped const, var and type
+// declarations give one goDecl per name.
+type goDecl struct {
+	// Name is the declared name, or "Type.Method" for a method.
+	Name     string
+	Kind     string // "func", "method", "type", "const" or "var"
+	File     string
+	Exported bool
+	// Signature is the declaration on one line, without body.
+	Signature string
+	// api is what the API comparison looks at: the signature without
+	// parameter names for functions. members are the exported fields of a
+	// struct or the methods of an interface, by name.
+	api     string
+	members map[string]string
+	// definition is the declaration as shown to the model, body excluded.
+	definition string
+	// start and end are the lines the declaration spans.
+	start, end int
+}
+
+// printGo renders node. With a nil fset the result is on one line; with the
+// file's fset it keeps the source layout. Comments are never printed.
+func printGo(fset *token.FileSet, node any) string {
+	if fset == nil {
+		fset = token.NewFileSet()
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, node); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// typesOnly returns fl with the names dropped, one field per name, so
+// renaming a parameter does not count as an API change.
+func typesOnly(fl *ast.FieldList) *ast.FieldList {
+	if fl == nil {
+		return nil
+	}
+	out := &ast.FieldList{}
+	for _, f := range fl.List {
+		for range max(len(f.Names), 1) {
+			out.List = append(out.List, &ast.Field{Type: f.Type})
+		}
+	}
+	return out
+}
+
+// recvTypeName returns the type name of a method receiver such as *T[K].
+func recvTypeName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// parseGoDecls parses a Go source file and lists its top-level declarations.
+func parseGoDecls(fset *token.FileSet, file string, src []byte) (*ast.File, []*goDecl, error) {
+	f, err := parser.ParseFile(fset, file, src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, nil, err
+	}
+	lineOf := func(p token.Pos) int { return fset.Position(p).Line }
+	var decls []*goDecl
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			gd := funcGoDecl(fset, d)
+			gd.File, gd.start, gd.end = file, lineOf(d.Pos()), lineOf(d.End())
+			decls = append(decls, gd)
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				start, end := lineOf(spec.Pos()), lineOf(spec.End())
+				if !d.Lparen.IsValid() {
+					start = lineOf(d.Pos())
+				}
+				for _, gd := range specGoDecls(fset, d.Tok, spec) {
+					gd.File, gd.start, gd.end = file, start, end
+					decls = append(decls, gd)
+				}
+			}
+		}
+	}
+	return f, decls, nil
+}
+
+func funcGoDecl(fset *token.FileSet, d *ast.FuncDecl) *goDecl {
+	sig := *d
+	sig.Body, sig.Doc = nil, nil
+	gd := &goDecl{Name: d.Name.Name, Kind: "func", Exported: d.Name.IsExported()}
+	if d.Recv != nil && len(d.Recv.List) > 0 {
+		recv := recvTypeName(d.Recv.List[0].Type)
+		gd.Name, gd.Kind = recv+"."+d.Name.Name, "method"
+		gd.Exported = gd.Exported && ast.IsExported(recv)
+	}
+	gd.Signature = printGo(nil, &sig)
+	gd.api = printGo(nil, &ast.FuncDecl{
+		Recv: typesOnly(d.Recv),
+		Name: d.Name,
+		Type: &ast.FuncType{TypeParams: d.Type.TypeParams, Params: typesOnly(d.Type.Params), Results: typesOnly(d.Type.Results)},
+	})
+	gd.definition = printGo(fset, &sig)
+	return gd
+}
+
+func specGoDecls(fset *token.FileSet, tok token.Token, spec ast.Spec) []*goDecl {
+	switch s := spec.(type) {
+	case *ast.TypeSpec:
+		gd := &goDecl{Name: s.Name.Name, Kind: "type", Exported: s.Name.IsExported()}
+		short := *s
+		short.Doc, short.Comment = nil, nil
+		gd.definition = printGo(fset, &ast.GenDecl{Tok: token.TYPE, Specs: []ast.Spec{&short}})
+		switch t := s.Type.(type) {
+		case *ast.StructType:
+			short.Type, gd.members = ast.NewIdent("struct"), structMembers(t)
+		case *ast.InterfaceType:
+			short.Type, gd.members = ast.NewIdent("interface"), interfaceMembers(t)
+		}
+		gd.Signature = printGo(nil, &ast.GenDecl{Tok: token.TYPE, Specs: []ast.Spec{&short}})
+		gd.api = gd.Signature
+		return []*goDecl{gd}
+	case *ast.ValueSpec:
+		var decls []*goDecl
+		for i, name := range s.Names {
+			one := &ast.ValueSpec{Names: []*ast.Ident{name}, Type: s.Type}
+			// A constant's value is part of its API; a variable's is not.
+			if tok == token.CONST && len(s.Values) == len(s.Names) {
+				one.Values = []ast.Expr{s.Values[i]}
+			}
+			sig := printGo(nil, &ast.GenDecl{Tok: tok, Specs: []ast.Spec{one}})
+			decls = append(decls, &goDecl{
+				Name: name.Name, Kind: tok.String(), Exported: name.IsExported(),
+				Signature: sig, api: sig, definition: sig,
+			})
+		}
+		return decls
+	}
+	return nil
+}
+
+// structMembers lists a struct's exported and embedded fields.
+func structMembers(t *ast.StructType) map[string]string {
+	members := map[string]string{}
+	for _, f := range t.Fields.List {
+		typ := printGo(nil, f.Type)
+		if len(f.Names) == 0 {
+			members[typ] = typ
+		}
+		for _, n := range f.Names {
+			if n.IsExported() {
+				members[n.Name] = n.Name + " " + typ
+			}
+		}
+	}
+	return members
+}
+
+// interfaceMembers lists an interface's methods and embedded types.
+func interfaceMembers(t *ast.InterfaceType) map[string]string {
+	members := map[string]string{}
+	for _, f := range t.Methods.List {
+		typ := printGo(nil, f.Type)
+		if len(f.Names) == 0 {
+			members[typ] = typ
+		}
+		for _, n := range f.Names {
+			members[n.Name] = n.Name + strings.TrimPrefix(typ, "func")
+		}
+	}
+	return members
+}
+
+// goFileChange is one changed Go file of a diff: its package directory, the
+// declarations before and after, and the lines the diff removed and added.
+type goFileChange struct {
+	Path     string
+	Dir      string
+	Package  string
+	Test     bool
+	New      *ast.File
+	fset     *token.FileSet
+	oldDecls []*goDecl
+	newDecls []*goDecl
+	added    map[int]bool
+	removed  map[int]bool
+}
+
+// changedLines returns the old lines f removes and the new lines it adds.
+func changedLines(f *diffFile) (removed, added map[int]bool) {
+	removed, added = map[int]bool{}, map[int]bool{}
+	for _, h := range f.Hunks {
+		oldN := h.OldStart
+		if h.OldLines == 0 {
+			oldN++
+		}
+		newN, _ := h.newSpan()
+		for _, line := range h.Lines {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				added[newN] = true
+				newN++
+			case strings.HasPrefix(line, "-"):
+				removed[oldN] = true
+				oldN++
+			case strings.HasPrefix(line, " "):
+				oldN++
+				newN++
+			}
+		}
+	}
+	return removed, added
+}
+
+// sideLines returns the lines of f's hunks with the given prefix or context,
+// which for an added or deleted file is the whole file.
+func sideLines(f *diffFile, prefix string) []string {
+	var lines []string
+	for _, h := range f.Hunks {
+		for _, line := range h.Lines {
+			if strings.HasPrefix(line, prefix) || strings.HasPrefix(line, " ") {
+				lines = append(lines, line[1:])
+			}
+		}
+	}
+	return lines
+}
+
+// reconstructOld undoes hunks on local, the new file, giving the old file.
+// The hunks must match local.
+func reconstructOld(local []string, hunks []*diffHunk) []string {
+	var old []string
+	next := 1
+	for _, h := range hunks {
+		first, last := h.newSpan()
+		old = append(old, local[next-1:first-1]...)
+		for _, line := range h.Lines {
+			if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "-") {
+				old = append(old, line[1:])
+			}
+		}
+		next = last + 1
+	}
+	return append(old, local[next-1:]...)
+}
+
+// newGoFileChange reads both sides of a changed Go file. An added or deleted
+// file is complete in the diff; a modified one is read from root and must
+// match the diff, as for --context=function. ok is false when the file is
+// not Go, lies outside root, cannot be read, or does not parse.
+func newGoFileChange(f *diffFile, root string) (*goFileChange, bool) {
+	if f.Binary || !strings.HasSuffix(f.Path(), ".go") || len(f.Hunks) == 0 {
+		return nil, false
+	}
+	for _, p := range []string{f.OldPath, f.NewPath} {
+		if p != "" && !filepath.IsLocal(filepath.FromSlash(p)) {
+			return nil, false
+		}
+	}
+	var oldSrc, newSrc []string
+	switch f.Status {
+	case fileAdded:
+		newSrc = sideLines(f, "+")
+	case fileDeleted:
+		oldSrc = sideLines(f, "-")
+	default:
+		local, err := readCheckoutFile(root, f.NewPath)
+		if err != nil || !f.matchesCheckout(local) {
+			return nil, false
+		}
+		newSrc = local
+		oldSrc = reconstructOld(newSrc, f.Hunks)
+	}
+
+	c := &goFileChange{Path: f.Path(), Dir: path.Dir(f.Path()), Test: strings.HasSuffix(f.Path(), "_test.go"), fset: token.NewFileSet()}
+	c.removed, c.added = changedLines(f)
+	if oldSrc != nil {
+		parsed, decls, err := parseGoDecls(token.NewFileSet(), f.OldPath, []byte(strings.Join(oldSrc, "\n")+"\n"))
+		if err != nil {
+			return nil, false
+		}
+		c.oldDecls, c.Package = decls, parsed.Name.Name
+	}
+	if newSrc != nil {
+		parsed, decls, err := parseGoDecls(c.fset, f.NewPath, []byte(strings.Join(newSrc, "\n")+"\n"))
+		if err != nil {
+			return nil, false
+		}
+		c.New, c.newDecls, c.Package = parsed, decls, parsed.Name.Name
+	}
+	return c, true
+}
+
+// changedDecls returns the names of the declarations whose lines the diff
+// touches, on either side, in order.
+func (c *goFileChange) changedDecls() []string {
+	var names []string
+	seen := map[string]bool{}
+	add := func(decls []*goDecl, lines map[int]bool) {
+		for _, d := range decls {
+			if seen[d.Name] {
+				continue
+			}
+			for n := d.start; n <= d.end; n++ {
+				if lines[n] {
+					seen[d.Name] = true
+					names = append(names, d.Name)
+					break
+				}
+			}
+		}
+	}
+	add(c.newDecls, c.added)
+	add(c.oldDecls, c.removed)
+	return names
+}
+
+// apiChange describes how an exported declaration changed from before to
+// after, either of which is nil when it was added or removed. It returns ""
+// when the API is the same.
+func apiChange(before, after *goDecl) string {
+	switch {
+	case before == nil:
+		return "added " + after.Signature
+	case after == nil:
+		return "removed " + before.Signature
+	case before.api != after.api:
+		return "changed " + after.Signature + "\n  was " + before.Signature
+	}
+	var parts []string
+	noun := "field"
+	if strings.HasSuffix(after.Signature, " interface") {
+		noun = "method"
+	}
+	for _, k := range slices.Sorted(maps.Keys(after.members)) {
+		if was, ok := before.members[k]; !ok {
+			parts = append(parts, "added "+noun+" "+after.members[k])
+		} else if was != after.members[k] {
+			parts = append(parts, noun+" "+was+" → "+after.members[k])
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(before.members)) {
+		if _, ok := after.members[k]; !ok {
+			parts = append(parts, "removed "+noun+" "+before.members[k])
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "changed " + after.Signature + ": " + strings.Join(parts, "; ")
+}
+
+// goPackageIndex holds the top-level declarations of one package, for
+// finding the definitions a change refers to.
+type goPackageIndex struct {
+	names   map[string]*goDecl
+	methods map[string][]*goDecl
+}
+
+// loadGoPackage indexes the package of c from the .go files in its directory
+// under root, using the diff's version of the changed files. Test files are
+// only included for a change to a test file.
+func loadGoPackage(root string, c *goFileChange, changed map[string]*goFileChange) *goPackageIndex {
+	idx := &goPackageIndex{names: map[string]*goDecl{}, methods: map[string][]*goDecl{}}
+	add := func(decls []*goDecl) {
+		for _, d := range decls {
+			if d.Kind == "method" {
+				_, method, _ := strings.Cut(d.Name, ".")
+				idx.methods[method] = append(idx.methods[method], d)
+			} else if d.Name != "_" && d.Name != "init" {
+				idx.names[d.Name] = d
+			}
+		}
+	}
+	if !filepath.IsLocal(filepath.FromSlash(c.Dir)) {
+		return idx
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, filepath.FromSlash(c.Dir)))
+	for _, e := range entries {
+		name := e.Name()
+		p := path.Join(c.Dir, name)
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || (strings.HasSuffix(name, "_test.go") && !c.Test) || changed[p] != nil {
+			continue
+		}
+		src, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(p))) //nolint:gosec // G304: a Go file next to one in the diff
+		if err != nil {
+			continue
+		}
+		f, decls, err := parseGoDecls(token.NewFileSet(), p, src)
+		if err == nil && f.Name.Name == c.Package {
+			add(decls)
+		}
+	}
+	for _, other := range changed {
+		if other.Dir == c.Dir && other.Package == c.Package && (c.Test || !other.Test) {
+			add(other.newDecls)
+		}
+	}
+	return idx
+}
+
+var goMajorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// importNames returns the names f's imports are used by.
+func importNames(f *ast.File) map[string]bool {
+	names := map[string]bool{}
+	for _, imp := range f.Imports {
+		if imp.Name != nil {
+			names[imp.Name.Name] = true
+			continue
+		}
+		p := strings.Trim(imp.Path.Value, `"`)
+		base := path.Base(p)
+		if goMajorVersion.MatchString(base) {
+			base = path.Base(path.Dir(p))
+		}
+		base, _, _ = strings.Cut(base, ".")
+		names[strings.TrimPrefix(base, "go-")] = true
+	}
+	return names
+}
+
+// referencedDecls returns the package declarations named on the lines c
+// adds, in order of first use, leaving out those in skip. A selector is
+// resolved as a method only when one method of the package has that name;
+// qualified identifiers of imported packages are ignored.
+func (c *goFileChange) referencedDecls(idx *goPackageIndex, skip map[string]bool) []*goDecl {
+	imports := importNames(c.New)
+	var refs []*goDecl
+	seen := map[*goDecl]bool{}
+	use := func(d *goDecl, id *ast.Ident) {
+		if d != nil && !seen[d] && !skip[d.Name] && c.added[c.fset.Position(id.Pos()).Line] {
+			seen[d] = true
+			refs = append(refs, d)
+		}
+	}
+	var visit func(ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			if x, ok := n.X.(*ast.Ident); ok && imports[x.Name] {
+				return false
+			}
+			if m := idx.methods[n.Sel.Name]; len(m) == 1 {
+				use(m[0], n.Sel)
+			}
+			ast.Inspect(n.X, visit)
+			return false
+		case *ast.Ident:
+			use(idx.names[n.Name], n)
+		}
+		return true
+	}
+	for _, d := range c.New.Decls {
+		ast.Inspect(d, visit)
+	}
+	return refs
+}
+
+// goSymbolLines builds the prompt sections for the Go files of d: the
+// declarations each file changes, the changes to exported API, and the
+// definitions the added lines refer to. root is the checkout the files and
+// their packages are read from.
+func goSymbolLines(d *parsedDiff, root string) []string {
+	var changes []*goFileChange
+	byPath := map[string]*goFileChange{}
+	for _, f := range d.Files {
+		if c, ok := newGoFileChange(f, root); ok {
+			changes = append(changes, c)
+			byPath[c.Path] = c
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	// Exported declarations are compared per package, so moving one between
+	// files of the diff is not reported.
+	type key struct{ dir, name string }
+	olds, news := map[key]*goDecl{}, map[key]*goDecl{}
+	for _, c := range changes {
+		for _, gd := range c.oldDecls {
+			olds[key{c.Dir, gd.Name}] = gd
+		}
+		for _, gd := range c.newDecls {
+			news[key{c.Dir, gd.Name}] = gd
+		}
+	}
+
+	var declLines, apiLines, defLines []string
+	reported := map[key]bool{}
+	report := func(k key, file string, before, after *goDecl) {
+		if reported[k] || (before != nil && !before.Exported) || (after != nil && !after.Exported) {
+			return
+		}
+		reported[k] = true
+		if change := apiChange(before, after); change != "" {
+			apiLines = append(apiLines, "- "+file+": "+change)
+		}
+	}
+	defs := 0
+	lastFile := ""
+	for _, c := range changes {
+		names := c.changedDecls()
+		if len(names) == 0 {
+			continue
+		}
+		declLines = append(declLines, "- "+c.Path+": "+strings.Join(names, ", "))
+		for _, gd := range c.newDecls {
+			report(key{c.Dir, gd.Name}, c.Path, olds[key{c.Dir, gd.Name}], gd)
+		}
+		for _, gd := range c.oldDecls {
+			if news[key{c.Dir, gd.Name}] == nil {
+				report(key{c.Dir, gd.Name}, c.Path, gd, nil)
+			}
+		}
+
+		if c.New == nil {
+			continue
+		}
+		skip := map[string]bool{}
+		for _, other := range changes {
+			if other.Dir == c.Dir {
+				for _, n := range other.changedDecls() {
+					skip[n] = true
+				}
+			}
+		}
+		for _, gd := range c.referencedDecls(loadGoPackage(root, c, byPath), skip) {
+			if defs == maxGoDefinitions {
+				break
+			}
+			if gd.File != lastFile {
+				defLines = append(defLines, "// "+gd.File)
+				lastFile = gd.File
+			}
+			defLines = append(defLines, definitionLines(gd.definition)...)
+			defs++
+		}
+	}
+
+	if len(declLines) == 0 {
+		return nil
+	}
+	lines := append([]string{"Go declarations changed:"}, declLines...)
+	if len(apiLines) > 0 {
+		if len(apiLines) > maxGoAPIChanges {
+			apiLines = append(apiLines[:maxGoAPIChanges], fmt.Sprintf("- ... and %d more", len(apiLines)-maxGoAPIChanges))
+		}
+		lines = append(append(lines, "", "Go API changes:"), apiLines...)
+	}
+	if len(defLines) > 0 {
+		lines = append(append(lines, "", "Go definitions referenced:"), defLines...)
+	}
+	return lines
+}
+
+// definitionLines splits a definition into lines, cutting long ones.
+func definitionLines(def string) []string {
+	lines := strings.Split(strings.TrimRight(def, "\n"), "\n")
+	if len(lines) > maxGoDefinitionLines {
+		lines = append(lines[:maxGoDefinitionLines-1], "\t// ...")
+	}
+	return lines
+}
+
+// addGoSymbols puts the Go symbol sections at the end of diff's preamble.
+// diff is unchanged when it has no Go changes the sections can describe.
+func addGoSymbols(cfg *Config, diff, root string) string {
+	d := parseDiff(diff)
+	lines := goSymbolLines(d, root)
+	debugLog(cfg, "go-symbols: %d line(s) of symbol context", len(lines))
+	if len(lines) == 0 {
+		return diff
+	}
+	d.Preamble = append(d.Preamble, append(lines, "")...)
+	return d.String()
+}
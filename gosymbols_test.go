@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// initGoSymbolsRepo commits a small Go package, then changes it in the
+// working tree: client.go is modified, legacy.go deleted, options.go added
+// and types.go, which the change uses, left alone.
+func initGoSymbolsRepo(t *testing.T) string {
+	t.Helper()
+	dir := initEmptyRepo(t)
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("client.go", `package api
+
+import "net/http"
+
+type Client struct {
+	BaseURL string
+	Retries int
+}
+
+func (c *Client) Do(req *Request) (*http.Response, error) {
+	return nil, nil
+}
+
+func helper() {}
+`)
+	write("types.go", `package api
+
+type Request struct {
+	Method string
+	URL    string
+}
+
+func newRequest(method, url string) *Request { return &Request{Method: method, URL: url} }
+
+func (r *Request) Validate() error { return nil }
+
+const MaxRetries = 3
+`)
+	write("legacy.go", "package api\n\nfunc Legacy() {}\n")
+	runGit(t, "add", ".")
+	runGit(t, "commit", "-m", "initial")
+
+	write("client.go", `package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type Client struct {
+	BaseURL string
+	Timeout time.Duration
+}
+
+func (c *Client) Do(ctx context.Context, req *Request) (*http.Response, error) {
+	r := newRequest(http.MethodGet, c.BaseURL)
+	_ = r.Validate()
+	return nil, nil
+}
+
+func helper() {}
+`)
+	write("options.go", "package api\n\ntype Options struct {\n\tVerbose bool\n}\n")
+	runGit(t, "rm", "-q", "legacy.go")
+	runGit(t, "add", "options.go")
+	return dir
+}
+
+func TestGoSymbolLines(t *testing.T) {
+	dir := initGoSymbolsRepo(t)
+	diff, err := getGitDiff("", false, gitBase{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Join(goSymbolLines(parseDiff(diff), dir), "\n")
+	want := `Go declarations changed:
+- client.go: Client, Client.Do
+- legacy.go: Legacy
+- options.go: Options
+
+Go API changes:
+- client.go: changed type Client struct: added field Timeout time.Duration; removed field Retries int
+- client.go: changed func (c *Client) Do(ctx context.Context, req *Request) (*http.Response, error)
+  was func (c *Client) Do(req *Request) (*http.Response, error)
+- legacy.go: removed func Legacy()
+- options.go: added type Options struct
+
+Go definitions referenced:
+// types.go
+type Request struct {
+	Method string
+	URL    string
+}
+func newRequest(method, url string) *Request
+func (r *Request) Validate() error`
+	if got != want {
+		t.Errorf("got:\n%s\n\nwant:\n%s", got, want)
+	}
+}
+
+func TestGoSymbolLines_SkipsMismatchedCheckout(t *testing.T) {
+	dir := initGoSymbolsRepo(t)
+	diff, err := getGitDiff("", false, gitBase{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "client.go"), []byte("package api\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(goSymbolLines(parseDiff(diff), dir), "\n")
+	if strings.Contains(got, "client.go") || !strings.Contains(got, "- legacy.go: Legacy") {
+		t.Errorf("expected client.go skipped and the added and deleted files kept, got:\n%s", got)
+	}
+	if lines := goSymbolLines(parseDiff(fileDiff("README.md", 3)), dir); lines != nil {
+		t.Errorf("expected nothing for a diff without Go files, got %q", lines)
+	}
+}
+
+func TestAPIChange(t *testing.T) {
+	decl := func(src string) *goDecl {
+		_, decls, err := parseGoDecls(token.NewFileSet(), "x.go", []byte("package x\n\n"+src+"\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decls[0]
+	}
+	tests := []struct{ before, after, want string }{
+		{"func F(a int) error", "func F(b int) error", ""},
+		{"func F(a, b int)", "func F(a int, b int64)", "changed func F(a int, b int64)\n  was func F(a, b int)"},
+		{"type I interface{ Get() int }", "type I interface {\n\tGet() int\n\tPut(int)\n}", "changed type I interface: added method Put(int)"},
+		{"type S struct{ A int; b int }", "type S struct{ A string }", "changed type S struct: field A int → A string"},
+		{"const Max = 3", "const Max = 4", "changed const Max = 4\n  was const Max = 3"},
+		{"var V = 1", "var V = 2", ""},
+	}
+	for _, tt := range tests {
+		if got := apiChange(decl(tt.before), decl(tt.after)); got != tt.want {
+			t.Errorf("apiChange(%q, %q) = %q, want %q", tt.before, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestImportNames(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "x.go", `package x
+
+import (
+	"net/http"
+	yaml "gopkg.in/yaml.v3"
+	"github.com/google/go-github/v68/github"
+	"github.com/mattn/go-isatty"
+)
+`, parser.ImportsOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := importNames(f)
+	for _, want := range []string{"http", "yaml", "github", "isatty"} {
+		if !names[want] {
+			t.Errorf("expected %q in %v", want, names)
+		}
+	}
+}
+
+func TestRootCmd_GoSymbols(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "dummy")
+	initGoSymbolsRepo(t)
+
+	for _, enabled := range []bool{false, true} {
+		args := []string{"--provider=openai", "--print-request"}
+		if enabled {
+			args = append(args, "--go-symbols")
+		}
+		var out strings.Builder
+		cmd := newRootCmd(func(context.Context, *Config, ApiProvider, string, string) (string, error) {
+			return "ok", nil
+		})
+		cmd.SetArgs(args)
+		cmd.SetOut(&out)
+		cmd.SetErr(io.Discard)
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var payload struct {
+			Diff string `json:"diff"`
+		}
+		if err := json.Unmarshal([]byte(out.String()), &payload); err != nil {
+			t.Fatalf("invalid JSON %q: %v", out.String(), err)
+		}
+		if got := strings.Contains(payload.Diff, "Go API changes:\n"); got != enabled {
+			t.Errorf("go-symbols=%v: API section present=%v in:\n%s", enabled, got, payload.Diff)
+		}
+	}
+}
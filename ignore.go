@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFileName is the repo-level file of gitignore-style patterns for files
+// to leave out of every diff sent to the model.
+const ignoreFileName = ".aimrignore"
+
+// defaultIgnorePatterns are applied before .aimrignore unless
+// --no-default-ignores is set: lockfiles, vendored dependencies, minified
+// assets and generated protobuf code rarely say anything a reviewer needs.
+// A "!pattern" line in .aimrignore re-includes a file.
+var defaultIgnorePatterns = []string{
+	"go.sum",
+	"package-lock.json",
+	"npm-shrinkwrap.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	"bun.lockb",
+	"Cargo.lock",
+	"Gemfile.lock",
+	"poetry.lock",
+	"Pipfile.lock",
+	"composer.lock",
+	"vendor/",
+	"node_modules/",
+	"*.min.js",
+	"*.min.css",
+	"*.pb.go",
+	"*.pb.gw.go",
+	"*_pb2.py",
+	"*_pb2_grpc.py",
+}
+
+// ignoreRule is one compiled gitignore pattern.
+type ignoreRule struct {
+	pattern string
+	negate  bool
+	re      *regexp.Regexp
+}
+
+// ignoreRules is an ordered list of patterns; as in .gitignore, the last
+// pattern that matches a path decides whether it is ignored.
+type ignoreRules []ignoreRule
+
+// parseIgnorePattern compiles a single gitignore line. It returns false for
+// blank lines and comments, and an error for a glob that is not a valid
+// pattern, such as a reversed range in "[z-a]".
+func parseIgnorePattern(line string) (ignoreRule, bool, error) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false, nil
+	}
+	rule := ignoreRule{pattern: line}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:] // "\#" and "\!" escape a literal first character
+	}
+	dirOnly := strings.HasSuffix(line, "/")
+	line = strings.TrimSuffix(line, "/")
+	// A slash anywhere but the end anchors the pattern to the repo root.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignoreRule{}, false, nil
+	}
+
+	var re strings.Builder
+	if anchored {
+		re.WriteString("^")
+	} else {
+		re.WriteString("^(?:.*/)?")
+	}
+	re.WriteString(globToRegexp(line))
+	if dirOnly {
+		// Only directories match, i.e. paths with something below them.
+		re.WriteString("/.*$")
+	} else {
+		// A match on a directory ignores everything below it.
+		re.WriteString("(?:/.*)?$")
+	}
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return ignoreRule{}, false, err
+	}
+	rule.re = compiled
+	return rule, true, nil
+}
+
+// globToRegexp converts gitignore glob syntax (*, ?, [...], **) to a regexp.
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			sb.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// add appends the patterns in lines to the rules. source names where they
+// came from in the error for an invalid pattern.
+func (r *ignoreRules) add(source string, lines ...string) error {
+	for _, line := range lines {
+		rule, ok, err := parseIgnorePattern(line)
+		if err != nil {
+			return fmt.Errorf("invalid ignore pattern %q in %s: %w", line, source, err)
+		}
+		if ok {
+			*r = append(*r, rule)
+		}
+	}
+	return nil
+}
+
+// match reports whether the repo-relative path p is ignored.
+func (r ignoreRules) match(p string) bool {
+	p = strings.TrimPrefix(p, "/")
+	ignored := false
+	for _, rule := range r {
+		if rule.re.MatchString(p) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// repoRoot returns the top level of the current git work tree, or the
+// working directory outside a repository.
+func repoRoot() string {
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output() //nolint:gosec // G204: git is a fixed binary, args are internal constants
+	if err == nil {
+		return strings.TrimSpace(string(out))
+	}
+	wd, _ := os.Getwd()
+	return wd
+}
+
+// readIgnoreFile returns the lines of the ignore file at path; a missing file
+// has none.
+func readIgnoreFile(path string) ([]string, error) {
+	f, err := os.Open(path) //nolint:gosec // G304: path is the repo's .aimrignore
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// loadIgnoreRules builds the rules applied to every diff: the defaults (unless
+// noDefaults), then the repository's .aimrignore, then --exclude patterns.
+func loadIgnoreRules(cfg *Config, noDefaults bool, exclude []string) (ignoreRules, error) {
+	var rules ignoreRules
+	if !noDefaults {
+		if err := rules.add("the default ignores", defaultIgnorePatterns...); err != nil {
+			return nil, err
+		}
+	}
+	path := filepath.Join(repoRoot(), ignoreFileName)
+	lines, err := readIgnoreFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if lines != nil {
+		debugLog(cfg, "ignore: loaded %s", path)
+	}
+	if err := rules.add(path, lines...); err != nil {
+		return nil, err
+	}
+	if err := rules.add("--exclude", exclude...); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// filterIgnoredFiles removes the file sections of diff whose path matches
+// rules and returns the remaining diff and the removed paths. Text before the
+// first "diff --git" header is kept.
+func filterIgnoredFiles(diff string, rules ignoreRules) (string, []string) {
+	if len(rules) == 0 || !strings.Contains(diff, "diff --git") {
+		return diff, nil
+	}
+	d := parseDiff(diff)
+	var kept []*diffFile
+	var removed []string
+	for _, f := range d.Files {
+		if p := f.Path(); rules.match(p) {
+			removed = append(removed, p)
+			continue
+		}
+		kept = append(kept, f)
+	}
+	if len(removed) == 0 {
+		return diff, nil
+	}
+	d.Files = kept
+	return d.String(), removed
+}
+
+// errAllFilesIgnored is returned when every file in a non-empty diff was
+// filtered out.
+var errAllFilesIgnored = errors.New("every changed file is ignored by the default ignores, .aimrignore or --exclude\n\nUse --no-default-ignores or a \"!pattern\" line in .aimrignore to include them")
+
+// applyIgnoreRules filters diff with the default ignores, .aimrignore and
+// exclude, logging what was dropped.
+func applyIgnoreRules(cfg *Config, diff string, noDefaults bool, exclude []string) (string, error) {
+	rules, err := loadIgnoreRules(cfg, noDefaults, exclude)
+	if err != nil {
+		return "", err
+	}
+	filtered, removed := filterIgnoredFiles(diff, rules)
+	if len(removed) > 0 {
+		debugLog(cfg, "ignore: dropped %d file(s): %s", len(removed), strings.Join(removed, ", "))
+	}
+	return filtered, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func mustAdd(t *testing.T, rules *ignoreRules, lines ...string) {
+	t.Helper()
+	if err := rules.add("test", lines...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIgnoreRules_Match(t *testing.T) {
+	var rules ignoreRules
+	mustAdd(t, &rules,
+		"# comment",
+		"",
+		"*.log",
+		"/build",
+		"docs/**/*.png",
+		"gen/",
+		"!keep.log",
+		"[ab].txt",
+	)
+	tests := map[string]bool{
+		"app.log":               true,
+		"deep/nested/app.log":   true,
+		"keep.log":              false,
+		"build/out.bin":         true,
+		"src/build/out.bin":     false,
+		"docs/a/b/shot.png":     true,
+		"docs/shot.png":         true,
+		"img/shot.png":          false,
+		"gen/types.go":          true,
+		"pkg/gen/types.go":      true,
+		"gen":                   false,
+		"a.txt":                 true,
+		"c.txt":                 false,
+		"main.go":               false,
+		"vendor/modules.txt":    false,
+		"src/app.log.d/main.go": false,
+	}
+	for path, want := range tests {
+		if got := rules.match(path); got != want {
+			t.Errorf("match(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestDefaultIgnorePatterns(t *testing.T) {
+	var rules ignoreRules
+	mustAdd(t, &rules, defaultIgnorePatterns...)
+	for _, p := range []string{"go.sum", "web/package-lock.json", "vendor/github.com/x/y.go", "static/app.min.js", "api/v1/service.pb.go", "node_modules/left-pad/index.js"} {
+		if !rules.match(p) {
+			t.Errorf("expected %q to be ignored by default", p)
+		}
+	}
+	for _, p := range []string{"main.go", "go.mod", "package.json", "src/vendor.go"} {
+		if rules.match(p) {
+			t.Errorf("expected %q not to be ignored by default", p)
+		}
+	}
+}
+
+func TestFilterIgnoredFiles(t *testing.T) {
+	diff := "Branch: main\n\n" + fileDiff("main.go", 2) + fileDiff("go.sum", 5) + fileDiff("README.md", 1)
+	var rules ignoreRules
+	mustAdd(t, &rules, "go.sum")
+	got, removed := filterIgnoredFiles(diff, rules)
+	if len(removed) != 1 || removed[0] != "go.sum" {
+		t.Fatalf("expected go.sum removed, got %v", removed)
+	}
+	if strings.Contains(got, "go.sum") || !strings.HasPrefix(got, "Branch: main\n\n") || !strings.Contains(got, "+README.md line 0") {
+		t.Errorf("unexpected filtered diff:\n%s", got)
+	}
+	if same, removed := filterIgnoredFiles(diff, nil); same != diff || removed != nil {
+		t.Error("expected no rules to leave the diff unchanged")
+	}
+}
+
+func TestLoadIgnoreRules_AimrignoreAndNoDefaults(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.WriteFile(filepath.Join(dir, ignoreFileName), []byte("*.snap\n!go.sum\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := loadIgnoreRules(&Config{}, false, []string{"tmp/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rules.match("ui/__snapshots__/a.snap") || !rules.match("tmp/x") || !rules.match("yarn.lock") {
+		t.Error("expected .aimrignore, --exclude and default patterns to apply")
+	}
+	if rules.match("go.sum") {
+		t.Error("expected !go.sum in .aimrignore to re-include it")
+	}
+	rules, err = loadIgnoreRules(&Config{}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.match("yarn.lock") || !rules.match("a.snap") {
+		t.Error("expected --no-default-ignores to drop only the defaults")
+	}
+}
+
+func TestIgnoreRules_InvalidPatterns(t *testing.T) {
+	for _, p := range []string{"[z-a]", "foo[]bar"} {
+		var rules ignoreRules
+		err := rules.add(".aimrignore", p)
+		if err == nil || !strings.Contains(err.Error(), p) || !strings.Contains(err.Error(), ".aimrignore") {
+			t.Errorf("add(%q): expected an error naming the pattern and its source, got %v", p, err)
+		}
+	}
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if _, err := loadIgnoreRules(&Config{}, false, []string{"[z-a]"}); err == nil || !strings.Contains(err.Error(), "--exclude") {
+		t.Errorf("expected an error naming --exclude, got %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ignoreFileName), []byte("foo[]bar\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadIgnoreRules(&Config{}, false, nil); err == nil || !strings.Contains(err.Error(), ignoreFileName) {
+		t.Errorf("expected an error naming %s, got %v", ignoreFileName, err)
+	}
+}
+
+func TestRootCmd_IgnoresAppliedToFileInput(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "dummy")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "in.diff")
+	if err := os.WriteFile(path, []byte(fileDiff("main.go", 3)+fileDiff("go.sum", 3)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent string
+	chatFn := func(_ context.Context, _ *Config, _ ApiProvider, _, diff string) (string, error) {
+		sent = diff
+		return "ok", nil
+	}
+	cmd := newRootCmd(chatFn)
+	cmd.SetArgs([]string{"--file=" + path, "--provider=openai", "--format=json", "--exclude=docs/**"})
+	cmd.SetOut(&strings.Builder{})
+	cmd.SetErr(&strings.Builder{})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(sent, "go.sum") || !strings.Contains(sent, "+main.go line 2") {
+		t.Errorf("expected go.sum to be dropped from --file input, got:\n%s", sent)
+	}
+
+	if err := os.WriteFile(path, []byte(fileDiff("go.sum", 3)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cmd = newRootCmd(chatFn)
+	cmd.SetArgs([]string{"--file=" + path, "--provider=openai"})
+	cmd.SetOut(&strings.Builder{})
+	cmd.SetErr(&strings.Builder{})
+	if err := cmd.Execute(); exitCodeOf(err) != 3 {
+		t.Errorf("expected exit code 3 when every file is ignored, got %v", err)
+	}
+}
+
+func TestQuickCommit_OnlyIgnoredFiles(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "dummy")
+	dir := initEmptyRepo(t)
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("README.md", "demo\n")
+	write("go.sum", "example.com/x v1.0.0 h1:abc=\n")
+	runGit(t, "add", ".")
+	runGit(t, "commit", "-m", "initial")
+	write("go.sum", "example.com/x v1.1.0 h1:def=\n")
+
+	cmd := newRootCmd(func(context.Context, *Config, ApiProvider, string, string) (string, error) {
+		return "chore: bump deps", nil
+	})
+	cmd.SetArgs([]string{"quick-commit", "--dry-run", "--provider=openai"})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	if err := cmd.Execute(); !errors.Is(err, errAllFilesIgnored) {
+		t.Errorf("expected errAllFilesIgnored, got %v", err)
+	}
+}
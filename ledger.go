@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// ledgerEntry is one line of the JSONL usage ledger: a single completed
+// provider call, where it came from, and what it cost.
+type ledgerEntry struct {
+	Time              time.Time   `json:"time"`
+	Command           string      `json:"command,omitempty"`
+	Repo              string      `json:"repo,omitempty"`
+	PRURL             string      `json:"pr_url,omitempty"`
+	Provider          ApiProvider `json:"provider"`
+	Model             string      `json:"model"`
+	InputTokens       int64       `json:"input_tokens"`
+	CachedInputTokens int64       `json:"cached_input_tokens,omitempty"`
+	OutputTokens      int64       `json:"output_tokens"`
+	CostUSD           float64     `json:"cost_usd"`
+	// Estimated marks a call whose provider reported no token usage; its
+	// tokens were counted locally.
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// defaultLedgerPath returns $XDG_STATE_HOME/ai-mr-comment/usage.jsonl, falling
+// back to ~/.local/state/ai-mr-comment/usage.jsonl.
+func defaultLedgerPath() string {
+	if xdg := os.Getenv("XDG_STATE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "ai-mr-comment", "usage.jsonl")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "ai-mr-comment", "usage.jsonl")
+}
+
+// ledgerPath returns the configured ledger file or the default.
+func ledgerPath(cfg *Config) string {
+	if cfg.LedgerPath != "" {
+		return cfg.LedgerPath
+	}
+	return defaultLedgerPath()
+}
+
+// ledgerEnabled reports whether provider calls are written to the ledger.
+// The daily budget depends on it, so max_cost_per_day forces it on.
+func ledgerEnabled(cfg *Config) bool {
+	return cfg.RecordUsage || cfg.MaxCostPerDay > 0
+}
+
+// setLedgerRun labels the ledger entries written by this run with the command
+// that made them, the repository and, for --pr runs, the PR/MR URL.
+func setLedgerRun(cfg *Config, command, prURL string) {
+	cfg.ledgerCommand = command
+	cfg.ledgerPRURL = prURL
+	if ledgerEnabled(cfg) {
+		cfg.ledgerRepo = ledgerRepo(cfg.Remote, prURL)
+	}
+}
+
+// ledgerRepo identifies the repository as host/owner/repo, taken from the PR
+// or MR URL when given and from the configured remote otherwise. Returns ""
+// when neither can be parsed.
+func ledgerRepo(remoteName, prURL string) string {
+	if prURL != "" {
+		u, err := url.Parse(parseHostedURL(prURL))
+		if err != nil || u.Host == "" {
+			return ""
+		}
+		if owner, repo, _, err := parsePRURL(prURL); err == nil {
+			return strings.ToLower(u.Host) + "/" + owner + "/" + repo
+		}
+		if namespace, project, _, err := parseMRURL(prURL); err == nil {
+			return strings.ToLower(u.Host) + "/" + namespace + "/" + project
+		}
+		return ""
+	}
+	if remoteName == "" {
+		remoteName = defaultRemote
+	}
+	remote, err := getRemoteURL(remoteName)
+	if err != nil {
+		return ""
+	}
+	info, err := parseRemoteInfo(remote)
+	if err != nil {
+		return ""
+	}
+	return info.Host + "/" + strings.Join(info.PathParts, "/")
+}
+
+// appendLedger appends entry as one JSON line. Each entry is written with a
+// single write on an O_APPEND file, so concurrent runs sharing a ledger do not
+// interleave lines.
+func appendLedger(path string, entry ledgerEntry) error {
+	if path == "" {
+		return errors.New("no ledger path: set ledger_path")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) //nolint:gosec // G304: path comes from user config
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readLedger calls fn for every well-formed entry in the ledger. A missing file
+// is an empty ledger; unreadable lines (e.g. a torn write) are skipped.
+func readLedger(path string, fn func(ledgerEntry)) error {
+	f, err := os.Open(path) //nolint:gosec // G304: path comes from user config
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024), 1024*1024)
+	for scanner.Scan() {
+		var entry ledgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		fn(entry)
+	}
+	return scanner.Err()
+}
+
+// dailySpend returns the total cost recorded in the ledger on the local
+// calendar day containing now.
+func dailySpend(path string, now time.Time) (float64, error) {
+	y, m, d := now.Date()
+	var total float64
+	err := readLedger(path, func(e ledgerEntry) {
+		ey, em, ed := e.Time.In(now.Location()).Date()
+		if ey == y && em == m && ed == d {
+			total += e.CostUSD
+		}
+	})
+	return total, err
+}
+
+// spendGroup is the usage total for one day, provider, model or repo.
+type spendGroup struct {
+	Key          string  `json:"key"`
+	Calls        int     `json:"calls"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+func (g *spendGroup) add(e ledgerEntry) {
+	g.Calls++
+	g.InputTokens += e.InputTokens
+	g.OutputTokens += e.OutputTokens
+	g.CostUSD += e.CostUSD
+}
+
+// spendReport summarises the ledger for the usage command.
+type spendReport struct {
+	Since      *time.Time   `json:"since,omitempty"`
+	Total      spendGroup   `json:"total"`
+	ByDay      []spendGroup `json:"by_day"`
+	ByProvider []spendGroup `json:"by_provider"`
+	ByModel    []spendGroup `json:"by_model"`
+	ByRepo     []spendGroup `json:"by_repo"`
+}
+
+// summarizeLedger totals the entries at or after since (all entries when since
+// is zero). Days are in loc and listed oldest first; the other groups are
+// listed most expensive first.
+func summarizeLedger(entries []ledgerEntry, since time.Time, loc *time.Location) *spendReport {
+	r := &spendReport{Total: spendGroup{Key: "total"}}
+	if !since.IsZero() {
+		r.Since = &since
+	}
+	days := map[string]*spendGroup{}
+	providers := map[string]*spendGroup{}
+	models := map[string]*spendGroup{}
+	repos := map[string]*spendGroup{}
+	addTo := func(groups map[string]*spendGroup, key string, e ledgerEntry) {
+		if key == "" {
+			key = "(unknown)"
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &spendGroup{Key: key}
+			groups[key] = g
+		}
+		g.add(e)
+	}
+	for _, e := range entries {
+		if !since.IsZero() && e.Time.Before(since) {
+			continue
+		}
+		r.Total.add(e)
+		addTo(days, e.Time.In(loc).Format(time.DateOnly), e)
+		addTo(providers, string(e.Provider), e)
+		addTo(models, e.Model, e)
+		addTo(repos, e.Repo, e)
+	}
+	r.ByDay = sortedGroups(days, func(a, b spendGroup) bool { return a.Key < b.Key })
+	byCost := func(a, b spendGroup) bool {
+		if a.CostUSD != b.CostUSD {
+			return a.CostUSD > b.CostUSD
+		}
+		return a.Key < b.Key
+	}
+	r.ByProvider = sortedGroups(providers, byCost)
+	r.ByModel = sortedGroups(models, byCost)
+	r.ByRepo = sortedGroups(repos, byCost)
+	return r
+}
+
+func sortedGroups(groups map[string]*spendGroup, less func(a, b spendGroup) bool) []spendGroup {
+	out := make([]spendGroup, 0, len(groups))
+	for _, g := range groups {
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool { return less(out[i], out[j]) })
+	return out
+}
+
+// parseSince parses the usage command's --since value: a local date
+// (YYYY-MM-DD), a number of days ("7d") or a Go duration ("36h"), the last two
+// counted back from now. An empty value means no lower bound.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, now.Location()); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: use a date (2006-01-02), days (7d) or a duration (24h)", value)
+}
+
+// writeSpendText prints the report as one aligned table per grouping.
+func writeSpendText(w io.Writer, r *spendReport, path string) {
+	_, _ = fmt.Fprintf(w, "Ledger: %s\n", path)
+	if r.Since != nil {
+		_, _ = fmt.Fprintf(w, "Since : %s\n", r.Since.Format("2006-01-02 15:04"))
+	}
+	if r.Total.Calls == 0 {
+		_, _ = fmt.Fprintln(w, "\nNo recorded usage.")
+		return
+	}
+	sections := []struct {
+		title  string
+		groups []spendGroup
+	}{
+		{"Day", r.ByDay},
+		{"Provider", r.ByProvider},
+		{"Model", r.ByModel},
+		{"Repo", r.ByRepo},
+	}
+	for _, sec := range sections {
+		width := len("total")
+		if len(sec.title) > width {
+			width = len(sec.title)
+		}
+		for _, g := range sec.groups {
+			if len(g.Key) > width {
+				width = len(g.Key)
+			}
+		}
+		_, _ = fmt.Fprintf(w, "\n%-*s  %6s  %12s  %12s  %10s\n", width, sec.title, "Calls", "Input", "Output", "Cost")
+		for _, g := range append(sec.groups, r.Total) {
+			_, _ = fmt.Fprintf(w, "%-*s  %6d  %12d  %12d  $%9.4f\n", width, g.Key, g.Calls, g.InputTokens, g.OutputTokens, g.CostUSD)
+		}
+	}
+}
+
+// writeSpendCSV writes one row per group, with the grouping in the first column.
+func writeSpendCSV(w io.Writer, r *spendReport) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"group", "key", "calls", "input_tokens", "output_tokens", "cost_usd"})
+	row := func(group string, g spendGroup) {
+		_ = cw.Write([]string{
+			group, g.Key,
+			strconv.Itoa(g.Calls),
+			strconv.FormatInt(g.InputTokens, 10),
+			strconv.FormatInt(g.OutputTokens, 10),
+			strconv.FormatFloat(g.CostUSD, 'f', 6, 64),
+		})
+	}
+	row("total", r.Total)
+	for _, g := range r.ByDay {
+		row("day", g)
+	}
+	for _, g := range r.ByProvider {
+		row("provider", g)
+	}
+	for _, g := range r.ByModel {
+		row("model", g)
+	}
+	for _, g := range r.ByRepo {
+		row("repo", g)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// newUsageCmd returns the usage command, which summarises the spend ledger.
+func newUsageCmd() *cobra.Command {
+	var profileName, since, format string
+
+	cmd := &cobra.Command{
+		Use:   "usage",
+		Short: "Summarise recorded token usage and spend",
+		Long: `Every provider call is recorded to a local ledger (record_usage, on by
+default) with its command, provider, model, repository, tokens and cost. This
+command totals the ledger by day, provider, model and repository.
+
+--since accepts a date (2026-01-31), a number of days (7d) or a duration (24h).`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" && format != "csv" {
+				return withExitCode(4, fmt.Errorf("invalid --format %q: must be text, json or csv", format))
+			}
+			now := time.Now()
+			from, err := parseSince(since, now)
+			if err != nil {
+				return withExitCode(4, err)
+			}
+			cfg, err := loadConfigForProfile(profileName)
+			if err != nil {
+				return err
+			}
+			path := ledgerPath(cfg)
+			var entries []ledgerEntry
+			if err := readLedger(path, func(e ledgerEntry) { entries = append(entries, e) }); err != nil {
+				return fmt.Errorf("reading usage ledger: %w", err)
+			}
+			report := summarizeLedger(entries, from, now.Location())
+
+			out := cmd.OutOrStdout()
+			switch format {
+			case "json":
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(report)
+			case "csv":
+				return writeSpendCSV(out, report)
+			}
+			writeSpendText(out, report, path)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&profileName, "profile", "", "Named config profile to activate")
+	cmd.Flags().StringVar(&since, "since", "", "Only include calls since a date (YYYY-MM-DD) or for a period (7d, 24h)")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format: text, json or csv")
+	return cmd
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatCompletions_RecordUsageLabelsLedgerEntries(t *testing.T) {
+	withTestRegistry(t, newMetered("metered", "gpt-4o-mini", 1000, 200))
+	path := writeLedger(t)
+
+	cfg := &Config{Provider: "metered", RecordUsage: true, LedgerPath: path}
+	setLedgerRun(cfg, "comment", "https://github.com/Acme/widgets/pull/42")
+	if _, err := chatCompletions(context.Background(), cfg, "metered", "p", "d"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var entries []ledgerEntry
+	if err := readLedger(path, func(e ledgerEntry) { entries = append(entries, e) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 ledger entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Command != "comment" || e.Repo != "github.com/Acme/widgets" || e.PRURL != "https://github.com/Acme/widgets/pull/42" {
+		t.Errorf("unexpected labels: %+v", e)
+	}
+	if e.Model != "gpt-4o-mini" || e.InputTokens != 1000 || e.OutputTokens != 200 {
+		t.Errorf("unexpected usage: %+v", e)
+	}
+}
+
+func TestChatCompletions_RecordsEstimatedUsage(t *testing.T) {
+	withTestRegistry(t, &fakeProvider{name: "cli", reply: "a short reply", model: "gpt-4o-mini"})
+	path := writeLedger(t)
+
+	cfg := &Config{Provider: "cli", RecordUsage: true, LedgerPath: path}
+	if _, err := chatCompletions(context.Background(), cfg, "cli", "prompt", strings.Repeat("+changed line\n", 100)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var entries []ledgerEntry
+	if err := readLedger(path, func(e ledgerEntry) { entries = append(entries, e) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected a ledger entry for a provider that reports no usage, got %d", len(entries))
+	}
+	if e := entries[0]; !e.Estimated || e.InputTokens < 100 || e.OutputTokens == 0 || e.CostUSD == 0 {
+		t.Errorf("expected estimated tokens and cost, got %+v", e)
+	}
+	if got := runUsage(cfg); got != nil {
+		t.Errorf("expected estimates kept out of the run's actual usage, got %+v", got)
+	}
+}
+
+func TestChatCompletions_RecordUsageDisabled(t *testing.T) {
+	withTestRegistry(t, newMetered("metered", "gpt-4o-mini", 1000, 200))
+	path := writeLedger(t)
+
+	cfg := &Config{Provider: "metered", LedgerPath: path}
+	if _, err := chatCompletions(context.Background(), cfg, "metered", "p", "d"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	calls := 0
+	_ = readLedger(path, func(ledgerEntry) { calls++ })
+	if calls != 0 {
+		t.Errorf("expected nothing recorded with record_usage off, got %d entries", calls)
+	}
+}
+
+func TestLedgerRepo_FromPRURL(t *testing.T) {
+	tests := map[string]string{
+		"https://github.com/owner/repo/pull/1":                            "github.com/owner/repo",
+		"https://gitlab.example.com/group/sub/project/-/merge_requests/7": "gitlab.example.com/group/sub/project",
+	}
+	for in, want := range tests {
+		if got := ledgerRepo(defaultRemote, in); got != want {
+			t.Errorf("ledgerRepo(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"", time.Time{}},
+		{"2026-03-01", time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)},
+		{"7d", now.AddDate(0, 0, -7)},
+		{"36h", now.Add(-36 * time.Hour)},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.in, now)
+		if err != nil {
+			t.Errorf("parseSince(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+	for _, bad := range []string{"yesterday", "-3d", "2026-13-01"} {
+		if _, err := parseSince(bad, now); err == nil {
+			t.Errorf("parseSince(%q): expected error", bad)
+		}
+	}
+}
+
+func sampleLedgerEntries() []ledgerEntry {
+	day1 := time.Date(2026, 3, 9, 10, 0, 0, 0, time.Local)
+	day2 := time.Date(2026, 3, 10, 10, 0, 0, 0, time.Local)
+	return []ledgerEntry{
+		{Time: day1, Provider: "openai", Model: "gpt-4o", Repo: "github.com/a/b", InputTokens: 100, OutputTokens: 10, CostUSD: 1},
+		{Time: day2, Provider: "anthropic", Model: "claude-sonnet-4-6", Repo: "github.com/a/b", InputTokens: 200, OutputTokens: 20, CostUSD: 2},
+		{Time: day2, Provider: "openai", Model: "gpt-4o", InputTokens: 300, OutputTokens: 30, CostUSD: 0.5},
+	}
+}
+
+func TestSummarizeLedger_Groups(t *testing.T) {
+	r := summarizeLedger(sampleLedgerEntries(), time.Time{}, time.Local)
+
+	if r.Total.Calls != 3 || r.Total.InputTokens != 600 || r.Total.OutputTokens != 60 || r.Total.CostUSD != 3.5 {
+		t.Errorf("unexpected total: %+v", r.Total)
+	}
+	if len(r.ByDay) != 2 || r.ByDay[0].Key != "2026-03-09" || r.ByDay[1].CostUSD != 2.5 {
+		t.Errorf("unexpected days: %+v", r.ByDay)
+	}
+	if len(r.ByProvider) != 2 || r.ByProvider[0].Key != "anthropic" || r.ByProvider[1].Calls != 2 {
+		t.Errorf("expected providers sorted by cost: %+v", r.ByProvider)
+	}
+	if len(r.ByRepo) != 2 || r.ByRepo[0].Key != "github.com/a/b" || r.ByRepo[1].Key != "(unknown)" {
+		t.Errorf("unexpected repos: %+v", r.ByRepo)
+	}
+}
+
+func TestSummarizeLedger_Since(t *testing.T) {
+	since := time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local)
+	r := summarizeLedger(sampleLedgerEntries(), since, time.Local)
+	if r.Total.Calls != 2 || len(r.ByDay) != 1 {
+		t.Errorf("expected only 2026-03-10 calls, got %+v", r)
+	}
+}
+
+func TestUsageCmd_Formats(t *testing.T) {
+	t.Setenv("AI_MR_COMMENT_LEDGER_PATH", writeLedger(t, sampleLedgerEntries()...))
+
+	run := func(args ...string) string {
+		t.Helper()
+		cmd := newUsageCmd()
+		var out bytes.Buffer
+		cmd.SetOut(&out)
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("usage %v: %v", args, err)
+		}
+		return out.String()
+	}
+
+	text := run()
+	for _, want := range []string{"Provider", "claude-sonnet-4-6", "2026-03-09", "$   3.5000"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text output missing %q:\n%s", want, text)
+		}
+	}
+
+	var report spendReport
+	if err := json.Unmarshal([]byte(run("--format=json", "--since=2026-03-10")), &report); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if report.Total.Calls != 2 || report.Since == nil || len(report.ByModel) != 2 {
+		t.Errorf("unexpected JSON report: %+v", report)
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(run("--format=csv"))).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if rows[0][0] != "group" || rows[1][0] != "total" || rows[1][2] != "3" || rows[1][5] != "3.500000" {
+		t.Errorf("unexpected CSV rows: %v", rows[:2])
+	}
+}
+
+func TestUsageCmd_InvalidFlags(t *testing.T) {
+	for _, args := range [][]string{{"--format=xml"}, {"--since=soon"}} {
+		cmd := newUsageCmd()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); exitCodeOf(err) != 4 {
+			t.Errorf("usage %v: expected exit code 4, got %v", args, err)
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -63,6 +64,12 @@ func main() {
 	if err := newRootCmd(chatCompletions).Execute(); err != nil {
 		var exitErr interface{ ExitCode() int }
 		if errors.As(err, &exitErr) {
+			// A bare exitCodeError has no message of its own: the verdict
+			// that caused it has already been printed.
+			var coded codedError
+			if errors.As(err, &coded) {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
 			os.Exit(exitErr.ExitCode())
 		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -360,14 +367,22 @@ func encodeJSONLine(w io.Writer, typ string, fields map[string]any) error {
 // newRootCmd builds the root cobra command, wiring flags to the provided chatFn.
 // Accepting chatFn as a parameter allows tests to inject a mock without real API calls.
 func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, string) (string, error)) *cobra.Command {
-	var commit, diffFilePath, outputPath, provider, modelOverride, templateName, format, prURL, clipboardFlag, systemPromptFlag, profileName string
-	var inputFormat, streamMode string
+	var commit, baseBranch, diffFilePath, outputPath, provider, modelOverride, templateName, format, prURL, clipboardFlag, systemPromptFlag, profileName string
+	var inputFormat, streamMode, contextFlag string
 	var debug, staged, smartChunk, generateTitle, generateCommitMsg, multiLine, verbose, exitCodeFlag, postFlag, estimate, autoYes, versionFlag bool
 	var quiet, plain, printPrompt, printRequest, verdictOnly, titleOnly bool
 	var mrChaos, mrHaiku, mrRoast bool
 	var mrIntern, mrShakespeare, mrManager, mrYoda, mrExcuse bool
+	var noCache, noDefaultIgnores, includeCommits, noUntracked, chunkPartial, goSymbols, apiDiff bool
+	var chunkWorkers int
 	var exclude []string
 
+	// Every completion below goes through the response cache. check and the
+	// agent aliases get the raw chatFn: a ping must reach the provider, and
+	// the aliases re-enter newRootCmd, which wraps it again.
+	rawChatFn := chatFn
+	chatFn = withResponseCache(chatFn)
+
 	rootCmd := &cobra.Command{
 		Use:           "ai-mr-comment",
 		Short:         "Generate MR/PR comments using AI",
@@ -392,6 +407,38 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 			if cmd.Flags().Changed("template") {
 				cfg.Template = templateName
 			}
+			cfg.NoCache = noCache
+			if includeCommits {
+				cfg.IncludeCommits = true
+			}
+			if noUntracked {
+				cfg.IncludeUntracked = false
+			}
+			if cmd.Flags().Changed("smart-chunk-workers") {
+				cfg.SmartChunkWorkers = chunkWorkers
+			}
+			if chunkPartial {
+				cfg.SmartChunkPartial = true
+			}
+			if goSymbols {
+				cfg.GoSymbols = true
+			}
+			if apiDiff {
+				cfg.APIDiff = true
+			}
+			if cmd.Flags().Changed("context") {
+				cfg.DiffContext = contextFlag
+			}
+			ledgerCommand := "comment"
+			switch {
+			case generateCommitMsg:
+				ledgerCommand = "commit-msg"
+			case titleOnly:
+				ledgerCommand = "title"
+			case verdictOnly:
+				ledgerCommand = "verdict"
+			}
+			setLedgerRun(cfg, ledgerCommand, prURL)
 			if verbose {
 				cfg.DebugWriter = cmd.ErrOrStderr()
 				defer func() {
@@ -419,9 +466,16 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 			if prURL != "" && (staged || commit != "" || diffFilePath != "") {
 				return withExitCode(4, errors.New("--pr cannot be combined with --staged, --commit, or --file"))
 			}
+			if baseBranch != "" && (staged || commit != "" || prURL != "" || diffFilePath != "") {
+				return withExitCode(4, errors.New("--base cannot be combined with --staged, --commit, --pr, or --file"))
+			}
 			if multiLine && !generateCommitMsg {
 				return withExitCode(4, errors.New("--multi-line requires --commit-msg"))
 			}
+			diffCtx, err := parseDiffContext(cfg.DiffContext)
+			if err != nil {
+				return withExitCode(4, err)
+			}
 			effectiveTemplate := cfg.Template
 			commitOnlyTemplates := map[string]bool{"commit": true, "commit-emoji": true, "commit-conventional": true}
 			if commitOnlyTemplates[effectiveTemplate] && !generateCommitMsg {
@@ -486,6 +540,8 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 
 			var diffContent string
 			var diffSource string
+			var fromGit bool
+			base := newGitBase(cmd.Context(), cfg, baseBranch)
 			diffFetchStart := time.Now()
 			err = nil
 			if inputFormat == "json" {
@@ -528,15 +584,31 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 					diffSource = "git (staged)"
 				case commit != "":
 					diffSource = "git (commit: " + commit + ")"
+				case baseBranch != "":
+					diffSource = "git (base: " + baseBranch + ")"
 				default:
 					diffSource = "git"
 				}
-				diffContent, err = getGitDiff(commit, staged, exclude)
+				fromGit = true
+				diffContent, err = getGitDiff(commit, staged, base, diffCtx)
+				if err == nil {
+					workingTree := commit == "" && !staged
+					diffContent, err = addUntrackedFiles(cfg, diffContent, workingTree && cfg.IncludeUntracked, noDefaultIgnores, exclude)
+				}
 			}
 			debugLog(cfg, "diff fetch: elapsed=%dms", time.Since(diffFetchStart).Milliseconds())
 			if err != nil {
 				return err
 			}
+			if strings.TrimSpace(diffContent) != "" {
+				diffContent, err = applyIgnoreRules(cfg, diffContent, noDefaultIgnores, exclude)
+				if err != nil {
+					return err
+				}
+				if strings.TrimSpace(diffContent) == "" {
+					return withExitCode(3, errAllFilesIgnored)
+				}
+			}
 			if strings.TrimSpace(diffContent) == "" {
 				if staged {
 					return withExitCode(3, fmt.Errorf("no staged changes found. Stage your changes with 'git add' first"))
@@ -545,29 +617,80 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 			}
 			debugLog(cfg, "diff: source=%s bytes=%d", diffSource, len(diffContent))
 
+			// git adds the --context lines itself. Other diffs get function
+			// context from the local checkout, when it has the same files, up
+			// to the token budget. The prompt is picked later; the default
+			// template stands in for it, and fitDiffToContext has the final say.
+			if diffCtx == functionContext && !fromGit {
+				var widened int
+				budget := diffTokenBudget(cmd.Context(), cfg, defaultPromptTemplate)
+				diffContent, widened = addFunctionContext(cmd.Context(), cfg, diffContent, repoRoot(), budget)
+				debugLog(cfg, "context: widened %d hunk(s) to their functions from the local checkout", widened)
+			}
+
+			// Commit messages are context only: failing to read them warns
+			// rather than stopping the run. They are added before redaction so
+			// secrets pasted into a message are caught too.
+			if cfg.IncludeCommits {
+				commits, commitsErr := fetchCommitMessages(cmd.Context(), cfg, prURL, fromGit, commit, staged, base)
+				if commitsErr != nil {
+					_, _ = fmt.Fprintln(cmd.ErrOrStderr(), "Warning: commit messages not included:", commitsErr)
+				}
+				diffContent = addCommitMessages(diffContent, commits)
+				debugLog(cfg, "commits: count=%d", len(commits))
+			}
+
+			// The API report needs both revisions locally, so it is limited to
+			// git diffs; like commit messages, failing to build it only warns.
+			if cfg.APIDiff {
+				if !fromGit || staged {
+					debugLog(cfg, "api-diff: skipped, only --commit and working-tree git diffs are compared")
+				} else if report, apiErr := buildAPIDiff(cfg, commit, base); apiErr != nil {
+					_, _ = fmt.Fprintln(cmd.ErrOrStderr(), "Warning: API diff not included:", apiErr)
+				} else {
+					diffContent = addAPIDiff(diffContent, report)
+				}
+			}
+
+			// Go symbol context comes from the checkout, so it is redacted too.
+			if cfg.GoSymbols {
+				diffContent = addGoSymbols(cfg, diffContent, repoRoot())
+			}
+
+			// Secrets are redacted after every step above has enriched the diff
+			// (function context, commit messages, API report, Go symbols) and
+			// before the cache key and the provider call see it. The branch
+			// name and stats added below come from git, not file contents.
+			diffContent, redactions, err := applyRedaction(cfg, diffContent)
+			if err != nil {
+				return err
+			}
+
 			// Prepend the current branch name when diffing a local git repo.
 			// This lets the AI and templates reference the branch/ticket number
 			// (e.g. "feat/ABC-123-add-login") for linking in systems like Jira.
 			// Skipped for --file and --pr since those have no local branch context.
 			if prURL == "" && diffFilePath == "" && diffSource != "stdin" && diffSource != "json" {
 				if branch, branchErr := getCurrentBranch(); branchErr == nil && branch != "" {
-					diffContent = "Branch: " + branch + "\n\n" + diffContent
+					parsed := parseDiff(diffContent)
+					parsed.prependPreamble("Branch: " + branch)
+					diffContent = parsed.String()
 					debugLog(cfg, "branch: name=%s", branch)
 				}
 			}
 
+			// Summarise the whole change before it is fitted to the context
+			// window, so the model knows its scope even when it sees only part.
+			diffContent, stats := addDiffStats(diffContent)
+			if stats != nil {
+				debugLog(cfg, "stats: %s", stats.summary())
+			}
+
 			out := cmd.OutOrStdout()
 			// When writing to a file, suppress all text output to the terminal.
 			if outputPath != "" {
 				out = io.Discard
 			}
-			// Split once; reuse the slice for line-count logging and truncation.
-			diffLines := strings.Split(diffContent, "\n")
-			rawLines := len(diffLines)
-			diffContent = truncateDiff(diffLines, 4000)
-			diffTruncated := rawLines > 4000
-			debugLog(cfg, "diff: lines before truncation=%d after=%d (max=4000)", rawLines, strings.Count(diffContent, "\n")+1)
-
 			systemPrompt, templateErr := NewPromptTemplate(cfg.Template)
 			if templateErr != nil {
 				_, _ = fmt.Fprintln(cmd.ErrOrStderr(), "Warning:", templateErr)
@@ -627,6 +750,15 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 				systemPrompt = exitCodePreamble + systemPrompt
 			}
 
+			// Trim the diff to what fits in the model's context window next to
+			// the system prompt and the response.
+			rawLines := strings.Count(diffContent, "\n") + 1
+			fullDiff := diffContent
+			var truncation *diffTruncation
+			diffContent, truncation = fitDiffToContext(cmd.Context(), cfg, systemPrompt, diffContent)
+			diffTruncated := truncation != nil
+			debugLog(cfg, "diff: lines before truncation=%d after=%d truncated=%v", rawLines, strings.Count(diffContent, "\n")+1, diffTruncated)
+
 			if printPrompt {
 				_, _ = fmt.Fprint(out, systemPrompt)
 				if !strings.HasSuffix(systemPrompt, "\n") {
@@ -637,12 +769,13 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 
 			if printRequest {
 				request := struct {
-					Provider     string `json:"provider"`
-					Model        string `json:"model"`
-					SystemPrompt string `json:"system_prompt"`
-					Diff         string `json:"diff"`
-					DiffSource   string `json:"diff_source"`
-					Template     string `json:"template"`
+					Provider     string     `json:"provider"`
+					Model        string     `json:"model"`
+					SystemPrompt string     `json:"system_prompt"`
+					Diff         string     `json:"diff"`
+					DiffSource   string     `json:"diff_source"`
+					Template     string     `json:"template"`
+					Stats        *diffStats `json:"stats,omitempty"`
 				}{
 					Provider:     string(cfg.Provider),
 					Model:        getModelName(cfg),
@@ -650,6 +783,7 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 					Diff:         diffContent,
 					DiffSource:   diffSource,
 					Template:     cfg.Template,
+					Stats:        stats,
 				}
 				return json.NewEncoder(out).Encode(request)
 			}
@@ -681,12 +815,17 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 			// The output block uses it to decide whether body was already written.
 			var streamedOK bool
 
+			// answered receives the provider and model behind the main output:
+			// the description, or the title or commit message when only that is
+			// generated. The parallel title call reports to its own context.
+			answerCtx, answered := withAnswerReport(cmd.Context())
 			var comment string
 			var title string
 			var commitMessage string
+			var notSummarized []string
 			if titleOnly {
 				title, err = timedCall(cfg, "title", func() (string, error) {
-					return chatFn(cmd.Context(), cfg, cfg.Provider, titlePrompt, diffContent)
+					return chatFn(answerCtx, cfg, cfg.Provider, titlePrompt, diffContent)
 				})
 				if err != nil {
 					if cfg.Provider == Ollama && strings.Contains(err.Error(), "connection refused") {
@@ -705,7 +844,7 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 					prompt = systemPrompt
 				}
 				commitMessage, err = timedCall(cfg, "commit-msg", func() (string, error) {
-					return chatFn(cmd.Context(), cfg, cfg.Provider, prompt, diffContent)
+					return chatFn(answerCtx, cfg, cfg.Provider, prompt, diffContent)
 				})
 				if err != nil {
 					if cfg.Provider == Ollama && strings.Contains(err.Error(), "connection refused") {
@@ -719,56 +858,40 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 					commitMessage = normalizeCommitMessage(commitMessage)
 				}
 			} else if smartChunk {
-				chunks := splitDiffByFile(diffContent)
-				debugLog(cfg, "smart-chunk: files=%d", len(chunks))
-				if len(chunks) > 1 {
-					// Summarize each file chunk independently in parallel, then do a final synthesis call.
-					const chunkPrompt = "Summarize the changes in this file diff in 3-5 bullet points. Be concise and technical."
-					summaries := make([]string, len(chunks))
-					debugLog(cfg, "smart-chunk: summarizing %d chunks in parallel", len(chunks))
-					eg, egCtx := errgroup.WithContext(cmd.Context())
-					for i, chunk := range chunks {
-						i, chunk := i, chunk // capture loop vars
-						eg.Go(func() error {
-							debugLog(cfg, "smart-chunk: processing chunk %d/%d", i+1, len(chunks))
-							summary, chunkErr := timedCall(cfg, fmt.Sprintf("chunk-summary-%d", i+1), func() (string, error) {
-								return chatFn(egCtx, cfg, cfg.Provider, chunkPrompt, processDiff(chunk, 1000))
-							})
-							if chunkErr != nil {
-								return chunkErr
-							}
-							summaries[i] = summary
-							return nil
-						})
-					}
-					if chunkErr := eg.Wait(); chunkErr != nil {
-						if cfg.Provider == Ollama && strings.Contains(chunkErr.Error(), "connection refused") {
-							return fmt.Errorf("failed to connect to Ollama at %s.\nMake sure Ollama is running (try 'ollama serve') or check your configuration", cfg.OllamaEndpoint)
-						}
-						return chunkErr
-					}
-					debugLog(cfg, "smart-chunk: all chunks summarized, running synthesis call")
-					combinedSummaries := strings.Join(summaries, "\n\n---\n\n")
-					comment, err = timedCall(cfg, "synthesis", func() (string, error) {
-						return chatFn(cmd.Context(), cfg, cfg.Provider, systemPrompt, combinedSummaries)
-					})
-				} else {
-					comment, err = timedCall(cfg, "comment", func() (string, error) {
-						return chatFn(cmd.Context(), cfg, cfg.Provider, systemPrompt, diffContent)
-					})
+				// Smart-chunk sizes its own calls, so it gets the diff as it was
+				// before it was fitted to the context window.
+				chunker := &smartChunker{cfg: cfg, chatFn: chatFn}
+				if !quiet && streamMode == "" {
+					chunker.progress = newChunkProgress(cmd.ErrOrStderr())
+				}
+				comment, notSummarized, err = chunker.comment(answerCtx, systemPrompt, fullDiff)
+				if err != nil && cfg.Provider == Ollama && strings.Contains(err.Error(), "connection refused") {
+					return fmt.Errorf("failed to connect to Ollama at %s.\nMake sure Ollama is running (try 'ollama serve') or check your configuration", cfg.OllamaEndpoint)
+				}
+				if len(notSummarized) > 0 {
+					_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %d file(s) not summarised because their smart-chunk call failed: %s\n", len(notSummarized), strings.Join(notSummarized, ", "))
 				}
 			} else if shouldStream {
-				comment, err = timedCall(cfg, "comment (stream)", func() (string, error) {
-					return streamToWriter(cmd.Context(), cfg, cfg.Provider, systemPrompt, diffContent, out)
-				})
-				if err != nil {
-					// Streaming failed; fall back to the buffered call.
-					// headerPrinted=true tells the output block to skip reprinting the separator.
-					comment, err = timedCall(cfg, "comment (fallback)", func() (string, error) {
-						return chatFn(cmd.Context(), cfg, cfg.Provider, systemPrompt, diffContent)
-					})
-				} else {
+				if cached, cachedAnswer, ok := cacheLookup(cfg, cfg.Provider, systemPrompt, diffContent); ok {
+					// A cache hit needs no streaming; print it as if it had streamed.
+					reportAnswer(answerCtx, cachedAnswer)
+					_, _ = fmt.Fprint(out, cached)
+					comment = cached
 					streamedOK = true
+				} else {
+					comment, err = timedCall(cfg, "comment (stream)", func() (string, error) {
+						return streamToWriter(answerCtx, cfg, cfg.Provider, systemPrompt, diffContent, out)
+					})
+					if err != nil {
+						// Streaming failed; fall back to the buffered call.
+						// headerPrinted=true tells the output block to skip reprinting the separator.
+						comment, err = timedCall(cfg, "comment (fallback)", func() (string, error) {
+							return chatFn(answerCtx, cfg, cfg.Provider, systemPrompt, diffContent)
+						})
+					} else {
+						cacheStore(cfg, answered.get(cfg, cfg.Provider), systemPrompt, diffContent, comment)
+						streamedOK = true
+					}
 				}
 			} else {
 				// When a title is also needed, run comment and title concurrently to
@@ -777,7 +900,7 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 				if needsTitle {
 					debugLog(cfg, "title+comment: running in parallel")
 					var parallelComment, parallelTitle string
-					eg, egCtx := errgroup.WithContext(cmd.Context())
+					eg, egCtx := errgroup.WithContext(answerCtx)
 					eg.Go(func() error {
 						var callErr error
 						parallelComment, callErr = timedCall(cfg, "comment (parallel)", func() (string, error) {
@@ -786,9 +909,10 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 						return callErr
 					})
 					eg.Go(func() error {
+						titleCtx, _ := withAnswerReport(egCtx)
 						var callErr error
 						parallelTitle, callErr = timedCall(cfg, "title (parallel)", func() (string, error) {
-							return chatFn(egCtx, cfg, cfg.Provider, titlePrompt, diffContent)
+							return chatFn(titleCtx, cfg, cfg.Provider, titlePrompt, diffContent)
 						})
 						return callErr
 					})
@@ -797,7 +921,7 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 					title = strings.TrimSpace(parallelTitle)
 				} else {
 					comment, err = timedCall(cfg, "comment", func() (string, error) {
-						return chatFn(cmd.Context(), cfg, cfg.Provider, systemPrompt, diffContent)
+						return chatFn(answerCtx, cfg, cfg.Provider, systemPrompt, diffContent)
 					})
 				}
 			}
@@ -851,60 +975,91 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 			// comment mirrors description for backwards compatibility.
 			// Hoisted to outer scope so --output file can reference it when format=json.
 			type outputJSON struct {
-				Title         string `json:"title,omitempty"`
-				Description   string `json:"description,omitempty"`
-				Comment       string `json:"comment,omitempty"`
-				CommitMessage string `json:"commit_message,omitempty"`
-				Verdict       string `json:"verdict,omitempty"`
-				Provider      string `json:"provider"`
-				Model         string `json:"model"`
-				DiffSource    string `json:"diff_source,omitempty"`
-				Truncated     bool   `json:"truncated,omitempty"`
-			}
+				Title         string           `json:"title,omitempty"`
+				Description   string           `json:"description,omitempty"`
+				Comment       string           `json:"comment,omitempty"`
+				CommitMessage string           `json:"commit_message,omitempty"`
+				Verdict       string           `json:"verdict,omitempty"`
+				Provider      string           `json:"provider"`
+				Model         string           `json:"model"`
+				DiffSource    string           `json:"diff_source,omitempty"`
+				Truncated     bool             `json:"truncated,omitempty"`
+				Truncation    *diffTruncation  `json:"truncation,omitempty"`
+				Stats         *diffStats       `json:"stats,omitempty"`
+				Redactions    *redactionReport `json:"redactions,omitempty"`
+				NotSummarized []string         `json:"not_summarized,omitempty"`
+				Usage         *usageSummary    `json:"usage,omitempty"`
+			}
+			usage := runUsage(cfg)
+			logRunUsage(cfg)
+			answer := answered.get(cfg, cfg.Provider)
 			var payload outputJSON
 			if titleOnly {
 				payload = outputJSON{
 					Title:      title,
-					Provider:   string(cfg.Provider),
-					Model:      getModelName(cfg),
+					Provider:   string(answer.Provider),
+					Model:      answer.Model,
 					DiffSource: diffSource,
 					Truncated:  diffTruncated,
+					Truncation: truncation,
+					Stats:      stats,
+					Redactions: redactions,
+					Usage:      usage,
 				}
 			} else if generateCommitMsg {
 				payload = outputJSON{
 					CommitMessage: commitMessage,
-					Provider:      string(cfg.Provider),
-					Model:         getModelName(cfg),
+					Provider:      string(answer.Provider),
+					Model:         answer.Model,
 					DiffSource:    diffSource,
 					Truncated:     diffTruncated,
+					Truncation:    truncation,
+					Stats:         stats,
+					Redactions:    redactions,
+					Usage:         usage,
 				}
 			} else {
 				payload = outputJSON{
-					Title:       title,
-					Description: comment,
-					Comment:     comment,
-					Verdict:     verdict,
-					Provider:    string(cfg.Provider),
-					Model:       getModelName(cfg),
-					DiffSource:  diffSource,
-					Truncated:   diffTruncated,
+					Title:         title,
+					Description:   comment,
+					Comment:       comment,
+					Verdict:       verdict,
+					Provider:      string(answer.Provider),
+					Model:         answer.Model,
+					DiffSource:    diffSource,
+					Truncated:     diffTruncated,
+					Truncation:    truncation,
+					Stats:         stats,
+					Redactions:    redactions,
+					NotSummarized: notSummarized,
+					Usage:         usage,
 				}
 			}
 
 			if verdictOnly {
 				if format == "json" {
 					if err := json.NewEncoder(out).Encode(struct {
-						Verdict    string `json:"verdict"`
-						Provider   string `json:"provider"`
-						Model      string `json:"model"`
-						DiffSource string `json:"diff_source,omitempty"`
-						Truncated  bool   `json:"truncated,omitempty"`
+						Verdict       string           `json:"verdict"`
+						Provider      string           `json:"provider"`
+						Model         string           `json:"model"`
+						DiffSource    string           `json:"diff_source,omitempty"`
+						Truncated     bool             `json:"truncated,omitempty"`
+						Truncation    *diffTruncation  `json:"truncation,omitempty"`
+						Stats         *diffStats       `json:"stats,omitempty"`
+						Redactions    *redactionReport `json:"redactions,omitempty"`
+						NotSummarized []string         `json:"not_summarized,omitempty"`
+						Usage         *usageSummary    `json:"usage,omitempty"`
 					}{
-						Verdict:    verdict,
-						Provider:   string(cfg.Provider),
-						Model:      getModelName(cfg),
-						DiffSource: diffSource,
-						Truncated:  diffTruncated,
+						Verdict:       verdict,
+						Provider:      string(answer.Provider),
+						Model:         answer.Model,
+						DiffSource:    diffSource,
+						Truncated:     diffTruncated,
+						Truncation:    truncation,
+						Stats:         stats,
+						Redactions:    redactions,
+						NotSummarized: notSummarized,
+						Usage:         usage,
 					}); err != nil {
 						return err
 					}
@@ -912,12 +1067,22 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 					_, _ = fmt.Fprintln(out, verdict)
 				}
 			} else if streamMode == "jsonl" {
-				if err := encodeJSONLine(out, "start", map[string]any{
-					"provider":    string(cfg.Provider),
-					"model":       getModelName(cfg),
+				start := map[string]any{
+					"provider":    string(answer.Provider),
+					"model":       answer.Model,
 					"diff_source": diffSource,
 					"truncated":   diffTruncated,
-				}); err != nil {
+				}
+				if truncation != nil {
+					start["truncation"] = truncation
+				}
+				if stats != nil {
+					start["stats"] = stats
+				}
+				if redactions != nil {
+					start["redactions"] = redactions
+				}
+				if err := encodeJSONLine(out, "start", start); err != nil {
 					return err
 				}
 				if titleOnly {
@@ -1050,6 +1215,7 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 	}
 
 	rootCmd.Flags().StringVar(&commit, "commit", "", "Commit or commit range")
+	rootCmd.Flags().StringVar(&baseBranch, "base", "", "Branch to diff the working tree against (default: base_branch, or the remote's detected default branch)")
 	rootCmd.Flags().StringVar(&diffFilePath, "file", "", "Path to diff file")
 	rootCmd.Flags().StringVar(&prURL, "pr", "", "GitHub PR or GitLab MR URL (e.g. https://github.com/owner/repo/pull/123 or https://gitlab.com/group/project/-/merge_requests/42)")
 	rootCmd.Flags().StringVar(&outputPath, "output", "", "Output file path")
@@ -1060,6 +1226,7 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 	rootCmd.Flags().BoolVar(&verbose, "verbose", false, "Enable verbose debug logging to stderr (provider, model, timing, errors)")
 	rootCmd.Flags().BoolVar(&staged, "staged", false, "Diff staged changes only (git diff --cached)")
 	rootCmd.Flags().StringVar(&clipboardFlag, "clipboard", "", "Copy to clipboard: title, description, or all")
+	rootCmd.Flags().StringVar(&contextFlag, "context", "", "Unchanged code around each change: \"function\" for the enclosing function, or a number of lines (default: diff_context, else git's 3)")
 	rootCmd.Flags().StringArrayVar(&exclude, "exclude", nil, "Exclude files matching pattern (e.g. vendor/**, *.sum). Can be repeated.")
 	rootCmd.Flags().StringVar(&format, "format", "text", "Output format: text or json")
 	rootCmd.Flags().StringVar(&inputFormat, "input", "text", "Input format: text or json")
@@ -1073,7 +1240,9 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 	_ = rootCmd.Flags().MarkHidden("verdict-only")
 	rootCmd.Flags().BoolVar(&titleOnly, "title-only", false, "Print only a generated title")
 	_ = rootCmd.Flags().MarkHidden("title-only")
-	rootCmd.Flags().BoolVar(&smartChunk, "smart-chunk", false, "Split large diffs by file, summarize each, then combine")
+	rootCmd.Flags().BoolVar(&smartChunk, "smart-chunk", false, "Summarize large diffs in batches of files, then combine the summaries (map-reduce)")
+	rootCmd.Flags().IntVar(&chunkWorkers, "smart-chunk-workers", defaultSmartChunkWorkers, "Maximum concurrent provider calls for --smart-chunk (default: smart_chunk_workers)")
+	rootCmd.Flags().BoolVar(&chunkPartial, "smart-chunk-partial", false, "With --smart-chunk, carry on when some summary calls fail and report their files as not summarised")
 	rootCmd.Flags().BoolVar(&generateTitle, "title", false, "Generate a concise MR/PR title in addition to the comment")
 	rootCmd.Flags().BoolVar(&generateCommitMsg, "commit-msg", false, "Generate a git commit message instead of a full MR/PR description")
 	rootCmd.Flags().BoolVar(&multiLine, "multi-line", false, "Generate a multi-line commit message (subject + body) when used with --commit-msg; body pre-fills the PR/MR description")
@@ -1083,6 +1252,12 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 	rootCmd.Flags().BoolVar(&estimate, "estimate", false, "Show token/cost estimate and prompt for confirmation before calling the API")
 	rootCmd.Flags().BoolVarP(&autoYes, "yes", "y", false, "Auto-confirm the cost estimate prompt (use with --estimate)")
 	rootCmd.Flags().BoolVar(&versionFlag, "version", false, "Print version and exit")
+	rootCmd.Flags().BoolVar(&noCache, "no-cache", false, "Bypass the response cache: always call the provider and do not store the result")
+	rootCmd.Flags().BoolVar(&noUntracked, "no-untracked", false, "Leave untracked files out of the working-tree diff")
+	rootCmd.Flags().BoolVar(&includeCommits, "include-commits", false, "Add the commit messages of the diffed range (or the PR/MR's commits) to the prompt")
+	rootCmd.Flags().BoolVar(&goSymbols, "go-symbols", false, "Add the changed Go declarations, exported API changes and referenced definitions to the prompt")
+	rootCmd.Flags().BoolVar(&apiDiff, "api-diff", false, "Add the type-checked exported Go API changes of the git diff to the prompt, flagging breaking ones")
+	rootCmd.Flags().BoolVar(&noDefaultIgnores, "no-default-ignores", false, "Keep lockfiles, vendored, minified and generated files that are left out of the diff by default")
 	rootCmd.Flags().StringVar(&profileName, "profile", "", "Named config profile to activate (defined in ~/.ai-mr-comment.toml under [profile.<name>])")
 	rootCmd.Flags().BoolVar(&mrChaos, "chaos", false, "Generate a chaotic, dramatically over-the-top MR/PR description (still technically accurate)")
 	rootCmd.Flags().BoolVar(&mrHaiku, "haiku", false, "Generate the entire MR/PR description as a sequence of haikus")
@@ -1095,16 +1270,19 @@ func newRootCmd(chatFn func(context.Context, *Config, ApiProvider, string, strin
 
 	rootCmd.AddCommand(newInitConfigCmd())
 	rootCmd.AddCommand(newModelsCmd())
-	rootCmd.AddCommand(newCheckCmd(chatFn))
+	rootCmd.AddCommand(newCheckCmd(rawChatFn))
 	rootCmd.AddCommand(newQuickCommitCmd(chatFn))
 	rootCmd.AddCommand(newChangelogCmd(chatFn))
-	rootCmd.AddCommand(newAgentAliasCmd("review", "Generate a review from a diff", nil, chatFn))
-	rootCmd.AddCommand(newAgentAliasCmd("title", "Generate only a PR/MR title", []string{"--title-only", "--plain"}, chatFn))
-	rootCmd.AddCommand(newAgentAliasCmd("commit-message", "Generate only a commit message", []string{"--commit-msg"}, chatFn))
-	rootCmd.AddCommand(newAgentAliasCmd("verdict", "Generate only a PASS/FAIL verdict", []string{"--exit-code", "--verdict-only", "--plain"}, chatFn))
-	rootCmd.AddCommand(newAgentAliasCmd("estimate", "Estimate prompt tokens and input cost", []string{"--debug"}, chatFn))
+	rootCmd.AddCommand(newAgentAliasCmd("review", "Generate a review from a diff", nil, rawChatFn))
+	rootCmd.AddCommand(newAgentAliasCmd("title", "Generate only a PR/MR title", []string{"--title-only", "--plain"}, rawChatFn))
+	rootCmd.AddCommand(newAgentAliasCmd("commit-message", "Generate only a commit message", []string{"--commit-msg"}, rawChatFn))
+	rootCmd.AddCommand(newAgentAliasCmd("verdict", "Generate only a PASS/FAIL verdict", []string{"--exit-code", "--verdict-only", "--plain"}, rawChatFn))
+	rootCmd.AddCommand(newAgentAliasCmd("estimate", "Estimate prompt tokens and input cost", []string{"--debug"}, rawChatFn))
 	rootCmd.AddCommand(newGenAliasesCmd())
 	rootCmd.AddCommand(newGenWorkflowCmd())
+	rootCmd.AddCommand(newCacheCmd())
+	rootCmd.AddCommand(newUsageCmd())
+	rootCmd.AddCommand(newAPIDiffCmd())
 
 	rootCmd.AddCommand(&cobra.Command{
 		Use:       "completion [bash|zsh|fish|powershell]",
@@ -1158,7 +1336,7 @@ func newAgentAliasCmd(name, short string, prefix []string, chatFn func(context.C
 const defaultConfigTOML = `# ai-mr-comment configuration
 # Place this file at ~/.ai-mr-comment.toml or in the project root.
 
-# Default AI provider: openai | anthropic | gemini | ollama | claude-cli | gemini-cli | codex-cli
+# Default AI provider: openai | anthropic | gemini | ollama | claude-cli | gemini-cli | codex-cli | openai-compatible | azure-openai
 provider = "anthropic"
 
 # Default prompt template.
@@ -1170,6 +1348,83 @@ template = "default"
 # "0s" disables the timeout and preserves the provider/client default behavior.
 request_timeout = "0s"
 
+# Automatic retry for transient failures (rate limits, 5xx/529, dropped connections).
+# Auth errors are never retried. A server Retry-After header is honoured up to retry_max_backoff.
+# Set retry_max_attempts = 1 to disable retries.
+retry_max_attempts = 3
+retry_backoff      = "1s"    # first delay; doubles on each retry with jitter
+retry_max_backoff  = "30s"
+
+# Providers to try, in order, when the active provider still fails with a transient
+# error after its retries. Providers without credentials are skipped. JSON output
+# and --verbose report the provider that actually answered.
+# fallback_providers = ["openai", "ollama"]
+
+# Response cache: identical requests (provider, model, system prompt, diff) are answered
+# from disk instead of paying for the same completion again. Bypass with --no-cache;
+# inspect or empty with "ai-mr-comment cache stats" / "ai-mr-comment cache clear".
+cache_ttl = "24h"   # "0s" disables the cache
+# cache_dir = ""    # default: $XDG_CACHE_HOME/ai-mr-comment or ~/.cache/ai-mr-comment
+
+# Large diffs are trimmed (head and tail kept) to fit the model's context window next to
+# the system prompt and a 4000-token response. Known models use a built-in window size;
+# set context_window (tokens) for other models. For Ollama it is also sent as num_ctx.
+# context_window = 0   # 0 = built-in table (Ollama: 8192, unknown models: 32768)
+
+# Extra [[pricing]] entries loaded from a shared file (same format as below).
+# pricing_file = ""
+
+# Spend budgets in USD (0 = unlimited). A call whose worst-case cost (estimated input
+# plus a maximum-length response) would go over either limit is refused with exit code 5.
+# max_cost_per_run = 0.25
+# max_cost_per_day = 5.00
+
+# Usage ledger: every provider call (command, provider, model, repo, tokens, cost) is
+# appended to a local JSONL file; summarise it with "ai-mr-comment usage". The daily
+# budget reads it too, so max_cost_per_day keeps it on. In CI point ledger_path at a
+# persistent directory.
+record_usage = true
+# ledger_path = ""   # default: $XDG_STATE_HOME/ai-mr-comment/usage.jsonl or ~/.local/state/ai-mr-comment/usage.jsonl
+
+# Secrets in the diff (cloud and VCS tokens, API keys, private keys, high-entropy strings)
+# are replaced with [REDACTED:<kind>] before anything is sent. "abort" refuses to send
+# a diff that contains one (exit code 6); "off" disables detection.
+redaction = "redact"
+# redaction_patterns = ['internal-[0-9a-f]{32}']   # extra regular expressions to redact
+
+# Branch the working tree is diffed against when no commit is given (--base overrides it).
+# Empty detects the default branch from <remote>/HEAD, the current branch's upstream, or the
+# GitHub/GitLab API, then falls back to <remote>/main and <remote>/master.
+# base_branch = "develop"
+# remote = "origin"   # also where quick-commit pushes
+
+# Add the commit messages of the diffed range (git log, or the PR/MR's commits for --pr) to
+# the prompt, so descriptions and changelogs pick up stated intent and ticket references.
+# include_commits = false   # or --include-commits
+
+# Unchanged code shown around each change: "function" for the whole enclosing function, or a
+# number of lines. Empty keeps git's 3. For --pr and --file, "function" reads the functions
+# from the local checkout when it has the same version of a file.
+# diff_context = "function"   # or --context
+
+# For Go files, list the changed declarations, changes to exported signatures, and the
+# definitions (types, functions, constants) the added lines use, read from the checkout.
+# go_symbols = false   # or --go-symbols
+
+# For git diffs, type-check the changed Go packages at both revisions and add the exported
+# API changes, marking removals and signature changes as breaking. Same report as api-diff.
+# api_diff = false   # or --api-diff
+
+# Working-tree diffs (no --staged or --commit) include untracked files that .gitignore does not
+# hide, as new files. The ignore rules above still apply.
+# include_untracked = true   # --no-untracked turns it off for one run
+
+# --smart-chunk summarises batches of about smart_chunk_batch_tokens tokens of diff, splitting
+# larger files between hunks, and merges the summaries until they fit the final prompt.
+# smart_chunk_batch_tokens = 12000
+# smart_chunk_workers = 4   # concurrent calls; lower it if the provider rate-limits you
+# smart_chunk_partial = false   # or --smart-chunk-partial: a failed summary call no longer fails the run
+
 # --- OpenAI ---
 # openai_api_key = ""   # or set OPENAI_API_KEY env var
 openai_model    = "gpt-4.1-mini"
@@ -1210,6 +1465,24 @@ gemini_cli_model = "gemini-2.5-flash"
 # codex_cli_path = ""               # auto-detected via PATH
 # codex_cli_model = ""              # leave empty to use codex default
 
+# --- OpenAI-compatible servers (openai-compatible) ---
+# vLLM, LM Studio, LiteLLM, or any gateway that speaks the OpenAI chat API.
+# Kept separate from the OpenAI settings above so keys and models never mix.
+# openai_compatible_endpoint    = "http://localhost:8000/v1/"
+# openai_compatible_model       = "meta-llama/Llama-3.1-8B-Instruct"
+# openai_compatible_api_key     = ""               # or set OPENAI_COMPATIBLE_API_KEY env var
+# openai_compatible_auth_header = "Authorization"  # "Authorization" sends "Bearer <key>"; any other name sends the raw key
+# openai_compatible_omit_params = ["temperature"]  # drop params the server rejects: temperature, max_tokens, stream_options
+# openai_compatible_headers     = { X-Tenant-ID = "my-team" }
+
+# --- Azure OpenAI (azure-openai) ---
+# Routes by deployment name and authenticates with an api-key header.
+# azure_endpoint    = "https://my-resource.openai.azure.com/"  # or set AZURE_OPENAI_ENDPOINT env var
+# azure_deployment  = "gpt-4o-prod"                            # or set AZURE_OPENAI_DEPLOYMENT env var; --model overrides
+# azure_api_key     = ""                                       # or set AZURE_OPENAI_API_KEY env var
+# azure_api_version = "2024-10-21"
+# azure_model       = "gpt-4o"   # model behind the deployment, used for cost estimation
+
 # --- GitHub / GitHub Enterprise ---
 # github_token = ""    # or set GITHUB_TOKEN env var
 # github_base_url = "" # GitHub Enterprise host, e.g. https://github.mycompany.com
@@ -1218,6 +1491,20 @@ gemini_cli_model = "gemini-2.5-flash"
 # gitlab_token = ""    # or set GITLAB_TOKEN env var
 # gitlab_base_url = "" # Self-hosted GitLab host, e.g. https://gitlab.mycompany.com
 
+# ---------------------------------------------------------------------------
+# Pricing Overrides
+# USD per 1M tokens. Overrides the built-in table for cost estimates and the
+# "usage" cost report, or adds models it does not know. Matched by exact model
+# name (case-insensitive); for azure-openai use azure_model. Must stay below
+# all top-level settings because each [[pricing]] starts a TOML table.
+# ---------------------------------------------------------------------------
+
+# [[pricing]]
+# model        = "gpt-4.1"
+# input        = 1.60
+# output       = 6.40
+# cached_input = 0.40   # prompt-cache hits; omit to bill them at the input rate
+
 # ---------------------------------------------------------------------------
 # Named Profiles
 # Switch profiles with: ai-mr-comment --profile <name>
@@ -1270,6 +1557,13 @@ template         = "default"
 [profile.codex-cli]
 provider = "codex-cli"
 template = "default"
+
+# Internal gateway — any OpenAI-compatible server with its own key and headers
+# [profile.gateway]
+# provider                      = "openai-compatible"
+# openai_compatible_endpoint    = "https://llm-gateway.internal/v1/"
+# openai_compatible_model       = "gpt-4.1"
+# openai_compatible_auth_header = "api-key"
 `
 
 // newInitConfigCmd returns the init-config subcommand, which writes a commented
@@ -1312,35 +1606,23 @@ models, endpoints, or the default provider.`,
 
 // getModelName returns the configured model name for the active provider.
 func getModelName(cfg *Config) string {
-	switch cfg.Provider {
-	case OpenAI:
-		return cfg.OpenAIModel
-	case Anthropic:
-		return cfg.AnthropicModel
-	case Gemini:
-		return cfg.GeminiModel
-	case Ollama:
-		return cfg.OllamaModel
-	case ClaudeCLI:
-		return cfg.ClaudeCLIModel
-	case GeminiCLI:
-		return cfg.GeminiCLIModel
-	case CodexCLI:
-		return cfg.CodexCLIModel
-	default:
+	p, ok := lookupProvider(cfg.Provider)
+	if !ok {
 		return "unknown"
 	}
+	return p.Model(cfg)
 }
 
 func validateProviderConfig(cfg *Config) error {
-	switch cfg.Provider {
-	case OpenAI, Anthropic, Gemini, Ollama, ClaudeCLI, GeminiCLI, CodexCLI:
-		// valid
-	default:
+	p, ok := lookupProvider(cfg.Provider)
+	if !ok {
 		return errors.New("unsupported provider: " + string(cfg.Provider))
 	}
-	// Delegate API key validation to validateAPIKey (same check used by chatCompletions).
-	return validateAPIKey(cfg.Provider, cfg)
+	// Same check used by chatCompletions before every call.
+	if err := p.Validate(cfg); err != nil {
+		return err
+	}
+	return validateFallbackProviders(cfg)
 }
 
 func applyRequestTimeout(cmd *cobra.Command, cfg *Config) context.CancelFunc {
@@ -1389,12 +1671,20 @@ func showCostEstimate(ctx context.Context, cfg *Config, systemPrompt, diffConten
 		totalTokens, _ = fallback.CountTokens(context.Background(), "", systemPrompt, diffContent)
 		_, _ = fmt.Fprintln(w, "Using heuristic fallback.")
 	}
-	cost := EstimateCost(model, totalTokens)
+	var price ModelPrice
+	if p, ok := lookupProvider(cfg.Provider); ok {
+		price, _ = providerPrice(cfg, p)
+	}
+	cost := price.cost(tokenUsage{InputTokens: int64(totalTokens)})
 	_, _ = fmt.Fprintln(w, "Token & Cost Estimation:")
 	_, _ = fmt.Fprintf(w, "- Model: %s\n", model)
 	_, _ = fmt.Fprintf(w, "- Diff lines: %d\n", strings.Count(diffContent, "\n")+1)
 	_, _ = fmt.Fprintf(w, "- Estimated Input Tokens: %d\n", totalTokens)
 	_, _ = fmt.Fprintf(w, "- Estimated Input Cost: $%.6f\n", cost)
+	if price.Output > 0 {
+		maxOutput := price.cost(tokenUsage{OutputTokens: maxResponseTokens})
+		_, _ = fmt.Fprintf(w, "- Output Price: $%.2f per 1M tokens (at most $%.6f for a %d-token response)\n", price.Output, maxOutput, maxResponseTokens)
+	}
 	_, _ = fmt.Fprintln(w, "\nNote: Output tokens and cost depend on the generated response length.")
 }
 
@@ -1424,105 +1714,84 @@ func promptConfirm(promptWriter io.Writer, stdinReader io.Reader, autoYes bool)
 
 // setModelOverride applies a CLI --model value to the correct provider field in cfg.
 func setModelOverride(cfg *Config, model string) {
-	switch cfg.Provider {
-	case OpenAI:
-		cfg.OpenAIModel = model
-	case Anthropic:
-		cfg.AnthropicModel = model
-	case Gemini:
-		cfg.GeminiModel = model
-	case Ollama:
-		cfg.OllamaModel = model
-	case ClaudeCLI:
-		cfg.ClaudeCLIModel = model
-	case GeminiCLI:
-		cfg.GeminiCLIModel = model
-	case CodexCLI:
-		cfg.CodexCLIModel = model
+	if p, ok := lookupProvider(cfg.Provider); ok {
+		p.SetModel(cfg, model)
 	}
 }
 
-// providerModels lists known models for each provider, used by the `models` subcommand.
-var providerModels = map[ApiProvider][]string{
-	OpenAI: {
-		"gpt-4.1",
-		"gpt-4.1-mini",
-		"gpt-4.1-nano",
-		"o3",
-		"o3-mini",
-		"gpt-4o",
-		"gpt-4o-mini",
-	},
-	Anthropic: {
-		"claude-opus-4-6",
-		"claude-sonnet-4-6",
-		"claude-haiku-4-5-20251001",
-		"claude-opus-4-5-20251101",
-		"claude-sonnet-4-5-20250929",
-		"claude-sonnet-4-20250514",
-		"claude-3-7-sonnet-20250219",
-		"claude-3-haiku-20240307",
-	},
-	Gemini: {
-		"gemini-2.5-pro",
-		"gemini-2.5-flash",
-		"gemini-2.5-flash-lite",
-		"gemini-3-flash-preview",
-		"gemini-3-pro-preview",
-		"gemini-2.0-flash",
-	},
-	Ollama: {
-		"llama3",
-		"llama3.1",
-		"llama3.2",
-		"mistral",
-		"codellama",
-		"phi3",
-	},
-	ClaudeCLI: {
-		"claude-opus-4-6",
-		"claude-sonnet-4-6",
-		"claude-haiku-4-5-20251001",
-	},
-	GeminiCLI: {
-		"gemini-2.5-pro",
-		"gemini-2.5-flash",
-		"gemini-2.5-flash-lite",
-	},
-	CodexCLI: {
-		// Model names depend on what is configured in the local codex CLI.
-		// Leave empty to use codex default, or set codex_cli_model in config.
-	},
-}
-
 // newModelsCmd returns the models subcommand, which lists known models for the active provider.
 func newModelsCmd() *cobra.Command {
-	var provider string
+	var provider, format, profileName string
+	var live bool
 
 	cmd := &cobra.Command{
 		Use:   "models",
 		Short: "List available models for a provider",
-		Long:  `Prints the known model names for the given provider. Use --provider to select a provider (defaults to the configured one).`,
+		Long: `Prints the known model names for the given provider. Use --provider to select a provider (defaults to the configured one).
+
+--live queries the provider's model listing endpoint with the configured credentials
+and endpoint instead (openai, anthropic, gemini, ollama, openai-compatible). Without
+--provider it lists every provider that supports it. Each model is marked with its
+known pricing; --format=json also reports whether the configured model was listed.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			p := ApiProvider(provider)
-			models, ok := providerModels[p]
-			if !ok {
-				return fmt.Errorf("unknown provider %q: choose from openai, anthropic, gemini, ollama, claude-cli, gemini-cli, codex-cli", provider)
+			if format != "text" && format != "json" {
+				return withExitCode(4, fmt.Errorf("invalid --format %q: must be text or json", format))
+			}
+			var listings []modelListing
+			if live {
+				cfg, err := loadConfigForProfile(profileName)
+				if err != nil {
+					return err
+				}
+				var targets []Provider
+				if cmd.Flags().Changed("provider") {
+					p, ok := lookupProvider(ApiProvider(provider))
+					if !ok {
+						return fmt.Errorf("unknown provider %q: choose from %s", provider, providerNameList())
+					}
+					targets = append(targets, p)
+				} else {
+					for _, name := range registeredProviders() {
+						p, _ := lookupProvider(name)
+						if _, ok := p.(modelLister); ok {
+							targets = append(targets, p)
+						}
+					}
+				}
+				for _, p := range targets {
+					listings = append(listings, liveModelListing(cmd.Context(), cfg, p))
+				}
+			} else {
+				p, ok := lookupProvider(ApiProvider(provider))
+				if !ok {
+					return fmt.Errorf("unknown provider %q: choose from %s", provider, providerNameList())
+				}
+				listings = append(listings, newModelListing(nil, p.Name(), "static", p.KnownModels()))
 			}
-			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Models for provider %s:\n\n", p)
-			if len(models) == 0 {
-				_, _ = fmt.Fprintln(cmd.OutOrStdout(), "  (no fixed model list — configured by the local CLI tool)")
+
+			out := cmd.OutOrStdout()
+			if format == "json" {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(listings); err != nil {
+					return err
+				}
+			} else {
+				writeModelListings(out, listings)
 			}
-			for _, m := range models {
-				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "  %s\n", m)
+			// A provider asked for by name must answer; when listing every
+			// provider, unconfigured or unreachable ones are only reported.
+			if live && cmd.Flags().Changed("provider") && listings[0].Error != "" {
+				return fmt.Errorf("listing %s models: %s", listings[0].Provider, listings[0].Error)
 			}
-			_, _ = fmt.Fprintln(cmd.OutOrStdout())
-			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Use --model <name> to select a model for a run.\n")
 			return nil
 		},
 	}
 
 	cmd.Flags().StringVar(&provider, "provider", "anthropic", "Provider to list models for (openai, anthropic, gemini, ollama, claude-cli, gemini-cli, codex-cli)")
+	cmd.Flags().BoolVar(&live, "live", false, "Query the provider's model listing API instead of the built-in list")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format: text or json")
+	cmd.Flags().StringVar(&profileName, "profile", "", "Named config profile to activate (with --live)")
 	return cmd
 }
 
@@ -1530,7 +1799,7 @@ func newModelsCmd() *cobra.Command {
 // AI commit message, commits, and pushes — all in one step.
 func newQuickCommitCmd(chatFn func(context.Context, *Config, ApiProvider, string, string) (string, error)) *cobra.Command {
 	var provider, modelOverride, format, profileName string
-	var dryRun, noPush, breaking, multiLine, emoji, noConventional, postFlag, verbose bool
+	var dryRun, noPush, breaking, multiLine, emoji, noConventional, postFlag, verbose, noCache, noDefaultIgnores bool
 	var chaos, haiku, roast, fortune bool
 	var qcMonday, qcJira, qcEmoji, qcSassy, qcTechnical bool
 	var qcIntern, qcShakespeare, qcManager, qcYoda, qcExcuse bool
@@ -1556,6 +1825,8 @@ remote. Use --dry-run to preview the generated message without committing.`,
 			if cmd.Flags().Changed("model") {
 				setModelOverride(cfg, modelOverride)
 			}
+			cfg.NoCache = noCache
+			setLedgerRun(cfg, "quick-commit", "")
 			if verbose {
 				cfg.DebugWriter = cmd.ErrOrStderr()
 			}
@@ -1596,19 +1867,37 @@ remote. Use --dry-run to preview the generated message without committing.`,
 			// Get the diff to feed to the AI.
 			// After a real git add the staged diff is the right source.
 			// In dry-run mode nothing was staged, so use the full working-tree diff
-			// (staged + unstaged) so the preview is still meaningful.
+			// (staged + unstaged), plus the untracked files git add would stage,
+			// so the preview is still meaningful.
 			var diffContent string
+			base := newGitBase(cmd.Context(), cfg, "")
 			if dryRun {
-				diffContent, err = getGitDiff("", false, nil)
+				diffContent, err = getGitDiff("", false, base, "")
+				if err == nil {
+					diffContent, err = addUntrackedFiles(cfg, diffContent, true, noDefaultIgnores, nil)
+				}
 			} else {
-				diffContent, err = getGitDiff("", true, nil)
+				diffContent, err = getGitDiff("", true, base, "")
 			}
 			if err != nil {
 				return fmt.Errorf("reading diff: %w", err)
 			}
+			if strings.TrimSpace(diffContent) != "" {
+				diffContent, err = applyIgnoreRules(cfg, diffContent, noDefaultIgnores, nil)
+				if err != nil {
+					return err
+				}
+				if strings.TrimSpace(diffContent) == "" && !chaos {
+					return errAllFilesIgnored
+				}
+			}
 			if strings.TrimSpace(diffContent) == "" && !chaos {
 				return fmt.Errorf("no changes found to generate a commit message for")
 			}
+			diffContent, _, err = applyRedaction(cfg, diffContent)
+			if err != nil {
+				return err
+			}
 
 			// Validate mutually exclusive style flags.
 			styleFlagNames := []string{"--chaos", "--haiku", "--roast", "--monday", "--jira", "--emoji-commit", "--sassy", "--technical", "--intern", "--shakespeare", "--manager", "--yoda", "--excuse"}
@@ -1634,7 +1923,6 @@ remote. Use --dry-run to preview the generated message without committing.`,
 
 			// Prepend branch name so the AI can reference the ticket key.
 			diffContent = "Branch: " + branch + "\n\n" + diffContent
-			diffContent = processDiff(diffContent, 4000)
 
 			// --chaos ignores the real diff; just pass a fixed token.
 			if chaos {
@@ -1677,11 +1965,14 @@ remote. Use --dry-run to preview the generated message without committing.`,
 			default:
 				prompt = quickCommitPrompt
 			}
+			diffContent, _ = addDiffStats(diffContent)
+			diffContent, _ = fitDiffToContext(cmd.Context(), cfg, prompt, diffContent)
 			if breaking {
 				prompt += "\n\nThis is a BREAKING CHANGE release. You MUST use the 'feat!' type (with an exclamation mark) to signal a breaking change, e.g. \"feat!(scope): description\" or \"feat!: description\"."
 				diffContent += "\n\nBREAKING CHANGE: this release introduces a breaking change and must use the feat! conventional commit type."
 			}
-			commitMessage, err := chatFn(cmd.Context(), cfg, cfg.Provider, prompt, diffContent)
+			answerCtx, answered := withAnswerReport(cmd.Context())
+			commitMessage, err := chatFn(answerCtx, cfg, cfg.Provider, prompt, diffContent)
 			if err != nil {
 				return fmt.Errorf("generating commit message: %w", err)
 			}
@@ -1707,7 +1998,11 @@ remote. Use --dry-run to preview the generated message without committing.`,
 			// Generate a fortune trailer if requested.
 			var fortuneBody string
 			if fortune {
-				rawFortune, fortuneErr := chatFn(cmd.Context(), cfg, cfg.Provider, fortunePrompt, "generate a fortune")
+				// The fortune prompt never changes, so a cached answer would
+				// repeat the same fortune on every commit.
+				fortuneCfg := *cfg
+				fortuneCfg.NoCache = true
+				rawFortune, fortuneErr := chatFn(cmd.Context(), &fortuneCfg, cfg.Provider, fortunePrompt, "generate a fortune")
 				if fortuneErr != nil {
 					return fmt.Errorf("generating fortune: %w", fortuneErr)
 				}
@@ -1718,15 +2013,19 @@ remote. Use --dry-run to preview the generated message without committing.`,
 			if fortuneBody != "" {
 				jsonMsg += "\n\n" + fortuneBody
 			}
+			logRunUsage(cfg)
 			if format == "json" {
+				answer := answered.get(cfg, cfg.Provider)
 				if err := json.NewEncoder(out).Encode(struct {
-					CommitMessage string `json:"commit_message"`
-					Provider      string `json:"provider"`
-					Model         string `json:"model"`
+					CommitMessage string        `json:"commit_message"`
+					Provider      string        `json:"provider"`
+					Model         string        `json:"model"`
+					Usage         *usageSummary `json:"usage,omitempty"`
 				}{
 					CommitMessage: jsonMsg,
-					Provider:      string(cfg.Provider),
-					Model:         getModelName(cfg),
+					Provider:      string(answer.Provider),
+					Model:         answer.Model,
+					Usage:         runUsage(cfg),
 				}); err != nil {
 					return err
 				}
@@ -1761,15 +2060,15 @@ remote. Use --dry-run to preview the generated message without committing.`,
 
 			// Push.
 			if format != "json" {
-				_, _ = fmt.Fprintf(out, "Pushing to origin/%s...\n", branch)
+				_, _ = fmt.Fprintf(out, "Pushing to %s/%s...\n", base.remote(), branch)
 			}
-			if err := gitPush(branch); err != nil {
+			if err := gitPush(base.remote(), branch); err != nil {
 				return err
 			}
 
 			if format != "json" {
 				_, _ = fmt.Fprintln(out, "Done.")
-				if remoteURL, remErr := getRemoteURL(); remErr == nil {
+				if remoteURL, remErr := getRemoteURL(base.remote()); remErr == nil {
 					if createURL := prCreateURL(remoteURL, branch); createURL != "" {
 						_, _ = fmt.Fprintf(out, "\nOpen PR/MR: %s\n", createURL)
 					}
@@ -1777,7 +2076,7 @@ remote. Use --dry-run to preview the generated message without committing.`,
 			}
 
 			if postFlag {
-				remoteURL, remErr := getRemoteURL()
+				remoteURL, remErr := getRemoteURL(base.remote())
 				if remErr != nil {
 					return fmt.Errorf("--post: getting remote URL: %w", remErr)
 				}
@@ -1819,6 +2118,12 @@ remote. Use --dry-run to preview the generated message without committing.`,
 				if err != nil {
 					return fmt.Errorf("--post: fetching PR/MR diff: %w", err)
 				}
+				// Like every other diff source, the PR/MR diff is redacted before
+				// it reaches the provider; in abort mode nothing is sent.
+				diffForReview, _, err = applyRedaction(cfg, diffForReview)
+				if err != nil {
+					return fmt.Errorf("--post: %w", err)
+				}
 
 				// Generate the AI review using the default MR system prompt.
 				reviewComment, reviewErr := chatFn(cmd.Context(), cfg, cfg.Provider, defaultPromptTemplate, diffForReview)
@@ -1874,19 +2179,25 @@ remote. Use --dry-run to preview the generated message without committing.`,
 	cmd.Flags().BoolVar(&qcExcuse, "excuse", false, "Generate a technically accurate commit message with a built-in excuse")
 	cmd.Flags().StringVar(&profileName, "profile", "", "Named config profile to activate (defined in ~/.ai-mr-comment.toml under [profile.<name>])")
 	cmd.Flags().BoolVar(&verbose, "verbose", false, "Print debug info (provider, model, prompt size, timing) to stderr")
+	cmd.Flags().BoolVar(&noCache, "no-cache", false, "Bypass the response cache for this run")
+	cmd.Flags().BoolVar(&noDefaultIgnores, "no-default-ignores", false, "Keep lockfiles, vendored, minified and generated files in the diff sent to the model")
 	return cmd
 }
 
 // providerSecrets maps each supported AI provider to the conventional GitHub
 // Actions secret name used for its API key.
 var providerSecrets = map[string]string{
-	"openai":    "OPENAI_API_KEY",
-	"anthropic": "ANTHROPIC_API_KEY",
-	"gemini":    "GEMINI_API_KEY",
+	"openai":       "OPENAI_API_KEY",
+	"anthropic":    "ANTHROPIC_API_KEY",
+	"gemini":       "GEMINI_API_KEY",
+	"azure-openai": "AZURE_OPENAI_API_KEY",
 }
 
-// allProviders is the ordered list used by check --all.
-var allProviders = []ApiProvider{OpenAI, Anthropic, Gemini, Ollama, ClaudeCLI, GeminiCLI, CodexCLI}
+// providerVariables lists the non-secret GitHub Actions repository variables a
+// provider needs in addition to its API key secret.
+var providerVariables = map[string][]string{
+	"azure-openai": {"AZURE_OPENAI_ENDPOINT", "AZURE_OPENAI_DEPLOYMENT"},
+}
 
 const checkPingPrompt = `Reply with the single word: OK`
 
@@ -1904,6 +2215,8 @@ type pingResult struct {
 func pingProvider(ctx context.Context, cfg *Config, provider ApiProvider, chatFn func(context.Context, *Config, ApiProvider, string, string) (string, error)) pingResult {
 	provCfg := *cfg
 	provCfg.Provider = provider
+	// A ping must reach the provider itself, never a fallback.
+	provCfg.FallbackProviders = nil
 
 	// Pre-flight: skip providers whose credentials/binary are clearly absent
 	// so we don't waste time on a doomed network call.
@@ -1945,6 +2258,7 @@ Examples:
 			if err != nil {
 				return err
 			}
+			setLedgerRun(cfg, "check", "")
 
 			out := cmd.OutOrStdout()
 
@@ -1961,41 +2275,15 @@ Examples:
 			if cmd.Flags().Changed("model") {
 				setModelOverride(cfg, modelOverride)
 			}
+			// A ping must reach the provider itself, never a fallback.
+			cfg.FallbackProviders = nil
 
 			// Print resolved config.
 			_, _ = fmt.Fprintf(out, "Provider : %s\n", cfg.Provider)
 			_, _ = fmt.Fprintf(out, "Model    : %s\n", getModelName(cfg))
-			switch cfg.Provider {
-			case OpenAI:
-				_, _ = fmt.Fprintf(out, "Endpoint : %s\n", cfg.OpenAIEndpoint)
-				_, _ = fmt.Fprintf(out, "API key  : %s\n", maskSecret(cfg.OpenAIAPIKey))
-			case Anthropic:
-				_, _ = fmt.Fprintf(out, "Endpoint : %s\n", cfg.AnthropicEndpoint)
-				_, _ = fmt.Fprintf(out, "API key  : %s\n", maskSecret(cfg.AnthropicAPIKey))
-			case Gemini:
-				_, _ = fmt.Fprintf(out, "API key  : %s\n", maskSecret(cfg.GeminiAPIKey))
-			case Ollama:
-				_, _ = fmt.Fprintf(out, "Endpoint : %s\n", cfg.OllamaEndpoint)
-			case ClaudeCLI:
-				binary, binErr := findClaudeBinary(cfg)
-				if binErr != nil {
-					_, _ = fmt.Fprintf(out, "Binary   : (not found: %v)\n", binErr)
-				} else {
-					_, _ = fmt.Fprintf(out, "Binary   : %s\n", binary)
-				}
-			case GeminiCLI:
-				binary, binErr := findGeminiCLIBinary(cfg)
-				if binErr != nil {
-					_, _ = fmt.Fprintf(out, "Binary   : (not found: %v)\n", binErr)
-				} else {
-					_, _ = fmt.Fprintf(out, "Binary   : %s\n", binary)
-				}
-			case CodexCLI:
-				binary, binErr := findCodexBinary(cfg)
-				if binErr != nil {
-					_, _ = fmt.Fprintf(out, "Binary   : (not found: %v)\n", binErr)
-				} else {
-					_, _ = fmt.Fprintf(out, "Binary   : %s\n", binary)
+			if p, ok := lookupProvider(cfg.Provider); ok {
+				for _, d := range p.Describe(cfg) {
+					_, _ = fmt.Fprintf(out, "%-9s: %s\n", d.Label, d.Value)
 				}
 			}
 			_, _ = fmt.Fprintln(out)
@@ -2043,16 +2331,17 @@ func runCheckAll(ctx context.Context, cfg *Config, chatFn func(context.Context,
 		pingResult
 	}
 
-	results := make([]pingResult, len(allProviders))
-	ch := make(chan indexedResult, len(allProviders))
+	names := registeredProviders()
+	results := make([]pingResult, len(names))
+	ch := make(chan indexedResult, len(names))
 
-	for i, p := range allProviders {
+	for i, p := range names {
 		i, p := i, p
 		go func() {
 			ch <- indexedResult{idx: i, pingResult: pingProvider(ctx, cfg, p, chatFn)}
 		}()
 	}
-	for range allProviders {
+	for range names {
 		r := <-ch
 		results[r.idx] = r.pingResult
 	}
@@ -2130,10 +2419,18 @@ Use --output=- to print to stdout instead of writing a file.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			secretName, ok := providerSecrets[provider]
 			if !ok {
-				supported := []string{"openai", "anthropic", "gemini"}
+				supported := make([]string, 0, len(providerSecrets))
+				for name := range providerSecrets {
+					supported = append(supported, name)
+				}
+				sort.Strings(supported)
 				return fmt.Errorf("unsupported provider %q for gen-workflow; supported: %s", provider, strings.Join(supported, ", "))
 			}
 			providerEnvVar := secretName // env var name == secret name
+			var extraEnv strings.Builder
+			for _, name := range providerVariables[provider] {
+				_, _ = fmt.Fprintf(&extraEnv, "\n          %s: ${{ vars.%s }}", name, name)
+			}
 
 			workflow := fmt.Sprintf(`name: AI PR Review
 
@@ -2158,13 +2455,13 @@ jobs:
       - name: Generate and post AI review
         env:
           GITHUB_TOKEN: ${{ secrets.GITHUB_TOKEN }}
-          %s: ${{ secrets.%s }}
+          %s: ${{ secrets.%s }}%s
         run: |
           ai-mr-comment \
             --pr "${{ github.event.pull_request.html_url }}" \
             --provider %s \
             --post
-`, providerEnvVar, secretName, provider)
+`, providerEnvVar, secretName, extraEnv.String(), provider)
 
 			if outputPath == "-" {
 				_, _ = fmt.Fprint(cmd.OutOrStdout(), workflow)
@@ -2178,12 +2475,16 @@ jobs:
 				return fmt.Errorf("writing workflow file: %w", err)
 			}
 			_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Wrote workflow to %s\n", outputPath)
-			_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Add secret %q to your repo, then commit the workflow file.\n", secretName)
+			if vars := providerVariables[provider]; len(vars) > 0 {
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Add secret %q and repository variables %s to your repo, then commit the workflow file.\n", secretName, strings.Join(vars, ", "))
+			} else {
+				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Add secret %q to your repo, then commit the workflow file.\n", secretName)
+			}
 			return nil
 		},
 	}
 
-	cmd.Flags().StringVar(&provider, "provider", "openai", "AI provider to use in the workflow (openai, anthropic, gemini)")
+	cmd.Flags().StringVar(&provider, "provider", "openai", "AI provider to use in the workflow (openai, anthropic, gemini, azure-openai)")
 	cmd.Flags().StringVar(&outputPath, "output", ".github/workflows/ai-review.yml", "Output file path (use - for stdout)")
 	return cmd
 }
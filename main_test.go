@@ -16,6 +16,14 @@ import (
 	"testing"
 )
 
+// TestMain disables the response cache for every test so mocked chat
+// functions are always called; cache tests opt back in with t.Setenv.
+func TestMain(m *testing.M) {
+	_ = os.Setenv("AI_MR_COMMENT_CACHE_TTL", "0s")
+	_ = os.Setenv("AI_MR_COMMENT_RECORD_USAGE", "false")
+	os.Exit(m.Run())
+}
+
 func dummyChatFn(ctx context.Context, cfg *Config, provider ApiProvider, systemPrompt, diffContent string) (string, error) {
 	if strings.Contains(diffContent, "fail") {
 		return "", errors.New("forced error")
@@ -1051,7 +1059,7 @@ func TestNewRootCmd_TitleFlagJSON(t *testing.T) {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	var result map[string]string
+	var result map[string]any
 	if err := json.Unmarshal([]byte(buf.String()), &result); err != nil {
 		t.Fatalf("expected valid JSON, got: %s", buf.String())
 	}
@@ -1122,7 +1130,7 @@ func TestNewRootCmd_FormatJSON(t *testing.T) {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	var result map[string]string
+	var result map[string]any
 	if err := json.Unmarshal([]byte(buf.String()), &result); err != nil {
 		t.Fatalf("expected valid JSON output, got error: %v\noutput: %s", err, buf.String())
 	}
@@ -1227,7 +1235,7 @@ func TestStreaming_JSONFormatSkipsStream(t *testing.T) {
 	if err := cmd.Execute(); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	var result map[string]string
+	var result map[string]any
 	if err := json.Unmarshal([]byte(buf.String()), &result); err != nil {
 		t.Fatalf("expected valid JSON, got: %s", buf.String())
 	}
@@ -1574,7 +1582,7 @@ func TestNewRootCmd_FormatJSON_AutoTitle(t *testing.T) {
 		t.Errorf("expected 2 chatFn calls (description + auto-title), got %d", got)
 	}
 
-	var result map[string]string
+	var result map[string]any
 	if err := json.Unmarshal([]byte(buf.String()), &result); err != nil {
 		t.Fatalf("expected valid JSON, got: %s", buf.String())
 	}
@@ -1766,7 +1774,7 @@ func TestNewRootCmd_CommitMsgFlag_FormatJSON(t *testing.T) {
 		t.Errorf("expected 1 chatFn call (no auto-title for --commit-msg), got %d", callCount)
 	}
 
-	var result map[string]string
+	var result map[string]any
 	if err := json.Unmarshal([]byte(buf.String()), &result); err != nil {
 		t.Fatalf("expected valid JSON, got error: %v\noutput: %s", err, buf.String())
 	}
@@ -2553,11 +2561,12 @@ func TestOutputFlag_JSON(t *testing.T) {
 // Smart-chunk tests
 // ---------------------------------------------------------------------------
 
-// TestSmartChunk_MultiFile verifies that --smart-chunk splits a multi-file diff
-// into per-file chunks, calls the chat function once per chunk (parallel) plus
-// one synthesis call, and returns a non-empty comment.
+// TestSmartChunk_MultiFile verifies that --smart-chunk packs a multi-file diff
+// into batches, calls the chat function once per batch (parallel) plus one
+// synthesis call, and returns a non-empty comment.
 func TestSmartChunk_MultiFile(t *testing.T) {
 	t.Setenv("OPENAI_API_KEY", "dummy")
+	t.Setenv("AI_MR_COMMENT_SMART_CHUNK_BATCH_TOKENS", "300")
 
 	var mu sync.Mutex
 	callsByPrompt := map[string]int{}
@@ -2599,13 +2608,13 @@ func TestSmartChunk_MultiFile(t *testing.T) {
 	defer mu.Unlock()
 
 	// Count chunk-summary calls vs synthesis calls.
-	chunkCalls := callsByPrompt["Summarize the changes in this file diff in 3-5 bullet points. Be concise and technical."]
+	chunkCalls := callsByPrompt[smartChunkPrompt]
 	if chunkCalls < 2 {
-		t.Errorf("expected at least 2 per-file chunk calls, got %d", chunkCalls)
+		t.Errorf("expected at least 2 batch summary calls, got %d", chunkCalls)
 	}
 }
 
-// TestSmartChunk_SingleFile verifies that when the diff contains only one file,
+// TestSmartChunk_SingleFile verifies that when the diff fits in one batch,
 // --smart-chunk falls through to a single direct comment call (no summarise+synthesise).
 func TestSmartChunk_SingleFile(t *testing.T) {
 	t.Setenv("OPENAI_API_KEY", "dummy")
@@ -2647,6 +2656,7 @@ func TestSmartChunk_SingleFile(t *testing.T) {
 // and the synthesis call receives content from every file.
 func TestSmartChunk_LargeFileSet(t *testing.T) {
 	t.Setenv("OPENAI_API_KEY", "dummy")
+	t.Setenv("AI_MR_COMMENT_SMART_CHUNK_BATCH_TOKENS", "300")
 
 	// Count unique files seen across all chunk calls.
 	var mu sync.Mutex
@@ -2684,7 +2694,7 @@ func TestSmartChunk_LargeFileSet(t *testing.T) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	// The fixture has 11 file diffs — verify all were chunked.
+	// The fixture has 11 file diffs — verify they were spread over batches.
 	if len(seenChunkDiffs) < 5 {
 		t.Errorf("expected at least 5 file chunks, got %d", len(seenChunkDiffs))
 	}
@@ -2702,6 +2712,7 @@ func TestSmartChunk_LargeFileSet(t *testing.T) {
 // the whole command returns an error.
 func TestSmartChunk_ChunkError(t *testing.T) {
 	t.Setenv("OPENAI_API_KEY", "dummy")
+	t.Setenv("AI_MR_COMMENT_SMART_CHUNK_BATCH_TOKENS", "300")
 
 	var callCount atomic.Int32
 	mockFn := func(ctx context.Context, cfg *Config, provider ApiProvider, systemPrompt, diffContent string) (string, error) {
@@ -2734,6 +2745,51 @@ func TestSmartChunk_ChunkError(t *testing.T) {
 	}
 }
 
+func TestSmartChunk_PartialJSON(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "dummy")
+	t.Setenv("AI_MR_COMMENT_SMART_CHUNK_BATCH_TOKENS", "300")
+
+	var callCount atomic.Int32
+	mockFn := func(_ context.Context, _ *Config, _ ApiProvider, systemPrompt, _ string) (string, error) {
+		if systemPrompt == smartChunkPrompt {
+			if callCount.Add(1) == 1 {
+				return "", errors.New("simulated chunk API failure")
+			}
+			return "ok summary", nil
+		}
+		return "synthesis", nil
+	}
+
+	var out, errOut strings.Builder
+	cmd := newRootCmd(mockFn)
+	cmd.SetArgs([]string{
+		"--smart-chunk",
+		"--smart-chunk-partial",
+		"--smart-chunk-workers=1",
+		"--file=testdata/large-multi-file.diff",
+		"--provider=openai",
+		"--format=json",
+	})
+	cmd.SetOut(&out)
+	cmd.SetErr(&errOut)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var payload struct {
+		Description   string   `json:"description"`
+		NotSummarized []string `json:"not_summarized"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &payload); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if payload.Description != "synthesis" || len(payload.NotSummarized) == 0 {
+		t.Errorf("expected the synthesis with the failed files listed, got %+v", payload)
+	}
+	if !strings.Contains(errOut.String(), "not summarised") {
+		t.Errorf("expected a warning on stderr, got %q", errOut.String())
+	}
+}
+
 // ── changelog subcommand tests ────────────────────────────────────────────────
 
 func TestChangelog_TextOutput(t *testing.T) {
@@ -3886,7 +3942,7 @@ func TestCheckAll_AllPass(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	out := buf.String()
-	for _, p := range allProviders {
+	for _, p := range registeredProviders() {
 		if !strings.Contains(out, string(p)) {
 			t.Errorf("expected provider %q in output", p)
 		}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	openai "github.com/openai/openai-go"
+	"google.golang.org/api/iterator"
+)
+
+// listOpenAIModels returns the model IDs from GET /models.
+func listOpenAIModels(ctx context.Context, client *openai.Client) ([]string, error) {
+	iter := client.Models.ListAutoPaging(ctx)
+	var models []string
+	for iter.Next() {
+		models = append(models, iter.Current().ID)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, enrichNetworkError(err)
+	}
+	return models, nil
+}
+
+// listGeminiModels returns the Gemini models that support generateContent,
+// without the "models/" resource prefix.
+func listGeminiModels(ctx context.Context, cfg *Config) ([]string, error) {
+	client, err := getGeminiClient(ctx, cfg.GeminiAPIKey)
+	if err != nil {
+		return nil, err
+	}
+	iter := client.ListModels(ctx)
+	var models []string
+	for {
+		info, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, enrichNetworkError(err)
+		}
+		for _, method := range info.SupportedGenerationMethods {
+			if method == "generateContent" {
+				models = append(models, strings.TrimPrefix(info.Name, "models/"))
+				break
+			}
+		}
+	}
+	return models, nil
+}
+
+// ollamaTagsURL derives the /api/tags URL from ollama_endpoint, which points at
+// /api/generate. A path prefix in front of /api (e.g. behind a proxy) is kept.
+func ollamaTagsURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid ollama_endpoint %q", endpoint)
+	}
+	prefix, _, _ := strings.Cut(u.Path, "/api/")
+	u.Path = strings.TrimSuffix(prefix, "/") + "/api/tags"
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// listOllamaModels returns the models pulled on the Ollama server.
+func listOllamaModels(ctx context.Context, cfg *Config) ([]string, error) {
+	tagsURL, err := ollamaTagsURL(cfg.OllamaEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tagsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ollamaHTTPClient.Do(req)
+	if err != nil {
+		return nil, enrichNetworkError(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("ollama returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("decoding ollama model list: %w", err)
+	}
+	models := make([]string, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		models = append(models, m.Name)
+	}
+	return models, nil
+}
+
+// modelEntry is one model in the models subcommand output. Prices are USD per
+// 1M tokens and only set when Priced is true.
+type modelEntry struct {
+	ID          string  `json:"id"`
+	Priced      bool    `json:"priced"`
+	InputPrice  float64 `json:"input_price,omitempty"`
+	OutputPrice float64 `json:"output_price,omitempty"`
+}
+
+// modelListing is the models subcommand output for one provider. Source is
+// "live" when the list came from the provider's API and "static" otherwise.
+// ConfiguredModelListed lets scripts check the configured model before a run.
+type modelListing struct {
+	Provider              ApiProvider  `json:"provider"`
+	Source                string       `json:"source"`
+	ConfiguredModel       string       `json:"configured_model,omitempty"`
+	ConfiguredModelListed *bool        `json:"configured_model_listed,omitempty"`
+	Models                []modelEntry `json:"models"`
+	Error                 string       `json:"error,omitempty"`
+}
+
+// newModelListing prices each model using cfg's pricing overrides, or the
+// built-in table when cfg is nil.
+func newModelListing(cfg *Config, provider ApiProvider, source string, models []string) modelListing {
+	l := modelListing{Provider: provider, Source: source, Models: make([]modelEntry, 0, len(models))}
+	var overrides []PricingOverride
+	if cfg != nil {
+		overrides = cfg.Pricing
+	}
+	p, _ := lookupProvider(provider)
+	for _, m := range models {
+		e := modelEntry{ID: m}
+		if price, ok := priceFor(overrides, p, m); ok {
+			e.Priced, e.InputPrice, e.OutputPrice = true, price.Input, price.Output
+		}
+		l.Models = append(l.Models, e)
+	}
+	return l
+}
+
+// liveModelListing queries p's listing endpoint. A failure is recorded in the
+// listing's Error rather than returned so one unreachable provider does not
+// hide the others.
+func liveModelListing(ctx context.Context, cfg *Config, p Provider) modelListing {
+	lister, ok := p.(modelLister)
+	if !ok {
+		return modelListing{Provider: p.Name(), Source: "live", Models: []modelEntry{}, Error: "provider has no model listing endpoint"}
+	}
+	if err := p.Validate(cfg); err != nil {
+		return modelListing{Provider: p.Name(), Source: "live", Models: []modelEntry{}, Error: firstLine(err.Error())}
+	}
+	debugLog(cfg, "models: listing %s", p.Name())
+	models, err := lister.ListModels(ctx, cfg)
+	if err != nil {
+		return modelListing{Provider: p.Name(), Source: "live", Models: []modelEntry{}, Error: firstLine(err.Error())}
+	}
+	sort.Strings(models)
+	l := newModelListing(cfg, p.Name(), "live", models)
+	if configured := p.Model(cfg); configured != "" {
+		listed := modelListed(models, configured)
+		l.ConfiguredModel = configured
+		l.ConfiguredModelListed = &listed
+	}
+	return l
+}
+
+// modelListed reports whether model is in models. Ollama lists untagged
+// models as "name:latest", which matches a configured "name".
+func modelListed(models []string, model string) bool {
+	for _, m := range models {
+		if m == model || m == model+":latest" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeModelListings prints listings for humans.
+func writeModelListings(w io.Writer, listings []modelListing) {
+	for i, l := range listings {
+		if i > 0 {
+			_, _ = fmt.Fprintln(w)
+		}
+		_, _ = fmt.Fprintf(w, "Models for provider %s (%s):\n\n", l.Provider, l.Source)
+		if l.Error != "" {
+			_, _ = fmt.Fprintf(w, "  (unavailable: %s)\n", l.Error)
+			continue
+		}
+		if len(l.Models) == 0 {
+			if l.Source == "static" {
+				_, _ = fmt.Fprintln(w, "  (no fixed model list — configured by the local CLI tool)")
+			} else {
+				_, _ = fmt.Fprintln(w, "  (no models returned)")
+			}
+		}
+		width := 0
+		for _, m := range l.Models {
+			if len(m.ID) > width {
+				width = len(m.ID)
+			}
+		}
+		for _, m := range l.Models {
+			pricing := "(no pricing)"
+			if m.Priced {
+				pricing = fmt.Sprintf("$%.2f in / $%.2f out per 1M", m.InputPrice, m.OutputPrice)
+			}
+			marker := " "
+			if m.ID == l.ConfiguredModel || m.ID == l.ConfiguredModel+":latest" {
+				marker = "*"
+			}
+			_, _ = fmt.Fprintf(w, "%s %-*s  %s\n", marker, width, m.ID, pricing)
+		}
+		if l.ConfiguredModelListed != nil && !*l.ConfiguredModelListed {
+			_, _ = fmt.Fprintf(w, "\n  Configured model %q was not returned by the provider.\n", l.ConfiguredModel)
+		}
+	}
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintf(w, "Use --model <name> to select a model for a run.\n")
+}
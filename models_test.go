@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOllamaTagsURL(t *testing.T) {
+	tests := map[string]string{
+		"http://localhost:11434/api/generate":       "http://localhost:11434/api/tags",
+		"http://proxy.internal/ollama/api/generate": "http://proxy.internal/ollama/api/tags",
+		"http://localhost:11434":                    "http://localhost:11434/api/tags",
+	}
+	for in, want := range tests {
+		got, err := ollamaTagsURL(in)
+		if err != nil || got != want {
+			t.Errorf("ollamaTagsURL(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ollamaTagsURL("not a url"); err == nil {
+		t.Error("expected error for invalid endpoint")
+	}
+}
+
+func TestLiveModelListing_OpenAI(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"object":"list","data":[{"id":"gpt-4o","object":"model"},{"id":"my-finetune","object":"model"}]}`))
+	}))
+	defer srv.Close()
+
+	cfg := &Config{OpenAIAPIKey: "k", OpenAIEndpoint: srv.URL + "/v1/", OpenAIModel: "gpt-4o"}
+	l := liveModelListing(context.Background(), cfg, openAIProvider{})
+	if l.Error != "" {
+		t.Fatalf("unexpected error: %s", l.Error)
+	}
+	if len(l.Models) != 2 || l.Models[0].ID != "gpt-4o" || !l.Models[0].Priced || l.Models[1].Priced {
+		t.Errorf("unexpected models: %+v", l.Models)
+	}
+	if l.ConfiguredModelListed == nil || !*l.ConfiguredModelListed {
+		t.Errorf("expected configured model to be listed: %+v", l)
+	}
+}
+
+func TestLiveModelListing_Anthropic(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[{"id":"claude-sonnet-4-6","type":"model","display_name":"Claude Sonnet 4.6","created_at":"2026-01-01T00:00:00Z"}],"has_more":false}`))
+	}))
+	defer srv.Close()
+
+	cfg := &Config{AnthropicAPIKey: "k", AnthropicEndpoint: srv.URL, AnthropicModel: "claude-retired-1"}
+	l := liveModelListing(context.Background(), cfg, anthropicProvider{})
+	if l.Error != "" {
+		t.Fatalf("unexpected error: %s", l.Error)
+	}
+	if len(l.Models) != 1 || l.Models[0].ID != "claude-sonnet-4-6" {
+		t.Errorf("unexpected models: %+v", l.Models)
+	}
+	if l.ConfiguredModelListed == nil || *l.ConfiguredModelListed {
+		t.Errorf("expected configured model to be reported missing: %+v", l)
+	}
+}
+
+func TestLiveModelListing_OllamaLatestTag(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"models":[{"name":"qwen2.5-coder:7b"},{"name":"llama3:latest"}]}`))
+	}))
+	defer srv.Close()
+
+	cfg := &Config{OllamaEndpoint: srv.URL + "/api/generate", OllamaModel: "llama3"}
+	l := liveModelListing(context.Background(), cfg, ollamaProvider{})
+	if l.Error != "" {
+		t.Fatalf("unexpected error: %s", l.Error)
+	}
+	if len(l.Models) != 2 || l.Models[0].ID != "llama3:latest" {
+		t.Errorf("expected sorted models, got %+v", l.Models)
+	}
+	if l.ConfiguredModelListed == nil || !*l.ConfiguredModelListed {
+		t.Errorf("expected llama3 to match llama3:latest: %+v", l)
+	}
+}
+
+func TestLiveModelListing_Errors(t *testing.T) {
+	if l := liveModelListing(context.Background(), &Config{}, openAIProvider{}); !strings.Contains(l.Error, "no OpenAI API key") {
+		t.Errorf("expected missing key error, got %q", l.Error)
+	}
+	if l := liveModelListing(context.Background(), &Config{}, codexCLIProvider{}); l.Error == "" {
+		t.Error("expected error for provider without a listing endpoint")
+	}
+}
+
+func TestModelsCmd_StaticJSONMarksPricing(t *testing.T) {
+	cmd := newModelsCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"--provider=openai", "--format=json"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	var listings []modelListing
+	if err := json.Unmarshal(out.Bytes(), &listings); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if len(listings) != 1 || listings[0].Source != "static" || len(listings[0].Models) == 0 {
+		t.Fatalf("unexpected listings: %+v", listings)
+	}
+	for _, m := range listings[0].Models {
+		if !m.Priced {
+			t.Errorf("expected built-in OpenAI model %s to be priced", m.ID)
+		}
+	}
+}
+
+func TestModelsCmd_LiveNamedProviderFails(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	cmd := newModelsCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"--live", "--provider=openai"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "listing openai models") {
+		t.Errorf("expected listing error, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	openai "github.com/openai/openai-go"
+	openaiopt "github.com/openai/openai-go/option"
+)
+
+func init() {
+	RegisterProvider(openAICompatibleProvider{})
+}
+
+// openAICompatibleProvider talks to any server that implements the OpenAI
+// chat completions API (vLLM, LM Studio, LiteLLM, internal gateways). It
+// reuses the OpenAI request path but has its own endpoint, model, and key,
+// and lets the config add headers, rename the auth header, and drop request
+// parameters the server rejects.
+type openAICompatibleProvider struct{}
+
+func (openAICompatibleProvider) Name() ApiProvider { return OpenAICompatible }
+
+// clientOptions builds the SDK options for the configured server. The OpenAI
+// SDK picks up OPENAI_API_KEY, OPENAI_ORG_ID, and OPENAI_PROJECT_ID from the
+// environment by default; those headers are removed so real OpenAI
+// credentials are never sent to a third-party server.
+func (openAICompatibleProvider) clientOptions(cfg *Config) []openaiopt.RequestOption {
+	opts := []openaiopt.RequestOption{
+		openaiopt.WithBaseURL(cfg.OpenAICompatibleEndpoint),
+		openaiopt.WithMaxRetries(0), // retried by withRetry
+		openaiopt.WithHeaderDel("Authorization"),
+		openaiopt.WithHeaderDel("OpenAI-Organization"),
+		openaiopt.WithHeaderDel("OpenAI-Project"),
+	}
+	if cfg.OpenAICompatibleAPIKey != "" {
+		header := cfg.OpenAICompatibleAuthHeader
+		if header == "" || strings.EqualFold(header, "Authorization") {
+			opts = append(opts, openaiopt.WithHeader("Authorization", "Bearer "+cfg.OpenAICompatibleAPIKey))
+		} else {
+			opts = append(opts, openaiopt.WithHeader(header, cfg.OpenAICompatibleAPIKey))
+		}
+	}
+	// Sort for a deterministic header order in debug logs and tests.
+	keys := make([]string, 0, len(cfg.OpenAICompatibleHeaders))
+	for k := range cfg.OpenAICompatibleHeaders {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		opts = append(opts, openaiopt.WithHeader(k, cfg.OpenAICompatibleHeaders[k]))
+	}
+	return opts
+}
+
+// requestOptions maps openai_compatible_omit_params onto openAIRequestOptions.
+func (openAICompatibleProvider) requestOptions(cfg *Config) openAIRequestOptions {
+	var opts openAIRequestOptions
+	for _, p := range cfg.OpenAICompatibleOmitParams {
+		switch strings.ToLower(strings.TrimSpace(p)) {
+		case "temperature":
+			opts.OmitTemperature = true
+		case "max_tokens":
+			opts.OmitMaxTokens = true
+		case "stream_options":
+			opts.OmitStreamUsage = true
+		}
+	}
+	return opts
+}
+
+func (p openAICompatibleProvider) params(cfg *Config, systemPrompt, diffContent string) openai.ChatCompletionNewParams {
+	return newOpenAIChatParams(cfg.OpenAICompatibleModel, systemPrompt, diffContent, p.requestOptions(cfg))
+}
+
+func (p openAICompatibleProvider) Call(ctx context.Context, cfg *Config, systemPrompt, diffContent string) (string, error) {
+	debugLog(cfg, "api: calling openai-compatible model=%s endpoint=%s mode=buffered", cfg.OpenAICompatibleModel, cfg.OpenAICompatibleEndpoint)
+	client := openai.NewClient(p.clientOptions(cfg)...)
+	return callOpenAIChat(ctx, &client, p.params(cfg, systemPrompt, diffContent))
+}
+
+func (p openAICompatibleProvider) Stream(ctx context.Context, cfg *Config, systemPrompt, diffContent string, w io.Writer) (string, error) {
+	debugLog(cfg, "api: calling openai-compatible model=%s endpoint=%s mode=stream", cfg.OpenAICompatibleModel, cfg.OpenAICompatibleEndpoint)
+	client := openai.NewClient(p.clientOptions(cfg)...)
+	params := p.params(cfg, systemPrompt, diffContent)
+	if !p.requestOptions(cfg).OmitStreamUsage {
+		params = withStreamUsage(params)
+	}
+	return streamOpenAIChat(ctx, &client, params, w)
+}
+
+// Validate requires an endpoint and model. The API key is optional because
+// local servers such as LM Studio and vLLM often run without auth.
+func (openAICompatibleProvider) Validate(cfg *Config) error {
+	if cfg.OpenAICompatibleEndpoint == "" {
+		return errors.New("no OpenAI-compatible endpoint configured\n\nAdd 'openai_compatible_endpoint' (e.g. http://localhost:8000/v1/) to ~/.ai-mr-comment.toml")
+	}
+	if cfg.OpenAICompatibleModel == "" {
+		return errors.New("no OpenAI-compatible model configured\n\nAdd 'openai_compatible_model' to ~/.ai-mr-comment.toml or pass --model")
+	}
+	for _, p := range cfg.OpenAICompatibleOmitParams {
+		switch strings.ToLower(strings.TrimSpace(p)) {
+		case "temperature", "max_tokens", "stream_options":
+		default:
+			return fmt.Errorf("unsupported openai_compatible_omit_params value %q: must be temperature, max_tokens or stream_options", p)
+		}
+	}
+	if h := cfg.OpenAICompatibleAuthHeader; h != "" && !isValidHeaderName(h) {
+		return fmt.Errorf("invalid openai_compatible_auth_header %q", h)
+	}
+	return nil
+}
+
+func (openAICompatibleProvider) Model(cfg *Config) string { return cfg.OpenAICompatibleModel }
+func (openAICompatibleProvider) SetModel(cfg *Config, model string) {
+	cfg.OpenAICompatibleModel = model
+}
+func (openAICompatibleProvider) TokenEstimator(*Config) TokenEstimator {
+	return &HeuristicTokenEstimator{}
+}
+
+// KnownModels is empty: the model list depends entirely on the server.
+func (openAICompatibleProvider) KnownModels() []string { return nil }
+
+// ListModels queries GET /models on the server. Not every compatible server
+// implements it.
+func (p openAICompatibleProvider) ListModels(ctx context.Context, cfg *Config) ([]string, error) {
+	client := openai.NewClient(p.clientOptions(cfg)...)
+	return listOpenAIModels(ctx, &client)
+}
+
+func (openAICompatibleProvider) Describe(cfg *Config) []ProviderDetail {
+	details := []ProviderDetail{
+		{Label: "Endpoint", Value: cfg.OpenAICompatibleEndpoint},
+		{Label: "Auth", Value: cfg.OpenAICompatibleAuthHeader},
+		{Label: "API key", Value: maskSecret(cfg.OpenAICompatibleAPIKey)},
+	}
+	if len(cfg.OpenAICompatibleHeaders) > 0 {
+		names := make([]string, 0, len(cfg.OpenAICompatibleHeaders))
+		for k := range cfg.OpenAICompatibleHeaders {
+			names = append(names, http.CanonicalHeaderKey(k))
+		}
+		sort.Strings(names)
+		details = append(details, ProviderDetail{Label: "Headers", Value: strings.Join(names, ", ")})
+	}
+	if len(cfg.OpenAICompatibleOmitParams) > 0 {
+		details = append(details, ProviderDetail{Label: "Omitted", Value: strings.Join(cfg.OpenAICompatibleOmitParams, ", ")})
+	}
+	return details
+}
+
+// isValidHeaderName reports whether name is a valid HTTP header field name
+// (an RFC 7230 token).
+func isValidHeaderName(name string) bool {
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", r):
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
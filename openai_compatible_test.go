@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// compatServer returns a chat completions server that records the last
+// request's headers and decoded JSON body.
+func compatServer(t *testing.T, headers *http.Header, body *map[string]any) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*headers = r.Header.Clone()
+		raw, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(raw, body)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id": "1", "object": "chat.completion", "created": 0, "model": "local",
+			"choices": []map[string]any{
+				{"index": 0, "message": map[string]string{"role": "assistant", "content": "compat response"}, "finish_reason": "stop"},
+			},
+		})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestOpenAICompatible_BearerAuthAndHeaders(t *testing.T) {
+	var headers http.Header
+	var body map[string]any
+	ts := compatServer(t, &headers, &body)
+
+	cfg := &Config{
+		Provider:                   OpenAICompatible,
+		OpenAICompatibleEndpoint:   ts.URL + "/v1/",
+		OpenAICompatibleModel:      "llama-3.1-8b",
+		OpenAICompatibleAPIKey:     "compat-key",
+		OpenAICompatibleAuthHeader: "Authorization",
+		OpenAICompatibleHeaders:    map[string]string{"x-tenant-id": "team-a"},
+	}
+	got, err := chatCompletions(context.Background(), cfg, OpenAICompatible, "prompt", "diff")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "compat response" {
+		t.Errorf("expected 'compat response', got %q", got)
+	}
+	if auth := headers.Get("Authorization"); auth != "Bearer compat-key" {
+		t.Errorf("expected bearer auth, got %q", auth)
+	}
+	if tenant := headers.Get("X-Tenant-Id"); tenant != "team-a" {
+		t.Errorf("expected tenant header, got %q", tenant)
+	}
+	if body["model"] != "llama-3.1-8b" {
+		t.Errorf("expected model llama-3.1-8b, got %v", body["model"])
+	}
+	if _, ok := body["temperature"]; !ok {
+		t.Error("expected temperature to be sent by default")
+	}
+}
+
+func TestOpenAICompatible_CustomAuthHeaderDoesNotLeakOpenAIKey(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "real-openai-key")
+	t.Setenv("OPENAI_ORG_ID", "org-real")
+	var headers http.Header
+	var body map[string]any
+	ts := compatServer(t, &headers, &body)
+
+	cfg := &Config{
+		OpenAICompatibleEndpoint:   ts.URL + "/v1/",
+		OpenAICompatibleModel:      "gpt-4o",
+		OpenAICompatibleAPIKey:     "azure-style-key",
+		OpenAICompatibleAuthHeader: "api-key",
+	}
+	if _, err := chatCompletions(context.Background(), cfg, OpenAICompatible, "prompt", "diff"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := headers.Get("Api-Key"); got != "azure-style-key" {
+		t.Errorf("expected raw key under api-key header, got %q", got)
+	}
+	if got := headers.Get("Authorization"); got != "" {
+		t.Errorf("expected no Authorization header, got %q", got)
+	}
+	if got := headers.Get("OpenAI-Organization"); got != "" {
+		t.Errorf("expected no OpenAI-Organization header, got %q", got)
+	}
+}
+
+func TestOpenAICompatible_NoKeySendsNoAuth(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "real-openai-key")
+	var headers http.Header
+	var body map[string]any
+	ts := compatServer(t, &headers, &body)
+
+	cfg := &Config{OpenAICompatibleEndpoint: ts.URL + "/v1/", OpenAICompatibleModel: "local"}
+	if _, err := chatCompletions(context.Background(), cfg, OpenAICompatible, "prompt", "diff"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := headers.Get("Authorization"); got != "" {
+		t.Errorf("expected no Authorization header, got %q", got)
+	}
+}
+
+func TestOpenAICompatible_OmitParams(t *testing.T) {
+	var headers http.Header
+	var body map[string]any
+	ts := compatServer(t, &headers, &body)
+
+	cfg := &Config{
+		OpenAICompatibleEndpoint:   ts.URL + "/v1/",
+		OpenAICompatibleModel:      "o-series",
+		OpenAICompatibleOmitParams: []string{"temperature", "max_tokens"},
+	}
+	if _, err := chatCompletions(context.Background(), cfg, OpenAICompatible, "prompt", "diff"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, k := range []string{"temperature", "max_tokens"} {
+		if _, ok := body[k]; ok {
+			t.Errorf("expected %s to be omitted, body: %v", k, body)
+		}
+	}
+}
+
+func TestOpenAICompatible_Stream(t *testing.T) {
+	var gotTenant string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTenant = r.Header.Get("X-Tenant-Id")
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range []string{"Hello", " compat"} {
+			_, _ = fmt.Fprintf(w, "data: {\"id\":\"1\",\"object\":\"chat.completion.chunk\",\"created\":0,\"model\":\"local\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q},\"finish_reason\":null}]}\n\n", c)
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer ts.Close()
+
+	cfg := &Config{
+		OpenAICompatibleEndpoint: ts.URL + "/v1/",
+		OpenAICompatibleModel:    "local",
+		OpenAICompatibleHeaders:  map[string]string{"X-Tenant-ID": "team-b"},
+	}
+	var buf strings.Builder
+	got, err := streamToWriter(context.Background(), cfg, OpenAICompatible, "sys", "diff", &buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "Hello compat" || buf.String() != "Hello compat" {
+		t.Errorf("unexpected stream result %q / %q", got, buf.String())
+	}
+	if gotTenant != "team-b" {
+		t.Errorf("expected tenant header on stream, got %q", gotTenant)
+	}
+}
+
+func TestOpenAICompatible_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{"missing endpoint", Config{OpenAICompatibleModel: "m"}, "openai_compatible_endpoint"},
+		{"missing model", Config{OpenAICompatibleEndpoint: "http://x/v1/"}, "openai_compatible_model"},
+		{"bad omit param", Config{OpenAICompatibleEndpoint: "http://x/v1/", OpenAICompatibleModel: "m", OpenAICompatibleOmitParams: []string{"top_p"}}, "top_p"},
+		{"bad auth header", Config{OpenAICompatibleEndpoint: "http://x/v1/", OpenAICompatibleModel: "m", OpenAICompatibleAuthHeader: "api key"}, "openai_compatible_auth_header"},
+		{"valid", Config{OpenAICompatibleEndpoint: "http://x/v1/", OpenAICompatibleModel: "m", OpenAICompatibleAuthHeader: "api-key"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := openAICompatibleProvider{}.Validate(&tt.cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigWith_OpenAICompatibleProfile(t *testing.T) {
+	t.Setenv("OPENAI_COMPATIBLE_API_KEY", "")
+	path := writeConfigFile(t, `
+provider = "openai"
+
+[profile.gateway]
+provider = "openai-compatible"
+openai_compatible_endpoint = "https://llm.internal/v1/"
+openai_compatible_model = "gpt-4o"
+openai_compatible_api_key = "gw-key"
+openai_compatible_auth_header = "api-key"
+openai_compatible_omit_params = ["temperature"]
+
+[profile.gateway.openai_compatible_headers]
+X-Tenant-ID = "team-a"
+`)
+	cfg, err := loadConfigWith(newViperFromFile(path), "gateway")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Provider != OpenAICompatible {
+		t.Errorf("expected provider openai-compatible, got %v", cfg.Provider)
+	}
+	if cfg.OpenAICompatibleEndpoint != "https://llm.internal/v1/" || cfg.OpenAICompatibleModel != "gpt-4o" {
+		t.Errorf("unexpected endpoint/model: %q / %q", cfg.OpenAICompatibleEndpoint, cfg.OpenAICompatibleModel)
+	}
+	if cfg.OpenAICompatibleAPIKey != "gw-key" || cfg.OpenAICompatibleAuthHeader != "api-key" {
+		t.Errorf("unexpected auth: %q / %q", cfg.OpenAICompatibleAPIKey, cfg.OpenAICompatibleAuthHeader)
+	}
+	if len(cfg.OpenAICompatibleOmitParams) != 1 || cfg.OpenAICompatibleOmitParams[0] != "temperature" {
+		t.Errorf("unexpected omit params: %v", cfg.OpenAICompatibleOmitParams)
+	}
+	// Viper lower-cases map keys; header names are case-insensitive anyway.
+	if cfg.OpenAICompatibleHeaders["x-tenant-id"] != "team-a" {
+		t.Errorf("expected tenant header from profile, got %v", cfg.OpenAICompatibleHeaders)
+	}
+}
+
+func TestLoadConfig_OpenAICompatibleDefaults(t *testing.T) {
+	path := writeConfigFile(t, `provider = "openai"`)
+	cfg, err := loadConfigWith(newViperFromFile(path), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.OpenAICompatibleAuthHeader != "Authorization" {
+		t.Errorf("expected default auth header Authorization, got %q", cfg.OpenAICompatibleAuthHeader)
+	}
+}
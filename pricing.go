@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+)
+
+// PricingOverride sets the USD price per 1M tokens for one model. Entries come
+// from [[pricing]] tables in the config file or in pricing_file, and take
+// precedence over the built-in table so negotiated rates and newly released
+// models are priced correctly.
+type PricingOverride struct {
+	Model       string  `mapstructure:"model"`
+	Input       float64 `mapstructure:"input"`
+	Output      float64 `mapstructure:"output"`
+	CachedInput float64 `mapstructure:"cached_input"`
+}
+
+// loadPricingFile reads the [[pricing]] tables from a standalone TOML file.
+func loadPricingFile(path string) ([]PricingOverride, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("toml")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("reading pricing_file: %w", err)
+	}
+	var file struct {
+		Pricing []PricingOverride `mapstructure:"pricing"`
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		ErrorUnused: true,
+		Result:      &file,
+		TagName:     "mapstructure",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(v.AllSettings()); err != nil {
+		return nil, fmt.Errorf("reading pricing_file %s: %w", path, err)
+	}
+	return file.Pricing, nil
+}
+
+// resolvePricing merges pricing_file entries into cfg.Pricing and validates
+// the result. Entries from the config file are listed first so they win over
+// the pricing file for the same model.
+func resolvePricing(cfg *Config) error {
+	if cfg.PricingFile != "" {
+		fromFile, err := loadPricingFile(cfg.PricingFile)
+		if err != nil {
+			return err
+		}
+		cfg.Pricing = append(cfg.Pricing, fromFile...)
+	}
+	for i, p := range cfg.Pricing {
+		if p.Model == "" {
+			return fmt.Errorf("pricing entry %d: model is required", i+1)
+		}
+		if p.Input < 0 || p.Output < 0 || p.CachedInput < 0 {
+			return fmt.Errorf("pricing entry for %q: prices must not be negative", p.Model)
+		}
+	}
+	return nil
+}
+
+// providerPrice returns the price of p's calls, honouring cfg's pricing
+// overrides; see priceFor.
+func providerPrice(cfg *Config, p Provider) (ModelPrice, bool) {
+	return priceFor(cfg.Pricing, p, providerPricingModel(cfg, p))
+}
+
+// priceFor returns the price of model served by p: an override for model,
+// then p's own price when it is a pricer, then lookupPrice. p may be nil.
+func priceFor(overrides []PricingOverride, p Provider, model string) (ModelPrice, bool) {
+	if price, ok := pricingOverride(overrides, model); ok {
+		return price, true
+	}
+	if pr, ok := p.(pricer); ok {
+		return pr.Price(), true
+	}
+	return lookupPrice(overrides, model)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigWith_PricingSection(t *testing.T) {
+	path := writeConfigFile(t, `
+provider = "openai"
+
+[[pricing]]
+model = "gpt-4.1"
+input = 1.6
+output = 6.4
+cached_input = 0.4
+
+[[pricing]]
+model = "acme-coder-2"
+input = 1
+output = 3
+
+[profile.enterprise]
+openai_model = "acme-coder-2"
+
+[[profile.enterprise.pricing]]
+model = "acme-coder-2"
+input = 0.5
+output = 1.5
+`)
+	cfg, err := loadConfigWith(newViperFromFile(path), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Pricing) != 2 || cfg.Pricing[0].Model != "gpt-4.1" || cfg.Pricing[0].CachedInput != 0.4 {
+		t.Fatalf("unexpected pricing: %+v", cfg.Pricing)
+	}
+	// Integer prices decode into float fields.
+	if price, _ := lookupPrice(cfg.Pricing, "acme-coder-2"); price.Input != 1 || price.Output != 3 {
+		t.Errorf("unexpected acme price: %+v", price)
+	}
+
+	cfg, err = loadConfigWith(newViperFromFile(path), "enterprise")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if price, _ := lookupPrice(cfg.Pricing, "acme-coder-2"); price.Input != 0.5 {
+		t.Errorf("expected profile pricing to replace the global table, got %+v", price)
+	}
+}
+
+func TestLoadConfigWith_PricingFile(t *testing.T) {
+	pricingPath := filepath.Join(t.TempDir(), "pricing.toml")
+	if err := os.WriteFile(pricingPath, []byte(`
+[[pricing]]
+model = "gpt-4o"
+input = 2.0
+output = 8.0
+
+[[pricing]]
+model = "team-model"
+input = 0.25
+output = 1.0
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := writeConfigFile(t, `
+pricing_file = "`+pricingPath+`"
+
+[[pricing]]
+model = "gpt-4o"
+input = 1.0
+output = 4.0
+`)
+	cfg, err := loadConfigWith(newViperFromFile(path), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if price, _ := lookupPrice(cfg.Pricing, "gpt-4o"); price.Input != 1.0 {
+		t.Errorf("expected config entry to win over pricing_file, got %+v", price)
+	}
+	if price, ok := lookupPrice(cfg.Pricing, "team-model"); !ok || price.Output != 1.0 {
+		t.Errorf("expected pricing_file entry, got %+v", price)
+	}
+}
+
+func TestLoadConfigWith_InvalidPricing(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"missing model", "[[pricing]]\ninput = 1.0\n", "model is required"},
+		{"negative price", "[[pricing]]\nmodel = \"m\"\noutput = -1.0\n", "must not be negative"},
+		{"unknown key", "[[pricing]]\nmodel = \"m\"\ninptu = 1.0\n", "inptu"},
+		{"missing file", "pricing_file = \"/nonexistent/pricing.toml\"\n", "pricing_file"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadConfigWith(newViperFromFile(writeConfigFile(t, tc.content)), "")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	anthropic "github.com/anthropics/anthropic-sdk-go"
+	anthropicopt "github.com/anthropics/anthropic-sdk-go/option"
+	openai "github.com/openai/openai-go"
+	openaiopt "github.com/openai/openai-go/option"
+)
+
+// Provider is implemented by every AI backend. The dispatch helpers
+// (chatCompletions, streamToWriter, validateAPIKey, getModelName, …) look the
+// active provider up in the registry instead of switching on its name, so a
+// new backend only needs a type that satisfies this interface and a call to
+// RegisterProvider from an init function in its own file.
+type Provider interface {
+	// Name is the identifier used by --provider and the provider config key.
+	Name() ApiProvider
+	// Call sends a buffered request and returns the complete response text.
+	Call(ctx context.Context, cfg *Config, systemPrompt, diffContent string) (string, error)
+	// Stream writes response tokens to w as they arrive and returns the full text.
+	Stream(ctx context.Context, cfg *Config, systemPrompt, diffContent string, w io.Writer) (string, error)
+	// Validate reports missing credentials or settings before any request is made.
+	Validate(cfg *Config) error
+	// Model returns the configured model name for this provider.
+	Model(cfg *Config) string
+	// SetModel applies a --model override to the provider's config field.
+	SetModel(cfg *Config, model string)
+	// TokenEstimator returns the estimator used by --debug and --estimate.
+	TokenEstimator(cfg *Config) TokenEstimator
+	// KnownModels lists the model names printed by the models subcommand.
+	KnownModels() []string
+	// Describe returns the label/value pairs printed by the check subcommand.
+	Describe(cfg *Config) []ProviderDetail
+}
+
+// pricingModeler is implemented by providers whose Model is not a priceable
+// model name (e.g. an Azure deployment). Cost estimation uses PricingModel
+// instead when it is available.
+type pricingModeler interface {
+	PricingModel(cfg *Config) string
+}
+
+// pricer is implemented by providers whose calls cost the same whatever the
+// model, such as Ollama, which runs models locally for free. Cost estimation
+// uses Price instead of the pricing table; [[pricing]] entries still win.
+type pricer interface {
+	Price() ModelPrice
+}
+
+// modelLister is implemented by providers that can list the models available
+// to the configured credentials. The models subcommand uses it for --live.
+type modelLister interface {
+	ListModels(ctx context.Context, cfg *Config) ([]string, error)
+}
+
+// ProviderDetail is a single "Label : Value" line printed by the check subcommand.
+type ProviderDetail struct {
+	Label string
+	Value string
+}
+
+// providerRegistry holds the registered providers in registration order so
+// that check --all and error messages list them deterministically.
+type providerRegistry struct {
+	mu     sync.RWMutex
+	byName map[ApiProvider]Provider
+	order  []ApiProvider
+}
+
+// providers is the process-wide registry. Built-in providers are added during
+// variable initialisation, which runs before any init function, so a provider
+// registered from init always wins over a built-in of the same name.
+var providers = newProviderRegistry(
+	openAIProvider{},
+	anthropicProvider{},
+	geminiProvider{},
+	ollamaProvider{},
+	claudeCLIProvider{},
+	geminiCLIProvider{},
+	codexCLIProvider{},
+)
+
+func newProviderRegistry(builtins ...Provider) *providerRegistry {
+	r := &providerRegistry{byName: map[ApiProvider]Provider{}}
+	for _, p := range builtins {
+		r.register(p)
+	}
+	return r
+}
+
+func (r *providerRegistry) register(p Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.byName[p.Name()]; !exists {
+		r.order = append(r.order, p.Name())
+	}
+	r.byName[p.Name()] = p
+}
+
+func (r *providerRegistry) lookup(name ApiProvider) (Provider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.byName[name]
+	return p, ok
+}
+
+func (r *providerRegistry) names() []ApiProvider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]ApiProvider(nil), r.order...)
+}
+
+// RegisterProvider adds p to the registry, replacing any provider with the same
+// name. Replacing keeps the original position in the listing order.
+func RegisterProvider(p Provider) {
+	providers.register(p)
+}
+
+// lookupProvider returns the registered provider for name.
+func lookupProvider(name ApiProvider) (Provider, bool) {
+	return providers.lookup(name)
+}
+
+// registeredProviders returns every registered provider name in registration order.
+func registeredProviders() []ApiProvider {
+	return providers.names()
+}
+
+// providerNameList returns the registered provider names as a comma-separated
+// string for use in error messages.
+func providerNameList() string {
+	names := registeredProviders()
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = string(n)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// binaryDetail reports the resolved CLI binary path, or why it was not found.
+func binaryDetail(binary string, err error) ProviderDetail {
+	if err != nil {
+		return ProviderDetail{Label: "Binary", Value: "(not found: " + err.Error() + ")"}
+	}
+	return ProviderDetail{Label: "Binary", Value: binary}
+}
+
+// --- OpenAI ---
+
+type openAIProvider struct{}
+
+func (openAIProvider) Name() ApiProvider { return OpenAI }
+
+func (openAIProvider) client(cfg *Config) openai.Client {
+	return openai.NewClient(
+		openaiopt.WithAPIKey(cfg.OpenAIAPIKey),
+		openaiopt.WithBaseURL(cfg.OpenAIEndpoint),
+		openaiopt.WithMaxRetries(0), // retried by withRetry
+	)
+}
+
+func (p openAIProvider) Call(ctx context.Context, cfg *Config, systemPrompt, diffContent string) (string, error) {
+	debugLog(cfg, "api: calling openai model=%s endpoint=%s mode=buffered", cfg.OpenAIModel, cfg.OpenAIEndpoint)
+	client := p.client(cfg)
+	return callOpenAI(ctx, &client, cfg, systemPrompt, diffContent)
+}
+
+func (p openAIProvider) Stream(ctx context.Context, cfg *Config, systemPrompt, diffContent string, w io.Writer) (string, error) {
+	debugLog(cfg, "api: calling openai model=%s endpoint=%s mode=stream", cfg.OpenAIModel, cfg.OpenAIEndpoint)
+	client := p.client(cfg)
+	return streamOpenAI(ctx, &client, cfg, systemPrompt, diffContent, w)
+}
+
+func (openAIProvider) Validate(cfg *Config) error {
+	if cfg.OpenAIAPIKey == "" {
+		return errors.New("no OpenAI API key found\n\nSet the OPENAI_API_KEY environment variable or add 'openai_api_key' to ~/.ai-mr-comment.toml")
+	}
+	return nil
+}
+
+func (openAIProvider) Model(cfg *Config) string              { return cfg.OpenAIModel }
+func (openAIProvider) SetModel(cfg *Config, model string)    { cfg.OpenAIModel = model }
+func (openAIProvider) TokenEstimator(*Config) TokenEstimator { return &HeuristicTokenEstimator{} }
+
+func (openAIProvider) KnownModels() []string {
+	return []string{"gpt-4.1", "gpt-4.1-mini", "gpt-4.1-nano", "o3", "o3-mini", "gpt-4o", "gpt-4o-mini"}
+}
+
+// ListModels queries GET /models on the configured endpoint.
+func (p openAIProvider) ListModels(ctx context.Context, cfg *Config) ([]string, error) {
+	client := p.client(cfg)
+	return listOpenAIModels(ctx, &client)
+}
+
+func (openAIProvider) Describe(cfg *Config) []ProviderDetail {
+	return []ProviderDetail{
+		{Label: "Endpoint", Value: cfg.OpenAIEndpoint},
+		{Label: "API key", Value: maskSecret(cfg.OpenAIAPIKey)},
+	}
+}
+
+// --- Anthropic ---
+
+type anthropicProvider struct{}
+
+func (anthropicProvider) Name() ApiProvider { return Anthropic }
+
+func (anthropicProvider) client(cfg *Config) anthropic.Client {
+	return anthropic.NewClient(
+		anthropicopt.WithAPIKey(cfg.AnthropicAPIKey),
+		anthropicopt.WithBaseURL(strings.TrimRight(cfg.AnthropicEndpoint, "/")+"/"),
+		anthropicopt.WithMaxRetries(0), // retried by withRetry
+	)
+}
+
+func (p anthropicProvider) Call(ctx context.Context, cfg *Config, systemPrompt, diffContent string) (string, error) {
+	debugLog(cfg, "api: calling anthropic model=%s endpoint=%s mode=buffered", cfg.AnthropicModel, cfg.AnthropicEndpoint)
+	client := p.client(cfg)
+	return callAnthropic(ctx, &client, cfg, systemPrompt, diffContent)
+}
+
+func (p anthropicProvider) Stream(ctx context.Context, cfg *Config, systemPrompt, diffContent string, w io.Writer) (string, error) {
+	debugLog(cfg, "api: calling anthropic model=%s endpoint=%s mode=stream", cfg.AnthropicModel, cfg.AnthropicEndpoint)
+	client := p.client(cfg)
+	return streamAnthropic(ctx, &client, cfg, systemPrompt, diffContent, w)
+}
+
+func (anthropicProvider) Validate(cfg *Config) error {
+	if cfg.AnthropicAPIKey == "" {
+		return errors.New("no Anthropic API key found\n\nSet the ANTHROPIC_API_KEY environment variable or add 'anthropic_api_key' to ~/.ai-mr-comment.toml")
+	}
+	return nil
+}
+
+func (anthropicProvider) Model(cfg *Config) string              { return cfg.AnthropicModel }
+func (anthropicProvider) SetModel(cfg *Config, model string)    { cfg.AnthropicModel = model }
+func (anthropicProvider) TokenEstimator(*Config) TokenEstimator { return &HeuristicTokenEstimator{} }
+
+func (anthropicProvider) KnownModels() []string {
+	return []string{
+		"claude-opus-4-6",
+		"claude-sonnet-4-6",
+		"claude-haiku-4-5-20251001",
+		"claude-opus-4-5-20251101",
+		"claude-sonnet-4-5-20250929",
+		"claude-sonnet-4-20250514",
+		"claude-3-7-sonnet-20250219",
+		"claude-3-haiku-20240307",
+	}
+}
+
+// ListModels queries the Anthropic models API.
+func (p anthropicProvider) ListModels(ctx context.Context, cfg *Config) ([]string, error) {
+	client := p.client(cfg)
+	iter := client.Models.ListAutoPaging(ctx, anthropic.ModelListParams{})
+	var models []string
+	for iter.Next() {
+		models = append(models, iter.Current().ID)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, enrichNetworkError(err)
+	}
+	return models, nil
+}
+
+func (anthropicProvider) Describe(cfg *Config) []ProviderDetail {
+	return []ProviderDetail{
+		{Label: "Endpoint", Value: cfg.AnthropicEndpoint},
+		{Label: "API key", Value: maskSecret(cfg.AnthropicAPIKey)},
+	}
+}
+
+// --- Gemini ---
+
+type geminiProvider struct{}
+
+func (geminiProvider) Name() ApiProvider { return Gemini }
+
+func (geminiProvider) Call(ctx context.Context, cfg *Config, systemPrompt, diffContent string) (string, error) {
+	debugLog(cfg, "api: calling gemini model=%s mode=buffered", cfg.GeminiModel)
+	return callGemini(ctx, cfg, systemPrompt, diffContent)
+}
+
+func (geminiProvider) Stream(ctx context.Context, cfg *Config, systemPrompt, diffContent string, w io.Writer) (string, error) {
+	debugLog(cfg, "api: calling gemini model=%s mode=stream", cfg.GeminiModel)
+	return streamGemini(ctx, cfg, systemPrompt, diffContent, w)
+}
+
+func (geminiProvider) Validate(cfg *Config) error {
+	if cfg.GeminiAPIKey == "" {
+		return errors.New("no Gemini API key found\n\nSet the GEMINI_API_KEY environment variable or add 'gemini_api_key' to ~/.ai-mr-comment.toml")
+	}
+	return nil
+}
+
+func (geminiProvider) Model(cfg *Config) string           { return cfg.GeminiModel }
+func (geminiProvider) SetModel(cfg *Config, model string) { cfg.GeminiModel = model }
+
+// TokenEstimator uses the Gemini countTokens API for exact counts.
+func (geminiProvider) TokenEstimator(cfg *Config) TokenEstimator {
+	return &GeminiTokenEstimator{APIKey: cfg.GeminiAPIKey}
+}
+
+func (geminiProvider) KnownModels() []string {
+	return []string{
+		"gemini-2.5-pro",
+		"gemini-2.5-flash",
+		"gemini-2.5-flash-lite",
+		"gemini-3-flash-preview",
+		"gemini-3-pro-preview",
+		"gemini-2.0-flash",
+	}
+}
+
+// ListModels queries Gemini ListModels, keeping only models that support
+// generateContent.
+func (geminiProvider) ListModels(ctx context.Context, cfg *Config) ([]string, error) {
+	return listGeminiModels(ctx, cfg)
+}
+
+func (geminiProvider) Describe(cfg *Config) []ProviderDetail {
+	return []ProviderDetail{{Label: "API key", Value: maskSecret(cfg.GeminiAPIKey)}}
+}
+
+// --- Ollama ---
+
+type ollamaProvider struct{}
+
+func (ollamaProvider) Name() ApiProvider { return Ollama }
+
+func (ollamaProvider) Call(ctx context.Context, cfg *Config, systemPrompt, diffContent string) (string, error) {
+	debugLog(cfg, "api: calling ollama model=%s endpoint=%s mode=buffered", cfg.OllamaModel, cfg.OllamaEndpoint)
+	return callOllama(ctx, cfg, systemPrompt, diffContent)
+}
+
+func (ollamaProvider) Stream(ctx context.Context, cfg *Config, systemPrompt, diffContent string, w io.Writer) (string, error) {
+	debugLog(cfg, "api: calling ollama model=%s endpoint=%s mode=stream", cfg.OllamaModel, cfg.OllamaEndpoint)
+	return streamOllama(ctx, cfg, systemPrompt, diffContent, w)
+}
+
+func (ollamaProvider) Validate(*Config) error                { return nil }
+func (ollamaProvider) Model(cfg *Config) string              { return cfg.OllamaModel }
+func (ollamaProvider) SetModel(cfg *Config, model string)    { cfg.OllamaModel = model }
+func (ollamaProvider) TokenEstimator(*Config) TokenEstimator { return &HeuristicTokenEstimator{} }
+
+// Price is zero: Ollama models run locally.
+func (ollamaProvider) Price() ModelPrice { return ModelPrice{} }
+
+func (ollamaProvider) KnownModels() []string {
+	return []string{"llama3", "llama3.1", "llama3.2", "mistral", "codellama", "phi3"}
+}
+
+// ListModels queries /api/tags on the Ollama server for locally pulled models.
+func (ollamaProvider) ListModels(ctx context.Context, cfg *Config) ([]string, error) {
+	return listOllamaModels(ctx, cfg)
+}
+
+func (ollamaProvider) Describe(cfg *Config) []ProviderDetail {
+	return []ProviderDetail{{Label: "Endpoint", Value: cfg.OllamaEndpoint}}
+}
+
+// --- Claude CLI ---
+
+type claudeCLIProvider struct{}
+
+func (claudeCLIProvider) Name() ApiProvider { return ClaudeCLI }
+
+func (claudeCLIProvider) Call(ctx context.Context, cfg *Config, systemPrompt, diffContent string) (string, error) {
+	debugLog(cfg, "api: calling claude-cli model=%s mode=buffered", cfg.ClaudeCLIModel)
+	return callClaudeCLI(ctx, cfg, systemPrompt, diffContent)
+}
+
+func (claudeCLIProvider) Stream(ctx context.Context, cfg *Config, systemPrompt, diffContent string, w io.Writer) (string, error) {
+	debugLog(cfg, "api: calling claude-cli model=%s mode=stream", cfg.ClaudeCLIModel)
+	return streamClaudeCLI(ctx, cfg, systemPrompt, diffContent, w)
+}
+
+// Validate is a no-op: auth is delegated to the claude CLI process.
+func (claudeCLIProvider) Validate(*Config) error                { return nil }
+func (claudeCLIProvider) Model(cfg *Config) string              { return cfg.ClaudeCLIModel }
+func (claudeCLIProvider) SetModel(cfg *Config, model string)    { cfg.ClaudeCLIModel = model }
+func (claudeCLIProvider) TokenEstimator(*Config) TokenEstimator { return &HeuristicTokenEstimator{} }
+
+func (claudeCLIProvider) KnownModels() []string {
+	return []string{"claude-opus-4-6", "claude-sonnet-4-6", "claude-haiku-4-5-20251001"}
+}
+
+func (claudeCLIProvider) Describe(cfg *Config) []ProviderDetail {
+	return []ProviderDetail{binaryDetail(findClaudeBinary(cfg))}
+}
+
+// --- Gemini CLI ---
+
+type geminiCLIProvider struct{}
+
+func (geminiCLIProvider) Name() ApiProvider { return GeminiCLI }
+
+func (geminiCLIProvider) Call(ctx context.Context, cfg *Config, systemPrompt, diffContent string) (string, error) {
+	debugLog(cfg, "api: calling gemini-cli model=%s mode=buffered", cfg.GeminiCLIModel)
+	return callGeminiCLI(ctx, cfg, systemPrompt, diffContent)
+}
+
+func (geminiCLIProvider) Stream(ctx context.Context, cfg *Config, systemPrompt, diffContent string, w io.Writer) (string, error) {
+	debugLog(cfg, "api: calling gemini-cli model=%s mode=stream", cfg.GeminiCLIModel)
+	return streamGeminiCLI(ctx, cfg, systemPrompt, diffContent, w)
+}
+
+// Validate is a no-op: auth is delegated to the gemini CLI process.
+func (geminiCLIProvider) Validate(*Config) error                { return nil }
+func (geminiCLIProvider) Model(cfg *Config) string              { return cfg.GeminiCLIModel }
+func (geminiCLIProvider) SetModel(cfg *Config, model string)    { cfg.GeminiCLIModel = model }
+func (geminiCLIProvider) TokenEstimator(*Config) TokenEstimator { return &HeuristicTokenEstimator{} }
+
+func (geminiCLIProvider) KnownModels() []string {
+	return []string{"gemini-2.5-pro", "gemini-2.5-flash", "gemini-2.5-flash-lite"}
+}
+
+func (geminiCLIProvider) Describe(cfg *Config) []ProviderDetail {
+	return []ProviderDetail{binaryDetail(findGeminiCLIBinary(cfg))}
+}
+
+// --- Codex CLI ---
+
+type codexCLIProvider struct{}
+
+func (codexCLIProvider) Name() ApiProvider { return CodexCLI }
+
+func (codexCLIProvider) Call(ctx context.Context, cfg *Config, systemPrompt, diffContent string) (string, error) {
+	debugLog(cfg, "api: calling codex-cli model=%s mode=buffered", cfg.CodexCLIModel)
+	return callCodexCLI(ctx, cfg, systemPrompt, diffContent)
+}
+
+func (codexCLIProvider) Stream(ctx context.Context, cfg *Config, systemPrompt, diffContent string, w io.Writer) (string, error) {
+	debugLog(cfg, "api: calling codex-cli model=%s mode=stream", cfg.CodexCLIModel)
+	return streamCodexCLI(ctx, cfg, systemPrompt, diffContent, w)
+}
+
+// Validate is a no-op: auth is delegated to the codex CLI process.
+func (codexCLIProvider) Validate(*Config) error                { return nil }
+func (codexCLIProvider) Model(cfg *Config) string              { return cfg.CodexCLIModel }
+func (codexCLIProvider) SetModel(cfg *Config, model string)    { cfg.CodexCLIModel = model }
+func (codexCLIProvider) TokenEstimator(*Config) TokenEstimator { return &HeuristicTokenEstimator{} }
+
+// KnownModels is empty: model names depend on what is configured in the local
+// codex CLI. Leave codex_cli_model empty to use the codex default.
+func (codexCLIProvider) KnownModels() []string { return nil }
+
+func (codexCLIProvider) Describe(cfg *Config) []ProviderDetail {
+	return []ProviderDetail{binaryDetail(findCodexBinary(cfg))}
+}
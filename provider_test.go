@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fakeProvider is a minimal Provider used to exercise the registry.
+type fakeProvider struct {
+	name  ApiProvider
+	reply string
+	model string
+}
+
+func (f *fakeProvider) Name() ApiProvider { return f.name }
+func (f *fakeProvider) Call(_ context.Context, _ *Config, _, _ string) (string, error) {
+	return f.reply, nil
+}
+func (f *fakeProvider) Stream(_ context.Context, _ *Config, _, _ string, w io.Writer) (string, error) {
+	_, _ = io.WriteString(w, f.reply)
+	return f.reply, nil
+}
+func (f *fakeProvider) Validate(cfg *Config) error {
+	if cfg.Template == "reject" {
+		return errors.New("fake provider rejected config")
+	}
+	return nil
+}
+func (f *fakeProvider) Model(*Config) string                  { return f.model }
+func (f *fakeProvider) SetModel(_ *Config, model string)      { f.model = model }
+func (f *fakeProvider) TokenEstimator(*Config) TokenEstimator { return &HeuristicTokenEstimator{} }
+func (f *fakeProvider) KnownModels() []string                 { return []string{"fake-small", "fake-large"} }
+func (f *fakeProvider) Describe(*Config) []ProviderDetail {
+	return []ProviderDetail{{Label: "Gateway", Value: "fake"}}
+}
+
+// withTestRegistry swaps the process-wide registry for the duration of a test.
+func withTestRegistry(t *testing.T, extra ...Provider) {
+	t.Helper()
+	orig := providers
+	providers = newProviderRegistry(orig.byNameInOrder()...)
+	for _, p := range extra {
+		RegisterProvider(p)
+	}
+	t.Cleanup(func() { providers = orig })
+}
+
+func (r *providerRegistry) byNameInOrder() []Provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Provider, 0, len(r.order))
+	for _, n := range r.order {
+		out = append(out, r.byName[n])
+	}
+	return out
+}
+
+func TestRegistry_BuiltinsRegisteredInOrder(t *testing.T) {
+	want := []ApiProvider{OpenAI, Anthropic, Gemini, Ollama, ClaudeCLI, GeminiCLI, CodexCLI}
+	got := registeredProviders()
+	if len(got) < len(want) {
+		t.Fatalf("expected at least %d providers, got %v", len(want), got)
+	}
+	for i, p := range want {
+		if got[i] != p {
+			t.Errorf("position %d: expected %s, got %s", i, p, got[i])
+		}
+	}
+}
+
+func TestRegistry_CustomProviderDispatch(t *testing.T) {
+	fake := &fakeProvider{name: "gateway", reply: "from gateway", model: "fake-small"}
+	withTestRegistry(t, fake)
+
+	cfg := &Config{Provider: "gateway"}
+	if err := validateProviderConfig(cfg); err != nil {
+		t.Fatalf("expected custom provider to validate, got %v", err)
+	}
+	got, err := chatCompletions(context.Background(), cfg, "gateway", "prompt", "diff")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "from gateway" {
+		t.Errorf("expected 'from gateway', got %q", got)
+	}
+
+	var sb strings.Builder
+	streamed, err := streamToWriter(context.Background(), cfg, "gateway", "prompt", "diff", &sb)
+	if err != nil || streamed != "from gateway" || sb.String() != "from gateway" {
+		t.Errorf("unexpected stream result %q / %q / %v", streamed, sb.String(), err)
+	}
+
+	setModelOverride(cfg, "fake-large")
+	if getModelName(cfg) != "fake-large" {
+		t.Errorf("expected model override to reach custom provider, got %q", getModelName(cfg))
+	}
+}
+
+func TestRegistry_CustomProviderValidateError(t *testing.T) {
+	withTestRegistry(t, &fakeProvider{name: "gateway"})
+	cfg := &Config{Provider: "gateway", Template: "reject"}
+	_, err := chatCompletions(context.Background(), cfg, "gateway", "p", "d")
+	if err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("expected validation error, got %v", err)
+	}
+}
+
+func TestRegistry_ReplaceKeepsOrder(t *testing.T) {
+	withTestRegistry(t, &fakeProvider{name: Anthropic, reply: "swapped"})
+
+	names := registeredProviders()
+	if names[1] != Anthropic {
+		t.Errorf("expected replaced provider to keep its position, got %v", names)
+	}
+	got, err := chatCompletions(context.Background(), &Config{}, Anthropic, "p", "d")
+	if err != nil || got != "swapped" {
+		t.Errorf("expected swapped provider to answer, got %q / %v", got, err)
+	}
+}
+
+func TestRegistry_CustomProviderInModelsAndCheck(t *testing.T) {
+	withTestRegistry(t, &fakeProvider{name: "gateway", reply: "OK", model: "fake-small"})
+
+	var buf strings.Builder
+	cmd := newRootCmd(dummyChatFn)
+	cmd.SetArgs([]string{"models", "--provider=gateway"})
+	cmd.SetOut(&buf)
+	cmd.SetErr(io.Discard)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("models: unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "fake-large") {
+		t.Errorf("expected custom models in output, got:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	cmd = newRootCmd(chatCompletions)
+	cmd.SetArgs([]string{"check", "--provider=gateway"})
+	cmd.SetOut(&buf)
+	cmd.SetErr(io.Discard)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("check: unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Gateway  : fake") {
+		t.Errorf("expected Describe output in check, got:\n%s", buf.String())
+	}
+}
+
+func TestModelsCmd_InvalidProviderListsRegistered(t *testing.T) {
+	withTestRegistry(t, &fakeProvider{name: "gateway"})
+	cmd := newRootCmd(dummyChatFn)
+	cmd.SetArgs([]string{"models", "--provider=nope"})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "gateway") {
+		t.Errorf("expected error listing registered providers, got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redaction modes for the redaction config key.
+const (
+	redactionRedact = "redact" // replace detected secrets (default)
+	redactionAbort  = "abort"  // refuse to send a diff that contains secrets
+	redactionOff    = "off"    // send the diff unchanged
+)
+
+// secretDetector finds one kind of credential. When the pattern has a
+// capture group only the group is replaced, so "password = hunter22" keeps
+// its key and loses its value.
+type secretDetector struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// builtinSecretDetectors cover common credential formats. They run before
+// the entropy check, in order, over the whole diff.
+var builtinSecretDetectors = []secretDetector{
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`)},
+	{"aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA)[0-9A-Z]{16}\b`)},
+	{"aws-secret-key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`)},
+	{"github-token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"gitlab-token", regexp.MustCompile(`\bgl(?:pat|dt|ptt|rt)-[A-Za-z0-9_-]{20,}`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"openai-key", regexp.MustCompile(`\bsk-(?:proj-|ant-)?[A-Za-z0-9_-]{20,}`)},
+	{"google-api-key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{"url-credentials", regexp.MustCompile(`[a-z][a-z0-9+.-]*://[^/\s:@]+:([^/\s:@]{3,})@`)},
+	{"secret-assignment", regexp.MustCompile(`(?i)(?:password|passwd|pwd|secret|token|api_?key|access_?key|client_?secret)["']?\s*[:=]\s*["']([^"'\s]{8,})["']`)},
+}
+
+// highEntropyToken matches candidates for the entropy check: long runs of
+// base64 or hex characters.
+var highEntropyToken = regexp.MustCompile(`[A-Za-z0-9+/_=-]{32,}`)
+
+// minSecretEntropy is the Shannon entropy, in bits per character, above which
+// a token is treated as random. Random base64 scores about 5; English words,
+// identifiers and hex digests (at most 4) stay below it.
+const minSecretEntropy = 4.5
+
+// shannonEntropy returns the entropy of s in bits per character.
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	var h float64
+	n := float64(len(s))
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
+
+// isHighEntropySecret reports whether tok looks like a random credential: high
+// entropy and a mix of letters and digits.
+func isHighEntropySecret(tok string) bool {
+	if !strings.ContainsAny(tok, "0123456789") || strings.IndexFunc(tok, func(r rune) bool { return r >= 'A' && r <= 'z' }) < 0 {
+		return false
+	}
+	return shannonEntropy(tok) >= minSecretEntropy
+}
+
+// redactionReport counts the secrets replaced in a diff. It is reported as
+// "redactions" in the JSON output.
+type redactionReport struct {
+	Count  int            `json:"count"`
+	ByType map[string]int `json:"by_type"`
+}
+
+func (r *redactionReport) add(kind string) {
+	if r.ByType == nil {
+		r.ByType = map[string]int{}
+	}
+	r.Count++
+	r.ByType[kind]++
+}
+
+// summary lists the detector counts, e.g. "github-token=1, private-key=2".
+func (r *redactionReport) summary() string {
+	kinds := make([]string, 0, len(r.ByType))
+	for k := range r.ByType {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, len(kinds))
+	for i, k := range kinds {
+		parts[i] = fmt.Sprintf("%s=%d", k, r.ByType[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// compileRedactionPatterns validates the redaction mode and compiles the
+// custom redaction_patterns into detectors.
+func compileRedactionPatterns(cfg *Config) error {
+	switch cfg.Redaction {
+	case redactionRedact, redactionAbort, redactionOff:
+	default:
+		return fmt.Errorf("invalid redaction %q: must be redact, abort or off", cfg.Redaction)
+	}
+	cfg.redactors = nil
+	for _, p := range cfg.RedactionPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("invalid redaction_patterns entry %q: %w", p, err)
+		}
+		cfg.redactors = append(cfg.redactors, secretDetector{name: "custom", re: re})
+	}
+	return nil
+}
+
+// redactSecrets replaces every detected secret in diff with
+// "[REDACTED:<kind>]". Diff metadata lines (headers, index hashes) are left
+// alone by the entropy check.
+func redactSecrets(diff string, custom []secretDetector) (string, *redactionReport) {
+	report := &redactionReport{}
+	for _, d := range append(append([]secretDetector(nil), builtinSecretDetectors...), custom...) {
+		diff = replaceDetected(diff, d, report)
+	}
+
+	lines := strings.Split(diff, "\n")
+	for i, line := range lines {
+		if isDiffMetadataLine(line) {
+			continue
+		}
+		lines[i] = highEntropyToken.ReplaceAllStringFunc(line, func(tok string) string {
+			if !isHighEntropySecret(tok) {
+				return tok
+			}
+			report.add("high-entropy")
+			return "[REDACTED:high-entropy]"
+		})
+	}
+	return strings.Join(lines, "\n"), report
+}
+
+// replaceDetected replaces d's matches in s, or only the first capture group
+// when the pattern has one.
+func replaceDetected(s string, d secretDetector, report *redactionReport) string {
+	matches := d.re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) >= 4 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		sb.WriteString(s[last:start])
+		sb.WriteString("[REDACTED:" + d.name + "]")
+		last = end
+		report.add(d.name)
+	}
+	sb.WriteString(s[last:])
+	return sb.String()
+}
+
+// isDiffMetadataLine reports whether line is a git diff header rather than
+// file content.
+func isDiffMetadataLine(line string) bool {
+	for _, prefix := range []string{"diff --git ", "index ", "--- ", "+++ ", "@@ ", "similarity index", "rename from", "rename to", "new file mode", "deleted file mode", "old mode", "new mode"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRedaction runs the configured redaction over diff before it is sent to
+// a provider. In abort mode a diff containing secrets is refused with exit
+// code 6. The report is nil when redaction is off or nothing was found.
+func applyRedaction(cfg *Config, diff string) (string, *redactionReport, error) {
+	if cfg.Redaction == redactionOff {
+		return diff, nil, nil
+	}
+	redacted, report := redactSecrets(diff, cfg.redactors)
+	if report.Count == 0 {
+		return diff, nil, nil
+	}
+	if cfg.Redaction == redactionAbort {
+		return "", nil, withExitCode(6, fmt.Errorf(
+			"the diff contains %d possible secret(s) (%s); nothing was sent\n\nRemove them, add the file to .aimrignore, or set redaction = \"redact\" to send the diff with secrets replaced",
+			report.Count, report.summary()))
+	}
+	debugLog(cfg, "redaction: replaced %d secret(s): %s", report.Count, report.summary())
+	return redacted, report, nil
+}
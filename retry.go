@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	anthropic "github.com/anthropics/anthropic-sdk-go"
+	openai "github.com/openai/openai-go"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBackoff     = time.Second
+	defaultRetryMaxBackoff  = 30 * time.Second
+)
+
+// httpStatusError is returned by providers that call HTTP APIs directly
+// (Ollama) so the retry layer can see the status code and headers. Error
+// returns the same message the provider produced before it was typed.
+type httpStatusError struct {
+	StatusCode int
+	Header     http.Header
+	msg        string
+}
+
+func (e *httpStatusError) Error() string { return e.msg }
+
+// retryDecision is the outcome of classifying a failed call.
+type retryDecision struct {
+	retry      bool
+	retryAfter time.Duration // server-requested delay; 0 when absent
+}
+
+// classifyRetry reports whether err is transient and, if the server sent a
+// Retry-After (or retry-after-ms) header, how long it asked us to wait.
+// Auth failures and other client errors are never retried.
+func classifyRetry(err error) retryDecision {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return retryDecision{}
+	}
+	var final *noRetryError
+	if errors.As(err, &final) {
+		return retryDecision{}
+	}
+
+	status, header := errorStatus(err)
+	if status != 0 {
+		if !retryableStatus(status) {
+			return retryDecision{}
+		}
+		return retryDecision{retry: true, retryAfter: parseRetryAfter(header, time.Now())}
+	}
+
+	// No HTTP status: retry only connection-level failures that are likely
+	// to succeed on a second attempt. DNS failures and refused connections
+	// mean the host is wrong or down and are reported immediately.
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return retryDecision{retry: true}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return retryDecision{retry: true}
+	}
+	return retryDecision{}
+}
+
+// errorStatus extracts the HTTP status code and response headers from the
+// error types returned by each provider SDK. It returns 0 for errors that did
+// not come from an HTTP response.
+func errorStatus(err error) (int, http.Header) {
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return openaiErr.StatusCode, responseHeader(openaiErr.Response)
+	}
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.StatusCode, responseHeader(anthropicErr.Response)
+	}
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return googleErr.Code, googleErr.Header
+	}
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode, statusErr.Header
+	}
+	return 0, nil
+}
+
+func responseHeader(resp *http.Response) http.Header {
+	if resp == nil {
+		return nil
+	}
+	return resp.Header
+}
+
+// retryableStatus reports whether an HTTP status indicates a transient
+// failure: request timeout, conflict, rate limiting, and server errors
+// (including Anthropic's 529 "overloaded").
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return true
+	}
+	return status >= 500
+}
+
+// parseRetryAfter returns the delay requested by the retry-after-ms or
+// Retry-After header. Retry-After may be delay-seconds or an HTTP date.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if header == nil {
+		return 0
+	}
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	raw := header.Get("Retry-After")
+	if raw == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(raw, 64); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs * float64(time.Second))
+	}
+	if at, err := http.ParseTime(raw); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// retryBackoff returns the delay before the given retry (1 for the first
+// retry). It doubles from cfg.RetryBackoff with equal jitter, uses the
+// server's Retry-After when that is longer, and never exceeds
+// cfg.RetryMaxBackoff.
+func retryBackoff(cfg *Config, retry int, retryAfter time.Duration) time.Duration {
+	base, ceiling := cfg.RetryBackoff, cfg.RetryMaxBackoff
+	if base <= 0 {
+		base = defaultRetryBackoff
+	}
+	if ceiling <= 0 {
+		ceiling = defaultRetryMaxBackoff
+	}
+	d := base
+	for i := 1; i < retry && d < ceiling; i++ {
+		d *= 2
+	}
+	if d > ceiling {
+		d = ceiling
+	}
+	d = d/2 + rand.N(d/2+1)
+	if retryAfter > d {
+		d = retryAfter
+	}
+	if d > ceiling {
+		d = ceiling
+	}
+	return d
+}
+
+// retrySleep waits for d or until ctx is done. It is a variable so tests can
+// skip real sleeps.
+var retrySleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// withRetry calls fn until it succeeds, fails with a non-transient error, or
+// cfg.RetryMaxAttempts attempts have been made. Every failed attempt is
+// logged through debugLog with the delay before the next one.
+func withRetry(ctx context.Context, cfg *Config, label string, fn func() (string, error)) (string, error) {
+	attempts := cfg.RetryMaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		result, err := fn()
+		if err == nil {
+			if attempt > 1 {
+				debugLog(cfg, "retry: %s succeeded on attempt %d/%d", label, attempt, attempts)
+			}
+			return result, nil
+		}
+		decision := classifyRetry(err)
+		if !decision.retry || attempt >= attempts || ctx.Err() != nil {
+			if decision.retry && attempt > 1 {
+				return "", fmt.Errorf("%w\n\nGave up after %d attempts", err, attempt)
+			}
+			return "", err
+		}
+		delay := retryBackoff(cfg, attempt, decision.retryAfter)
+		debugLog(cfg, "retry: %s attempt %d/%d failed: %v — retrying in %s", label, attempt, attempts, firstLine(err.Error()), delay.Round(time.Millisecond))
+		if sleepErr := retrySleep(ctx, delay); sleepErr != nil {
+			return "", err
+		}
+	}
+}
+
+// noRetryError marks an otherwise transient error as final, e.g. a stream
+// that failed after part of the response was already written.
+type noRetryError struct{ err error }
+
+func (e *noRetryError) Error() string { return e.err.Error() }
+func (e *noRetryError) Unwrap() error { return e.err }
+
+// countingWriter records whether anything has been written so a failed
+// stream is only retried when no partial output reached the user.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
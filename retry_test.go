@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// stubRetrySleep replaces retrySleep with a recorder so tests never wait.
+func stubRetrySleep(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var delays []time.Duration
+	orig := retrySleep
+	retrySleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	t.Cleanup(func() { retrySleep = orig })
+	return &delays
+}
+
+func writeOpenAIChatResponse(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"id": "1", "object": "chat.completion", "created": 0, "model": "test",
+		"choices": []map[string]any{
+			{"index": 0, "message": map[string]string{"role": "assistant", "content": content}, "finish_reason": "stop"},
+		},
+	})
+}
+
+func retryTestConfig(endpoint string) *Config {
+	return &Config{
+		OpenAIAPIKey:     "test",
+		OpenAIModel:      "gpt-4o-mini",
+		OpenAIEndpoint:   endpoint,
+		RetryMaxAttempts: 3,
+		RetryBackoff:     100 * time.Millisecond,
+		RetryMaxBackoff:  10 * time.Second,
+	}
+}
+
+func TestChatCompletions_RetriesRateLimitWithRetryAfter(t *testing.T) {
+	delays := stubRetrySleep(t)
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":{"message":"slow down"}}`))
+			return
+		}
+		writeOpenAIChatResponse(w, "after retry")
+	}))
+	defer ts.Close()
+
+	var log strings.Builder
+	cfg := retryTestConfig(ts.URL + "/v1/")
+	cfg.DebugWriter = &log
+	got, err := chatCompletions(context.Background(), cfg, OpenAI, "p", "d")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "after retry" {
+		t.Errorf("expected 'after retry', got %q", got)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 calls, got %d", calls.Load())
+	}
+	if len(*delays) != 1 || (*delays)[0] != 2*time.Second {
+		t.Errorf("expected one 2s delay from Retry-After, got %v", *delays)
+	}
+	if !strings.Contains(log.String(), "attempt 1/3 failed") || !strings.Contains(log.String(), "succeeded on attempt 2/3") {
+		t.Errorf("expected retry attempts in debug log, got:\n%s", log.String())
+	}
+}
+
+func TestChatCompletions_DoesNotRetryAuthErrors(t *testing.T) {
+	delays := stubRetrySleep(t)
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":{"message":"bad key"}}`))
+	}))
+	defer ts.Close()
+
+	_, err := chatCompletions(context.Background(), retryTestConfig(ts.URL+"/v1/"), OpenAI, "p", "d")
+	if err == nil || !strings.Contains(err.Error(), "invalid or lacks permission") {
+		t.Fatalf("expected auth error, got %v", err)
+	}
+	if calls.Load() != 1 || len(*delays) != 0 {
+		t.Errorf("expected a single attempt without sleeping, got %d calls / %v", calls.Load(), *delays)
+	}
+}
+
+func TestChatCompletions_GivesUpAfterMaxAttempts(t *testing.T) {
+	delays := stubRetrySleep(t)
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"error":{"message":"overloaded"}}`))
+	}))
+	defer ts.Close()
+
+	_, err := chatCompletions(context.Background(), retryTestConfig(ts.URL+"/v1/"), OpenAI, "p", "d")
+	if err == nil || !strings.Contains(err.Error(), "Gave up after 3 attempts") {
+		t.Fatalf("expected give-up error, got %v", err)
+	}
+	if calls.Load() != 3 || len(*delays) != 2 {
+		t.Errorf("expected 3 calls and 2 sleeps, got %d / %v", calls.Load(), *delays)
+	}
+}
+
+func TestChatCompletions_RetriesOllamaServerError(t *testing.T) {
+	stubRetrySleep(t)
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error":"model loading"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"response":"ollama ok"}`))
+	}))
+	defer ts.Close()
+
+	cfg := &Config{OllamaModel: "llama3.2", OllamaEndpoint: ts.URL, RetryMaxAttempts: 2}
+	got, err := chatCompletions(context.Background(), cfg, Ollama, "p", "d")
+	if err != nil || got != "ollama ok" {
+		t.Fatalf("expected retry to succeed, got %q / %v", got, err)
+	}
+}
+
+func TestChatCompletions_ZeroAttemptsMeansSingleCall(t *testing.T) {
+	stubRetrySleep(t)
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	cfg := &Config{OllamaModel: "llama3.2", OllamaEndpoint: ts.URL}
+	if _, err := chatCompletions(context.Background(), cfg, Ollama, "p", "d"); err == nil {
+		t.Fatal("expected error")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call, got %d", calls.Load())
+	}
+}
+
+func TestStreamToWriter_RetriesOnlyBeforeOutput(t *testing.T) {
+	stubRetrySleep(t)
+
+	// Fails before writing anything: retried.
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"response":"streamed","done":true}` + "\n"))
+	}))
+	defer ts.Close()
+
+	cfg := &Config{OllamaModel: "llama3.2", OllamaEndpoint: ts.URL, RetryMaxAttempts: 3}
+	var buf strings.Builder
+	got, err := streamToWriter(context.Background(), cfg, Ollama, "p", "d", &buf)
+	if err != nil || got != "streamed" || buf.String() != "streamed" {
+		t.Fatalf("expected retried stream, got %q / %q / %v", got, buf.String(), err)
+	}
+
+	// Fails after partial output: not retried, so the user never sees
+	// duplicated text.
+	calls.Store(0)
+	partial := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"response":"half"}` + "\n" + `not json` + "\n"))
+	}))
+	defer partial.Close()
+
+	cfg.OllamaEndpoint = partial.URL
+	buf.Reset()
+	if _, err := streamToWriter(context.Background(), cfg, Ollama, "p", "d", &buf); err == nil {
+		t.Fatal("expected stream error")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected no retry after partial output, got %d calls", calls.Load())
+	}
+}
+
+func TestClassifyRetry(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		retry bool
+	}{
+		{"rate limit", &httpStatusError{StatusCode: 429}, true},
+		{"anthropic overloaded", &httpStatusError{StatusCode: 529}, true},
+		{"server error", &httpStatusError{StatusCode: 500}, true},
+		{"unauthorized", &httpStatusError{StatusCode: 401}, false},
+		{"forbidden", &httpStatusError{StatusCode: 403}, false},
+		{"bad request", &httpStatusError{StatusCode: 400}, false},
+		{"wrapped", fmt.Errorf("call: %w", &httpStatusError{StatusCode: 503}), true},
+		{"canceled", context.Canceled, false},
+		{"plain error", errors.New("boom"), false},
+		{"marked final", &noRetryError{err: &httpStatusError{StatusCode: 503}}, false},
+	}
+	for _, tt := range tests {
+		if got := classifyRetry(tt.err).retry; got != tt.retry {
+			t.Errorf("%s: expected retry=%v, got %v", tt.name, tt.retry, got)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"nil", nil, 0},
+		{"seconds", http.Header{"Retry-After": {"5"}}, 5 * time.Second},
+		{"milliseconds", http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"5"}}, 250 * time.Millisecond},
+		{"http date", http.Header{"Retry-After": {now.Add(3 * time.Second).Format(http.TimeFormat)}}, 3 * time.Second},
+		{"past date", http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}}, 0},
+		{"garbage", http.Header{"Retry-After": {"soon"}}, 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRetryBackoff_Bounds(t *testing.T) {
+	cfg := &Config{RetryBackoff: time.Second, RetryMaxBackoff: 5 * time.Second}
+	for i := 0; i < 50; i++ {
+		if d := retryBackoff(cfg, 1, 0); d < 500*time.Millisecond || d > time.Second {
+			t.Fatalf("retry 1: delay %v outside [500ms, 1s]", d)
+		}
+		if d := retryBackoff(cfg, 3, 0); d < 2*time.Second || d > 4*time.Second {
+			t.Fatalf("retry 3: delay %v outside [2s, 4s]", d)
+		}
+		if d := retryBackoff(cfg, 10, 0); d > 5*time.Second {
+			t.Fatalf("retry 10: delay %v exceeds max backoff", d)
+		}
+	}
+	if d := retryBackoff(cfg, 1, time.Minute); d != 5*time.Second {
+		t.Errorf("expected Retry-After to be capped at max backoff, got %v", d)
+	}
+}
+
+func TestLoadConfig_RetryDefaultsAndEnv(t *testing.T) {
+	cfg, err := loadConfigWith(newViperFromFile(writeConfigFile(t, `provider = "openai"`)), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.RetryMaxAttempts != 3 || cfg.RetryBackoff != time.Second || cfg.RetryMaxBackoff != 30*time.Second {
+		t.Errorf("unexpected retry defaults: %d / %v / %v", cfg.RetryMaxAttempts, cfg.RetryBackoff, cfg.RetryMaxBackoff)
+	}
+
+	t.Setenv("AI_MR_COMMENT_RETRY_MAX_ATTEMPTS", "5")
+	v := viper.New()
+	v.SetConfigFile(writeConfigFile(t, `retry_backoff = "250ms"`))
+	v.SetConfigType("toml")
+	v.AutomaticEnv()
+	v.SetEnvPrefix("AI_MR_COMMENT")
+	cfg, err = loadConfigWith(v, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.RetryMaxAttempts != 5 {
+		t.Errorf("expected retry_max_attempts from env, got %d", cfg.RetryMaxAttempts)
+	}
+	if cfg.RetryBackoff != 250*time.Millisecond {
+		t.Errorf("expected retry_backoff 250ms, got %v", cfg.RetryBackoff)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/term"
+)
+
+// --smart-chunk is a map-reduce over the diff. The map step packs files into
+// batches of about smart_chunk_batch_tokens and summarises each batch; a file
+// too large for one batch is split between hunks. The reduce step merges the
+// summaries in tiers until they fit next to the system prompt for the final
+// call. At most smart_chunk_workers calls run at once. With
+// smart_chunk_partial a failed batch is marked as not summarised instead of
+// failing the run.
+const (
+	smartChunkPrompt  = "Summarize the changes in this diff in 3-5 bullet points per file. Be concise and technical."
+	smartReducePrompt = "These are summaries of consecutive parts of one change. Merge them into a single summary that keeps every distinct change, grouped by area, as concise technical bullet points."
+
+	defaultSmartChunkWorkers     = 4
+	defaultSmartChunkBatchTokens = 12_000
+)
+
+// chunkSeparator joins summaries in the reduce and synthesis inputs.
+const chunkSeparator = "\n\n---\n\n"
+
+// roughTokens estimates text with the character heuristic. Planning counts
+// every file, so it never uses the provider's estimator.
+func roughTokens(ctx context.Context, text string) int {
+	n, _ := (&HeuristicTokenEstimator{}).CountTokens(ctx, "", text)
+	return int(n)
+}
+
+// splitFileByHunks cuts f into pieces of at most maxTokens, each with the
+// file's header and whole hunks. A single hunk larger than maxTokens is its
+// own piece and is trimmed when it is sent.
+func splitFileByHunks(ctx context.Context, f *diffFile, maxTokens int) []string {
+	header := strings.Join(f.Header, "\n") + "\n"
+	var pieces []string
+	var sb strings.Builder
+	tokens := 0
+	for _, h := range f.Hunks {
+		hunk := (&diffFile{Hunks: []*diffHunk{h}}).String()
+		n := roughTokens(ctx, hunk)
+		if sb.Len() > 0 && tokens+n > maxTokens {
+			pieces = append(pieces, sb.String())
+			sb.Reset()
+		}
+		if sb.Len() == 0 {
+			sb.WriteString(header)
+			tokens = roughTokens(ctx, header)
+		}
+		sb.WriteString(hunk)
+		tokens += n
+	}
+	if sb.Len() > 0 {
+		pieces = append(pieces, sb.String())
+	}
+	if len(pieces) == 0 {
+		return []string{f.String()}
+	}
+	return pieces
+}
+
+// planSmartChunks packs d's files, in order, into batches of at most maxTokens,
+// splitting files that do not fit in a batch of their own. It returns the
+// batches and how many files were split.
+func planSmartChunks(ctx context.Context, d *parsedDiff, maxTokens int) (batches []string, split int) {
+	var sb strings.Builder
+	tokens := 0
+	flush := func() {
+		if sb.Len() > 0 {
+			batches = append(batches, sb.String())
+			sb.Reset()
+			tokens = 0
+		}
+	}
+	for _, f := range d.Files {
+		text := f.String()
+		n := roughTokens(ctx, text)
+		if n > maxTokens && len(f.Hunks) > 1 {
+			flush()
+			batches = append(batches, splitFileByHunks(ctx, f, maxTokens)...)
+			split++
+			continue
+		}
+		if tokens+n > maxTokens {
+			flush()
+		}
+		sb.WriteString(text)
+		tokens += n
+	}
+	flush()
+	return batches, split
+}
+
+// smartChunker runs one --smart-chunk map-reduce.
+type smartChunker struct {
+	cfg    *Config
+	chatFn func(context.Context, *Config, ApiProvider, string, string) (string, error)
+	// progress shows calls done/total on a terminal; nil shows nothing.
+	progress *chunkProgress
+}
+
+// mapChunks calls chatFn with prompt on every input, at most
+// smart_chunk_workers at a time, and returns the responses in input order.
+// With keepGoing a failed call leaves its response empty and its error in
+// errs while the others carry on; otherwise the first failure cancels the
+// rest and is returned as err. label names the calls in --verbose timings.
+func (c *smartChunker) mapChunks(ctx context.Context, prompt, label string, inputs []string, keepGoing bool) (outputs []string, errs []error, err error) {
+	cfg := c.cfg
+	outputs = make([]string, len(inputs))
+	errs = make([]error, len(inputs))
+	c.progress.start(label, len(inputs))
+	defer c.progress.finish()
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(smartChunkWorkers(cfg))
+	for i, input := range inputs {
+		eg.Go(func() error {
+			debugLog(cfg, "smart-chunk: %s %d/%d", label, i+1, len(inputs))
+			out, err := timedCall(cfg, fmt.Sprintf("%s-%d", label, i+1), func() (string, error) {
+				input, _ := fitDiffToContext(egCtx, cfg, prompt, input)
+				return c.chatFn(egCtx, cfg, cfg.Provider, prompt, input)
+			})
+			c.progress.step(err != nil)
+			if err != nil {
+				errs[i] = err
+				if keepGoing {
+					return nil
+				}
+				return err
+			}
+			outputs[i] = strings.TrimSpace(out)
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, nil, err
+	}
+	return outputs, errs, nil
+}
+
+func smartChunkWorkers(cfg *Config) int {
+	if cfg.SmartChunkWorkers > 0 {
+		return cfg.SmartChunkWorkers
+	}
+	return defaultSmartChunkWorkers
+}
+
+// smartChunkBatchTokens is the map batch size, kept within what fits next to
+// the summary prompt.
+func smartChunkBatchTokens(ctx context.Context, cfg *Config) int {
+	size := cfg.SmartChunkBatchTokens
+	if size <= 0 {
+		size = defaultSmartChunkBatchTokens
+	}
+	if budget := diffTokenBudget(ctx, cfg, smartChunkPrompt); size > budget {
+		size = budget
+	}
+	return size
+}
+
+// groupSummaries packs summaries, in order, into groups whose joined text is
+// at most maxTokens. Every group but a trailing one has at least two
+// summaries, so each reduce tier shrinks the list.
+func groupSummaries(ctx context.Context, summaries []string, maxTokens int) [][]string {
+	var groups [][]string
+	var group []string
+	tokens := 0
+	for _, s := range summaries {
+		n := roughTokens(ctx, s+chunkSeparator)
+		if len(group) >= 2 && tokens+n > maxTokens {
+			groups = append(groups, group)
+			group, tokens = nil, 0
+		}
+		group = append(group, s)
+		tokens += n
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// reduceSummaries merges summaries in tiers until, with preamble, they fit in
+// the budget for systemPrompt, and returns the synthesis input.
+func (c *smartChunker) reduceSummaries(ctx context.Context, systemPrompt, preamble string, summaries []string) (string, error) {
+	cfg := c.cfg
+	finalBudget := diffTokenBudget(ctx, cfg, systemPrompt)
+	reduceBudget := diffTokenBudget(ctx, cfg, smartReducePrompt)
+	for tier := 1; ; tier++ {
+		combined := preamble + strings.Join(summaries, chunkSeparator)
+		if len(summaries) <= 1 || roughTokens(ctx, combined) <= finalBudget {
+			return combined, nil
+		}
+		groups := groupSummaries(ctx, summaries, reduceBudget)
+		debugLog(cfg, "smart-chunk: reduce tier %d: %d summaries in %d groups", tier, len(summaries), len(groups))
+		next := make([]string, len(groups))
+		var inputs []string
+		var at []int
+		for i, g := range groups {
+			if len(g) == 1 {
+				next[i] = g[0]
+				continue
+			}
+			inputs = append(inputs, strings.Join(g, chunkSeparator))
+			at = append(at, i)
+		}
+		reduced, _, err := c.mapChunks(ctx, smartReducePrompt, fmt.Sprintf("reduce-%d", tier), inputs, false)
+		if err != nil {
+			return "", err
+		}
+		for j, i := range at {
+			next[i] = reduced[j]
+		}
+		summaries = next
+	}
+}
+
+// comment writes the comment for diff with systemPrompt by map-reduce. A diff
+// that fits in a single batch gets one direct call. notSummarized lists the
+// files of batches that failed when smart_chunk_partial let the run go on.
+func (c *smartChunker) comment(ctx context.Context, systemPrompt, diff string) (comment string, notSummarized []string, err error) {
+	cfg := c.cfg
+	parsed := parseDiff(diff)
+	batchTokens := smartChunkBatchTokens(ctx, cfg)
+	batches, split := planSmartChunks(ctx, parsed, batchTokens)
+	debugLog(cfg, "smart-chunk: files=%d batches=%d split=%d batch-tokens=%d workers=%d partial=%v",
+		len(parsed.Files), len(batches), split, batchTokens, smartChunkWorkers(cfg), cfg.SmartChunkPartial)
+	if len(batches) <= 1 {
+		comment, err = timedCall(cfg, "comment", func() (string, error) {
+			diff, _ := fitDiffToContext(ctx, cfg, systemPrompt, diff)
+			return c.chatFn(ctx, cfg, cfg.Provider, systemPrompt, diff)
+		})
+		return comment, nil, err
+	}
+
+	summaries, errs, err := c.mapChunks(ctx, smartChunkPrompt, "chunk-summary", batches, cfg.SmartChunkPartial)
+	if err != nil {
+		return "", nil, err
+	}
+	failed := 0
+	for i, chunkErr := range errs {
+		if chunkErr == nil {
+			continue
+		}
+		failed++
+		paths := batchPaths(batches[i])
+		for _, p := range paths {
+			if !slices.Contains(notSummarized, p) {
+				notSummarized = append(notSummarized, p)
+			}
+		}
+		summaries[i] = "[Not summarised, the summary call failed: " + strings.Join(paths, ", ") + "]"
+		debugLog(cfg, "smart-chunk: chunk %d/%d failed, continuing without it: %v", i+1, len(batches), chunkErr)
+	}
+	if failed == len(batches) {
+		return "", nil, fmt.Errorf("every smart-chunk summary failed: %w", errs[0])
+	}
+
+	// The preamble (branch, PR title and description, commits, stats) is not
+	// a file; it goes to the synthesis call as is.
+	combined, err := c.reduceSummaries(ctx, systemPrompt, parsed.preambleString(), summaries)
+	if err != nil {
+		return "", nil, err
+	}
+	debugLog(cfg, "smart-chunk: all chunks summarized, running synthesis call")
+	comment, err = timedCall(cfg, "synthesis", func() (string, error) {
+		return c.chatFn(ctx, cfg, cfg.Provider, systemPrompt, combined)
+	})
+	return comment, notSummarized, err
+}
+
+// batchPaths lists the files in a batch, or the file a piece belongs to.
+func batchPaths(batch string) []string {
+	var paths []string
+	for _, f := range parseDiff(batch).Files {
+		paths = append(paths, f.Path())
+	}
+	return paths
+}
+
+// chunkProgress redraws one stderr line, "smart-chunk: label 3/12", as calls
+// finish. It is only created for a terminal; a nil *chunkProgress is a no-op.
+type chunkProgress struct {
+	mu                  sync.Mutex
+	w                   io.Writer
+	label               string
+	total, done, failed int
+}
+
+// newChunkProgress returns a progress line on w, or nil when w is not a
+// terminal.
+func newChunkProgress(w io.Writer) *chunkProgress {
+	f, ok := w.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return nil
+	}
+	return &chunkProgress{w: w}
+}
+
+func (p *chunkProgress) start(label string, total int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.label, p.total, p.done, p.failed = label, total, 0, 0
+	p.draw()
+}
+
+func (p *chunkProgress) step(failed bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if failed {
+		p.failed++
+	}
+	p.draw()
+}
+
+// finish clears the line.
+func (p *chunkProgress) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, _ = fmt.Fprint(p.w, "\r\033[K")
+}
+
+func (p *chunkProgress) draw() {
+	line := fmt.Sprintf("\r\033[Ksmart-chunk: %s %d/%d", p.label, p.done, p.total)
+	if p.failed > 0 {
+		line += fmt.Sprintf(" (%d failed)", p.failed)
+	}
+	_, _ = fmt.Fprint(p.w, line)
+}
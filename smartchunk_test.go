@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// manyHunkDiff builds a one-file diff with n hunks of ten added lines each.
+func manyHunkDiff(path string, n int) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "diff --git a/%s b/%s\nindex 1111111..2222222 100644\n--- a/%s\n+++ b/%s\n", path, path, path, path)
+	for h := 0; h < n; h++ {
+		fmt.Fprintf(&sb, "@@ -%d,0 +%d,10 @@\n", h*100+1, h*100+1)
+		for i := 0; i < 10; i++ {
+			fmt.Fprintf(&sb, "+hunk %d line %d of some reasonably long content\n", h, i)
+		}
+	}
+	return sb.String()
+}
+
+func TestPlanSmartChunks_BatchesSmallFiles(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 6; i++ {
+		sb.WriteString(fileDiff(fmt.Sprintf("f%d.go", i), 2))
+	}
+	d := parseDiff(sb.String())
+	one := roughTokens(context.Background(), d.Files[0].String())
+
+	batches, split := planSmartChunks(context.Background(), d, 3*one)
+	if len(batches) != 2 || split != 0 {
+		t.Fatalf("expected 6 files in 2 batches of 3, got %d batches, %d split", len(batches), split)
+	}
+	if strings.Join(batches, "") != d.String() {
+		t.Error("batches must cover the diff in order")
+	}
+}
+
+func TestPlanSmartChunks_SplitsLargeFileByHunk(t *testing.T) {
+	d := parseDiff(fileDiff("small.go", 1) + manyHunkDiff("big.go", 12) + fileDiff("after.go", 1))
+	batches, split := planSmartChunks(context.Background(), d, 500)
+	if split != 1 || len(batches) < 4 {
+		t.Fatalf("expected big.go split over several batches, got %d batches, %d split", len(batches), split)
+	}
+	if !strings.HasPrefix(batches[0], "diff --git a/small.go") || !strings.HasPrefix(batches[len(batches)-1], "diff --git a/after.go") {
+		t.Errorf("expected the files around big.go in their own batches")
+	}
+	hunks := 0
+	for _, b := range batches[1 : len(batches)-1] {
+		p := parseDiff(b)
+		if len(p.Files) != 1 || p.Files[0].Path() != "big.go" || !strings.Contains(b, "+++ b/big.go\n") {
+			t.Errorf("each piece must be big.go with its header, got:\n%s", b)
+		}
+		if roughTokens(context.Background(), b) > 500 {
+			t.Errorf("piece over the batch size: %d tokens", roughTokens(context.Background(), b))
+		}
+		hunks += len(p.Files[0].Hunks)
+	}
+	if hunks != 12 {
+		t.Errorf("expected all 12 hunks across the pieces, got %d", hunks)
+	}
+}
+
+func TestMapChunks_LimitsConcurrency(t *testing.T) {
+	cfg := &Config{Provider: OpenAI, SmartChunkWorkers: 2}
+	var running, peak atomic.Int32
+	chatFn := func(_ context.Context, _ *Config, _ ApiProvider, _, input string) (string, error) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		return "summary of " + input, nil
+	}
+	inputs := []string{"a", "b", "c", "d", "e", "f", "g"}
+	out, _, err := (&smartChunker{cfg: cfg, chatFn: chatFn}).mapChunks(context.Background(), smartChunkPrompt, "test", inputs, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if peak.Load() > 2 {
+		t.Errorf("expected at most 2 concurrent calls, saw %d", peak.Load())
+	}
+	for i, in := range inputs {
+		if out[i] != "summary of "+in {
+			t.Errorf("output %d out of order: %q", i, out[i])
+		}
+	}
+}
+
+func TestReduceSummaries_Tiers(t *testing.T) {
+	cfg := &Config{Provider: OpenAI, ContextWindow: 1000}
+	var summaries []string
+	for i := 0; i < 24; i++ {
+		summaries = append(summaries, fmt.Sprintf("- summary %d: %s", i, strings.Repeat("detail ", 30)))
+	}
+
+	var mu sync.Mutex
+	var reduceCalls int
+	chatFn := func(_ context.Context, _ *Config, _ ApiProvider, prompt, input string) (string, error) {
+		if prompt != smartReducePrompt {
+			t.Errorf("unexpected prompt %q", prompt)
+		}
+		mu.Lock()
+		reduceCalls++
+		mu.Unlock()
+		return fmt.Sprintf("merged %d parts", strings.Count(input, chunkSeparator)+1), nil
+	}
+	c := &smartChunker{cfg: cfg, chatFn: chatFn}
+	combined, err := c.reduceSummaries(context.Background(), "Write a PR description.", "Branch: main\n\n", summaries)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reduceCalls < 2 {
+		t.Errorf("expected the summaries to be reduced in groups, got %d calls", reduceCalls)
+	}
+	if !strings.HasPrefix(combined, "Branch: main\n\nmerged ") {
+		t.Errorf("expected the preamble followed by merged summaries, got %q", combined)
+	}
+	if roughTokens(context.Background(), combined) > diffTokenBudget(context.Background(), cfg, "Write a PR description.") {
+		t.Errorf("reduced input still over budget: %q", combined)
+	}
+
+	reduceCalls = 0
+	short := []string{"- a", "- b"}
+	combined, err = c.reduceSummaries(context.Background(), "Write a PR description.", "", short)
+	if err != nil || reduceCalls != 0 || combined != "- a"+chunkSeparator+"- b" {
+		t.Errorf("expected summaries that fit to pass through, got %q after %d calls (%v)", combined, reduceCalls, err)
+	}
+}
+
+func TestGroupSummaries_ShrinksEveryTier(t *testing.T) {
+	big := strings.Repeat("x", 4000)
+	groups := groupSummaries(context.Background(), []string{big, big, big, big, big}, 100)
+	if len(groups) != 3 || len(groups[0]) != 2 || len(groups[1]) != 2 || len(groups[2]) != 1 {
+		t.Errorf("expected groups of two even when over budget, got %d groups", len(groups))
+	}
+}
+
+func TestSmartChunkComment_SplitFile(t *testing.T) {
+	cfg := &Config{Provider: OpenAI, SmartChunkBatchTokens: 500}
+	diff := "Branch: feat/big\n\n" + manyHunkDiff("big.go", 12)
+
+	var mu sync.Mutex
+	var summaries int
+	var synthesis string
+	chatFn := func(_ context.Context, _ *Config, _ ApiProvider, prompt, input string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch prompt {
+		case smartChunkPrompt:
+			summaries++
+			return fmt.Sprintf("- part %d", summaries), nil
+		case "system":
+			synthesis = input
+			return "final", nil
+		}
+		t.Errorf("unexpected prompt %q", prompt)
+		return "", nil
+	}
+	got, notSummarized, err := (&smartChunker{cfg: cfg, chatFn: chatFn}).comment(context.Background(), "system", diff)
+	if err != nil || got != "final" || notSummarized != nil {
+		t.Fatalf("unexpected result %q (%v)", got, err)
+	}
+	if summaries < 3 {
+		t.Errorf("expected the single large file to be summarised in parts, got %d", summaries)
+	}
+	if !strings.HasPrefix(synthesis, "Branch: feat/big\n\n- part") || strings.Count(synthesis, chunkSeparator) != summaries-1 {
+		t.Errorf("unexpected synthesis input:\n%s", synthesis)
+	}
+}
+
+func TestMapChunks_KeepGoing(t *testing.T) {
+	cfg := &Config{Provider: OpenAI, SmartChunkWorkers: 1}
+	chatFn := func(_ context.Context, _ *Config, _ ApiProvider, _, input string) (string, error) {
+		if input == "b" {
+			return "", errors.New("rate limited")
+		}
+		return "summary of " + input, nil
+	}
+	c := &smartChunker{cfg: cfg, chatFn: chatFn}
+	out, errs, err := c.mapChunks(context.Background(), smartChunkPrompt, "test", []string{"a", "b", "c"}, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out[0] != "summary of a" || out[1] != "" || out[2] != "summary of c" || errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Errorf("expected only the second call to fail, got %q %v", out, errs)
+	}
+	if _, _, err := c.mapChunks(context.Background(), smartChunkPrompt, "test", []string{"a", "b", "c"}, false); err == nil {
+		t.Error("expected the failure to be returned without keepGoing")
+	}
+}
+
+func TestSmartChunkComment_Partial(t *testing.T) {
+	diff := "Branch: feat/x\n\n" + fileDiff("ok.go", 40) + fileDiff("bad.go", 40)
+	var mu sync.Mutex
+	var synthesis string
+	chatFn := func(_ context.Context, _ *Config, _ ApiProvider, prompt, input string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if prompt == "system" {
+			synthesis = input
+			return "final", nil
+		}
+		if strings.Contains(input, "bad.go") {
+			return "", errors.New("server error")
+		}
+		return "- ok.go changed", nil
+	}
+
+	cfg := &Config{Provider: OpenAI, SmartChunkBatchTokens: 300}
+	if _, _, err := (&smartChunker{cfg: cfg, chatFn: chatFn}).comment(context.Background(), "system", diff); err == nil {
+		t.Fatal("expected a failed chunk to fail the run without smart_chunk_partial")
+	}
+
+	cfg.SmartChunkPartial = true
+	got, notSummarized, err := (&smartChunker{cfg: cfg, chatFn: chatFn}).comment(context.Background(), "system", diff)
+	if err != nil || got != "final" {
+		t.Fatalf("unexpected result %q (%v)", got, err)
+	}
+	if len(notSummarized) != 1 || notSummarized[0] != "bad.go" {
+		t.Errorf("expected bad.go to be reported, got %v", notSummarized)
+	}
+	if !strings.Contains(synthesis, "- ok.go changed") || !strings.Contains(synthesis, "[Not summarised, the summary call failed: bad.go]") {
+		t.Errorf("expected the failed file marked in the synthesis input, got:\n%s", synthesis)
+	}
+
+	failAll := func(context.Context, *Config, ApiProvider, string, string) (string, error) {
+		return "", errors.New("down")
+	}
+	if _, _, err := (&smartChunker{cfg: cfg, chatFn: failAll}).comment(context.Background(), "system", diff); err == nil || !strings.Contains(err.Error(), "every smart-chunk summary failed") {
+		t.Errorf("expected an error when every chunk fails, got %v", err)
+	}
+}
+
+func TestChunkProgress(t *testing.T) {
+	var sb strings.Builder
+	if newChunkProgress(&sb) != nil {
+		t.Error("expected no progress line for a writer that is not a terminal")
+	}
+	var none *chunkProgress
+	none.start("x", 1)
+	none.step(true)
+	none.finish()
+
+	p := &chunkProgress{w: &sb}
+	p.start("chunk-summary", 3)
+	p.step(false)
+	p.step(true)
+	p.finish()
+	want := "\r\033[Ksmart-chunk: chunk-summary 0/3" +
+		"\r\033[Ksmart-chunk: chunk-summary 1/3" +
+		"\r\033[Ksmart-chunk: chunk-summary 2/3 (1 failed)" +
+		"\r\033[K"
+	if sb.String() != want {
+		t.Errorf("got %q, want %q", sb.String(), want)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxStatsFiles caps the per-file lines in the stats block sent to the model;
+// the JSON stats always list every file.
+const maxStatsFiles = 50
+
+// fileStats summarises one file of a diff.
+type fileStats struct {
+	Path       string         `json:"path"`
+	OldPath    string         `json:"old_path,omitempty"`
+	Status     diffFileStatus `json:"status"`
+	Binary     bool           `json:"binary,omitempty"`
+	OldMode    string         `json:"old_mode,omitempty"`
+	NewMode    string         `json:"new_mode,omitempty"`
+	Insertions int            `json:"insertions"`
+	Deletions  int            `json:"deletions"`
+}
+
+// diffStats summarises a whole diff. It is computed before the diff is fitted
+// to the context window, so it describes the full change even when the model
+// only sees part of it. It is reported as "stats" in the JSON output.
+type diffStats struct {
+	FilesChanged int         `json:"files_changed"`
+	Insertions   int         `json:"insertions"`
+	Deletions    int         `json:"deletions"`
+	Added        int         `json:"added"`
+	Deleted      int         `json:"deleted"`
+	Renamed      int         `json:"renamed"`
+	Binary       int         `json:"binary"`
+	Files        []fileStats `json:"files"`
+}
+
+// stats computes d's statistics, or nil when it has no files.
+func (d *parsedDiff) stats() *diffStats {
+	if len(d.Files) == 0 {
+		return nil
+	}
+	s := &diffStats{FilesChanged: len(d.Files), Files: make([]fileStats, 0, len(d.Files))}
+	for _, f := range d.Files {
+		fs := fileStats{
+			Path:       f.Path(),
+			Status:     f.Status,
+			Binary:     f.Binary,
+			Insertions: f.Added,
+			Deletions:  f.Removed,
+		}
+		if f.Status == fileRenamed || f.Status == fileCopied {
+			fs.OldPath = f.OldPath
+		}
+		if f.ModeChanged() {
+			fs.OldMode, fs.NewMode = f.OldMode, f.NewMode
+		}
+		s.Insertions += f.Added
+		s.Deletions += f.Removed
+		switch f.Status {
+		case fileAdded:
+			s.Added++
+		case fileDeleted:
+			s.Deleted++
+		case fileRenamed:
+			s.Renamed++
+		}
+		if f.Binary {
+			s.Binary++
+		}
+		s.Files = append(s.Files, fs)
+	}
+	return s
+}
+
+// summary is the one-line total, in the style of git diff --stat.
+func (s *diffStats) summary() string {
+	line := fmt.Sprintf("%d %s changed, %d %s(+), %d %s(-)",
+		s.FilesChanged, plural(s.FilesChanged, "file", "files"),
+		s.Insertions, plural(s.Insertions, "insertion", "insertions"),
+		s.Deletions, plural(s.Deletions, "deletion", "deletions"))
+	var extra []string
+	for _, c := range []struct {
+		n    int
+		what string
+	}{{s.Added, "new"}, {s.Deleted, "deleted"}, {s.Renamed, "renamed"}, {s.Binary, "binary"}} {
+		if c.n > 0 {
+			extra = append(extra, fmt.Sprintf("%d %s", c.n, c.what))
+		}
+	}
+	if len(extra) > 0 {
+		line += "; " + strings.Join(extra, ", ")
+	}
+	return line
+}
+
+// lines renders the stats block prepended to the diff sent to the model.
+func (s *diffStats) lines() []string {
+	lines := []string{"Diff stats: " + s.summary()}
+	for i, f := range s.Files {
+		if i == maxStatsFiles {
+			lines = append(lines, fmt.Sprintf("- ... and %d more files", len(s.Files)-maxStatsFiles))
+			break
+		}
+		status := string(f.Status)
+		if f.OldPath != "" {
+			status += " from " + f.OldPath
+		}
+		parts := []string{status}
+		if f.Binary {
+			parts = append(parts, "binary")
+		} else {
+			parts = append(parts, fmt.Sprintf("+%d -%d", f.Insertions, f.Deletions))
+		}
+		if f.OldMode != "" {
+			parts = append(parts, "mode "+f.OldMode+" → "+f.NewMode)
+		}
+		lines = append(lines, fmt.Sprintf("- %s (%s)", f.Path, strings.Join(parts, ", ")))
+	}
+	return lines
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// addDiffStats puts the stats block for diff at the end of its preamble, so it
+// follows the branch or PR title, and returns the stats. A diff with no
+// "diff --git" files is returned unchanged with nil stats.
+func addDiffStats(diff string) (string, *diffStats) {
+	d := parseDiff(diff)
+	stats := d.stats()
+	if stats == nil {
+		return diff, nil
+	}
+	d.Preamble = append(d.Preamble, append(stats.lines(), "")...)
+	return d.String(), stats
+}
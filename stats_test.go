@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDiffStats_Fixtures(t *testing.T) {
+	data, err := os.ReadFile("testdata/binary-files.diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := parseDiff(string(data)).stats()
+	if s.FilesChanged != 5 || s.Binary != 5 || s.Added != 1 || s.Deleted != 1 {
+		t.Errorf("unexpected binary stats %+v", s)
+	}
+
+	data, err = os.ReadFile("testdata/rename-move.diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = parseDiff(string(data)).stats()
+	if s.Renamed == 0 || s.Files[0].OldPath != "internal/util/helpers.go" || s.Files[0].Path != "internal/utils/helpers.go" {
+		t.Errorf("expected the rename to be recorded, got %+v", s.Files[0])
+	}
+	if s.Insertions == 0 || s.Deletions == 0 {
+		t.Errorf("expected line counts, got +%d -%d", s.Insertions, s.Deletions)
+	}
+}
+
+func TestDiffStats_Lines(t *testing.T) {
+	diff := "diff --git a/run.sh b/run.sh\nold mode 100644\nnew mode 100755\n" +
+		"diff --git a/a.go b/b.go\nsimilarity index 90%\nrename from a.go\nrename to b.go\n--- a/a.go\n+++ b/b.go\n@@ -1 +1 @@\n-x\n+y\n"
+	lines := parseDiff(diff).stats().lines()
+	want := []string{
+		"Diff stats: 2 files changed, 1 insertion(+), 1 deletion(-); 1 renamed",
+		"- run.sh (modified, +0 -0, mode 100644 → 100755)",
+		"- b.go (renamed from a.go, +1 -1)",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDiffStats_LinesCapped(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < maxStatsFiles+3; i++ {
+		sb.WriteString(fileDiff(fmt.Sprintf("f%d.go", i), 1))
+	}
+	lines := parseDiff(sb.String()).stats().lines()
+	if len(lines) != maxStatsFiles+2 || lines[len(lines)-1] != "- ... and 3 more files" {
+		t.Errorf("expected the file list to be capped, got %d lines ending %q", len(lines), lines[len(lines)-1])
+	}
+}
+
+func TestAddDiffStats(t *testing.T) {
+	got, stats := addDiffStats("Branch: main\n\n" + fileDiff("main.go", 2))
+	if stats == nil || stats.Insertions != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	want := "Branch: main\n\nDiff stats: 1 file changed, 2 insertions(+), 0 deletions(-)\n- main.go (modified, +2 -0)\n\ndiff --git a/main.go"
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("expected the stats block after the preamble, got:\n%s", got)
+	}
+
+	if got, stats := addDiffStats("not a git diff"); got != "not a git diff" || stats != nil {
+		t.Errorf("expected text without files to be unchanged, got %q %+v", got, stats)
+	}
+}
+
+func TestRootCmd_StatsInJSONAndPrintRequest(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "dummy")
+
+	var sent string
+	for _, args := range [][]string{
+		{"--file=testdata/multiple-files.diff", "--provider=openai", "--format=json"},
+		{"--file=testdata/multiple-files.diff", "--provider=openai", "--print-request"},
+	} {
+		var out strings.Builder
+		cmd := newRootCmd(func(_ context.Context, _ *Config, _ ApiProvider, _, diff string) (string, error) {
+			sent = diff
+			return "ok", nil
+		})
+		cmd.SetArgs(args)
+		cmd.SetOut(&out)
+		cmd.SetErr(io.Discard)
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("%v: unexpected error: %v", args, err)
+		}
+		var payload struct {
+			Diff  string     `json:"diff"`
+			Stats *diffStats `json:"stats"`
+		}
+		if err := json.Unmarshal([]byte(out.String()), &payload); err != nil {
+			t.Fatalf("%v: invalid JSON %q: %v", args, out.String(), err)
+		}
+		if payload.Stats == nil || payload.Stats.FilesChanged != 2 || len(payload.Stats.Files) != 2 {
+			t.Errorf("%v: expected stats for 2 files, got %s", args, out.String())
+		}
+		if payload.Diff != "" {
+			sent = payload.Diff
+		}
+		if !strings.HasPrefix(sent, "Diff stats: 2 files changed") {
+			t.Errorf("%v: expected the stats block at the top of the diff, got:\n%s", args, sent)
+		}
+	}
+}
@@ -55,132 +55,165 @@ func (e *HeuristicTokenEstimator) CountTokens(_ context.Context, _ string, text
 	return int32(math.Ceil(float64(totalChars) / 3.5)), nil
 }
 
-// NewTokenEstimator returns the appropriate TokenEstimator for the configured provider.
+// NewTokenEstimator returns the TokenEstimator for the configured provider,
+// falling back to the character heuristic for unknown providers.
 func NewTokenEstimator(cfg *Config) TokenEstimator {
-	switch cfg.Provider {
-	case Gemini:
-		return &GeminiTokenEstimator{APIKey: cfg.GeminiAPIKey}
-	case OpenAI, Anthropic, Ollama:
-		return &HeuristicTokenEstimator{}
-	default:
-		return &HeuristicTokenEstimator{}
+	if p, ok := lookupProvider(cfg.Provider); ok {
+		return p.TokenEstimator(cfg)
 	}
+	return &HeuristicTokenEstimator{}
 }
 
-// ModelPrice holds the input cost in USD per 1 million tokens.
+// ModelPrice holds USD prices per 1 million tokens. CachedInput is the rate
+// for prompt tokens served from the provider's prompt cache; 0 means cached
+// tokens are billed at the Input rate.
 type ModelPrice struct {
-	Input float64
+	Input       float64
+	Output      float64
+	CachedInput float64
 }
 
-// EstimateCost returns the estimated USD cost for the given number of input tokens.
-// It performs an exact lookup first, then falls back to substring matching.
-// Returns 0 for unknown models or Ollama (which runs locally at no cost).
-func EstimateCost(model string, inputTokens int32) float64 {
-	if inputTokens <= 0 {
-		return 0.0
+// cost returns the USD cost of u at these prices.
+func (p ModelPrice) cost(u tokenUsage) float64 {
+	cached := u.CachedInputTokens
+	if cached > u.InputTokens {
+		cached = u.InputTokens
 	}
+	cachedRate := p.CachedInput
+	if cachedRate == 0 {
+		cachedRate = p.Input
+	}
+	return (float64(u.InputTokens-cached)*p.Input +
+		float64(cached)*cachedRate +
+		float64(u.OutputTokens)*p.Output) / 1_000_000
+}
 
-	model = strings.ToLower(model)
+// builtinPrices is the pricing table in USD per 1M tokens (approximate,
+// subject to change). Entries can be overridden or extended with [[pricing]]
+// in the config file; see lookupPrice.
+var builtinPrices = map[string]ModelPrice{
+	// OpenAI (current)
+	"o3":           {Input: 2.00, Output: 8.00, CachedInput: 0.50},
+	"o3-mini":      {Input: 1.10, Output: 4.40, CachedInput: 0.55},
+	"o3-pro":       {Input: 20.00, Output: 80.00},
+	"o1":           {Input: 15.00, Output: 60.00, CachedInput: 7.50},
+	"o1-mini":      {Input: 1.10, Output: 4.40, CachedInput: 0.55},
+	"gpt-4.1":      {Input: 2.00, Output: 8.00, CachedInput: 0.50},
+	"gpt-4.1-mini": {Input: 0.40, Output: 1.60, CachedInput: 0.10},
+	"gpt-4.1-nano": {Input: 0.10, Output: 0.40, CachedInput: 0.025},
+	// OpenAI (legacy)
+	"gpt-4o":            {Input: 2.50, Output: 10.00, CachedInput: 1.25},
+	"gpt-4o-2024-05-13": {Input: 5.00, Output: 15.00},
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.60, CachedInput: 0.075},
+	"gpt-4-turbo":       {Input: 10.00, Output: 30.00},
+	"gpt-3.5-turbo":     {Input: 0.50, Output: 1.50},
+
+	// Anthropic (current; cache reads are billed at 10% of input)
+	"claude-opus-4-6":           {Input: 5.00, Output: 25.00, CachedInput: 0.50},
+	"claude-sonnet-4-6":         {Input: 3.00, Output: 15.00, CachedInput: 0.30},
+	"claude-haiku-4-5-20251001": {Input: 1.00, Output: 5.00, CachedInput: 0.10},
+	// Anthropic (legacy)
+	"claude-opus-4-5-20251101":   {Input: 5.00, Output: 25.00, CachedInput: 0.50},
+	"claude-opus-4-1-20250805":   {Input: 15.00, Output: 75.00, CachedInput: 1.50},
+	"claude-opus-4-20250514":     {Input: 15.00, Output: 75.00, CachedInput: 1.50},
+	"claude-sonnet-4-5-20250929": {Input: 3.00, Output: 15.00, CachedInput: 0.30},
+	"claude-sonnet-4-20250514":   {Input: 3.00, Output: 15.00, CachedInput: 0.30},
+	"claude-3-7-sonnet-20250219": {Input: 3.00, Output: 15.00, CachedInput: 0.30},
+	"claude-3-5-sonnet-20240620": {Input: 3.00, Output: 15.00, CachedInput: 0.30},
+	"claude-3-opus-20240229":     {Input: 15.00, Output: 75.00, CachedInput: 1.50},
+	"claude-3-sonnet-20240229":   {Input: 3.00, Output: 15.00},
+	"claude-3-haiku-20240307":    {Input: 0.25, Output: 1.25, CachedInput: 0.03},
+
+	// Gemini (current; pricing for text ≤200k context window)
+	"gemini-2.5-pro":        {Input: 1.25, Output: 10.00, CachedInput: 0.125},
+	"gemini-2.5-flash":      {Input: 0.30, Output: 2.50, CachedInput: 0.03},
+	"gemini-2.5-flash-lite": {Input: 0.10, Output: 0.40, CachedInput: 0.01},
+	// Gemini (preview)
+	"gemini-3-flash-preview": {Input: 0.50, Output: 3.00, CachedInput: 0.05},
+	"gemini-3-pro-preview":   {Input: 2.00, Output: 12.00, CachedInput: 0.20},
+	// Gemini (legacy — retiring June 2026)
+	"gemini-2.0-flash":      {Input: 0.10, Output: 0.40, CachedInput: 0.025},
+	"gemini-2.0-flash-lite": {Input: 0.075, Output: 0.30},
+	"gemini-1.5-pro":        {Input: 3.50, Output: 10.50},
+	"gemini-1.5-pro-latest": {Input: 3.50, Output: 10.50},
+	"gemini-1.5-flash":      {Input: 0.075, Output: 0.30},
+}
 
-	// Ollama models are local and free.
-	if strings.Contains(model, "ollama") || strings.Contains(model, "llama") {
-		return 0.0
+// lookupPrice returns the price for model. Overrides from the [[pricing]]
+// config section win, then an exact match in the built-in table, then
+// substring matching. ok is false for unknown models. Whether a provider is
+// free is up to the provider; see priceFor.
+func lookupPrice(overrides []PricingOverride, model string) (price ModelPrice, ok bool) {
+	if price, ok := pricingOverride(overrides, model); ok {
+		return price, true
 	}
-
-	// Pricing table in USD per 1M tokens (approximate, subject to change).
-	prices := map[string]ModelPrice{
-		// OpenAI (current)
-		"o3":           {Input: 2.00},
-		"o3-mini":      {Input: 1.10},
-		"o3-pro":       {Input: 20.00},
-		"o1":           {Input: 15.00},
-		"o1-mini":      {Input: 1.10},
-		"gpt-4.1":      {Input: 2.00},
-		"gpt-4.1-mini": {Input: 0.40},
-		"gpt-4.1-nano": {Input: 0.10},
-		// OpenAI (legacy)
-		"gpt-4o":            {Input: 2.50},
-		"gpt-4o-2024-05-13": {Input: 5.00},
-		"gpt-4o-mini":       {Input: 0.15},
-		"gpt-4-turbo":       {Input: 10.00},
-		"gpt-3.5-turbo":     {Input: 0.50},
-
-		// Anthropic (current)
-		"claude-opus-4-6":           {Input: 5.00},
-		"claude-sonnet-4-6":         {Input: 3.00},
-		"claude-haiku-4-5-20251001": {Input: 1.00},
-		// Anthropic (legacy)
-		"claude-opus-4-5-20251101":   {Input: 5.00},
-		"claude-opus-4-1-20250805":   {Input: 15.00},
-		"claude-opus-4-20250514":     {Input: 15.00},
-		"claude-sonnet-4-5-20250929": {Input: 3.00},
-		"claude-sonnet-4-20250514":   {Input: 3.00},
-		"claude-3-7-sonnet-20250219": {Input: 3.00},
-		"claude-3-5-sonnet-20240620": {Input: 3.00},
-		"claude-3-opus-20240229":     {Input: 15.00},
-		"claude-3-sonnet-20240229":   {Input: 3.00},
-		"claude-3-haiku-20240307":    {Input: 0.25},
-
-		// Gemini (current; pricing for text ≤200k context window)
-		"gemini-2.5-pro":        {Input: 1.25},
-		"gemini-2.5-flash":      {Input: 0.30},
-		"gemini-2.5-flash-lite": {Input: 0.10},
-		// Gemini (preview)
-		"gemini-3-flash-preview": {Input: 0.50},
-		"gemini-3-pro-preview":   {Input: 2.00},
-		// Gemini (legacy — retiring June 2026)
-		"gemini-2.0-flash":      {Input: 0.10},
-		"gemini-2.0-flash-lite": {Input: 0.075},
-		"gemini-1.5-pro":        {Input: 3.50},
-		"gemini-1.5-pro-latest": {Input: 3.50},
-		"gemini-1.5-flash":      {Input: 0.075},
+	model = strings.ToLower(model)
+	if price, ok := builtinPrices[model]; ok {
+		return price, true
 	}
+	return substringFallbackPrice(model)
+}
 
-	if price, ok := prices[model]; ok {
-		return float64(inputTokens) / 1_000_000 * price.Input
+// pricingOverride returns the [[pricing]] entry for model, matched
+// case-insensitively.
+func pricingOverride(overrides []PricingOverride, model string) (ModelPrice, bool) {
+	for _, o := range overrides {
+		if strings.EqualFold(o.Model, model) {
+			return ModelPrice{Input: o.Input, Output: o.Output, CachedInput: o.CachedInput}, true
+		}
 	}
+	return ModelPrice{}, false
+}
 
-	return substringFallbackPrice(model, inputTokens)
+// EstimateCost returns the estimated USD cost for the given number of input
+// tokens using the built-in pricing table.
+// Returns 0 for unknown models.
+func EstimateCost(model string, inputTokens int32) float64 {
+	if inputTokens <= 0 {
+		return 0.0
+	}
+	price, _ := lookupPrice(nil, model)
+	return price.cost(tokenUsage{InputTokens: int64(inputTokens)})
 }
 
 // substringRule matches a model name by one or two required substrings.
 type substringRule struct {
 	must1, must2 string
-	inputPerM    float64
+	price        ModelPrice
 }
 
-// substringFallbackPrice returns the cost for model name variants not in the
+// substringFallbackPrice returns the price for model name variants not in the
 // exact pricing table, evaluated in priority order (most-specific first).
-func substringFallbackPrice(model string, inputTokens int32) float64 {
+func substringFallbackPrice(model string) (ModelPrice, bool) {
 	// Rules are checked in order; the first match wins.
 	// More-specific patterns (e.g. "gpt-4.1-nano") must appear before
 	// less-specific ones (e.g. "gpt-4.1") to avoid shadowing.
 	rules := []substringRule{
 		// OpenAI
-		{"gpt-4.1-nano", "", 0.10},
-		{"gpt-4.1-mini", "", 0.40},
-		{"gpt-4.1", "", 2.00},
-		{"gpt-4o-mini", "", 0.15},
-		{"gpt-4o", "", 2.50},
-		{"o3-mini", "", 1.10},
-		{"o3", "", 2.00},
+		{"gpt-4.1-nano", "", ModelPrice{Input: 0.10, Output: 0.40, CachedInput: 0.025}},
+		{"gpt-4.1-mini", "", ModelPrice{Input: 0.40, Output: 1.60, CachedInput: 0.10}},
+		{"gpt-4.1", "", ModelPrice{Input: 2.00, Output: 8.00, CachedInput: 0.50}},
+		{"gpt-4o-mini", "", ModelPrice{Input: 0.15, Output: 0.60, CachedInput: 0.075}},
+		{"gpt-4o", "", ModelPrice{Input: 2.50, Output: 10.00, CachedInput: 1.25}},
+		{"o3-mini", "", ModelPrice{Input: 1.10, Output: 4.40, CachedInput: 0.55}},
+		{"o3", "", ModelPrice{Input: 2.00, Output: 8.00, CachedInput: 0.50}},
 		// Anthropic
-		{"claude", "opus", 5.00},
-		{"claude", "sonnet", 3.00},
-		{"claude", "haiku", 1.00},
+		{"claude", "opus", ModelPrice{Input: 5.00, Output: 25.00, CachedInput: 0.50}},
+		{"claude", "sonnet", ModelPrice{Input: 3.00, Output: 15.00, CachedInput: 0.30}},
+		{"claude", "haiku", ModelPrice{Input: 1.00, Output: 5.00, CachedInput: 0.10}},
 		// Gemini — most-specific generation/tier first
-		{"gemini-3", "pro", 2.00},
-		{"gemini-3", "flash", 0.50},
-		{"gemini-2.5", "pro", 1.25},
-		{"gemini-2.5", "flash", 0.30},
-		{"gemini", "flash", 0.10},
-		{"gemini", "pro", 1.25},
+		{"gemini-3", "pro", ModelPrice{Input: 2.00, Output: 12.00, CachedInput: 0.20}},
+		{"gemini-3", "flash", ModelPrice{Input: 0.50, Output: 3.00, CachedInput: 0.05}},
+		{"gemini-2.5", "pro", ModelPrice{Input: 1.25, Output: 10.00, CachedInput: 0.125}},
+		{"gemini-2.5", "flash", ModelPrice{Input: 0.30, Output: 2.50, CachedInput: 0.03}},
+		{"gemini", "flash", ModelPrice{Input: 0.10, Output: 0.40, CachedInput: 0.025}},
+		{"gemini", "pro", ModelPrice{Input: 1.25, Output: 10.00, CachedInput: 0.125}},
 	}
 
 	for _, r := range rules {
 		if strings.Contains(model, r.must1) && (r.must2 == "" || strings.Contains(model, r.must2)) {
-			return float64(inputTokens) / 1_000_000 * r.inputPerM
+			return r.price, true
 		}
 	}
-	return 0.0
+	return ModelPrice{}, false
 }
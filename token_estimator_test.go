@@ -68,7 +68,6 @@ func TestEstimateCost(t *testing.T) {
 		{"GPT-4o-Mini", 1_000_000, 0.15, "Case insensitive"},
 		{"claude-3-5-sonnet-20240620", 1_000_000, 3.00, "Anthropic model"},
 		{"gemini-1.5-flash", 1_000_000, 0.075, "Gemini Flash"},
-		{"llama3", 1_000_000, 0.0, "Ollama/Llama (free)"},
 		{"unknown-model", 1000, 0.0, "Unknown model"},
 		{"custom-gpt-4o-mini-v2", 1_000_000, 0.15, "Fuzzy match"},
 		// Fuzzy match branches
@@ -102,6 +101,69 @@ func TestEstimateCost_NonPositiveTokens(t *testing.T) {
 	}
 }
 
+func TestModelPrice_Cost(t *testing.T) {
+	price := ModelPrice{Input: 2.00, Output: 8.00, CachedInput: 0.50}
+	tests := []struct {
+		usage    tokenUsage
+		expected float64
+		desc     string
+	}{
+		{tokenUsage{InputTokens: 1_000_000}, 2.00, "input only"},
+		{tokenUsage{OutputTokens: 1_000_000}, 8.00, "output only"},
+		{tokenUsage{InputTokens: 1_000_000, CachedInputTokens: 500_000}, 1.25, "half cached"},
+		{tokenUsage{InputTokens: 100, CachedInputTokens: 500}, 0.00005, "cached clamped to input"},
+	}
+	for _, tc := range tests {
+		if got := price.cost(tc.usage); math.Abs(got-tc.expected) > 1e-9 {
+			t.Errorf("%s: expected %.6f, got %.6f", tc.desc, tc.expected, got)
+		}
+	}
+
+	noCacheRate := ModelPrice{Input: 3.00}
+	if got := noCacheRate.cost(tokenUsage{InputTokens: 1_000_000, CachedInputTokens: 1_000_000}); math.Abs(got-3.00) > 1e-9 {
+		t.Errorf("expected cached tokens at the input rate when no cached rate is set, got %.6f", got)
+	}
+}
+
+func TestLookupPrice(t *testing.T) {
+	overrides := []PricingOverride{
+		{Model: "GPT-4o", Input: 1.00, Output: 4.00},
+		{Model: "llama3-hosted", Input: 0.20, Output: 0.20},
+		{Model: "acme-coder-2", Input: 0.50, Output: 1.50},
+	}
+	tests := []struct {
+		model string
+		want  ModelPrice
+		ok    bool
+		desc  string
+	}{
+		{"gpt-4o", ModelPrice{Input: 1.00, Output: 4.00}, true, "override wins over built-in"},
+		{"llama3-hosted", ModelPrice{Input: 0.20, Output: 0.20}, true, "override adds hosted model"},
+		{"acme-coder-2", ModelPrice{Input: 0.50, Output: 1.50}, true, "override adds new model"},
+		{"gpt-4o-mini", builtinPrices["gpt-4o-mini"], true, "built-in exact"},
+		{"my-claude-sonnet", ModelPrice{Input: 3.00, Output: 15.00, CachedInput: 0.30}, true, "substring"},
+		{"llama3", ModelPrice{}, false, "model name alone is not free"},
+		{"unknown-model", ModelPrice{}, false, "unknown"},
+	}
+	for _, tc := range tests {
+		got, ok := lookupPrice(overrides, tc.model)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%s: lookupPrice(%q) = %+v, %v; want %+v, %v", tc.desc, tc.model, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestBuiltinPrices_HaveOutputRates(t *testing.T) {
+	for model, price := range builtinPrices {
+		if price.Output <= 0 {
+			t.Errorf("%s has no output price", model)
+		}
+		if price.CachedInput > price.Input {
+			t.Errorf("%s cached input rate exceeds input rate", model)
+		}
+	}
+}
+
 func TestGeminiTokenEstimator_Mock(t *testing.T) {
 	// Create a mock server
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// filePriority ranks a changed file by how much it matters to a review.
+// Lower values are kept longest when a diff has to be cut down.
+type filePriority int
+
+const (
+	prioritySource filePriority = iota
+	priorityTest
+	priorityDocs
+	priorityLow // lockfiles, generated and vendored code, minified assets
+)
+
+func (p filePriority) String() string {
+	switch p {
+	case priorityTest:
+		return "test"
+	case priorityDocs:
+		return "docs"
+	case priorityLow:
+		return "generated/vendored"
+	}
+	return "source"
+}
+
+// lockfileNames are dependency lockfiles, matched on the base name.
+var lockfileNames = map[string]bool{
+	"go.sum":              true,
+	"package-lock.json":   true,
+	"npm-shrinkwrap.json": true,
+	"yarn.lock":           true,
+	"pnpm-lock.yaml":      true,
+	"bun.lockb":           true,
+	"Cargo.lock":          true,
+	"Gemfile.lock":        true,
+	"poetry.lock":         true,
+	"Pipfile.lock":        true,
+	"composer.lock":       true,
+	"mix.lock":            true,
+	"pubspec.lock":        true,
+	"Podfile.lock":        true,
+	"flake.lock":          true,
+}
+
+// classifyDiffFile returns the priority of the file at p (a repo-relative path).
+func classifyDiffFile(p string) filePriority {
+	base := path.Base(p)
+	lower := strings.ToLower(p)
+	lowerBase := strings.ToLower(base)
+	dirs := "/" + lower
+
+	switch {
+	case lockfileNames[base],
+		strings.HasSuffix(lowerBase, ".lock"),
+		strings.Contains(dirs, "/vendor/"),
+		strings.Contains(dirs, "/node_modules/"),
+		strings.Contains(dirs, "/third_party/"),
+		strings.Contains(dirs, "/dist/"),
+		strings.HasSuffix(lowerBase, ".min.js"),
+		strings.HasSuffix(lowerBase, ".min.css"),
+		strings.HasSuffix(lowerBase, ".map"),
+		strings.HasSuffix(lowerBase, ".pb.go"),
+		strings.HasSuffix(lowerBase, ".pb.gw.go"),
+		strings.HasSuffix(lowerBase, "_pb2.py"),
+		strings.HasSuffix(lowerBase, "_pb2_grpc.py"),
+		strings.HasSuffix(lowerBase, "_gen.go"),
+		strings.HasSuffix(lowerBase, ".gen.go"),
+		strings.HasPrefix(lowerBase, "zz_generated"),
+		strings.Contains(lowerBase, ".generated."):
+		return priorityLow
+	case strings.HasSuffix(lowerBase, "_test.go"),
+		strings.HasSuffix(lowerBase, "_test.py"),
+		strings.HasPrefix(lowerBase, "test_") && strings.HasSuffix(lowerBase, ".py"),
+		strings.Contains(lowerBase, ".test."),
+		strings.Contains(lowerBase, ".spec."),
+		strings.Contains(dirs, "/test/"),
+		strings.Contains(dirs, "/tests/"),
+		strings.Contains(dirs, "/__tests__/"),
+		strings.Contains(dirs, "/testdata/"):
+		return priorityTest
+	case strings.HasSuffix(lowerBase, ".md"),
+		strings.HasSuffix(lowerBase, ".rst"),
+		strings.HasSuffix(lowerBase, ".txt"),
+		strings.HasSuffix(lowerBase, ".adoc"),
+		strings.Contains(dirs, "/docs/"),
+		strings.Contains(dirs, "/doc/"),
+		strings.HasPrefix(base, "LICENSE"),
+		strings.HasPrefix(base, "CHANGELOG"):
+		return priorityDocs
+	}
+	return prioritySource
+}
+
+// diffTruncation describes what was cut from a diff to fit the context window.
+// It is reported as "truncation" in the JSON output.
+type diffTruncation struct {
+	// OmittedFiles had their hunks replaced by a one-line summary.
+	OmittedFiles []string `json:"omitted_files,omitempty"`
+	// Trimmed is set when, after omitting files, the remaining diff still
+	// had to be cut in the middle.
+	Trimmed bool `json:"trimmed,omitempty"`
+}
+
+// truncateDiffByPriority shrinks diff to about maxBytes. Non-source files are
+// summarised first, lowest priority and then largest first, so lockfiles and
+// generated code go before docs and docs before tests. The summarised files
+// are listed in a note at the top of the diff. If that is not enough, the
+// remaining diff is cut in the middle by truncateDiffBytes.
+// It returns nil when diff already fits.
+func truncateDiffByPriority(diff string, maxBytes int) (string, *diffTruncation) {
+	if len(diff) <= maxBytes {
+		return diff, nil
+	}
+	d := parseDiff(diff)
+	preamble := d.preambleString()
+
+	type fileChunk struct {
+		index    int
+		file     *diffFile
+		priority filePriority
+	}
+	chunks := make([]string, len(d.Files))
+	order := make([]fileChunk, len(d.Files))
+	for i, f := range d.Files {
+		chunks[i] = f.String()
+		order[i] = fileChunk{index: i, file: f, priority: classifyDiffFile(f.Path())}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i].priority != order[j].priority {
+			return order[i].priority > order[j].priority
+		}
+		return len(chunks[order[i].index]) > len(chunks[order[j].index])
+	})
+
+	t := &diffTruncation{}
+	size := len(diff)
+	var notes []string
+	// Source files are never summarised, and neither is the last file left:
+	// a change that is mostly tests or docs still shows the most important one.
+	for _, f := range order[:max(len(order)-1, 0)] {
+		if size <= maxBytes || f.priority == prioritySource {
+			break
+		}
+		summary := fmt.Sprintf("%s\n[... %s file omitted to fit the context window: +%d -%d lines ...]\n", f.file.Header[0], f.priority, f.file.Added, f.file.Removed)
+		size += len(summary) - len(chunks[f.index])
+		chunks[f.index] = summary
+		t.OmittedFiles = append(t.OmittedFiles, f.file.Path())
+		notes = append(notes, fmt.Sprintf("%s (%s, +%d -%d)", f.file.Path(), f.priority, f.file.Added, f.file.Removed))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(preamble)
+	if len(notes) > 0 {
+		sb.WriteString("[Changes to these files were omitted to fit the model's context window: ")
+		sb.WriteString(strings.Join(notes, ", "))
+		sb.WriteString("]\n\n")
+	}
+	for _, c := range chunks {
+		sb.WriteString(c)
+	}
+	out := sb.String()
+	if d.noFinalNewline {
+		out = strings.TrimSuffix(out, "\n")
+	}
+	if len(out) > maxBytes {
+		out = truncateDiffBytes(out, maxBytes)
+		t.Trimmed = true
+	}
+	return out, t
+}
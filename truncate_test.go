@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestClassifyDiffFile(t *testing.T) {
+	tests := map[string]filePriority{
+		"main.go":                           prioritySource,
+		"internal/api/handler.ts":           prioritySource,
+		"main_test.go":                      priorityTest,
+		"src/__tests__/app.js":              priorityTest,
+		"web/app.spec.ts":                   priorityTest,
+		"tests/test_api.py":                 priorityTest,
+		"README.md":                         priorityDocs,
+		"docs/setup.html":                   priorityDocs,
+		"go.sum":                            priorityLow,
+		"web/package-lock.json":             priorityLow,
+		"vendor/github.com/x/y/z.go":        priorityLow,
+		"static/app.min.js":                 priorityLow,
+		"api/v1/service.pb.go":              priorityLow,
+		"pkg/apis/zz_generated.deepcopy.go": priorityLow,
+	}
+	for path, want := range tests {
+		if got := classifyDiffFile(path); got != want {
+			t.Errorf("classifyDiffFile(%q) = %s, want %s", path, got, want)
+		}
+	}
+}
+
+// fileDiff returns a one-hunk diff adding n lines to path.
+func fileDiff(path string, n int) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "diff --git a/%s b/%s\n--- a/%s\n+++ b/%s\n@@ -0,0 +1,%d @@\n", path, path, path, path, n)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "+%s line %d\n", path, i)
+	}
+	return sb.String()
+}
+
+func TestTruncateDiffByPriority_FitsUnchanged(t *testing.T) {
+	diff := fileDiff("main.go", 5)
+	got, truncation := truncateDiffByPriority(diff, len(diff))
+	if got != diff || truncation != nil {
+		t.Errorf("expected diff to be unchanged, got truncation %+v", truncation)
+	}
+}
+
+func TestTruncateDiffByPriority_DropsLowPriorityFirst(t *testing.T) {
+	source := fileDiff("main.go", 20)
+	test := fileDiff("main_test.go", 20)
+	docs := fileDiff("README.md", 50)
+	lock := fileDiff("go.sum", 2000)
+	diff := "Branch: feat/x\n\n" + source + lock + docs + test
+
+	got, truncation := truncateDiffByPriority(diff, len(source)+len(docs)+len(test)+1000)
+	if truncation == nil {
+		t.Fatal("expected truncation")
+	}
+	if len(truncation.OmittedFiles) != 1 || truncation.OmittedFiles[0] != "go.sum" || truncation.Trimmed {
+		t.Errorf("expected only go.sum to be omitted, got %+v", truncation)
+	}
+	for _, keep := range []string{source, docs, test} {
+		if !strings.Contains(got, keep) {
+			t.Errorf("expected file to be kept intact:\n%s", keep[:60])
+		}
+	}
+	if !strings.HasPrefix(got, "Branch: feat/x\n\n[Changes to these files were omitted to fit the model's context window: go.sum (generated/vendored, +2000 -0)]") {
+		t.Errorf("expected omitted-files note after the preamble, got:\n%s", got[:200])
+	}
+	if !strings.Contains(got, "diff --git a/go.sum b/go.sum\n[... generated/vendored file omitted") {
+		t.Error("expected go.sum header with an in-place summary")
+	}
+
+	// A tighter budget omits docs, then tests, but never source.
+	got, truncation = truncateDiffByPriority(diff, len(source)+600)
+	if strings.Join(truncation.OmittedFiles, ",") != "go.sum,README.md,main_test.go" {
+		t.Errorf("unexpected omission order: %v", truncation.OmittedFiles)
+	}
+	if !strings.Contains(got, source) {
+		t.Error("expected source file to survive")
+	}
+}
+
+func TestTruncateDiffByPriority_TrimsSourceLast(t *testing.T) {
+	diff := fileDiff("a.go", 500) + fileDiff("b.go", 500)
+	got, truncation := truncateDiffByPriority(diff, 4000)
+	if truncation == nil || !truncation.Trimmed || len(truncation.OmittedFiles) != 0 {
+		t.Fatalf("expected source-only diff to be trimmed, got %+v", truncation)
+	}
+	if !strings.Contains(got, "[...diff truncated...]") || len(got) > 4100 {
+		t.Errorf("expected a trimmed diff of about 4000 bytes, got %d", len(got))
+	}
+}
+
+func TestRootCmd_JSONReportsOmittedFiles(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "dummy")
+	t.Setenv("AI_MR_COMMENT_CONTEXT_WINDOW", "8000")
+	path := filepath.Join(t.TempDir(), "big.diff")
+	if err := os.WriteFile(path, []byte(fileDiff("main.go", 10)+fileDiff("go.sum", 3000)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var sent []string
+	chatFn := func(_ context.Context, _ *Config, _ ApiProvider, _, diff string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, diff)
+		return "ok", nil
+	}
+	var out strings.Builder
+	cmd := newRootCmd(chatFn)
+	cmd.SetArgs([]string{"--file=" + path, "--provider=openai", "--format=json", "--no-default-ignores"})
+	cmd.SetOut(&out)
+	cmd.SetErr(&strings.Builder{})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var payload struct {
+		Truncated  bool            `json:"truncated"`
+		Truncation *diffTruncation `json:"truncation"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &payload); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if !payload.Truncated || payload.Truncation == nil || len(payload.Truncation.OmittedFiles) != 1 || payload.Truncation.OmittedFiles[0] != "go.sum" {
+		t.Errorf("expected go.sum in truncation metadata, got %s", out.String())
+	}
+	for _, d := range sent {
+		if !strings.Contains(d, "+main.go line 9") || strings.Contains(d, "+go.sum line 2999") {
+			t.Errorf("expected main.go kept and go.sum omitted in the prompt")
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Untracked files are diffed one git process each, so a checkout full of
+// build output that is missing from .gitignore is capped: at most
+// maxUntrackedFiles files, each at most maxUntrackedFileBytes.
+const (
+	maxUntrackedFiles     = 200
+	maxUntrackedFileBytes = 1 << 20
+)
+
+// maxLoggedPaths caps how many paths one --verbose line names.
+const maxLoggedPaths = 20
+
+// joinLoggedPaths joins paths for a debug line, naming at most
+// maxLoggedPaths of them.
+func joinLoggedPaths(paths []string) string {
+	if len(paths) <= maxLoggedPaths {
+		return strings.Join(paths, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(paths[:maxLoggedPaths], ", "), len(paths)-maxLoggedPaths)
+}
+
+// listUntrackedFiles returns the untracked files of the repository at root
+// that .gitignore and the other standard excludes do not hide, relative to
+// root.
+func listUntrackedFiles(root string) ([]string, error) {
+	out, err := exec.Command("git", "-C", root, "ls-files", "--others", "--exclude-standard", "-z").Output() //nolint:gosec // G204: git is a fixed binary, root is from git rev-parse
+	if err != nil {
+		return nil, fmt.Errorf("listing untracked files: %w", err)
+	}
+	var paths []string
+	for _, p := range strings.Split(string(out), "\x00") {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
+// untrackedFileDiff returns the diff git would show for path as a new file,
+// including its mode and, for binary files, the "Binary files" marker.
+func untrackedFileDiff(root, path string) (string, error) {
+	out, err := exec.Command("git", "-C", root, "diff", "--no-index", "--no-color", "--", devNull, path).Output() //nolint:gosec // G204: git is a fixed binary, path is from git ls-files
+	// --no-index exits 1 when the files differ, which they always do here.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("diffing untracked file %s: %w", path, err)
+	}
+	return string(out), nil
+}
+
+// addUntrackedFiles appends the repository's untracked files to a working-tree
+// diff as new-file diffs, which git diff alone leaves out. Files the ignore
+// rules drop are skipped before they are read. When include is false
+// (include_untracked is off, or the diff is of staged changes or a commit) the
+// files are only counted, so --verbose can say what the diff leaves out, and
+// without --verbose they are not listed at all.
+func addUntrackedFiles(cfg *Config, diff string, include, noDefaults bool, exclude []string) (string, error) {
+	if !include && cfg.DebugWriter == nil {
+		return diff, nil
+	}
+	root := repoRoot()
+	paths, err := listUntrackedFiles(root)
+	if err != nil {
+		if !include {
+			return diff, nil
+		}
+		return "", err
+	}
+	if len(paths) == 0 {
+		return diff, nil
+	}
+	if !include {
+		debugLog(cfg, "untracked: %d file(s) left out of the diff: %s", len(paths), joinLoggedPaths(paths))
+		return diff, nil
+	}
+
+	rules, err := loadIgnoreRules(cfg, noDefaults, exclude)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.WriteString(diff)
+	if diff != "" && !strings.HasSuffix(diff, "\n") {
+		sb.WriteByte('\n')
+	}
+	var added, skipped []string
+	for _, p := range paths {
+		if rules.match(p) {
+			continue
+		}
+		if len(added) == maxUntrackedFiles {
+			skipped = append(skipped, p)
+			continue
+		}
+		if info, statErr := os.Lstat(filepath.Join(root, p)); statErr == nil && info.Size() > maxUntrackedFileBytes {
+			skipped = append(skipped, p)
+			continue
+		}
+		d, err := untrackedFileDiff(root, p)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(d)
+		added = append(added, p)
+	}
+	if len(added) > 0 {
+		debugLog(cfg, "untracked: added %d file(s): %s", len(added), joinLoggedPaths(added))
+	}
+	if len(skipped) > 0 {
+		debugLog(cfg, "untracked: skipped %d file(s) over the %d-file or %d-byte limit: %s", len(skipped), maxUntrackedFiles, maxUntrackedFileBytes, joinLoggedPaths(skipped))
+	}
+	return sb.String(), nil
+}
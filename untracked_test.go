@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// initRepoWithUntracked creates a repo with one commit and these untracked
+// files: new.go, a binary logo.png, build/out.txt (hidden by .gitignore) and
+// vendor/lib.go (dropped by the default ignores).
+func initRepoWithUntracked(t *testing.T) string {
+	t.Helper()
+	dir := initEmptyRepo(t)
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".gitignore", "build/\n")
+	runGit(t, "add", ".gitignore")
+	runGit(t, "commit", "-m", "initial")
+	write("new.go", "package main\n\nfunc hello() {}\n")
+	write("logo.png", "\x89PNG\r\n\x1a\n\x00\x00\x00")
+	write("build/out.txt", "generated\n")
+	write("vendor/lib.go", "package lib\n")
+	return dir
+}
+
+func TestAddUntrackedFiles(t *testing.T) {
+	dir := initRepoWithUntracked(t)
+	t.Chdir(filepath.Join(dir, "vendor"))
+
+	got, err := addUntrackedFiles(&Config{}, "", true, false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files := map[string]*diffFile{}
+	for _, f := range parseDiff(got).Files {
+		files[f.Path()] = f
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected new.go and logo.png only, got:\n%s", got)
+	}
+	if f := files["new.go"]; f == nil || f.Status != fileAdded || f.NewMode != "100644" || f.Added != 3 {
+		t.Errorf("unexpected new.go diff %+v", f)
+	}
+	if f := files["logo.png"]; f == nil || !f.Binary || f.Status != fileAdded {
+		t.Errorf("expected logo.png as a new binary file, got %+v", f)
+	}
+
+	got, err = addUntrackedFiles(&Config{}, "", true, true, []string{"*.png"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(got, "b/vendor/lib.go") || strings.Contains(got, "logo.png") {
+		t.Errorf("expected --no-default-ignores and --exclude to apply, got:\n%s", got)
+	}
+}
+
+func TestAddUntrackedFiles_AppendsToTrackedDiff(t *testing.T) {
+	initRepoWithUntracked(t)
+	tracked := strings.TrimSuffix(fileDiff("main.go", 1), "\n")
+
+	got, err := addUntrackedFiles(&Config{}, tracked, true, false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(got, tracked+"\ndiff --git ") || len(parseDiff(got).Files) != 3 {
+		t.Errorf("expected the untracked files after the tracked diff, got:\n%s", got)
+	}
+
+	var log strings.Builder
+	got, err = addUntrackedFiles(&Config{DebugWriter: &log}, tracked, false, false, nil)
+	if err != nil || got != tracked {
+		t.Errorf("expected the diff unchanged when not included, got %q (%v)", got, err)
+	}
+	if !strings.Contains(log.String(), "untracked: 3 file(s) left out of the diff") {
+		t.Errorf("expected a verbose note about the skipped files, got %q", log.String())
+	}
+}
+
+func TestJoinLoggedPaths(t *testing.T) {
+	if got := joinLoggedPaths([]string{"a", "b"}); got != "a, b" {
+		t.Errorf("got %q", got)
+	}
+	paths := make([]string, maxLoggedPaths+5)
+	for i := range paths {
+		paths[i] = "f"
+	}
+	if got := joinLoggedPaths(paths); !strings.HasSuffix(got, "f and 5 more") || strings.Count(got, "f") != maxLoggedPaths {
+		t.Errorf("expected %d paths and a count of the rest, got %q", maxLoggedPaths, got)
+	}
+}
+
+func TestAddUntrackedFiles_SkipsLargeFiles(t *testing.T) {
+	dir := initRepoWithUntracked(t)
+	if err := os.WriteFile(filepath.Join(dir, "dump.sql"), make([]byte, maxUntrackedFileBytes+1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var log strings.Builder
+	got, err := addUntrackedFiles(&Config{DebugWriter: &log}, "", true, false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(got, "dump.sql") || !strings.Contains(log.String(), "skipped 1 file(s)") {
+		t.Errorf("expected dump.sql to be skipped, got log %q", log.String())
+	}
+}
+
+func TestRootCmd_UntrackedFiles(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "dummy")
+	initRepoWithUntracked(t)
+
+	run := func(extra ...string) (string, error) {
+		var out strings.Builder
+		cmd := newRootCmd(func(context.Context, *Config, ApiProvider, string, string) (string, error) {
+			return "ok", nil
+		})
+		cmd.SetArgs(append([]string{"--provider=openai", "--print-request"}, extra...))
+		cmd.SetOut(&out)
+		cmd.SetErr(io.Discard)
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	out, err := run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var payload struct {
+		Diff string `json:"diff"`
+	}
+	if err := json.Unmarshal([]byte(out), &payload); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out, err)
+	}
+	if !strings.Contains(payload.Diff, "diff --git a/new.go b/new.go\nnew file mode 100644") {
+		t.Errorf("expected the untracked file in the working-tree diff, got:\n%s", payload.Diff)
+	}
+
+	if _, err := run("--no-untracked"); exitCodeOf(err) != 3 {
+		t.Errorf("expected no diff with --no-untracked, got %v", err)
+	}
+	if _, err := run("--staged"); exitCodeOf(err) != 3 {
+		t.Errorf("expected --staged to leave untracked files out, got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenUsage is the token count a provider reported for one request.
+// CachedInputTokens is the part of InputTokens served from the provider's
+// prompt cache.
+type tokenUsage struct {
+	InputTokens       int64
+	CachedInputTokens int64
+	OutputTokens      int64
+}
+
+// usageReportKey is the context key under which a *usageReport is stored.
+type usageReportKey struct{}
+
+// usageReport receives the usage of a single provider request. Provider
+// implementations report into it through the context, which keeps the
+// Provider and chatFn signatures unchanged.
+type usageReport struct {
+	mu       sync.Mutex
+	usage    tokenUsage
+	reported bool
+}
+
+// withUsageReport returns a context carrying a fresh usageReport.
+func withUsageReport(ctx context.Context) (context.Context, *usageReport) {
+	r := &usageReport{}
+	return context.WithValue(ctx, usageReportKey{}, r), r
+}
+
+// reportUsage records the usage returned by a provider response. It is a no-op
+// when ctx carries no usageReport (e.g. the check command's ping).
+func reportUsage(ctx context.Context, u tokenUsage) {
+	r, ok := ctx.Value(usageReportKey{}).(*usageReport)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.usage = u
+	r.reported = true
+}
+
+func (r *usageReport) get() (tokenUsage, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.usage, r.reported
+}
+
+// callUsage is the usage and cost of one completed provider call.
+type callUsage struct {
+	Provider ApiProvider
+	Model    string
+	tokenUsage
+	CostUSD float64
+}
+
+// usageTracker accumulates callUsage for a whole run. Config holds a pointer
+// so that copies of a Config share one tracker.
+type usageTracker struct {
+	mu    sync.Mutex
+	calls []callUsage
+	// reserved is the estimated cost of calls that passed the budget check
+	// and have not finished yet; see reserveBudget.
+	reserved float64
+}
+
+// usageMu guards lazy creation of Config.usage for configs that were not
+// built by loadConfigWith (tests, check --all copies).
+var usageMu sync.Mutex
+
+func usageTrackerFor(cfg *Config) *usageTracker {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	if cfg.usage == nil {
+		cfg.usage = &usageTracker{}
+	}
+	return cfg.usage
+}
+
+// trackUsage runs one provider request with a usage report attached to its
+// context and adds the usage the provider reported to the run totals. A
+// successful call that reported none (the CLI providers) still goes in the
+// usage ledger, with token counts estimated from systemPrompt, diffContent
+// and the response.
+func trackUsage(ctx context.Context, cfg *Config, p Provider, systemPrompt, diffContent string, fn func(context.Context) (string, error)) (string, error) {
+	callCtx, report := withUsageReport(ctx)
+	result, err := fn(callCtx)
+	if u, ok := report.get(); ok {
+		recordUsage(cfg, p, u, false)
+	} else if err == nil {
+		u := tokenUsage{
+			InputTokens:  int64(estimateTokens(ctx, cfg, p, systemPrompt, diffContent)),
+			OutputTokens: int64(estimateTokens(ctx, cfg, p, result)),
+		}
+		recordUsage(cfg, p, u, true)
+	}
+	return result, err
+}
+
+// estimateTokens counts text with p's TokenEstimator, falling back to the
+// character heuristic when the estimator fails.
+func estimateTokens(ctx context.Context, cfg *Config, p Provider, text ...string) int32 {
+	tokens, err := p.TokenEstimator(cfg).CountTokens(ctx, p.Model(cfg), text...)
+	if err != nil {
+		debugLog(cfg, "usage: token count failed, using heuristic: %v", err)
+		tokens, _ = (&HeuristicTokenEstimator{}).CountTokens(ctx, "", text...)
+	}
+	return tokens
+}
+
+// recordUsage prices u for p's model and appends it to the run totals and
+// the ledger. Estimated counts, which the provider did not report, are only
+// written to the ledger, marked as estimated; the run totals stay actual.
+func recordUsage(cfg *Config, p Provider, u tokenUsage, estimated bool) {
+	c := callUsage{Provider: p.Name(), Model: p.Model(cfg), tokenUsage: u}
+	price, _ := providerPrice(cfg, p)
+	c.CostUSD = price.cost(u)
+	debugLog(cfg, "usage: provider=%s model=%s input=%d cached=%d output=%d cost=$%.6f estimated=%v",
+		c.Provider, c.Model, u.InputTokens, u.CachedInputTokens, u.OutputTokens, c.CostUSD, estimated)
+
+	if !estimated {
+		t := usageTrackerFor(cfg)
+		t.mu.Lock()
+		t.calls = append(t.calls, c)
+		t.mu.Unlock()
+	}
+
+	if ledgerEnabled(cfg) {
+		entry := ledgerEntry{
+			Time:              time.Now(),
+			Command:           cfg.ledgerCommand,
+			Repo:              cfg.ledgerRepo,
+			PRURL:             cfg.ledgerPRURL,
+			Provider:          c.Provider,
+			Model:             c.Model,
+			InputTokens:       u.InputTokens,
+			CachedInputTokens: u.CachedInputTokens,
+			OutputTokens:      u.OutputTokens,
+			CostUSD:           c.CostUSD,
+			Estimated:         estimated,
+		}
+		if err := appendLedger(ledgerPath(cfg), entry); err != nil {
+			debugLog(cfg, "ledger: write failed: %v", err)
+		}
+	}
+}
+
+// providerPricingModel returns the model name p is priced by, which differs
+// from p.Model for providers such as azure-openai that route by deployment.
+func providerPricingModel(cfg *Config, p Provider) string {
+	if pm, ok := p.(pricingModeler); ok {
+		return pm.PricingModel(cfg)
+	}
+	return p.Model(cfg)
+}
+
+// spent returns the actual cost of completed calls and the estimated cost of
+// calls still in flight.
+func (t *usageTracker) spent() (completed, reserved float64) {
+	for _, c := range t.calls {
+		completed += c.CostUSD
+	}
+	return completed, t.reserved
+}
+
+// usageSummary is the per-run usage reported in --verbose and the JSON output.
+type usageSummary struct {
+	Calls             int     `json:"calls"`
+	InputTokens       int64   `json:"input_tokens"`
+	CachedInputTokens int64   `json:"cached_input_tokens,omitempty"`
+	OutputTokens      int64   `json:"output_tokens"`
+	TotalTokens       int64   `json:"total_tokens"`
+	CostUSD           float64 `json:"cost_usd"`
+}
+
+// runUsage sums every call recorded for cfg. It returns nil when no provider
+// reported usage (CLI providers, cache hits), so JSON output omits the field.
+func runUsage(cfg *Config) *usageSummary {
+	t := usageTrackerFor(cfg)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.calls) == 0 {
+		return nil
+	}
+	s := &usageSummary{Calls: len(t.calls)}
+	for _, c := range t.calls {
+		s.InputTokens += c.InputTokens
+		s.CachedInputTokens += c.CachedInputTokens
+		s.OutputTokens += c.OutputTokens
+		s.CostUSD += c.CostUSD
+	}
+	s.TotalTokens = s.InputTokens + s.OutputTokens
+	return s
+}
+
+// logRunUsage writes the run totals to the --verbose log.
+func logRunUsage(cfg *Config) {
+	if s := runUsage(cfg); s != nil {
+		debugLog(cfg, "usage: total %d call(s) input=%d output=%d tokens=%d cost=$%.6f",
+			s.Calls, s.InputTokens, s.OutputTokens, s.TotalTokens, s.CostUSD)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	anthropicopt "github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/openai/openai-go"
+	openaiopt "github.com/openai/openai-go/option"
+)
+
+// meteredProvider is a fakeProvider that reports fixed token usage per call.
+type meteredProvider struct {
+	fakeProvider
+	usage tokenUsage
+	calls atomic.Int32
+}
+
+func (m *meteredProvider) Call(ctx context.Context, _ *Config, _, _ string) (string, error) {
+	m.calls.Add(1)
+	reportUsage(ctx, m.usage)
+	return m.reply, nil
+}
+
+func (m *meteredProvider) Stream(ctx context.Context, _ *Config, _, _ string, w io.Writer) (string, error) {
+	_, _ = io.WriteString(w, m.reply)
+	reportUsage(ctx, m.usage)
+	return m.reply, nil
+}
+
+func newMetered(name ApiProvider, model string, in, out int64) *meteredProvider {
+	return &meteredProvider{
+		fakeProvider: fakeProvider{name: name, reply: "metered reply", model: model},
+		usage:        tokenUsage{InputTokens: in, OutputTokens: out},
+	}
+}
+
+func TestRunUsage_AggregatesParallelCalls(t *testing.T) {
+	withTestRegistry(t, newMetered("metered", "gpt-4o", 500_000, 100))
+
+	cfg := &Config{Provider: "metered"}
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := chatCompletions(context.Background(), cfg, "metered", "p", "d"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got := runUsage(cfg)
+	if got == nil {
+		t.Fatal("expected usage to be recorded")
+	}
+	if got.Calls != 4 || got.InputTokens != 2_000_000 || got.OutputTokens != 400 || got.TotalTokens != 2_000_400 {
+		t.Errorf("unexpected totals: %+v", got)
+	}
+	// gpt-4o is $2.50 per 1M input and $10.00 per 1M output tokens.
+	if math.Abs(got.CostUSD-5.004) > 1e-9 {
+		t.Errorf("expected $5.004, got $%f", got.CostUSD)
+	}
+}
+
+func TestRunUsage_NilWithoutReports(t *testing.T) {
+	withTestRegistry(t, &fakeProvider{name: "silent", reply: "x"})
+	cfg := &Config{Provider: "silent"}
+	if _, err := chatCompletions(context.Background(), cfg, "silent", "p", "d"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := runUsage(cfg); got != nil {
+		t.Errorf("expected no usage for a provider that reports none, got %+v", got)
+	}
+}
+
+func TestRunUsage_SharedAcrossConfigCopies(t *testing.T) {
+	withTestRegistry(t, newMetered("metered", "m", 10, 5))
+	cfg := &Config{Provider: "metered", usage: &usageTracker{}}
+	copied := *cfg
+	if _, err := chatCompletions(context.Background(), &copied, "metered", "p", "d"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := runUsage(cfg); got == nil || got.InputTokens != 10 {
+		t.Errorf("expected usage from the copy to reach the original, got %+v", got)
+	}
+}
+
+func TestRecordUsage_AzurePricesByModelNotDeployment(t *testing.T) {
+	cfg := &Config{AzureDeployment: "prod-deploy", AzureModel: "gpt-4o-mini"}
+	recordUsage(cfg, azureOpenAIProvider{}, tokenUsage{InputTokens: 1_000_000}, false)
+	got := runUsage(cfg)
+	if got == nil || math.Abs(got.CostUSD-0.15) > 1e-9 {
+		t.Errorf("expected gpt-4o-mini pricing, got %+v", got)
+	}
+}
+
+func TestCallOllama_ReportsUsage(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"response": "ok", "done": true, "prompt_eval_count": 42, "eval_count": 7})
+	}))
+	defer ts.Close()
+
+	ctx, report := withUsageReport(context.Background())
+	if _, err := callOllama(ctx, &Config{OllamaModel: "llama3", OllamaEndpoint: ts.URL}, "sys", "diff"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u, ok := report.get(); !ok || u != (tokenUsage{InputTokens: 42, OutputTokens: 7}) {
+		t.Errorf("unexpected usage %+v (reported=%v)", u, ok)
+	}
+}
+
+func TestStreamOllama_ReportsUsageFromFinalChunk(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "{\"response\":\"a\",\"done\":false}\n")
+		_, _ = fmt.Fprint(w, "{\"response\":\"b\",\"done\":true,\"prompt_eval_count\":30,\"eval_count\":2}\n")
+	}))
+	defer ts.Close()
+
+	ctx, report := withUsageReport(context.Background())
+	if _, err := streamOllama(ctx, &Config{OllamaModel: "llama3", OllamaEndpoint: ts.URL}, "sys", "diff", io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u, ok := report.get(); !ok || u != (tokenUsage{InputTokens: 30, OutputTokens: 2}) {
+		t.Errorf("unexpected usage %+v (reported=%v)", u, ok)
+	}
+}
+
+func TestCallAnthropic_ReportsUsage(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id": "msg_1", "type": "message", "role": "assistant", "model": "test",
+			"content":     []map[string]string{{"type": "text", "text": "ok"}},
+			"stop_reason": "end_turn",
+			"usage":       map[string]int{"input_tokens": 120, "cache_read_input_tokens": 80, "output_tokens": 15},
+		})
+	}))
+	defer ts.Close()
+
+	client := anthropic.NewClient(anthropicopt.WithBaseURL(ts.URL), anthropicopt.WithAPIKey("test"))
+	ctx, report := withUsageReport(context.Background())
+	if _, err := callAnthropic(ctx, &client, &Config{AnthropicModel: "claude-sonnet-4-6"}, "sys", "diff"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Anthropic reports cache reads separately from input_tokens.
+	if u, ok := report.get(); !ok || u != (tokenUsage{InputTokens: 200, CachedInputTokens: 80, OutputTokens: 15}) {
+		t.Errorf("unexpected usage %+v (reported=%v)", u, ok)
+	}
+}
+
+func TestStreamAnthropic_ReportsUsage(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"1\",\"type\":\"message\",\"role\":\"assistant\",\"content\":[],\"model\":\"m\",\"stop_reason\":null,\"stop_sequence\":null,\"usage\":{\"input_tokens\":25,\"output_tokens\":1}}}\n\n")
+		_, _ = fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n")
+		_, _ = fmt.Fprint(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\",\"stop_sequence\":null},\"usage\":{\"output_tokens\":9}}\n\n")
+		_, _ = fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+	defer ts.Close()
+
+	client := anthropic.NewClient(anthropicopt.WithBaseURL(ts.URL), anthropicopt.WithAPIKey("test"))
+	ctx, report := withUsageReport(context.Background())
+	if _, err := streamAnthropic(ctx, &client, &Config{AnthropicModel: "m"}, "sys", "diff", io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u, ok := report.get(); !ok || u != (tokenUsage{InputTokens: 25, OutputTokens: 9}) {
+		t.Errorf("unexpected usage %+v (reported=%v)", u, ok)
+	}
+}
+
+func TestStreamOpenAI_RequestsAndReportsUsage(t *testing.T) {
+	var body map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(raw, &body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "data: {\"id\":\"1\",\"object\":\"chat.completion.chunk\",\"created\":0,\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"},\"finish_reason\":null}]}\n\n")
+		_, _ = fmt.Fprint(w, "data: {\"id\":\"1\",\"object\":\"chat.completion.chunk\",\"created\":0,\"model\":\"gpt-4o\",\"choices\":[],\"usage\":{\"prompt_tokens\":64,\"completion_tokens\":3,\"total_tokens\":67,\"prompt_tokens_details\":{\"cached_tokens\":32}}}\n\n")
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer ts.Close()
+
+	client := openai.NewClient(openaiopt.WithAPIKey("test"), openaiopt.WithBaseURL(ts.URL+"/"))
+	ctx, report := withUsageReport(context.Background())
+	if _, err := streamOpenAI(ctx, &client, &Config{OpenAIModel: "gpt-4o"}, "sys", "diff", io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts, _ := body["stream_options"].(map[string]any)
+	if opts["include_usage"] != true {
+		t.Errorf("expected stream_options.include_usage, body: %v", body)
+	}
+	if u, ok := report.get(); !ok || u != (tokenUsage{InputTokens: 64, CachedInputTokens: 32, OutputTokens: 3}) {
+		t.Errorf("unexpected usage %+v (reported=%v)", u, ok)
+	}
+}
+
+func TestOpenAICompatible_OmitStreamOptions(t *testing.T) {
+	var body map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(raw, &body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer ts.Close()
+
+	cfg := &Config{
+		OpenAICompatibleEndpoint:   ts.URL + "/v1/",
+		OpenAICompatibleModel:      "local",
+		OpenAICompatibleOmitParams: []string{"stream_options"},
+	}
+	if _, err := streamToWriter(context.Background(), cfg, OpenAICompatible, "sys", "diff", io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := body["stream_options"]; ok {
+		t.Errorf("expected stream_options to be omitted, body: %v", body)
+	}
+}
+
+func TestRootCmd_JSONIncludesUsage(t *testing.T) {
+	withTestRegistry(t, newMetered("metered", "gpt-4o", 1000, 200))
+
+	var out, errOut strings.Builder
+	cmd := newRootCmd(chatCompletions)
+	cmd.SetArgs([]string{"--file=testdata/simple.diff", "--provider=metered", "--format=json", "--verbose"})
+	cmd.SetOut(&out)
+	cmd.SetErr(&errOut)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var payload struct {
+		Usage *usageSummary `json:"usage"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &payload); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if payload.Usage == nil || payload.Usage.Calls == 0 {
+		t.Fatalf("expected usage in JSON, got %s", out.String())
+	}
+	u := payload.Usage
+	if u.InputTokens != int64(u.Calls)*1000 || u.OutputTokens != int64(u.Calls)*200 || u.CostUSD <= 0 {
+		t.Errorf("unexpected usage totals: %+v", u)
+	}
+	if !strings.Contains(errOut.String(), fmt.Sprintf("usage: total %d call(s)", u.Calls)) {
+		t.Errorf("expected usage total in verbose log, got:\n%s", errOut.String())
+	}
+}
+
+func TestRootCmd_JSONOmitsUsageWhenUnreported(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "dummy")
+	var out strings.Builder
+	cmd := newRootCmd(dummyChatFn)
+	cmd.SetArgs([]string{"--file=testdata/simple.diff", "--provider=openai", "--format=json"})
+	cmd.SetOut(&out)
+	cmd.SetErr(&strings.Builder{})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(out.String(), `"usage"`) {
+		t.Errorf("expected no usage field, got %s", out.String())
+	}
+}